@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxSeedCount = 10000
+
+// requireAdmin lets a request through only when it carries the configured
+// admin token as `Authorization: Bearer <token>`.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.AdminToken == "" {
+			writeError(w, http.StatusForbidden, "admin endpoints are disabled")
+			return
+		}
+		if !adminAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminAuthorized tells whether r carries the admin token.
+func adminAuthorized(r *http.Request) bool {
+	if cfg.AdminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) == 1
+}
+
+type SeedResult struct {
+	Seeded  int   `json:"seeded"`
+	FirstID int64 `json:"first_id"`
+	LastID  int64 `json:"last_id"`
+}
+
+// seed adds demo drivers on demand, numbered after the existing ones.
+func seed(w http.ResponseWriter, r *http.Request) {
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count < 1 || count > maxSeedCount {
+		writeError(w, http.StatusBadRequest, (&paramError{"count", "must be a number between 1 and " + strconv.Itoa(maxSeedCount)}).Error())
+		return
+	}
+	tx, err := srv.DB().Begin()
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	defer tx.Rollback()
+	first, last, err := seedDrivers(tx, count)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	err = recordAudit(tx, requestActor(r), "create", "driver", fmt.Sprintf("%d-%d", first, last), map[string]interface{}{"seeded": count})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(SeedResult{Seeded: count, FirstID: first, LastID: last})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getConfig shows the configuration the service is running with.
+func getConfig(w http.ResponseWriter, r *http.Request) {
+	d, err := json.Marshal(cfg.Redacted())
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// DBStats reports the connection pool of database/sql, to diagnose lock
+// contention, along with the size and cache settings of the SQLite database.
+type DBStats struct {
+	MaxOpenConnections int    `json:"max_open_connections"`
+	OpenConnections    int    `json:"open_connections"`
+	InUse              int    `json:"in_use"`
+	Idle               int    `json:"idle"`
+	WaitCount          int64  `json:"wait_count"`
+	WaitDuration       string `json:"wait_duration"`
+	MaxIdleClosed      int64  `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64  `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64  `json:"max_lifetime_closed"`
+	PageCount          int64  `json:"page_count"`
+	PageSize           int64  `json:"page_size"`
+	FreelistCount      int64  `json:"freelist_count"`
+	CacheSize          int64  `json:"cache_size"`
+	BusyTimeout        int64  `json:"busy_timeout"`
+	JournalMode        string `json:"journal_mode"`
+}
+
+func getDBStats(w http.ResponseWriter, r *http.Request) {
+	db := srv.DB()
+	s := db.Stats()
+	stats := DBStats{
+		MaxOpenConnections: s.MaxOpenConnections,
+		OpenConnections:    s.OpenConnections,
+		InUse:              s.InUse,
+		Idle:               s.Idle,
+		WaitCount:          s.WaitCount,
+		WaitDuration:       s.WaitDuration.String(),
+		MaxIdleClosed:      s.MaxIdleClosed,
+		MaxIdleTimeClosed:  s.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  s.MaxLifetimeClosed,
+	}
+	pragmas := []struct {
+		name  string
+		value interface{}
+	}{
+		{"page_count", &stats.PageCount},
+		{"page_size", &stats.PageSize},
+		{"freelist_count", &stats.FreelistCount},
+		{"cache_size", &stats.CacheSize},
+		{"busy_timeout", &stats.BusyTimeout},
+		{"journal_mode", &stats.JournalMode},
+	}
+	for _, p := range pragmas {
+		err := db.QueryRow("PRAGMA " + p.name).Scan(p.value)
+		if err != nil {
+			writeInternalError(w, err)
+			return
+		}
+	}
+	d, err := json.Marshal(stats)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// adminEnv turns on the admin endpoints, adminAuth authorizes a request to
+// them.
+var (
+	adminEnv  = map[string]string{"ADMIN_TOKEN": "secret-token"}
+	adminAuth = []string{"Authorization", "Bearer secret-token"}
+)
+
+func TestSeedAfterExistingDrivers(t *testing.T) {
+	h := openTestDB(t, adminEnv)
+	expectStatus(t, serveTest(h, "POST", "/admin/seed?count=3", ""), http.StatusUnauthorized)
+	rec := serveTest(h, "POST", "/admin/seed?count=3", "", adminAuth...)
+	expectStatus(t, rec, http.StatusCreated)
+	var result SeedResult
+	decodeBody(t, rec, &result)
+	if result.Seeded != 3 || result.FirstID != seedDriverCount+1 || result.LastID != seedDriverCount+3 {
+		t.Fatalf("seeded %+v, want ids %d to %d", result, seedDriverCount+1, seedDriverCount+3)
+	}
+	var count int
+	if err := srv.DB().QueryRow("SELECT COUNT(*) FROM drivers").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != seedDriverCount+3 {
+		t.Fatalf("%d drivers after seeding, want %d", count, seedDriverCount+3)
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers/33", ""), http.StatusOK)
+}
+
+func TestConfigRedactsSecrets(t *testing.T) {
+	h := openTestDB(t, map[string]string{
+		"ADMIN_TOKEN":            "secret-token",
+		"JWT_SECRET":             "jwt-secret",
+		"MAX_RATINGS_PER_DRIVER": "7",
+	})
+	rec := serveTest(h, "GET", "/admin/config", "", adminAuth...)
+	expectStatus(t, rec, http.StatusOK)
+	body := rec.Body.String()
+	for _, secret := range []string{"secret-token", "jwt-secret"} {
+		if strings.Contains(body, secret) {
+			t.Fatalf("the configuration shows %q: %s", secret, body)
+		}
+	}
+	var config map[string]interface{}
+	decodeBody(t, rec, &config)
+	if config["admin_token"] != redactedValue || config["jwt_secret"] != redactedValue {
+		t.Fatalf("secrets are %v and %v, want them redacted", config["admin_token"], config["jwt_secret"])
+	}
+	if config["db_path"] != cfg.DBPath || config["max_ratings_per_driver"] != 7.0 {
+		t.Fatalf("db_path is %v and max_ratings_per_driver %v, want %s and 7", config["db_path"], config["max_ratings_per_driver"], cfg.DBPath)
+	}
+}
+
+func TestDBStats(t *testing.T) {
+	h := openTestDB(t, adminEnv)
+	rec := serveTest(h, "GET", "/admin/db-stats", "", adminAuth...)
+	expectStatus(t, rec, http.StatusOK)
+	var stats map[string]interface{}
+	decodeBody(t, rec, &stats)
+	for _, field := range []string{"max_open_connections", "open_connections", "in_use", "idle", "wait_count", "wait_duration", "page_count", "cache_size"} {
+		if _, ok := stats[field]; !ok {
+			t.Fatalf("db stats have no %s: %v", field, stats)
+		}
+	}
+	if stats["open_connections"].(float64) < 1 || stats["page_count"].(float64) < 1 || stats["busy_timeout"] != 5000.0 {
+		t.Fatalf("db stats are %v, want an open connection, pages and the busy timeout", stats)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// aggregates is set when coalesced aggregate updates are enabled, see
+// Config.AggregateInterval.
+var aggregates *aggregateBuffer
+
+type aggregateDelta struct {
+	sum, count int64
+}
+
+// aggregateBuffer accumulates the changes to the rating_sum and rating_count
+// of drivers in memory and applies them every interval, one UPDATE per driver
+// however many ratings it got in the meantime. This takes the drivers row of
+// a hot driver out of the path of every rating.
+//
+// The average is eventually consistent: a rating is in driver_ratings as soon
+// as it is acknowledged, but only counts in avg_rating after the next flush,
+// at most one interval later. Changes not yet flushed when the process dies
+// are lost, the aggregates can then be rebuilt from driver_ratings.
+type aggregateBuffer struct {
+	mu       sync.Mutex
+	pending  map[string]aggregateDelta
+	interval time.Duration
+	closing  chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func newAggregateBuffer(interval time.Duration) *aggregateBuffer {
+	b := &aggregateBuffer{
+		pending:  map[string]aggregateDelta{},
+		interval: interval,
+		closing:  make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *aggregateBuffer) add(driverId string, sum, count int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d := b.pending[driverId]
+	d.sum += sum
+	d.count += count
+	b.pending[driverId] = d
+}
+
+// drop discards the pending changes and returns the number of drivers they
+// were of.
+func (b *aggregateBuffer) drop() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(b.pending)
+	b.pending = map[string]aggregateDelta{}
+	return n
+}
+
+// stop flushes the pending changes one last time and waits for the buffer
+// to stop. Changes added after it returns are never applied. Only the first
+// call does anything.
+func (b *aggregateBuffer) stop() {
+	b.stopOnce.Do(func() {
+		close(b.closing)
+		<-b.stopped
+	})
+}
+
+func (b *aggregateBuffer) run() {
+	defer close(b.stopped)
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.closing:
+			if err := b.flush(); err != nil {
+				log.Println("flush aggregates:", err)
+			}
+			return
+		}
+		if err := b.flush(); err != nil {
+			log.Println("flush aggregates:", err)
+		}
+	}
+}
+
+// flush applies the pending changes in one transaction. When it fails they
+// are put back to be retried with the next flush.
+func (b *aggregateBuffer) flush() error {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = map[string]aggregateDelta{}
+	b.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	err := applyAggregates(pending)
+	if err != nil {
+		for driverId, d := range pending {
+			b.add(driverId, d.sum, d.count)
+		}
+	}
+	return err
+}
+
+func applyAggregates(pending map[string]aggregateDelta) error {
+	tx, err := srv.DB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	statement, err := tx.Prepare(`UPDATE drivers SET rating_sum = rating_sum + ?, rating_count = rating_count + ? WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	for driverId, d := range pending {
+		if _, err = statement.Exec(d.sum, d.count, driverId); err != nil {
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	for driverId := range pending {
+		averageCache.forget(driverId)
+	}
+	return nil
+}
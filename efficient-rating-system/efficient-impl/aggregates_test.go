@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestAggregatesConvergeAfterBurst(t *testing.T) {
+	h := openTestDB(t, map[string]string{"AGGREGATE_FLUSH_INTERVAL_MS": "20"})
+	const users = 20
+	var wg sync.WaitGroup
+	for u := 0; u < users; u++ {
+		wg.Add(1)
+		go func(u int) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"user_id": "u%d", "rating": %d}`, u, u%5+1)
+			if rec := serveTest(h, "POST", "/drivers/1/ratings", body); rec.Code != http.StatusOK {
+				t.Errorf("status %d: %s", rec.Code, rec.Body.String())
+			}
+		}(u)
+	}
+	wg.Wait()
+	// The rows are written right away, the aggregates with the next flush.
+	var rows, stars int64
+	if err := srv.DB().QueryRow("SELECT COUNT(*), SUM(rating) FROM driver_ratings WHERE driver_id = '1'").Scan(&rows, &stars); err != nil {
+		t.Fatal(err)
+	}
+	if rows != users {
+		t.Fatalf("%d ratings stored, want %d", rows, users)
+	}
+	eventually(t, func() bool {
+		sum, count := driverAggregates(t, "1")
+		return sum == stars && count == rows
+	})
+}
+
+// TestAggregatesStopFlushes checks that stop applies the pending changes
+// without waiting for the ticker, and can be called again.
+func TestAggregatesStopFlushes(t *testing.T) {
+	h := openTestDB(t, map[string]string{"AGGREGATE_FLUSH_INTERVAL_MS": "60000"})
+	rateTest(t, h, "1", "a", 4)
+	if _, count := driverAggregates(t, "1"); count != 0 {
+		t.Fatalf("driver 1 has %d ratings before the flush, want none", count)
+	}
+	aggregates.stop()
+	if sum, count := driverAggregates(t, "1"); sum != 4 || count != 1 {
+		t.Fatalf("driver 1 has %d stars in %d ratings after stop, want 4 in 1", sum, count)
+	}
+	aggregates.stop()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	// avgMedian is the median rating, the mean of the two middle ratings
+	// when there is an even number of them.
+	avgMedian = "median"
+	// avgTrimmed is the mean of the ratings left once the lowest and the
+	// highest cfg.TrimPercent of them are dropped.
+	avgTrimmed = "trimmed"
+)
+
+// aggFunctions are the accepted values of AGG_FUNCTION.
+var aggFunctions = []string{avgMean, avgMedian, avgTrimmed, avgBayesian, avgDecayed}
+
+// averageOptions returns the accepted values of avg for the drivers list,
+// cfg.AggFunction first so that it is the default.
+func averageOptions() []string {
+	options := []string{cfg.AggFunction}
+	for _, o := range append(aggFunctions, avgTrusted, avgSmart) {
+		if o != cfg.AggFunction {
+			options = append(options, o)
+		}
+	}
+	return options
+}
+
+// checkAverage rejects a value of avg the configuration can't compute.
+func checkAverage(avg string) error {
+	if avg == avgTrusted && !trustConfigured() {
+		return &paramError{"avg", "trusted raters are not configured"}
+	}
+	if avg == avgBayesian && cfg.PriorWeight == 0 {
+		return &paramError{"avg", "the bayesian prior is not configured"}
+	}
+	return nil
+}
+
+// averageExpr returns the SQL expression, with its arguments, of the average
+// of the drivers in alias computed with fn, one of aggFunctions. The mean and
+// the bayesian average come from the stored aggregates, the median and the
+// trimmed mean from the ratings themselves, as does avgSmart. It is NULL for
+// unrated drivers, except for the bayesian average which is then the prior.
+func averageExpr(alias, fn string) (string, []interface{}) {
+	switch fn {
+	case avgBayesian:
+		return bayesianAverage(alias)
+	case avgSmart:
+		return smartAverage(alias)
+	case avgMedian, avgTrimmed, avgDecayed:
+		return ratingsAverageExpr(alias, fn, "", nil)
+	}
+	return "CAST(" + alias + ".rating_sum AS REAL)/" + alias + ".rating_count", nil
+}
+
+// ratingsAverageExpr is like averageExpr but always computes the average from
+// the rows of driver_ratings selected by ratingsFilter. It takes avgTrusted
+// and avgSmart too.
+func ratingsAverageExpr(alias, fn, excludeUser string, since *time.Time) (string, []interface{}) {
+	filter, args := ratingsFilter(alias, excludeUser, since)
+	ratings := "SELECT rating, ROW_NUMBER() OVER (ORDER BY rating) AS rn, COUNT(*) OVER () AS c" +
+		" FROM driver_ratings WHERE " + filter
+	switch fn {
+	case avgDecayed:
+		return decayedAverage(filter, args)
+	case avgSmart:
+		return smartRatingsAverage(filter, args)
+	case avgTrusted:
+		cond, condArgs := trustedRaterCondition("driver_ratings")
+		return "(SELECT AVG(rating) FROM driver_ratings WHERE " + filter + " AND " + cond + ")", append(args, condArgs...)
+	case avgMedian:
+		return "(SELECT AVG(rating) FROM (" + ratings + ") WHERE rn IN ((c + 1) / 2, (c + 2) / 2))", args
+	case avgTrimmed:
+		// c * p / 100 is an integer division, the number of ratings dropped
+		// at each end rounded down.
+		p := strconv.Itoa(cfg.TrimPercent)
+		return "(SELECT AVG(rating) FROM (" + ratings + ") WHERE rn > c * " + p + " / 100 AND rn <= c - c * " + p + " / 100)", args
+	case avgBayesian:
+		return "(SELECT (? * ? + COALESCE(SUM(rating), 0)) / (? + COUNT(*)) FROM driver_ratings WHERE " + filter + ")",
+			append([]interface{}{priorMean(), cfg.PriorWeight, float64(cfg.PriorWeight)}, args...)
+	}
+	return "(SELECT AVG(rating) FROM driver_ratings WHERE " + filter + ")", args
+}
+
+// ratingsFilter selects the ratings of the driver in alias, leaving out the
+// rating of excludeUser if any and, when since is set, the ratings created
+// before it.
+func ratingsFilter(alias, excludeUser string, since *time.Time) (string, []interface{}) {
+	filter, args := "driver_id = "+alias+".id AND user_id IS NOT ?", []interface{}{nullString(excludeUser)}
+	if since != nil {
+		filter, args = filter+" AND created_at >= ?", append(args, since.UTC().Format(timeFormat))
+	}
+	return filter, args
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDefaultAverageFunction(t *testing.T) {
+	// The ratings 1, 2, 4, 5, 5: the trimmed mean drops 1 and one 5, the
+	// bayesian average adds 5 ratings of 3.
+	tests := []struct {
+		fn   string
+		want float64
+	}{
+		{avgMean, 3.4},
+		{avgMedian, 4},
+		{avgTrimmed, 11.0 / 3},
+		{avgBayesian, 3.2},
+	}
+	for _, test := range tests {
+		t.Run(test.fn, func(t *testing.T) {
+			h := openTestDB(t, map[string]string{"AGG_FUNCTION": test.fn, "TRIM_PERCENT": "20", "PRIOR_WEIGHT": "5"})
+			for i, stars := range []int{1, 2, 4, 5, 5} {
+				rateTest(t, h, "1", string(rune('a'+i)), stars)
+			}
+			var driver Driver
+			rec := serveTest(h, "GET", "/drivers/1", "")
+			expectStatus(t, rec, http.StatusOK)
+			decodeBody(t, rec, &driver)
+			var list []Driver
+			rec = serveTest(h, "GET", "/drivers?limit=1", "")
+			expectStatus(t, rec, http.StatusOK)
+			decodeBody(t, rec, &list)
+			if len(list) != 1 {
+				t.Fatalf("%d drivers listed, want 1", len(list))
+			}
+			if driver.AverageRating != test.want || list[0].AverageRating != test.want {
+				t.Fatalf("avg_rating is %v, %v in the list, want %v", driver.AverageRating, list[0].AverageRating, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// archiveInterval is how often ratings older than cfg.ArchiveAfter are
+// archived.
+const archiveInterval = time.Hour
+
+const (
+	defaultArchivedLimit = 50
+	maxArchivedLimit     = 100
+)
+
+// ArchivedRating is a rating moved out of driver_ratings by archiveOldRatings.
+type ArchivedRating struct {
+	Rating
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// countedRatings is the set of ratings the stored rating_sum and rating_count
+// are made of: driver_ratings, plus archived_ratings when archived ratings
+// keep counting, or the ratings replayed from the event log.
+func countedRatings() string {
+	if cfg.RatingEventLog {
+		return loggedRatings
+	}
+	if cfg.ArchiveKeepAverage {
+		return "(SELECT driver_id, user_id, rating FROM driver_ratings UNION ALL SELECT driver_id, user_id, rating FROM archived_ratings)"
+	}
+	return "driver_ratings"
+}
+
+// archiveLoop archives the old ratings once on startup and then every
+// archiveInterval.
+func archiveLoop() {
+	for {
+		n, err := archiveOldRatings(time.Now().Add(-cfg.ArchiveAfter))
+		if err != nil {
+			log.Println("archive:", err)
+		} else if n > 0 {
+			log.Println("archive: archived", n, "ratings")
+		}
+		time.Sleep(archiveInterval)
+	}
+}
+
+// archiveOldRatings moves the ratings last updated before the given time to
+// archived_ratings and, unless cfg.ArchiveKeepAverage is set, takes them out
+// of the aggregates of their drivers. It returns how many were moved.
+func archiveOldRatings(before time.Time) (int64, error) {
+	tx, err := srv.DB().Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	cutoff := before.UTC().Format(timeFormat)
+	if !cfg.ArchiveKeepAverage {
+		if lazy != nil {
+			_, err = markStaleDrivers(tx, "SELECT DISTINCT driver_id FROM driver_ratings WHERE updated_at < ?", cutoff)
+		} else {
+			_, err = tx.Exec(`UPDATE drivers
+      SET rating_sum = rating_sum - (SELECT SUM(rating) FROM driver_ratings WHERE driver_id = drivers.id AND updated_at < ?),
+        rating_count = rating_count - (SELECT COUNT(*) FROM driver_ratings WHERE driver_id = drivers.id AND updated_at < ?)
+      WHERE id IN (SELECT driver_id FROM driver_ratings WHERE updated_at < ?)`, cutoff, cutoff, cutoff)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO archived_ratings (driver_id, user_id, rating, source, comment, tags, region, created_at, updated_at, moderation)
+    SELECT driver_id, user_id, rating, source, comment, tags, region, created_at, updated_at, moderation FROM driver_ratings WHERE updated_at < ?`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("DELETE FROM driver_ratings WHERE updated_at < ?", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	averageCache.purge()
+	return n, nil
+}
+
+// unarchiveRating deletes the archived rating of the user, if any, and
+// returns its value. With cfg.ArchiveKeepAverage a user rating the driver
+// again replaces the archived rating in the aggregates instead of counting
+// twice.
+func unarchiveRating(q dbtx, driverId, userId string) (sql.NullInt64, error) {
+	var rating sql.NullInt64
+	err := q.QueryRow("DELETE FROM archived_ratings WHERE driver_id = ? AND user_id = ? RETURNING rating", driverId, userId).Scan(&rating)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return rating, err
+}
+
+func getArchivedRatings(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListParams(r, listOptions{DefaultLimit: defaultArchivedLimit, MaxLimit: maxArchivedLimit})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query := r.URL.Query()
+	list, err := getArchivedRatingsList(query.Get("driver_id"), storedUserID(query.Get("user_id")), params.Limit, params.Offset)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	for i := range list {
+		list[i].UserID = shownUserID(r, list[i].UserID)
+	}
+	d, err := json.Marshal(list)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getArchivedRatingsList returns a page of the archived ratings, latest
+// archived first, of the given driver and user when they are not empty.
+func getArchivedRatingsList(driverId, userId string, limit, offset int) ([]ArchivedRating, error) {
+	query := `SELECT driver_id, user_id, rating, COALESCE(source, ''), COALESCE(comment, ''), tags, COALESCE(region, ''), created_at, updated_at, moderation, archived_at
+    FROM archived_ratings WHERE 1 = 1`
+	var args []interface{}
+	if driverId != "" {
+		query += " AND driver_id = ?"
+		args = append(args, driverId)
+	}
+	if userId != "" {
+		query += " AND user_id = ?"
+		args = append(args, userId)
+	}
+	row, err := srv.DB().Query(query+" ORDER BY archived_at DESC, rowid DESC LIMIT ? OFFSET ?", append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []ArchivedRating{}
+	for row.Next() {
+		var archived ArchivedRating
+		rating := &archived.Rating
+		err = row.Scan(&rating.DriverID, &rating.UserID, &rating.Rating, &rating.Source, &rating.Comment, &rating.Tags, &rating.Region, &rating.CreatedAt, &rating.UpdatedAt, &rating.Moderation, &archived.ArchivedAt)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, archived)
+	}
+	return list, row.Err()
+}
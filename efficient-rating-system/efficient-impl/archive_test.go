@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestArchiveOldRatings(t *testing.T) {
+	tests := []struct {
+		keep       bool
+		sum, count int64
+	}{
+		{false, 4, 1},
+		{true, 6, 2},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint("keep=", test.keep), func(t *testing.T) {
+			h := openTestDB(t, map[string]string{"ADMIN_TOKEN": "secret-token",
+				"RATING_ARCHIVE_AFTER_DAYS": "30", "RATING_ARCHIVE_KEEP_AVERAGE": fmt.Sprint(test.keep)})
+			rateTest(t, h, "1", "a", 2)
+			rateTest(t, h, "1", "b", 4)
+			old := time.Now().Add(-40 * 24 * time.Hour).UTC().Format(timeFormat)
+			execTest(t, "UPDATE driver_ratings SET updated_at = ? WHERE user_id = 'a'", old)
+			n, err := archiveOldRatings(time.Now().Add(-cfg.ArchiveAfter))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 1 {
+				t.Fatalf("%d ratings archived, want 1", n)
+			}
+			var active int
+			if err := srv.DB().QueryRow("SELECT COUNT(*) FROM driver_ratings WHERE driver_id = '1'").Scan(&active); err != nil {
+				t.Fatal(err)
+			}
+			if active != 1 {
+				t.Fatalf("%d active ratings left, want only the one of b", active)
+			}
+			rec := serveTest(h, "GET", "/admin/ratings/archived?driver_id=1", "", adminAuth...)
+			expectStatus(t, rec, http.StatusOK)
+			var archived []ArchivedRating
+			decodeBody(t, rec, &archived)
+			if len(archived) != 1 || archived[0].UserID != "a" || archived[0].Rating.Rating != 2 {
+				t.Fatalf("archived ratings are %+v, want the rating of a", archived)
+			}
+			if sum, count := driverAggregates(t, "1"); sum != test.sum || count != test.count {
+				t.Fatalf("aggregates are sum %d count %d, want %d and %d", sum, count, test.sum, test.count)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	ratingPending   = "pending"
+	ratingSucceeded = "succeeded"
+	ratingFailed    = "failed"
+)
+
+// ratingStatusTTL is how long the outcome of an accepted rating can be
+// polled once its batch is flushed.
+const ratingStatusTTL = 10 * time.Minute
+
+// RatingStatus is the outcome of a rating accepted with cfg.AsyncRatings,
+// polled at GET /ratings/status/{id}. Error is set when it failed.
+type RatingStatus struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ratingStatuses tracks the ratings accepted but maybe not written yet. The
+// statuses live in memory, like the queue of the write buffer they follow.
+var ratingStatuses = &statusTracker{statuses: map[string]*trackedStatus{}}
+
+type trackedStatus struct {
+	RatingStatus
+	done time.Time
+}
+
+type statusTracker struct {
+	mu       sync.Mutex
+	statuses map[string]*trackedStatus
+}
+
+// start tracks a new pending rating and returns its id. The statuses that
+// are done since longer than ratingStatusTTL are dropped on the way.
+func (t *statusTracker) start() (string, error) {
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, s := range t.statuses {
+		if !s.done.IsZero() && time.Since(s.done) > ratingStatusTTL {
+			delete(t.statuses, key)
+		}
+	}
+	t.statuses[id] = &trackedStatus{RatingStatus: RatingStatus{ID: id, Status: ratingPending}}
+	return id, nil
+}
+
+// finish records the outcome of the rating with the id, ids that aren't
+// tracked are ignored.
+func (t *statusTracker) finish(id string, err error) {
+	if id == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.statuses[id]
+	if !ok {
+		return
+	}
+	s.Status, s.done = ratingSucceeded, time.Now()
+	if err != nil {
+		s.Status, s.Error = ratingFailed, err.Error()
+	}
+}
+
+func (t *statusTracker) get(id string) (RatingStatus, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.statuses[id]
+	if !ok || !s.done.IsZero() && time.Since(s.done) > ratingStatusTTL {
+		return RatingStatus{}, false
+	}
+	return s.RatingStatus, true
+}
+
+// acceptRating answers a rating queued with cfg.AsyncRatings: 202 Accepted
+// and where to poll for its outcome.
+func acceptRating(w http.ResponseWriter, id string) {
+	d, err := json.Marshal(RatingStatus{ID: id, Status: ratingPending})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/ratings/status/"+id)
+	w.WriteHeader(http.StatusAccepted)
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func getRatingStatus(w http.ResponseWriter, r *http.Request) {
+	status, ok := ratingStatuses.get(mux.Vars(r)["id"])
+	if !ok {
+		writeError(w, http.StatusNotFound, "rating status not found")
+		return
+	}
+	d, err := json.Marshal(status)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
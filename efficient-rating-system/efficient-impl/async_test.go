@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAsyncRatingStatus(t *testing.T) {
+	h := openTestDB(t, map[string]string{"RATING_BATCH_INTERVAL_MS": "10", "RATING_ASYNC": "true"})
+	// The ratings of user x fail when their batch is written.
+	execTest(t, `CREATE TRIGGER reject_x BEFORE INSERT ON driver_ratings WHEN NEW.user_id = 'x'
+    BEGIN SELECT RAISE(ABORT, 'rejected'); END`)
+	submit := func(userId string) string {
+		t.Helper()
+		rec := serveTest(h, "POST", "/drivers/1/ratings", `{"user_id": "`+userId+`", "rating": 4}`)
+		expectStatus(t, rec, http.StatusAccepted)
+		var status RatingStatus
+		decodeBody(t, rec, &status)
+		if status.Status != ratingPending || rec.Header().Get("Location") != "/ratings/status/"+status.ID {
+			t.Fatalf("accepted rating is %+v at %q, want it pending at its status URL", status, rec.Header().Get("Location"))
+		}
+		return rec.Header().Get("Location")
+	}
+	poll := func(location string) RatingStatus {
+		t.Helper()
+		var status RatingStatus
+		eventually(t, func() bool {
+			rec := serveTest(h, "GET", location, "")
+			expectStatus(t, rec, http.StatusOK)
+			decodeBody(t, rec, &status)
+			return status.Status != ratingPending
+		})
+		return status
+	}
+
+	if status := poll(submit("a")); status.Status != ratingSucceeded {
+		t.Fatalf("status of a valid rating is %+v, want succeeded", status)
+	}
+	if sum, count := driverAggregates(t, "1"); sum != 4 || count != 1 {
+		t.Fatalf("driver 1 has a sum of %d over %d ratings, want 4 over 1", sum, count)
+	}
+
+	status := poll(submit("x"))
+	if status.Status != ratingFailed || !strings.Contains(status.Error, "rejected") {
+		t.Fatalf("status of a rejected rating is %+v, want failed with the error", status)
+	}
+	if sum, count := driverAggregates(t, "1"); sum != 4 || count != 1 {
+		t.Fatalf("driver 1 has a sum of %d over %d ratings after a failed rating, want it unchanged", sum, count)
+	}
+	expectStatus(t, serveTest(h, "GET", "/ratings/status/unknown", ""), http.StatusNotFound)
+}
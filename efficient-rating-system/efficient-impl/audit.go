@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 100
+)
+
+// AuditEntry records who created, updated or deleted what and when. Detail
+// holds the values involved, e.g. the new and the previous rating.
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Entity    string          `json:"entity"`
+	EntityID  string          `json:"entity_id"`
+	Detail    json.RawMessage `json:"detail,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// recordAudit adds an entry to the audit log when cfg.AuditLog is set. q is
+// the transaction of the change, so that the entry is kept only with it.
+func recordAudit(q dbtx, actor, action, entity, entityId string, detail map[string]interface{}) error {
+	if !cfg.AuditLog {
+		return nil
+	}
+	var d []byte
+	if detail != nil {
+		var err error
+		if d, err = json.Marshal(detail); err != nil {
+			return err
+		}
+	}
+	_, err := q.Exec("INSERT INTO audit_log (actor, action, entity, entity_id, detail) VALUES (?, ?, ?, ?, ?)",
+		actor, action, entity, entityId, nullString(string(d)))
+	return err
+}
+
+// requestActor names who makes a request for the audit log: the subject of
+// its token when JWTs are configured, admin on the admin endpoints and for
+// callers with the admin token, and anonymous otherwise.
+func requestActor(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, "/admin/") || callerRole(r) == roleAdmin {
+		return "admin"
+	}
+	if identity != nil {
+		if sub, err := identity.subject(r); err == nil {
+			return storedUserID(sub)
+		}
+	}
+	return "anonymous"
+}
+
+func getAuditLog(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListParams(r, listOptions{DefaultLimit: defaultAuditLimit, MaxLimit: maxAuditLimit})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	list, err := getAuditEntries(params.Limit, params.Offset)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	for i := range list {
+		showAuditUserIDs(r, &list[i])
+	}
+	d, err := json.Marshal(list)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// showAuditUserIDs replaces the stored user ids in the actor and entity id of
+// the entry by shownUserID.
+func showAuditUserIDs(r *http.Request, entry *AuditEntry) {
+	entry.Actor = shownUserID(r, entry.Actor)
+	switch entry.Entity {
+	case "rating":
+		if driverId, userId, ok := strings.Cut(entry.EntityID, "/"); ok {
+			entry.EntityID = driverId + "/" + shownUserID(r, userId)
+		}
+	case "user_ratings":
+		entry.EntityID = shownUserID(r, entry.EntityID)
+	}
+}
+
+// getAuditEntries returns a page of the audit log, newest first.
+func getAuditEntries(limit, offset int) ([]AuditEntry, error) {
+	row, err := srv.DB().Query(`SELECT id, actor, action, entity, entity_id, COALESCE(detail, ''), created_at
+    FROM audit_log ORDER BY id DESC LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []AuditEntry{}
+	for row.Next() {
+		var entry AuditEntry
+		var detail string
+		err = row.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.Entity, &entry.EntityID, &detail, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if detail != "" {
+			entry.Detail = json.RawMessage(detail)
+		}
+		list = append(list, entry)
+	}
+	return list, row.Err()
+}
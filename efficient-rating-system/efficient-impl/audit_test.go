@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	h := openTestDB(t, map[string]string{"ADMIN_TOKEN": "secret-token", "AUDIT_LOG": "true"})
+	rateTest(t, h, "1", "a", 3)
+	rateTest(t, h, "1", "a", 5)
+	expectStatus(t, serveTest(h, "DELETE", "/drivers/2", ""), http.StatusNoContent)
+	rec := serveTest(h, "GET", "/admin/audit?limit=2", "", adminAuth...)
+	expectStatus(t, rec, http.StatusOK)
+	var entries []AuditEntry
+	decodeBody(t, rec, &entries)
+	if len(entries) != 2 || entries[0].Action != "delete" || entries[0].EntityID != "2" ||
+		entries[1].Action != "update" || entries[1].Entity != "rating" || entries[1].EntityID != "1/a" || entries[1].Actor != "a" {
+		t.Fatalf("newest audit entries are %+v, want the delete of driver 2 then the update of the rating of a", entries)
+	}
+	if got, want := string(entries[1].Detail), `{"previous_rating":3,"rating":5}`; got != want {
+		t.Fatalf("detail of the update is %s, want %s", got, want)
+	}
+	rec = serveTest(h, "GET", "/admin/audit?offset=2", "", adminAuth...)
+	expectStatus(t, rec, http.StatusOK)
+	entries = nil
+	decodeBody(t, rec, &entries)
+	if len(entries) != 1 || entries[0].Action != "create" || entries[0].Entity != "rating" {
+		t.Fatalf("oldest audit entries are %+v, want the first rating", entries)
+	}
+}
+
+func TestAuditLogOff(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 3)
+	var count int
+	if err := srv.DB().QueryRow("SELECT COUNT(*) FROM audit_log").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("%d audit entries without AUDIT_LOG, want none", count)
+	}
+}
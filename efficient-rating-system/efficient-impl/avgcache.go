@@ -0,0 +1,252 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// averageCache holds the drivers GET /drivers/{driver_id} returns without
+// exclude_user or window, it is nil unless cfg.AverageCacheSize is set or
+// cfg.AverageCacheBackend is redis. Every change to a driver or its
+// aggregates goes through forget or purge once it is committed, the rating
+// writes refresh the cached driver right away.
+var averageCache *driverCache
+
+// driverCache caches drivers by id in its backend, and keeps the writes
+// racing with a read from storing a driver that is already outdated. Its
+// methods do nothing on a nil cache.
+type driverCache struct {
+	backend cacheBackend
+	// mu orders the stores with the invalidations.
+	mu sync.Mutex
+	// generation counts the invalidations, a driver read before one of them
+	// is not stored.
+	generation   uint64
+	hits, misses atomic.Int64
+}
+
+// cacheBackend holds the drivers of a driverCache: lruCache in the process,
+// or redisCache shared by the instances with AVERAGE_CACHE_BACKEND=redis.
+// set, remove and clear are called with the lock of the driverCache held.
+type cacheBackend interface {
+	get(driverId string) (*Driver, error)
+	set(driver Driver) error
+	remove(ids ...string) error
+	clear() error
+	// size returns how many drivers are stored and how many can be, 0 when
+	// the backend evicts them itself.
+	size() (int, int, error)
+}
+
+func newDriverCache(backend cacheBackend) *driverCache {
+	return &driverCache{backend: backend}
+}
+
+// CacheStats is the response of GET /admin/cache.
+type CacheStats struct {
+	Backend  string `json:"backend"`
+	Size     int    `json:"size"`
+	Capacity int    `json:"capacity"`
+	Hits     int64  `json:"hits"`
+	Misses   int64  `json:"misses"`
+}
+
+// get returns the cached driver, and otherwise the generation to store it
+// with once it is read. A backend that fails counts as a miss.
+func (c *driverCache) get(driverId string) (*Driver, uint64) {
+	c.mu.Lock()
+	generation := c.generation
+	c.mu.Unlock()
+	driver, err := c.backend.get(driverId)
+	if err != nil {
+		log.Println("average cache:", err)
+	}
+	if driver != nil {
+		c.hits.Add(1)
+		return driver, 0
+	}
+	c.misses.Add(1)
+	return nil, generation
+}
+
+// put stores a driver read at the given generation, unless something was
+// invalidated since.
+func (c *driverCache) put(driver Driver, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if err := c.backend.set(driver); err != nil {
+		log.Println("average cache:", err)
+	}
+}
+
+// forget drops the drivers from the cache.
+func (c *driverCache) forget(ids ...string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if err := c.backend.remove(ids...); err != nil {
+		log.Println("average cache:", err)
+	}
+}
+
+// purge empties the cache, for changes touching many drivers at once.
+func (c *driverCache) purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if err := c.backend.clear(); err != nil {
+		log.Println("average cache:", err)
+	}
+}
+
+// refresh reads a cached driver again after a write, so that it stays
+// cached. Failing to is only logged, the driver is then dropped.
+func (c *driverCache) refresh(driverId string) {
+	if c == nil {
+		return
+	}
+	c.forget(driverId)
+	c.mu.Lock()
+	generation := c.generation
+	c.mu.Unlock()
+	driver, err := readDriver(driverId, driverRead{})
+	if err != nil {
+		log.Println("average cache:", err)
+		return
+	}
+	if driver != nil {
+		c.put(*driver, generation)
+	}
+}
+
+func (c *driverCache) stats() (CacheStats, error) {
+	size, capacity, err := c.backend.size()
+	return CacheStats{
+		Backend:  cfg.AverageCacheBackend,
+		Size:     size,
+		Capacity: capacity,
+		Hits:     c.hits.Load(),
+		Misses:   c.misses.Load(),
+	}, err
+}
+
+// cachedDriver is getDriverByID without exclude_user and window, served
+// from averageCache when it can be. The smart average and a bayesian one
+// with the global prior change without any write to the driver, they are
+// never cached.
+func cachedDriver(driverId string) (*Driver, error) {
+	if averageCache == nil || cfg.AggFunction == avgSmart || cfg.AggFunction == avgBayesian && cfg.PriorFromGlobal {
+		return readDriver(driverId, driverRead{})
+	}
+	driver, generation := averageCache.get(driverId)
+	if driver != nil {
+		return driver, nil
+	}
+	driver, err := readDriver(driverId, driverRead{})
+	if err == nil && driver != nil {
+		averageCache.put(*driver, generation)
+	}
+	return driver, err
+}
+
+func getCacheStats(w http.ResponseWriter, r *http.Request) {
+	if averageCache == nil {
+		writeError(w, http.StatusNotFound, "the average cache is not enabled")
+		return
+	}
+	stats, err := averageCache.stats()
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(stats)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// lruCache is the cacheBackend in the process, the least recently used
+// driver goes first once it holds capacity drivers.
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // most recently used first, of Driver
+}
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{capacity: capacity, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+func (c *lruCache) get(driverId string) (*Driver, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[driverId]
+	if !ok {
+		return nil, nil
+	}
+	c.order.MoveToFront(e)
+	driver := e.Value.(Driver)
+	return &driver, nil
+}
+
+func (c *lruCache) set(driver Driver) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[driver.ID]; ok {
+		e.Value = driver
+		c.order.MoveToFront(e)
+		return nil
+	}
+	c.entries[driver.ID] = c.order.PushFront(driver)
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(Driver).ID)
+	}
+	return nil
+}
+
+func (c *lruCache) remove(ids ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, driverId := range ids {
+		if e, ok := c.entries[driverId]; ok {
+			c.order.Remove(e)
+			delete(c.entries, driverId)
+		}
+	}
+	return nil
+}
+
+func (c *lruCache) clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]*list.Element{}
+	c.order.Init()
+	return nil
+}
+
+func (c *lruCache) size() (int, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len(), c.capacity, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisCacheKeys prefixes the keys of the cached drivers, followed by their
+// id.
+const redisCacheKeys = "articles:driver:"
+
+// redisCache is the cacheBackend of AVERAGE_CACHE_BACKEND=redis, shared by
+// the instances of the service: a driver one of them forgets is forgotten by
+// all. The drivers are stored as JSON and expire after ttl, which bounds how
+// long a driver read by one instance while another one wrote it can stay.
+type redisCache struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func newRedisCache(url string, ttl time.Duration) (*redisCache, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return &redisCache{client: redis.NewClient(opts), ttl: ttl}, nil
+}
+
+func (c *redisCache) get(driverId string) (*Driver, error) {
+	d, err := c.client.Get(context.Background(), redisCacheKeys+driverId).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var driver Driver
+	if err = json.Unmarshal(d, &driver); err != nil {
+		return nil, err
+	}
+	return &driver, nil
+}
+
+func (c *redisCache) set(driver Driver) error {
+	d, err := json.Marshal(driver)
+	if err != nil {
+		return err
+	}
+	return c.client.Set(context.Background(), redisCacheKeys+driver.ID, d, c.ttl).Err()
+}
+
+func (c *redisCache) remove(ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	keys := make([]string, len(ids))
+	for i, driverId := range ids {
+		keys[i] = redisCacheKeys + driverId
+	}
+	return c.client.Del(context.Background(), keys...).Err()
+}
+
+// clear deletes the cached drivers a scan finds, the other keys of the
+// database are left alone.
+func (c *redisCache) clear() error {
+	ctx := context.Background()
+	iter := c.client.Scan(ctx, 0, redisCacheKeys+"*", 1000).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil || len(keys) == 0 {
+		return err
+	}
+	return c.client.Del(ctx, keys...).Err()
+}
+
+// size counts the cached drivers, Redis evicts them itself.
+func (c *redisCache) size() (int, int, error) {
+	ctx := context.Background()
+	iter := c.client.Scan(ctx, 0, redisCacheKeys+"*", 1000).Iterator()
+	n := 0
+	for iter.Next(ctx) {
+		n++
+	}
+	return n, 0, iter.Err()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// testAverageCache checks that the drivers are served from the cache h was
+// opened with, and that the ratings write through it.
+func testAverageCache(t *testing.T, h http.Handler) {
+	rateTest(t, h, "1", "a", 4)
+	readAverage := func(want float64) {
+		t.Helper()
+		rec := serveTest(h, "GET", "/drivers/1", "")
+		expectStatus(t, rec, http.StatusOK)
+		var driver Driver
+		decodeBody(t, rec, &driver)
+		if driver.AverageRating != want {
+			t.Fatalf("average is %v, want %v", driver.AverageRating, want)
+		}
+	}
+	readAverage(4)
+	// Changed behind the service's back, the cached driver is served.
+	execTest(t, "UPDATE drivers SET rating_sum = 1 WHERE id = 1")
+	readAverage(4)
+	// A rating refreshes the cached driver once written.
+	rateTest(t, h, "1", "b", 2)
+	readAverage(1.5)
+	stats, err := averageCache.stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Hits != 3 || stats.Misses != 0 || stats.Size != 1 || stats.Backend != cfg.AverageCacheBackend {
+		t.Fatalf("stats are %+v, want 3 hits, no miss and 1 driver", stats)
+	}
+	averageCache.purge()
+	readAverage(1.5)
+	if stats, _ = averageCache.stats(); stats.Misses != 1 {
+		t.Fatalf("stats after a purge are %+v, want a miss", stats)
+	}
+}
+
+func TestMemoryAverageCache(t *testing.T) {
+	h := openTestDB(t, map[string]string{"AVERAGE_CACHE_SIZE": "2"})
+	testAverageCache(t, h)
+	for _, id := range []string{"2", "3"} {
+		expectStatus(t, serveTest(h, "GET", "/drivers/"+id, ""), http.StatusOK)
+	}
+	// Driver 1 was used least recently.
+	if d, _ := averageCache.backend.get("1"); d != nil {
+		t.Fatal("driver 1 is still cached, want it evicted")
+	}
+	if stats, _ := averageCache.stats(); stats.Size != 2 || stats.Capacity != 2 {
+		t.Fatalf("stats are %+v, want 2 drivers out of 2", stats)
+	}
+}
+
+func TestRedisAverageCache(t *testing.T) {
+	redis := miniredis.RunT(t)
+	redis.Set("other", "kept")
+	h := openTestDB(t, map[string]string{
+		"AVERAGE_CACHE_BACKEND": "redis",
+		"REDIS_URL":             "redis://" + redis.Addr(),
+		"AVERAGE_CACHE_TTL_MS":  "60000",
+	})
+	testAverageCache(t, h)
+	if !redis.Exists(redisCacheKeys+"1") || !redis.Exists("other") {
+		t.Fatalf("redis has %v, want driver 1 and the other key", redis.Keys())
+	}
+	if ttl := redis.TTL(redisCacheKeys + "1"); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("driver 1 expires in %s, want at most a minute", ttl)
+	}
+	// Another instance forgetting the driver drops it for this one too.
+	redis.Del(redisCacheKeys + "1")
+	execTest(t, "UPDATE drivers SET rating_sum = 8 WHERE id = 1")
+	rec := serveTest(h, "GET", "/drivers/1", "")
+	var driver Driver
+	decodeBody(t, rec, &driver)
+	if driver.AverageRating != 4 {
+		t.Fatalf("average is %v, want 4 read from the database", driver.AverageRating)
+	}
+	// Redis going away makes the reads miss, they are still served.
+	redis.Close()
+	expectStatus(t, serveTest(h, "GET", "/drivers/1", ""), http.StatusOK)
+}
+
+func TestAverageCacheBackendConfig(t *testing.T) {
+	defer func() { getenv = os.Getenv }()
+	for _, env := range []map[string]string{
+		{"AVERAGE_CACHE_BACKEND": "memcached"},
+		{"AVERAGE_CACHE_BACKEND": "redis"},
+		{"AVERAGE_CACHE_BACKEND": "redis", "REDIS_URL": "redis://localhost:6379", "AVERAGE_CACHE_SIZE": "10"},
+	} {
+		getenv = func(name string) string { return env[name] }
+		if _, err := loadConfig(); err == nil {
+			t.Fatalf("config of %v loaded, want an error", env)
+		}
+	}
+}
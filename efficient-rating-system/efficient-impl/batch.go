@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	// maxBatchRatings is the most ratings POST /ratings/batch takes at once,
+	// larger backfills go through the streaming import.
+	maxBatchRatings = 10000
+	// batchInsertRows is the number of rows of one multi-row INSERT, 7
+	// variables each stay well below SQLite's limit.
+	batchInsertRows = 100
+)
+
+const (
+	batchStored = "stored"
+	batchFailed = "failed"
+)
+
+// BatchResult is the outcome of the rating at Index of a batch.
+type BatchResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type BatchSummary struct {
+	Stored  int           `json:"stored"`
+	Failed  int           `json:"failed"`
+	Results []BatchResult `json:"results"`
+}
+
+// batchItem is a rating of a batch that passed validation.
+type batchItem struct {
+	index int
+	Rating
+}
+
+type ratingKey struct {
+	driverId string
+	userId   string
+}
+
+// postRatingsBatch stores a JSON array of {"driver_id", "user_id", "rating",
+// ...} objects, or an NDJSON body of them with Content-Type
+// application/x-ndjson, in a single transaction. The ratings are written with
+// multi-row upserts and the aggregates of every driver are updated once. An
+// invalid rating, or one POST /drivers/{driver_id}/ratings would refuse for
+// its driver, fails alone and is reported in its result, the others are
+// stored.
+func postRatingsBatch(w http.ResponseWriter, r *http.Request) {
+	raw, err := readBatch(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(raw) > maxBatchRatings {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("a batch has at most %d ratings", maxBatchRatings))
+		return
+	}
+	summary := BatchSummary{Results: make([]BatchResult, len(raw))}
+	items := make([]batchItem, 0, len(raw))
+	for i, d := range raw {
+		summary.Results[i] = BatchResult{Index: i, Status: batchStored}
+		rating, err := parseBatchRating(d)
+		if err != nil {
+			summary.Results[i].Status, summary.Results[i].Error = batchFailed, err.Error()
+			continue
+		}
+		items = append(items, batchItem{i, rating})
+	}
+	valid, err := ratableItems(entityType(r), items, summary.Results)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if err = storeBatch(valid); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	for _, result := range summary.Results {
+		if result.Status == batchStored {
+			summary.Stored++
+		} else {
+			summary.Failed++
+		}
+	}
+	log.Printf("batch: %d ratings stored, %d failed", summary.Stored, summary.Failed)
+	d, err := json.Marshal(summary)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// readBatch returns the ratings of the body undecoded, so that one that
+// isn't a rating fails on its own. Blank NDJSON lines are skipped.
+func readBatch(r *http.Request) ([]json.RawMessage, error) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-ndjson") {
+		var raw []json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			return nil, errors.New("body must be a JSON array of ratings")
+		}
+		return raw, nil
+	}
+	var raw []json.RawMessage
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 4096), maxImportLine)
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if len(raw) == maxBatchRatings {
+			// One more is enough for the caller to refuse the batch.
+			return append(raw, nil), nil
+		}
+		raw = append(raw, json.RawMessage(text))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading the body: %v", err)
+	}
+	return raw, nil
+}
+
+func parseBatchRating(d json.RawMessage) (Rating, error) {
+	var rating Rating
+	if err := json.Unmarshal(d, &rating); err != nil {
+		return rating, errors.New("invalid JSON")
+	}
+	if rating.DriverID == "" || rating.UserID == "" {
+		return rating, errors.New("driver_id and user_id are required")
+	}
+	return rating, validateRating(rating)
+}
+
+// ratableItems returns the items whose driver can be rated by their user,
+// with the user ids as stored. The others fail in results with the error of
+// ratableDriver, which runs once per driver.
+func ratableItems(entityType string, items []batchItem, results []BatchResult) ([]batchItem, error) {
+	type check struct {
+		owner string
+		err   error
+	}
+	checks := map[string]check{}
+	valid := items[:0]
+	for _, item := range items {
+		c, ok := checks[item.DriverID]
+		if !ok {
+			c.owner, c.err = ratableDriver(entityType, item.DriverID)
+			var serr *serviceError
+			if c.err != nil && !errors.As(c.err, &serr) {
+				return nil, c.err
+			}
+			checks[item.DriverID] = c
+		}
+		err := c.err
+		if err == nil && c.owner != "" && c.owner == item.UserID {
+			err = errSelfRating
+		}
+		if err != nil {
+			results[item.index].Status, results[item.index].Error = batchFailed, err.Error()
+			continue
+		}
+		item.UserID = storedUserID(item.UserID)
+		valid = append(valid, item)
+	}
+	return valid, nil
+}
+
+// storeBatch writes the items in one transaction. When a user rates the same
+// driver more than once, the last rating is the one written, as if they had
+// been posted one after the other.
+func storeBatch(items []batchItem) error {
+	last := map[ratingKey]int{}
+	for i, item := range items {
+		last[ratingKey{item.DriverID, item.UserID}] = i
+	}
+	unique := make([]Rating, 0, len(last))
+	for i, item := range items {
+		if last[ratingKey{item.DriverID, item.UserID}] == i {
+			unique = append(unique, item.Rating)
+		}
+	}
+	tx, err := srv.DB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	prev := map[ratingKey]sql.NullInt64{}
+	for start := 0; start < len(unique); start += batchInsertRows {
+		if err = upsertRatingRows(tx, unique[start:batchEnd(start, len(unique))], prev); err != nil {
+			return err
+		}
+	}
+	type change struct{ delta, added int64 }
+	changes := map[string]*change{}
+	var drivers []string
+	for _, r := range unique {
+		key := ratingKey{r.DriverID, r.UserID}
+		p := prev[key]
+		if !p.Valid && cfg.ArchiveKeepAverage {
+			if p, err = unarchiveRating(tx, r.DriverID, r.UserID); err != nil {
+				return err
+			}
+		}
+		c := changes[r.DriverID]
+		if c == nil {
+			c = &change{}
+			changes[r.DriverID] = c
+			drivers = append(drivers, r.DriverID)
+		}
+		action, detail := "create", map[string]interface{}{"rating": r.Rating, "batch": true}
+		if p.Valid {
+			c.delta += int64(r.Rating) - p.Int64
+			action, detail["previous_rating"] = "update", p.Int64
+		} else {
+			c.delta, c.added = c.delta+int64(r.Rating), c.added+1
+		}
+		if err = recordAudit(tx, r.UserID, action, "rating", r.DriverID+"/"+r.UserID, detail); err != nil {
+			return err
+		}
+		if err = events.record(tx, r); err != nil {
+			return err
+		}
+	}
+	// Every submission is logged, also the ones a later one of the batch
+	// replaced.
+	for start := 0; start < len(items); start += batchInsertRows {
+		group := items[start:batchEnd(start, len(items))]
+		args := make([]interface{}, 0, 3*len(group))
+		for _, item := range group {
+			args = append(args, item.DriverID, item.UserID, item.Rating.Rating)
+		}
+		values := strings.TrimSuffix(strings.Repeat("(?, ?, ?), ", len(group)), ", ")
+		if _, err = tx.Exec("INSERT INTO rating_events (driver_id, user_id, rating) VALUES "+values, args...); err != nil {
+			return err
+		}
+	}
+	for _, id := range drivers {
+		if lazy != nil {
+			lazy.markStale(id)
+			continue
+		}
+		if aggregates != nil {
+			continue
+		}
+		_, err = tx.Exec("UPDATE drivers SET rating_sum = rating_sum + ?, rating_count = rating_count + ? WHERE id = ?", changes[id].delta, changes[id].added, id)
+		if err != nil {
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	for _, id := range drivers {
+		// Only committed ratings may reach the aggregates.
+		if aggregates != nil && lazy == nil {
+			aggregates.add(id, changes[id].delta, changes[id].added)
+		}
+		averageCache.forget(id)
+	}
+	events.notify()
+	metrics.ratingsStored(len(items))
+	return nil
+}
+
+// batchEnd is the end of the group of rows starting at start, out of n.
+func batchEnd(start, n int) int {
+	if start+batchInsertRows < n {
+		return start + batchInsertRows
+	}
+	return n
+}
+
+// upsertRatingRows writes the ratings with one statement, like upsertRating
+// does one, and adds the rating each replaced to prev. The ratings must be of
+// distinct users and drivers.
+func upsertRatingRows(tx *sql.Tx, ratings []Rating, prev map[ratingKey]sql.NullInt64) error {
+	args := make([]interface{}, 0, 7*len(ratings))
+	for _, r := range ratings {
+		args = append(args, r.DriverID, r.UserID, r.Rating, nullString(r.Source), nullString(r.Comment), r.Tags, nullString(r.Region))
+	}
+	values := strings.TrimSuffix(strings.Repeat("(?, ?, ?, ?, ?, ?, ?), ", len(ratings)), ", ")
+	row, err := tx.Query(`INSERT INTO driver_ratings (driver_id, user_id, rating, source, comment, tags, region) VALUES `+values+`
+    ON CONFLICT(driver_id, user_id) DO UPDATE SET`+ratingUpdateSet+`
+    RETURNING driver_id, user_id, prev_rating`, args...)
+	if err != nil {
+		return err
+	}
+	defer row.Close()
+	for row.Next() {
+		var key ratingKey
+		var p sql.NullInt64
+		if err = row.Scan(&key.driverId, &key.userId, &p); err != nil {
+			return err
+		}
+		prev[key] = p
+	}
+	return row.Err()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestBatchItemsFailLikeSingleRatings checks that the items of a batch fail
+// for the reason POST /drivers/{driver_id}/ratings gives, and that the others
+// reach the aggregates and the outbox.
+func TestBatchItemsFailLikeSingleRatings(t *testing.T) {
+	h := openTestDB(t, map[string]string{"ADMIN_TOKEN": "secret-token", "SELF_RATING_FIELD": "user_id"})
+	execTest(t, `UPDATE drivers SET driver_info = '{"user_id": "ann"}' WHERE id = 1`)
+	expectStatus(t, serveTest(h, "PUT", "/admin/drivers/2/status", `{"status": "suspended"}`, adminAuth...), http.StatusOK)
+	execTest(t, "UPDATE drivers SET deleted_at = CURRENT_TIMESTAMP WHERE id = 3")
+	rec := serveTest(h, "POST", "/entities/restaurant", `{"driver_info": {"name": "Bistro"}}`)
+	expectStatus(t, rec, http.StatusCreated)
+	var restaurant Driver
+	decodeBody(t, rec, &restaurant)
+	rateTest(t, h, "1", "carl", 5)
+
+	stub := &stubPublisher{}
+	// The relay isn't started, the outbox keeps the events.
+	events = &eventRelay{publisher: stub, wake: make(chan struct{}, 1)}
+	defer func() { events = nil }()
+	rec = serveTest(h, "POST", "/ratings/batch", `[
+		{"driver_id": "1", "user_id": "bob", "rating": 4},
+		"not a rating",
+		{"driver_id": "1", "user_id": "bob", "rating": 9},
+		{"driver_id": "404", "user_id": "bob", "rating": 3},
+		{"driver_id": "2", "user_id": "bob", "rating": 3},
+		{"driver_id": "3", "user_id": "bob", "rating": 3},
+		{"driver_id": "1", "user_id": "ann", "rating": 5},
+		{"driver_id": "`+restaurant.ID+`", "user_id": "bob", "rating": 3},
+		{"driver_id": "1", "user_id": "carl", "rating": 2},
+		{"driver_id": "4", "user_id": "ann", "rating": 5}
+	]`, adminAuth...)
+	expectStatus(t, rec, http.StatusOK)
+	var summary BatchSummary
+	decodeBody(t, rec, &summary)
+	if summary.Stored != 3 || summary.Failed != 7 || len(summary.Results) != 10 {
+		t.Fatalf("summary is %+v, want 3 stored and 7 failed", summary)
+	}
+	for _, i := range []int{0, 8, 9} {
+		if summary.Results[i].Status != batchStored {
+			t.Errorf("item %d is %+v, want stored", i, summary.Results[i])
+		}
+	}
+	for i, want := range map[int]string{1: "invalid JSON", 2: "rating must be between 1 and 5"} {
+		if got := summary.Results[i]; got.Status != batchFailed || got.Error != want {
+			t.Errorf("item %d is %+v, want failed with %q", i, got, want)
+		}
+	}
+	// The same ratings posted one at a time.
+	for i, single := range map[int]struct{ driverId, body string }{
+		3: {"404", `{"user_id": "bob", "rating": 3}`},
+		4: {"2", `{"user_id": "bob", "rating": 3}`},
+		5: {"3", `{"user_id": "bob", "rating": 3}`},
+		6: {"1", `{"user_id": "ann", "rating": 5}`},
+		7: {restaurant.ID, `{"user_id": "bob", "rating": 3}`},
+	} {
+		rec := serveTest(h, "POST", "/drivers/"+single.driverId+"/ratings", single.body)
+		if rec.Code < 400 {
+			t.Fatalf("rating driver %s alone gave %d", single.driverId, rec.Code)
+		}
+		var body map[string]string
+		decodeBody(t, rec, &body)
+		if got := summary.Results[i]; got.Status != batchFailed || got.Error != body["error"] {
+			t.Errorf("item %d is %+v, want failed with %q", i, got, body["error"])
+		}
+	}
+
+	for id, want := range map[string][2]int64{"1": {6, 2}, "2": {0, 0}, "3": {0, 0}, "4": {5, 1}, restaurant.ID: {0, 0}} {
+		if sum, count := driverAggregates(t, id); sum != want[0] || count != want[1] {
+			t.Errorf("driver %s has %d stars in %d ratings, want %d in %d", id, sum, count, want[0], want[1])
+		}
+	}
+	if n := outboxSize(t); n != 3 {
+		t.Fatalf("outbox has %d events, want one per stored rating", n)
+	}
+	if err := events.deliver(); err != nil {
+		t.Fatal(err)
+	}
+	list := stub.published()
+	if len(list) != 3 || list[1].Type != ratingUpdated || list[1].UserID != "carl" || list[1].PreviousRating == nil || *list[1].PreviousRating != 5 {
+		t.Fatalf("published %+v, want the update of carl's rating among three", list)
+	}
+}
+
+// TestBatchThroughAggregateBuffer checks that the ratings of a batch reach
+// the coalesced aggregates once committed.
+func TestBatchThroughAggregateBuffer(t *testing.T) {
+	h := openTestDB(t, map[string]string{"ADMIN_TOKEN": "secret-token", "AGGREGATE_FLUSH_INTERVAL_MS": "60000"})
+	rec := serveTest(h, "POST", "/ratings/batch", `[
+		{"driver_id": "1", "user_id": "a", "rating": 2},
+		{"driver_id": "1", "user_id": "b", "rating": 5},
+		{"driver_id": "1", "user_id": "a", "rating": 4},
+		{"driver_id": "404", "user_id": "a", "rating": 4}
+	]`, adminAuth...)
+	expectStatus(t, rec, http.StatusOK)
+	var summary BatchSummary
+	decodeBody(t, rec, &summary)
+	if summary.Stored != 3 || summary.Failed != 1 {
+		t.Fatalf("summary is %+v, want 3 stored and 1 failed", summary)
+	}
+	if _, count := driverAggregates(t, "1"); count != 0 {
+		t.Fatalf("driver 1 has %d ratings before the flush, want none", count)
+	}
+	aggregates.stop()
+	// The second rating of user a replaced the first one.
+	if sum, count := driverAggregates(t, "1"); sum != 9 || count != 2 {
+		t.Fatalf("driver 1 has %d stars in %d ratings, want 9 in 2", sum, count)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// ratedBitmapEncoding tells clients how to read RatedBitmap.Bitmap.
+const ratedBitmapEncoding = "base64 of a bitset, driver n is rated when bit n%8 (least significant first) of byte n/8 is set"
+
+// RatedBitmap tells which drivers a user rated in far less space than a list
+// of ids. Only integer ids fit a bitset, the uuid ones are listed in
+// OtherIDs.
+type RatedBitmap struct {
+	UserID   string   `json:"user_id"`
+	Encoding string   `json:"encoding"`
+	Bitmap   string   `json:"bitmap"`
+	OtherIDs []string `json:"other_ids"`
+}
+
+func getUserRatedBitmap(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	bitmap, err := getUserRatedBitmapByID(storedUserID(params["user_id"]))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	bitmap.UserID = params["user_id"]
+	d, err := json.Marshal(bitmap)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func getUserRatedBitmapByID(userId string) (*RatedBitmap, error) {
+	row, err := srv.DB().Query(`SELECT d.id, typeof(d.id) = 'integer' FROM driver_ratings r
+    JOIN drivers d ON d.id = r.driver_id AND d.deleted_at IS NULL
+    WHERE r.user_id = ?`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	var bits []byte
+	bitmap := &RatedBitmap{UserID: userId, Encoding: ratedBitmapEncoding, OtherIDs: []string{}}
+	for row.Next() {
+		var id string
+		var integer bool
+		if err = row.Scan(&id, &integer); err != nil {
+			return nil, err
+		}
+		if !integer {
+			bitmap.OtherIDs = append(bitmap.OtherIDs, id)
+			continue
+		}
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		for int64(len(bits)) <= n/8 {
+			bits = append(bits, 0)
+		}
+		bits[n/8] |= 1 << (n % 8)
+	}
+	bitmap.Bitmap = base64.StdEncoding.EncodeToString(bits)
+	return bitmap, row.Err()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestUserRatedBitmap(t *testing.T) {
+	h := openTestDB(t, map[string]string{"DRIVER_ID_TYPE": driverIDUUID})
+	rec := serveTest(h, "POST", "/drivers", `{"driver_info": {"name": "Ann"}}`)
+	expectStatus(t, rec, http.StatusCreated)
+	var other Driver
+	decodeBody(t, rec, &other)
+	for _, driver := range []string{"1", "9", "30", other.ID} {
+		rateTest(t, h, driver, "u", 4)
+	}
+	rateTest(t, h, "2", "v", 4)
+	rec = serveTest(h, "GET", "/users/u/rated-bitmap", "")
+	expectStatus(t, rec, http.StatusOK)
+	var bitmap RatedBitmap
+	decodeBody(t, rec, &bitmap)
+	bits, err := base64.StdEncoding.DecodeString(bitmap.Bitmap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := []int{}
+	for n := 0; n < len(bits)*8; n++ {
+		if bits[n/8]&(1<<(n%8)) != 0 {
+			set = append(set, n)
+		}
+	}
+	if fmt.Sprint(set) != "[1 9 30]" || len(bitmap.OtherIDs) != 1 || bitmap.OtherIDs[0] != other.ID {
+		t.Fatalf("bits %v and other ids %v are set, want 1, 9, 30 and %s", set, bitmap.OtherIDs, other.ID)
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+// sortBlended orders the drivers by blendedScore, highest first.
+const sortBlended = "blended"
+
+// blendedScore is the score of sort=blended for the drivers in alias, whose
+// average is avg_rating. It adds the average out of maxRating stars and the
+// recency of the latest rating, each times its weight of the config. The
+// recency is 1 for a rating given right now, 1/2 after BlendHalfLifeDays
+// and goes down towards 0 from there, it is 0 for unrated drivers.
+func blendedScore(alias string) (string, []interface{}) {
+	age := "julianday('now') - julianday((SELECT MAX(updated_at) FROM driver_ratings WHERE driver_id = " + alias + ".id))"
+	score := "(? * avg_rating / ? + ? * COALESCE(? / (? + MAX(" + age + ", 0)), 0))"
+	halfLife := float64(cfg.BlendHalfLifeDays)
+	return score, []interface{}{cfg.BlendRatingWeight, maxRating, cfg.BlendRecencyWeight, halfLife, halfLife}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlendedRanking(t *testing.T) {
+	h := openTestDB(t, nil)
+	daysAgo := func(n int) string { return time.Now().Add(-time.Duration(n) * 24 * time.Hour).UTC().Format(timeFormat) }
+	// Scored 0.7 * avg / 5 + 0.3 * recency: driver 1 at 4 rated 60 days
+	// ago gets 0.66, driver 2 at 4 rated now 0.86 and driver 3 at 5 rated 30
+	// days ago 0.85.
+	rateTest(t, h, "1", "a", 4)
+	rateTest(t, h, "2", "a", 4)
+	rateTest(t, h, "3", "a", 5)
+	execTest(t, "UPDATE driver_ratings SET updated_at = ? WHERE driver_id = '1'", daysAgo(60))
+	execTest(t, "UPDATE driver_ratings SET updated_at = ? WHERE driver_id = '3'", daysAgo(30))
+	rec := serveTest(h, "GET", "/drivers?sort=blended&limit=3", "")
+	expectStatus(t, rec, http.StatusOK)
+	var list []Driver
+	decodeBody(t, rec, &list)
+	ids := []string{}
+	for _, driver := range list {
+		ids = append(ids, driver.ID)
+	}
+	if got := strings.Join(ids, ","); got != "2,3,1" {
+		t.Fatalf("blended ranking is %s, want 2,3,1", got)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// chaosDelay holds every request for d before handling it. The probes are
+// left alone so that the delay does not get the service restarted. A client
+// giving up ends the wait early, and so does the deadline of the route which
+// then gets a 503.
+func chaosDelay(d time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/healthz" && r.URL.Path != "/readyz" && !holdRequest(w, r, d) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// holdRequest waits for d before r is handled. It returns false when the
+// client gave up or the deadline of the route passed in the meantime, the
+// latter is answered with a 503.
+func holdRequest(w http.ResponseWriter, r *http.Request, d time.Duration) bool {
+	t := time.NewTimer(d)
+	select {
+	case <-t.C:
+		return true
+	case <-r.Context().Done():
+		t.Stop()
+		if r.Context().Err() == context.DeadlineExceeded {
+			writeError(w, http.StatusServiceUnavailable, "request timed out")
+		}
+		return false
+	}
+}
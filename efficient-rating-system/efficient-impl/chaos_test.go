@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestChaosDelay(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	h := openTestDB(t, map[string]string{"CHAOS_DELAY_MS": "100"})
+	start := time.Now()
+	expectStatus(t, serveTest(h, "GET", "/drivers/1", ""), http.StatusOK)
+	if took := time.Since(start); took < delay {
+		t.Fatalf("the request took %v, want at least %v", took, delay)
+	}
+	start = time.Now()
+	expectStatus(t, serveTest(h, "GET", "/healthz", ""), http.StatusOK)
+	if took := time.Since(start); took >= delay {
+		t.Fatalf("the probe took %v, want it undelayed", took)
+	}
+}
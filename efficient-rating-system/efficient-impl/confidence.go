@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// avgBayesian pulls the average of drivers with few ratings towards
+// priorMean, as if every driver had cfg.PriorWeight extra ratings of that
+// value.
+const avgBayesian = "bayesian"
+
+// Confidence labels, from the fewest ratings to the most.
+var confidenceLabels = []string{"low", "medium", "high"}
+
+// bayesianAverage returns the SQL expression of the bayesian average of the
+// drivers in alias, with its arguments.
+func bayesianAverage(alias string) (string, []interface{}) {
+	return "(? * ? + " + alias + ".rating_sum) / (? + " + alias + ".rating_count)",
+		[]interface{}{priorMean(), cfg.PriorWeight, float64(cfg.PriorWeight)}
+}
+
+// globalMean caches the mean of all ratings for cfg.PriorFromGlobal.
+var globalMean struct {
+	mu       sync.Mutex
+	value    float64
+	computed time.Time
+}
+
+// priorMean returns the prior of the bayesian average: cfg.PriorMean, or with
+// cfg.PriorFromGlobal the mean of all the ratings of drivers that are not
+// deleted, computed again once it is older than cfg.PriorCacheTTL. It falls
+// back to cfg.PriorMean while there are no ratings, and to the last mean when
+// computing it fails.
+func priorMean() float64 {
+	if !cfg.PriorFromGlobal {
+		return cfg.PriorMean
+	}
+	globalMean.mu.Lock()
+	defer globalMean.mu.Unlock()
+	if !globalMean.computed.IsZero() && time.Since(globalMean.computed) < cfg.PriorCacheTTL {
+		return globalMean.value
+	}
+	var mean *float64
+	err := srv.DB().QueryRow("SELECT CAST(SUM(rating_sum) AS REAL) / SUM(rating_count) FROM drivers WHERE deleted_at IS NULL").Scan(&mean)
+	if err != nil {
+		log.Println("global mean:", err)
+		if globalMean.computed.IsZero() {
+			return cfg.PriorMean
+		}
+		return globalMean.value
+	}
+	globalMean.value = cfg.PriorMean
+	if mean != nil {
+		globalMean.value = *mean
+	}
+	globalMean.computed = time.Now()
+	return globalMean.value
+}
+
+// confidence labels a rating count with the band of cfg.ConfidenceBands it
+// falls in: below the first threshold is low, below the second medium, and
+// high from there.
+func confidence(count int) string {
+	for i, threshold := range cfg.ConfidenceBands {
+		if count < threshold {
+			return confidenceLabels[i]
+		}
+	}
+	return confidenceLabels[len(confidenceLabels)-1]
+}
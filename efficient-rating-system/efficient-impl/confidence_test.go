@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestConfidenceBands(t *testing.T) {
+	h := openTestDB(t, map[string]string{"CONFIDENCE_BANDS": "2,4"})
+	want := []string{"low", "low", "medium", "medium", "high", "high"}
+	for count, label := range want {
+		if count > 0 {
+			rateTest(t, h, "1", "u"+strconv.Itoa(count), 4)
+		}
+		rec := serveTest(h, "GET", "/drivers/1", "")
+		expectStatus(t, rec, http.StatusOK)
+		var driver Driver
+		decodeBody(t, rec, &driver)
+		if driver.Confidence != label {
+			t.Fatalf("confidence with %d ratings is %q, want %q", count, driver.Confidence, label)
+		}
+	}
+}
+
+func TestBayesianPriorFromGlobalMean(t *testing.T) {
+	h := openTestDB(t, map[string]string{"PRIOR_WEIGHT": "2", "PRIOR_FROM_GLOBAL": "true", "PRIOR_CACHE_MS": "0"})
+	average := func(driverId string) float64 {
+		t.Helper()
+		rec := serveTest(h, "GET", "/drivers/"+driverId+"?avg=bayesian", "")
+		expectStatus(t, rec, http.StatusOK)
+		var driver Driver
+		decodeBody(t, rec, &driver)
+		return driver.AverageRating
+	}
+	rateTest(t, h, "1", "a", 5)
+	if got1, got2 := average("1"), average("2"); got1 != 5 || got2 != 5 {
+		t.Fatalf("bayesian averages are %v and %v with a global mean of 5, want 5 and 5", got1, got2)
+	}
+	// The global mean drops to 3.
+	rateTest(t, h, "3", "a", 1)
+	if got1, got2 := average("1"), average("2"); got1 != 11.0/3 || got2 != 3 {
+		t.Fatalf("bayesian averages are %v and %v with a global mean of 3, want 11/3 and 3", got1, got2)
+	}
+}
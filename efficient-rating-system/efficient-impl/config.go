@@ -0,0 +1,770 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// getenv reads the settings, the tests swap it for a fixed environment.
+var getenv = os.Getenv
+
+// Config holds the settings of the service, they are read from environment
+// variables on startup. The json names are used by GET /admin/config, fields
+// tagged secret are redacted there.
+type Config struct {
+	// DBPath is the SQLite file the service starts with, ListenAddr the
+	// address it serves HTTP on and GRPCAddr the one it serves gRPC on, not
+	// at all when empty.
+	DBPath     string `json:"db_path"`
+	ListenAddr string `json:"listen_addr"`
+	GRPCAddr   string `json:"grpc_addr"`
+	// DBDriver names the database the service runs on, sqlite, postgres or
+	// mysql. DBDSN is the data source name of postgres and mysql, the SQLite
+	// file is DBPath.
+	DBDriver string `json:"db_driver"`
+	DBDSN    string `json:"db_dsn" secret:"true"`
+	// BatchInterval turns on batched rating writes when positive: ratings are
+	// queued and written in one transaction every BatchInterval, or as soon as
+	// BatchSize of them are queued.
+	BatchInterval time.Duration `json:"batch_interval"`
+	BatchSize     int           `json:"batch_size"`
+	// AsyncRatings answers batched ratings with 202 Accepted and the URL of
+	// their status instead of 200, see acceptRating.
+	AsyncRatings bool `json:"async_ratings"`
+	// DedupWindow drops a rating identical to the one the user gave the
+	// driver less than DedupWindow ago, when positive.
+	DedupWindow time.Duration `json:"dedup_window"`
+	// AggregateInterval turns on coalesced aggregate updates when positive:
+	// rating rows are written right away but the rating_sum and rating_count
+	// of drivers are only updated every AggregateInterval.
+	AggregateInterval time.Duration `json:"aggregate_interval"`
+	// AverageCacheSize turns on the cache of GET /drivers/{driver_id} when
+	// positive, it is how many drivers it holds.
+	AverageCacheSize int `json:"average_cache_size"`
+	// AverageCacheBackend is where the cache keeps the drivers, memory or
+	// redis. The redis one is at RedisURL, shared by the instances, and its
+	// drivers expire after AverageCacheTTL.
+	AverageCacheBackend string        `json:"average_cache_backend"`
+	RedisURL            string        `json:"redis_url" secret:"true"`
+	AverageCacheTTL     time.Duration `json:"average_cache_ttl"`
+	// LazyAggregateTTL turns on lazy aggregates when positive: writes don't
+	// touch the aggregates of drivers, they are computed from driver_ratings
+	// on the next read and kept for LazyAggregateTTL.
+	LazyAggregateTTL time.Duration `json:"lazy_aggregate_ttl"`
+	// TopDriversInterval is how often the ranking of GET /drivers/top is
+	// rebuilt.
+	TopDriversInterval time.Duration `json:"top_drivers_interval"`
+	// RecomputeBatchSize is how many drivers POST /admin/recompute updates
+	// per transaction.
+	RecomputeBatchSize int `json:"recompute_batch_size"`
+	// MaxRatingsPerDriver caps the ratings returned by an unpaginated
+	// GET /drivers/{driver_id}/ratings.
+	MaxRatingsPerDriver int `json:"max_ratings_per_driver"`
+	// SummaryComments caps the commented ratings GET
+	// /drivers/{driver_id}/summary returns inline.
+	SummaryComments int `json:"summary_comments"`
+	// AdminToken is the bearer token required by the /admin endpoints, they
+	// are disabled when it is empty.
+	AdminToken string `json:"admin_token" secret:"true"`
+	// RatingSources are the accepted values of the optional source of a
+	// rating, i.e. the channel it was submitted from.
+	RatingSources []string `json:"rating_sources"`
+	// RatingRegions are the accepted values of the optional region of a
+	// rating, regions are rejected when it is empty.
+	RatingRegions []string `json:"rating_regions"`
+	// RatingTags are the accepted tags of a rating, the reasons behind it.
+	RatingTags []string `json:"rating_tags"`
+	// EntityTypes are the types of entities that can be rated under
+	// /entities/{entity_type}, driver always being one of them.
+	EntityTypes []string `json:"entity_types"`
+	// SeedMode tells what to do with the demo drivers on startup when the
+	// database already has drivers: skip, replace or append.
+	SeedMode string `json:"seed_mode"`
+	// DisableSeed skips the demo drivers altogether, even on an empty
+	// database, for production deployments.
+	DisableSeed bool `json:"disable_seed"`
+	// ResetDB deletes the database on startup, the data is kept across
+	// restarts otherwise.
+	ResetDB bool `json:"reset_db"`
+	// AutoMigrate applies the pending migrations on startup, without it the
+	// service refuses to start on a schema that isn't current.
+	AutoMigrate bool `json:"auto_migrate"`
+	// AuditLog records every change made through the API in audit_log, see
+	// recordAudit.
+	AuditLog bool `json:"audit_log"`
+	// DriverIDType is the kind of id drivers created through the API get,
+	// integer or uuid. Existing drivers keep theirs.
+	DriverIDType string `json:"driver_id_type"`
+	// TrustedUsers and TrustedMinRatings define the trusted raters used by
+	// avg=trusted: users on the list, and users who rated at least
+	// TrustedMinRatings drivers when it is positive.
+	TrustedUsers      []string `json:"trusted_users"`
+	TrustedMinRatings int      `json:"trusted_min_ratings"`
+	// ChaosDelay is added to every response when positive, to let clients
+	// test their timeouts against the real service.
+	ChaosDelay time.Duration `json:"chaos_delay"`
+	// RateLimit caps the ratings a client address submits per minute when
+	// positive. RateLimitMode tells what happens nearing it: reject only
+	// rejects what is over it, throttle also delays requests by up to
+	// RateLimitMaxDelay from half of it on.
+	RateLimit         int           `json:"rate_limit"`
+	RateLimitMode     string        `json:"rate_limit_mode"`
+	RateLimitMaxDelay time.Duration `json:"rate_limit_max_delay"`
+	// UserRateLimit is how many ratings per second a user may submit when
+	// positive, with bursts of up to UserRateLimitBurst.
+	UserRateLimit      float64 `json:"user_rate_limit"`
+	UserRateLimitBurst int     `json:"user_rate_limit_burst"`
+	// SelfRatingField is the driver_info field holding the user id of the
+	// driver, when set users can't rate the driver they are.
+	SelfRatingField string `json:"self_rating_field"`
+	// AverageDecimals is the fixed number of decimals of avg_rating in the
+	// drivers JSON, -1 writes the shortest exact decimal.
+	AverageDecimals int `json:"avg_decimals"`
+	// JWTSecret (HS256) and JWTJWKSURL (RS256) verify the bearer token of
+	// rating requests, when either is set the user_id of a rating is the
+	// subject of the token instead of the one in the body.
+	JWTSecret  string `json:"jwt_secret" secret:"true"`
+	JWTJWKSURL string `json:"jwt_jwks_url"`
+	// JWTIssuer, when set, is the only iss accepted.
+	JWTIssuer string `json:"jwt_issuer"`
+	// RatingLinkSecret signs the single use rating links minted by
+	// POST /admin/rating-links, they are disabled when it is empty.
+	// RatingLinkTTL is how long a link is valid by default.
+	RatingLinkSecret string        `json:"rating_link_secret" secret:"true"`
+	RatingLinkTTL    time.Duration `json:"rating_link_ttl"`
+	// RequestTimeout is the deadline of the context of every request when
+	// positive. RouteTimeouts overrides it per route, keyed by the route's
+	// path template optionally prefixed by the method, e.g. "GET /drivers".
+	RequestTimeout time.Duration            `json:"request_timeout"`
+	RouteTimeouts  map[string]time.Duration `json:"route_timeouts"`
+	// RatingHours and RatingTimezone restrict the time of day ratings are
+	// accepted at, ratings are always accepted when RatingHours is empty.
+	RatingHours    string     `json:"rating_hours"`
+	RatingTimezone string     `json:"rating_timezone"`
+	ratingWindow   *openHours // parsed RatingHours
+	// UserIDPattern is a regular expression the whole user id of a rating
+	// has to match, any id is accepted when it is empty.
+	UserIDPattern string         `json:"user_id_pattern"`
+	userIDPattern *regexp.Regexp // compiled UserIDPattern
+	// PriorMean and PriorWeight define the prior of avg=bayesian, which is
+	// disabled while PriorWeight is 0.
+	PriorMean   float64 `json:"prior_mean"`
+	PriorWeight int     `json:"prior_weight"`
+	// PriorFromGlobal replaces PriorMean with the mean of all ratings, kept
+	// for PriorCacheTTL.
+	PriorFromGlobal bool          `json:"prior_from_global"`
+	PriorCacheTTL   time.Duration `json:"prior_cache_ttl"`
+	// BlendRatingWeight and BlendRecencyWeight weigh the average and the
+	// recency of the latest rating in sort=blended, the recency is 1/2 when
+	// the latest rating is BlendHalfLifeDays old.
+	BlendRatingWeight  float64 `json:"blend_rating_weight"`
+	BlendRecencyWeight float64 `json:"blend_recency_weight"`
+	BlendHalfLifeDays  int     `json:"blend_half_life_days"`
+	// SmartHalfLifeDays is the age at which a rating weighs 1/2 in
+	// avg=smart.
+	SmartHalfLifeDays int `json:"smart_half_life_days"`
+	// DecayHalfLifeDays is the age at which a rating weighs 1/2 in the
+	// decayed average.
+	DecayHalfLifeDays int `json:"decay_half_life_days"`
+	// ConfidenceBands are the two rating counts at which the confidence of
+	// an average goes from low to medium and from medium to high.
+	ConfidenceBands []int `json:"confidence_bands"`
+	// TrustScoreWeights weigh the count, recency, consistency and diversity
+	// components of the trust score, see trustScore.
+	TrustScoreWeights []float64 `json:"trust_score_weights"`
+	// AggFunction is what avg_rating is by default: mean, median, trimmed,
+	// bayesian or decayed. TrimPercent is the share of ratings the trimmed mean drops
+	// at each end.
+	AggFunction string `json:"agg_function"`
+	TrimPercent int    `json:"trim_percent"`
+	// TieBreak orders drivers with the same average in rankings, see
+	// tieBreak.
+	TieBreak string `json:"tie_break"`
+	// BucketUnrated adds the drivers without ratings to the star buckets of
+	// GET /stats/driver-buckets as "unrated", they are left out otherwise.
+	BucketUnrated bool `json:"bucket_unrated"`
+	// PaginationLinks adds the first, prev, next and last pages to the Link
+	// header of GET /drivers, at the cost of counting the drivers.
+	PaginationLinks bool `json:"pagination_links"`
+	// AllowedOrigins are the origins browsers may call the API from, see
+	// originAllowed for the accepted forms.
+	AllowedOrigins []string `json:"allowed_origins"`
+	// EventsBroker is where rating events are published to, nats or empty
+	// for nowhere. EventsURL is the address of the broker and EventsSubject
+	// the subject the events are published on.
+	EventsBroker  string `json:"events_broker"`
+	EventsURL     string `json:"events_url"`
+	EventsSubject string `json:"events_subject"`
+	// AccessLog logs every request as a line of JSON, see observe.
+	AccessLog bool `json:"access_log"`
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout are the
+	// timeouts of the http.Server, 0 means none.
+	ReadHeaderTimeout time.Duration `json:"read_header_timeout"`
+	ReadTimeout       time.Duration `json:"read_timeout"`
+	WriteTimeout      time.Duration `json:"write_timeout"`
+	IdleTimeout       time.Duration `json:"idle_timeout"`
+	// DrainTimeout is how long requests in flight may take to finish on
+	// shutdown before they are cancelled.
+	DrainTimeout time.Duration `json:"drain_timeout"`
+	// ArchiveAfter turns on archiving when positive: ratings not updated for
+	// ArchiveAfter are moved to archived_ratings. They keep counting in the
+	// stored aggregates when ArchiveKeepAverage is set.
+	ArchiveAfter       time.Duration `json:"archive_after"`
+	ArchiveKeepAverage bool          `json:"archive_keep_average"`
+	// RatingEventLog makes rating_events the source of truth: deletions are
+	// logged too, and the aggregates are computed from the latest event of
+	// every user. driver_ratings is kept as the current state for the
+	// other reads.
+	RatingEventLog bool `json:"rating_event_log"`
+	// UserIDKey turns on encryption of the user ids at rest when not empty,
+	// see userIDCipher.
+	UserIDKey string `json:"user_id_key" secret:"true"`
+	// SQLDebug logs every SQL statement with its arguments, for development.
+	SQLDebug bool `json:"sql_debug"`
+}
+
+// redactedValue replaces the value of secret settings that are set.
+const redactedValue = "[REDACTED]"
+
+// Redacted returns the settings keyed by their json name, with secrets
+// replaced by redactedValue and durations written like "1.5s".
+func (c Config) Redacted() map[string]interface{} {
+	v := reflect.ValueOf(c)
+	t := v.Type()
+	out := make(map[string]interface{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		value := v.Field(i).Interface()
+		switch d := value.(type) {
+		case time.Duration:
+			value = d.String()
+		case map[string]time.Duration:
+			m := make(map[string]string, len(d))
+			for k, dd := range d {
+				m[k] = dd.String()
+			}
+			value = m
+		}
+		if field.Tag.Get("secret") == "true" && !v.Field(i).IsZero() {
+			value = redactedValue
+		}
+		out[name] = value
+	}
+	return out
+}
+
+const (
+	dbDriverSQLite   = "sqlite"
+	dbDriverPostgres = "postgres"
+	dbDriverMySQL    = "mysql"
+)
+
+// The values of AVERAGE_CACHE_BACKEND.
+const (
+	cacheMemory = "memory"
+	cacheRedis  = "redis"
+)
+
+// sqliteOnlySettings change how ratings are written or read, and only the
+// SQLite storage implements them. With another DB_DRIVER the service only
+// serves the core routes, see newCoreRouter, and refuses them on startup.
+var sqliteOnlySettings = []string{
+	"RATING_BATCH_INTERVAL_MS", "AGGREGATE_FLUSH_INTERVAL_MS", "LAZY_AGGREGATES_TTL_MS",
+	"AVERAGE_CACHE_SIZE", "RATING_DEDUP_WINDOW_MS", "AUDIT_LOG", "SELF_RATING_FIELD",
+	"JWT_SECRET", "JWT_JWKS_URL", "RATING_LINK_SECRET", "EVENTS_BROKER",
+	"RATING_ARCHIVE_AFTER_DAYS", "RATING_EVENT_LOG", "USER_ID_KEY", "AGG_FUNCTION",
+	"GRPC_ADDR", "AVERAGE_CACHE_BACKEND",
+}
+
+func loadConfig() (Config, error) {
+	c := Config{
+		DBPath:     envString("DB_PATH", defaultDBPath),
+		ListenAddr: envString("LISTEN_ADDR", defaultListenAddr),
+		GRPCAddr:   getenv("GRPC_ADDR"),
+	}
+	c.DBDriver = envString("DB_DRIVER", dbDriverSQLite)
+	switch c.DBDriver {
+	case dbDriverSQLite:
+	case dbDriverPostgres, dbDriverMySQL:
+		c.DBDSN = getenv("DB_DSN")
+		if c.DBDSN == "" {
+			return c, fmt.Errorf("DB_DRIVER=%s needs DB_DSN", c.DBDriver)
+		}
+		// Most queries are written in SQLite's dialect (julianday,
+		// json_valid, rowid), only the Storage interface is ported.
+		for _, name := range sqliteOnlySettings {
+			if getenv(name) != "" {
+				return c, fmt.Errorf("%s needs DB_DRIVER=%s", name, dbDriverSQLite)
+			}
+		}
+	default:
+		return c, fmt.Errorf("DB_DRIVER must be %s, %s or %s", dbDriverSQLite, dbDriverPostgres, dbDriverMySQL)
+	}
+	batchMs, err := envInt("RATING_BATCH_INTERVAL_MS", 0)
+	if err != nil {
+		return c, err
+	}
+	c.BatchInterval = time.Duration(batchMs) * time.Millisecond
+	c.AsyncRatings, err = envBool("RATING_ASYNC", false)
+	if err != nil {
+		return c, err
+	}
+	if c.AsyncRatings && c.BatchInterval <= 0 {
+		return c, fmt.Errorf("RATING_ASYNC needs RATING_BATCH_INTERVAL_MS")
+	}
+	aggregateMs, err := envInt("AGGREGATE_FLUSH_INTERVAL_MS", 0)
+	if err != nil {
+		return c, err
+	}
+	c.AggregateInterval = time.Duration(aggregateMs) * time.Millisecond
+	lazyMs, err := envInt("LAZY_AGGREGATES_TTL_MS", 0)
+	if err != nil {
+		return c, err
+	}
+	c.LazyAggregateTTL = time.Duration(lazyMs) * time.Millisecond
+	if c.LazyAggregateTTL > 0 && c.AggregateInterval > 0 {
+		return c, fmt.Errorf("LAZY_AGGREGATES_TTL_MS and AGGREGATE_FLUSH_INTERVAL_MS can't be combined")
+	}
+	c.AverageCacheSize, err = envInt("AVERAGE_CACHE_SIZE", 0)
+	if err != nil {
+		return c, err
+	}
+	if c.AverageCacheSize < 0 {
+		return c, fmt.Errorf("AVERAGE_CACHE_SIZE must not be negative")
+	}
+	c.AverageCacheBackend = envString("AVERAGE_CACHE_BACKEND", cacheMemory)
+	switch c.AverageCacheBackend {
+	case cacheMemory:
+	case cacheRedis:
+		if c.AverageCacheSize > 0 {
+			return c, fmt.Errorf("AVERAGE_CACHE_SIZE is for AVERAGE_CACHE_BACKEND=%s, Redis evicts the drivers itself", cacheMemory)
+		}
+		c.RedisURL = getenv("REDIS_URL")
+		if _, err = redis.ParseURL(c.RedisURL); err != nil {
+			return c, fmt.Errorf("AVERAGE_CACHE_BACKEND=%s needs a REDIS_URL like redis://localhost:6379/0: %w", cacheRedis, err)
+		}
+		cacheMs, err := envInt("AVERAGE_CACHE_TTL_MS", 60000)
+		if err != nil {
+			return c, err
+		}
+		if cacheMs < 1 {
+			return c, fmt.Errorf("AVERAGE_CACHE_TTL_MS must be positive")
+		}
+		c.AverageCacheTTL = time.Duration(cacheMs) * time.Millisecond
+	default:
+		return c, fmt.Errorf("AVERAGE_CACHE_BACKEND must be %s or %s", cacheMemory, cacheRedis)
+	}
+	dedupMs, err := envInt("RATING_DEDUP_WINDOW_MS", 0)
+	if err != nil {
+		return c, err
+	}
+	c.DedupWindow = time.Duration(dedupMs) * time.Millisecond
+	topMs, err := envInt("TOP_DRIVERS_REFRESH_MS", 60000)
+	if err != nil {
+		return c, err
+	}
+	if topMs < 1 {
+		return c, fmt.Errorf("TOP_DRIVERS_REFRESH_MS must be positive")
+	}
+	c.TopDriversInterval = time.Duration(topMs) * time.Millisecond
+	c.RecomputeBatchSize, err = envInt("RECOMPUTE_BATCH_SIZE", 500)
+	if err != nil {
+		return c, err
+	}
+	if c.RecomputeBatchSize < 1 {
+		return c, fmt.Errorf("RECOMPUTE_BATCH_SIZE must be positive")
+	}
+	c.BatchSize, err = envInt("RATING_BATCH_SIZE", 100)
+	if err != nil {
+		return c, err
+	}
+	if c.BatchSize < 1 {
+		return c, fmt.Errorf("RATING_BATCH_SIZE must be positive")
+	}
+	c.MaxRatingsPerDriver, err = envInt("MAX_RATINGS_PER_DRIVER", 1000)
+	if err != nil {
+		return c, err
+	}
+	if c.MaxRatingsPerDriver < 1 {
+		return c, fmt.Errorf("MAX_RATINGS_PER_DRIVER must be positive")
+	}
+	c.SummaryComments, err = envInt("SUMMARY_MAX_COMMENTS", 5)
+	if err != nil {
+		return c, err
+	}
+	if c.SummaryComments < 1 || c.SummaryComments > maxFeedLimit {
+		return c, fmt.Errorf("SUMMARY_MAX_COMMENTS must be between 1 and %d", maxFeedLimit)
+	}
+	c.AdminToken = getenv("ADMIN_TOKEN")
+	c.RatingSources = envList("RATING_SOURCES", []string{"app", "web", "sms"})
+	c.RatingRegions = envList("RATING_REGIONS", nil)
+	c.RatingTags = envList("RATING_TAGS", []string{"late", "rude", "clean_car", "safe_driving", "friendly"})
+	c.EntityTypes = envList("ENTITY_TYPES", []string{entityDriver, "restaurant", "courier"})
+	if !contains(c.EntityTypes, entityDriver) {
+		return c, fmt.Errorf("ENTITY_TYPES must include %s", entityDriver)
+	}
+	c.SeedMode = envString("SEED_MODE", seedSkip)
+	if !contains([]string{seedSkip, seedReplace, seedAppend}, c.SeedMode) {
+		return c, fmt.Errorf("SEED_MODE must be skip, replace or append")
+	}
+	c.ResetDB, err = envBool("ARTICLES_RESET_DB", false)
+	if err != nil {
+		return c, err
+	}
+	c.AutoMigrate, err = envBool("SCHEMA_AUTO_MIGRATE", true)
+	if err != nil {
+		return c, err
+	}
+	c.AuditLog, err = envBool("AUDIT_LOG", false)
+	if err != nil {
+		return c, err
+	}
+	c.DriverIDType = envString("DRIVER_ID_TYPE", driverIDInteger)
+	if c.DriverIDType != driverIDInteger && c.DriverIDType != driverIDUUID {
+		return c, fmt.Errorf("DRIVER_ID_TYPE must be integer or uuid")
+	}
+	c.DisableSeed, err = envBool("DISABLE_SEED", false)
+	if err != nil {
+		return c, err
+	}
+	c.TrustedUsers = envList("TRUSTED_USERS", nil)
+	c.TrustedMinRatings, err = envInt("TRUSTED_MIN_RATINGS", 0)
+	if err != nil {
+		return c, err
+	}
+	chaosMs, err := envInt("CHAOS_DELAY_MS", 0)
+	if err != nil {
+		return c, err
+	}
+	c.ChaosDelay = time.Duration(chaosMs) * time.Millisecond
+	c.RateLimit, err = envInt("RATE_LIMIT_PER_MINUTE", 0)
+	if err != nil {
+		return c, err
+	}
+	if c.RateLimit < 0 {
+		return c, fmt.Errorf("RATE_LIMIT_PER_MINUTE must not be negative")
+	}
+	c.RateLimitMode = envString("RATE_LIMIT_MODE", rateLimitReject)
+	if c.RateLimitMode != rateLimitReject && c.RateLimitMode != rateLimitThrottle {
+		return c, fmt.Errorf("RATE_LIMIT_MODE must be %s or %s", rateLimitReject, rateLimitThrottle)
+	}
+	throttleMs, err := envInt("RATE_LIMIT_MAX_DELAY_MS", 2000)
+	if err != nil {
+		return c, err
+	}
+	if throttleMs < 0 {
+		return c, fmt.Errorf("RATE_LIMIT_MAX_DELAY_MS must not be negative")
+	}
+	c.RateLimitMaxDelay = time.Duration(throttleMs) * time.Millisecond
+	c.UserRateLimit, err = envFloat("USER_RATE_LIMIT_RPS", 0)
+	if err != nil {
+		return c, err
+	}
+	if c.UserRateLimit < 0 {
+		return c, fmt.Errorf("USER_RATE_LIMIT_RPS must not be negative")
+	}
+	c.UserRateLimitBurst, err = envInt("USER_RATE_LIMIT_BURST", 5)
+	if err != nil {
+		return c, err
+	}
+	if c.UserRateLimitBurst < 1 {
+		return c, fmt.Errorf("USER_RATE_LIMIT_BURST must be at least 1")
+	}
+	c.SelfRatingField = getenv("SELF_RATING_FIELD")
+	c.JWTSecret = getenv("JWT_SECRET")
+	c.JWTJWKSURL = getenv("JWT_JWKS_URL")
+	c.JWTIssuer = getenv("JWT_ISSUER")
+	c.RatingLinkSecret = getenv("RATING_LINK_SECRET")
+	linkHours, err := envInt("RATING_LINK_TTL_HOURS", 7*24)
+	if err != nil {
+		return c, err
+	}
+	if linkHours < 1 {
+		return c, fmt.Errorf("RATING_LINK_TTL_HOURS must be positive")
+	}
+	c.RatingLinkTTL = time.Duration(linkHours) * time.Hour
+	timeoutMs, err := envInt("REQUEST_TIMEOUT_MS", 0)
+	if err != nil {
+		return c, err
+	}
+	c.RequestTimeout = time.Duration(timeoutMs) * time.Millisecond
+	c.RouteTimeouts, err = envMillisMap("ROUTE_TIMEOUTS_MS")
+	if err != nil {
+		return c, err
+	}
+	c.RatingHours = getenv("RATING_HOURS")
+	c.RatingTimezone = envString("RATING_TIMEZONE", "UTC")
+	if c.RatingHours != "" {
+		c.ratingWindow, err = parseOpenHours(c.RatingHours, c.RatingTimezone)
+		if err != nil {
+			return c, err
+		}
+	}
+	c.UserIDPattern = getenv("USER_ID_PATTERN")
+	if c.UserIDPattern != "" {
+		c.userIDPattern, err = regexp.Compile("^(?:" + c.UserIDPattern + ")$")
+		if err != nil {
+			return c, fmt.Errorf("USER_ID_PATTERN: %w", err)
+		}
+	}
+	c.PriorMean, err = envFloat("PRIOR_MEAN", 3)
+	if err != nil {
+		return c, err
+	}
+	if c.PriorMean < minRating || c.PriorMean > maxRating {
+		return c, fmt.Errorf("PRIOR_MEAN must be between %d and %d", minRating, maxRating)
+	}
+	c.PriorWeight, err = envInt("PRIOR_WEIGHT", 0)
+	if err != nil {
+		return c, err
+	}
+	if c.PriorWeight < 0 {
+		return c, fmt.Errorf("PRIOR_WEIGHT must not be negative")
+	}
+	c.PriorFromGlobal, err = envBool("PRIOR_FROM_GLOBAL", false)
+	if err != nil {
+		return c, err
+	}
+	priorMs, err := envInt("PRIOR_CACHE_MS", 60000)
+	if err != nil {
+		return c, err
+	}
+	if priorMs < 0 {
+		return c, fmt.Errorf("PRIOR_CACHE_MS must not be negative")
+	}
+	c.PriorCacheTTL = time.Duration(priorMs) * time.Millisecond
+	c.BlendRatingWeight, err = envFloat("BLEND_RATING_WEIGHT", 0.7)
+	if err != nil {
+		return c, err
+	}
+	c.BlendRecencyWeight, err = envFloat("BLEND_RECENCY_WEIGHT", 0.3)
+	if err != nil {
+		return c, err
+	}
+	if c.BlendRatingWeight < 0 || c.BlendRecencyWeight < 0 {
+		return c, fmt.Errorf("BLEND_RATING_WEIGHT and BLEND_RECENCY_WEIGHT must not be negative")
+	}
+	c.BlendHalfLifeDays, err = envInt("BLEND_HALF_LIFE_DAYS", 30)
+	if err != nil {
+		return c, err
+	}
+	if c.BlendHalfLifeDays < 1 {
+		return c, fmt.Errorf("BLEND_HALF_LIFE_DAYS must be positive")
+	}
+	c.SmartHalfLifeDays, err = envInt("SMART_HALF_LIFE_DAYS", 90)
+	if err != nil {
+		return c, err
+	}
+	if c.SmartHalfLifeDays < 1 {
+		return c, fmt.Errorf("SMART_HALF_LIFE_DAYS must be positive")
+	}
+	c.DecayHalfLifeDays, err = envInt("DECAY_HALF_LIFE_DAYS", 30)
+	if err != nil {
+		return c, err
+	}
+	if c.DecayHalfLifeDays < 1 {
+		return c, fmt.Errorf("DECAY_HALF_LIFE_DAYS must be positive")
+	}
+	c.ConfidenceBands = []int{5, 20}
+	if bands := envList("CONFIDENCE_BANDS", nil); bands != nil {
+		c.ConfidenceBands = make([]int, len(bands))
+		for i, band := range bands {
+			c.ConfidenceBands[i], err = strconv.Atoi(band)
+			if err != nil {
+				break
+			}
+		}
+		if err != nil || len(bands) != 2 || c.ConfidenceBands[0] < 1 || c.ConfidenceBands[0] >= c.ConfidenceBands[1] {
+			return c, fmt.Errorf("CONFIDENCE_BANDS must be two increasing positive counts like 5,20")
+		}
+	}
+	c.TrustScoreWeights = []float64{0.4, 0.2, 0.2, 0.2}
+	if weights := envList("TRUST_SCORE_WEIGHTS", nil); weights != nil {
+		c.TrustScoreWeights = make([]float64, len(weights))
+		valid, total := len(weights) == 4, 0.0
+		for i, weight := range weights {
+			c.TrustScoreWeights[i], err = strconv.ParseFloat(weight, 64)
+			if err != nil || c.TrustScoreWeights[i] < 0 {
+				valid = false
+			}
+			total += c.TrustScoreWeights[i]
+		}
+		if !valid || total == 0 {
+			return c, fmt.Errorf("TRUST_SCORE_WEIGHTS must be four weights like 0.4,0.2,0.2,0.2, none negative and not all 0")
+		}
+	}
+	c.AggFunction = envString("AGG_FUNCTION", avgMean)
+	if !contains(aggFunctions, c.AggFunction) {
+		return c, fmt.Errorf("AGG_FUNCTION must be one of %v", aggFunctions)
+	}
+	if c.AggFunction == avgBayesian && c.PriorWeight == 0 {
+		return c, fmt.Errorf("AGG_FUNCTION=bayesian needs a positive PRIOR_WEIGHT")
+	}
+	c.TrimPercent, err = envInt("TRIM_PERCENT", 10)
+	if err != nil {
+		return c, err
+	}
+	if c.TrimPercent < 0 || c.TrimPercent >= 50 {
+		return c, fmt.Errorf("TRIM_PERCENT must be between 0 and 49")
+	}
+	c.TieBreak = envString("RANKING_TIE_BREAK", tieBreakCount)
+	if c.TieBreak != tieBreakCount && c.TieBreak != tieBreakID {
+		return c, fmt.Errorf("RANKING_TIE_BREAK must be count or id")
+	}
+	c.BucketUnrated, err = envBool("BUCKET_UNRATED", false)
+	if err != nil {
+		return c, err
+	}
+	c.PaginationLinks, err = envBool("PAGINATION_LINKS", false)
+	if err != nil {
+		return c, err
+	}
+	c.AllowedOrigins = envList("CORS_ALLOWED_ORIGINS", nil)
+	c.EventsBroker = getenv("EVENTS_BROKER")
+	c.EventsURL = envString("EVENTS_URL", "nats://127.0.0.1:4222")
+	c.EventsSubject = envString("EVENTS_SUBJECT", "ratings")
+	c.AccessLog, err = envBool("ACCESS_LOG", false)
+	if err != nil {
+		return c, err
+	}
+	serverTimeouts := []struct {
+		name string
+		def  int
+		to   *time.Duration
+	}{
+		{"SERVER_READ_HEADER_TIMEOUT_MS", 10000, &c.ReadHeaderTimeout},
+		{"SERVER_READ_TIMEOUT_MS", 0, &c.ReadTimeout},
+		{"SERVER_WRITE_TIMEOUT_MS", 0, &c.WriteTimeout},
+		{"SERVER_IDLE_TIMEOUT_MS", 120000, &c.IdleTimeout},
+	}
+	for _, t := range serverTimeouts {
+		ms, err := envInt(t.name, t.def)
+		if err != nil {
+			return c, err
+		}
+		if ms < 0 {
+			return c, fmt.Errorf("%s must not be negative", t.name)
+		}
+		*t.to = time.Duration(ms) * time.Millisecond
+	}
+	drainMs, err := envInt("SHUTDOWN_DRAIN_TIMEOUT_MS", 10000)
+	if err != nil {
+		return c, err
+	}
+	c.DrainTimeout = time.Duration(drainMs) * time.Millisecond
+	archiveDays, err := envInt("RATING_ARCHIVE_AFTER_DAYS", 0)
+	if err != nil {
+		return c, err
+	}
+	if archiveDays < 0 {
+		return c, fmt.Errorf("RATING_ARCHIVE_AFTER_DAYS must not be negative")
+	}
+	c.ArchiveAfter = time.Duration(archiveDays) * 24 * time.Hour
+	c.ArchiveKeepAverage, err = envBool("RATING_ARCHIVE_KEEP_AVERAGE", false)
+	if err != nil {
+		return c, err
+	}
+	c.RatingEventLog, err = envBool("RATING_EVENT_LOG", false)
+	if err != nil {
+		return c, err
+	}
+	if c.RatingEventLog && c.ArchiveAfter > 0 {
+		return c, fmt.Errorf("RATING_EVENT_LOG and RATING_ARCHIVE_AFTER_DAYS can't be combined")
+	}
+	c.UserIDKey = getenv("USER_ID_KEY")
+	c.SQLDebug, err = envBool("SQL_DEBUG", false)
+	if err != nil {
+		return c, err
+	}
+	c.AverageDecimals, err = envInt("AVG_DECIMALS", -1)
+	if err != nil {
+		return c, err
+	}
+	if c.AverageDecimals < -1 || c.AverageDecimals > maxPrecision {
+		return c, fmt.Errorf("AVG_DECIMALS must be between -1 and %d", maxPrecision)
+	}
+	return c, nil
+}
+
+// envList reads a comma separated list, blank items are dropped.
+func envList(name string, def []string) []string {
+	v := getenv(name)
+	if v == "" {
+		return def
+	}
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// envMillisMap reads a comma separated list of key=milliseconds pairs.
+func envMillisMap(name string) (map[string]time.Duration, error) {
+	m := map[string]time.Duration{}
+	for _, item := range envList(name, nil) {
+		k, v, ok := strings.Cut(item, "=")
+		ms, err := strconv.Atoi(strings.TrimSpace(v))
+		if !ok || err != nil || ms <= 0 {
+			return nil, fmt.Errorf("%s: %q is not a key=milliseconds pair", name, item)
+		}
+		m[strings.TrimSpace(k)] = time.Duration(ms) * time.Millisecond
+	}
+	return m, nil
+}
+
+func envString(name, def string) string {
+	if v := getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func envBool(name string, def bool) (bool, error) {
+	v := getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s: %q is not a boolean", name, v)
+	}
+	return b, nil
+}
+
+func envFloat(name string, def float64) (float64, error) {
+	v := getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %q is not a number", name, v)
+	}
+	return f, nil
+}
+
+func envInt(name string, def int) (int, error) {
+	v := getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %q is not a number", name, v)
+	}
+	return n, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDBPathAndListenAddr(t *testing.T) {
+	defaults := envConfig(t, nil)
+	if defaults.DBPath != defaultDBPath || defaults.ListenAddr != defaultListenAddr {
+		t.Fatalf("defaults are %q and %q, want %q and %q", defaults.DBPath, defaults.ListenAddr, defaultDBPath, defaultListenAddr)
+	}
+	path := filepath.Join(t.TempDir(), "ratings.sqlite")
+	c := envConfig(t, map[string]string{"DB_PATH": path, "LISTEN_ADDR": "127.0.0.1:9090"})
+	if c.DBPath != path || c.ListenAddr != "127.0.0.1:9090" {
+		t.Fatalf("config has %q and %q, want %q and 127.0.0.1:9090", c.DBPath, c.ListenAddr, path)
+	}
+	db, err := openDB(c.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err = migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("INSERT INTO drivers (driver_info) VALUES ('{}')"); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		t.Fatalf("nothing written to DB_PATH: %v", err)
+	}
+}
+
+func TestDBDriver(t *testing.T) {
+	c := envConfig(t, map[string]string{"DB_DRIVER": "postgres", "DB_DSN": "postgres://localhost/ratings"})
+	if c.DBDriver != dbDriverPostgres || c.DBDSN != "postgres://localhost/ratings" {
+		t.Fatalf("config has %q and %q, want postgres and its DSN", c.DBDriver, c.DBDSN)
+	}
+	c = envConfig(t, map[string]string{"DB_DRIVER": "mysql", "DB_DSN": "ratings@tcp(localhost:3306)/ratings"})
+	if c.DBDriver != dbDriverMySQL || c.DBDSN != "ratings@tcp(localhost:3306)/ratings" {
+		t.Fatalf("config has %q and %q, want mysql and its DSN", c.DBDriver, c.DBDSN)
+	}
+	defer func() { getenv = os.Getenv }()
+	for _, env := range []map[string]string{
+		{"DB_DRIVER": "oracle"},
+		{"DB_DRIVER": "mysql"},
+		{"DB_DRIVER": "mysql", "DB_DSN": "ratings@tcp(localhost:3306)/ratings", "EVENTS_BROKER": "nats"},
+		{"DB_DRIVER": "postgres"},
+		{"DB_DRIVER": "postgres", "DB_DSN": "postgres://localhost/ratings", "JWT_SECRET": "secret"},
+	} {
+		getenv = func(name string) string { return env[name] }
+		if _, err := loadConfig(); err == nil {
+			t.Fatalf("config of %v loaded, want an error", env)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// serveCore runs the service on the storage cfg.DBDriver names, with the
+// core routes only.
+func serveCore() error {
+	s, err := openStorage()
+	if err != nil {
+		return err
+	}
+	store = s
+	defer store.Close()
+	handler, err := newCoreRouter()
+	if err != nil {
+		return err
+	}
+	return serve(cfg.ListenAddr, handler)
+}
+
+// newCoreRouter serves the routes every Storage implements, the service runs
+// on it with a DB_DRIVER other than sqlite: rating drivers, creating them and
+// reading them back. Rating, creating drivers and deleting ratings share the
+// handlers of newRouter, the reads leave out what needs SQLite.
+func newCoreRouter() (http.Handler, error) {
+	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(routeNotFound)
+	r.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowed)
+	r.Use(observe)
+	r.Use(recoverPanics)
+	r.Use(requestTimeouts)
+	r.Use(withRole)
+	r.HandleFunc("/healthz", healthz).Methods("GET")
+	r.HandleFunc("/readyz", coreReadyz).Methods("GET")
+	r.HandleFunc("/metrics", getMetrics).Methods("GET")
+	r.Handle("/drivers/{driver_id}/ratings", rateLimit(http.HandlerFunc(rate))).Methods("POST")
+	r.HandleFunc("/drivers", coreGetDrivers).Methods("GET")
+	r.HandleFunc("/drivers", createDriver).Methods("POST")
+	r.HandleFunc("/drivers/{driver_id}/ratings", coreGetDriverRatings).Methods("GET")
+	r.HandleFunc("/drivers/{driver_id}/ratings/{user_id}", deleteRating).Methods("DELETE")
+	r.HandleFunc("/drivers/{driver_id}", coreGetDriver).Methods("GET")
+	if err := checkRouteTimeouts(r); err != nil {
+		return nil, err
+	}
+	return cors(r), nil
+}
+
+// coreGetDrivers is GET /drivers with limit, offset, the sorts but blended,
+// min_rating, min_count and user_id.
+func coreGetDrivers(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListParams(r, listOptions{
+		DefaultLimit: defaultDriversLimit,
+		MaxLimit:     maxDriversLimit,
+		Sorts:        []string{sortID, sortRating, sortRatingDesc, sortCount, sortCountDesc},
+		Rounding:     true,
+	})
+	var minAvg *float64
+	var minCount int
+	if err == nil {
+		minAvg, minCount, err = parseDriverMinimums(r.URL.Query())
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	list, err := store.ListDrivers(driverQuery{
+		UserID:    storedUserID(r.URL.Query().Get("user_id")),
+		Sort:      params.Sort,
+		Limit:     params.Limit,
+		Offset:    params.Offset,
+		MinRating: minAvg,
+		MinCount:  minCount,
+	})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	roundAverages(list, params)
+	drivers := make([]*Driver, len(list))
+	for i := range list {
+		drivers[i] = &list[i]
+	}
+	localizeAverages(w, r, drivers...)
+	writeCoreJSON(w, list)
+}
+
+// coreGetDriver is GET /drivers/{driver_id} with user_id.
+func coreGetDriver(w http.ResponseWriter, r *http.Request) {
+	driver, err := store.GetDriver(mux.Vars(r)["driver_id"], driverRead{UserID: storedUserID(r.URL.Query().Get("user_id"))})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if driver == nil {
+		writeError(w, http.StatusNotFound, "driver not found")
+		return
+	}
+	localizeAverages(w, r, driver)
+	writeCoreJSON(w, driver)
+}
+
+// coreGetDriverRatings is GET /drivers/{driver_id}/ratings with its filters,
+// up to cfg.MaxRatingsPerDriver ratings and without the feed.
+func coreGetDriverRatings(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseRatingFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	list, err := store.ListDriverRatings(mux.Vars(r)["driver_id"], filter, cfg.MaxRatingsPerDriver)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	showRatings(r, list)
+	writeCoreJSON(w, list)
+}
+
+// coreReadyz is readyz without the schema version, the Postgres and MySQL
+// schemas are applied on every start.
+func coreReadyz(w http.ResponseWriter, r *http.Request) {
+	health := Readiness{Health: Health{Status: "ready"}, InFlightRequests: srv.InFlight()}
+	status := http.StatusOK
+	if srv.Draining() {
+		health.Status, health.Error = "not ready", "shutting down"
+		status = http.StatusServiceUnavailable
+	} else if err := store.Ping(r.Context()); err != nil {
+		health.Status, health.Error = "not ready", err.Error()
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, health)
+}
+
+func writeCoreJSON(w http.ResponseWriter, body interface{}) {
+	d, err := json.Marshal(body)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
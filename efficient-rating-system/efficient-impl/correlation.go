@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Correlation tells how alike two drivers are rated by the users who rated
+// both. Score is the Pearson correlation of their ratings, from -1 to 1, it
+// is null when there are fewer than two shared raters or one of the drivers
+// got the same rating from all of them.
+type Correlation struct {
+	DriverA      string   `json:"driver_a"`
+	DriverB      string   `json:"driver_b"`
+	SharedRaters int      `json:"shared_raters"`
+	Score        *float64 `json:"score"`
+}
+
+func getDriverCorrelation(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	correlation, err := getDriversCorrelation(entityType(r), params["a"], params["b"])
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(correlation)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getDriversCorrelation correlates two entities of entityType, an entity of
+// another type has no shared raters.
+func getDriversCorrelation(entityType, driverA, driverB string) (*Correlation, error) {
+	xs, ys, err := queryRatingPairs(`SELECT a.rating, b.rating FROM driver_ratings a
+    JOIN driver_ratings b ON b.user_id = a.user_id AND b.driver_id = ?
+    JOIN drivers d ON d.id = a.driver_id AND d.entity_type = ?
+    JOIN drivers e ON e.id = b.driver_id AND e.entity_type = ?
+    WHERE a.driver_id = ?`, driverB, entityType, entityType, driverA)
+	if err != nil {
+		return nil, err
+	}
+	return &Correlation{
+		DriverA:      driverA,
+		DriverB:      driverB,
+		SharedRaters: len(xs),
+		Score:        pearson(xs, ys),
+	}, nil
+}
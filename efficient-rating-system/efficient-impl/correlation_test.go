@@ -0,0 +1,37 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+)
+
+func TestDriverCorrelation(t *testing.T) {
+	h := openTestDB(t, nil)
+	// u1 to u3 rate drivers 1 and 2 in opposite ways, only u1 rates driver 3
+	// and driver 4 gets the same rating from everyone.
+	for user, stars := range map[string][2]int{"u1": {1, 5}, "u2": {3, 3}, "u3": {5, 1}} {
+		rateTest(t, h, "1", user, stars[0])
+		rateTest(t, h, "2", user, stars[1])
+		rateTest(t, h, "4", user, 4)
+	}
+	rateTest(t, h, "3", "u1", 2)
+	rateTest(t, h, "3", "u9", 2)
+	for target, want := range map[string]struct {
+		shared int
+		score  *float64
+	}{
+		"/drivers/1/correlation/2": {3, floatPtr(-1)},
+		"/drivers/1/correlation/3": {1, nil},
+		"/drivers/1/correlation/4": {3, nil},
+	} {
+		rec := serveTest(h, "GET", target, "")
+		expectStatus(t, rec, http.StatusOK)
+		var c Correlation
+		decodeBody(t, rec, &c)
+		if c.SharedRaters != want.shared || (c.Score == nil) != (want.score == nil) ||
+			want.score != nil && math.Abs(*c.Score-*want.score) > 1e-9 {
+			t.Fatalf("%s: %d shared raters, score %v, want %d and %v", target, c.SharedRaters, c.Score, want.shared, want.score)
+		}
+	}
+}
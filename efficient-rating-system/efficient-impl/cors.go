@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsMaxAge is how long, in seconds, browsers may cache a preflight.
+const corsMaxAge = "600"
+
+// cors lets the browsers of the origins in cfg.AllowedOrigins call the API.
+// It wraps the whole router so that preflight requests, which no route
+// accepts, are answered too.
+func cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || len(cfg.AllowedOrigins) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !originAllowed(origin, cfg.AllowedOrigins) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// originAllowed matches an Origin header against the allowed origins. An
+// allowed origin is either "*", an exact origin like https://app.example.com,
+// or a wildcard like https://*.example.com which matches the subdomains of
+// example.com at any depth but not example.com itself. A wildcard without a
+// scheme matches any scheme.
+func originAllowed(origin string, allowed []string) bool {
+	origin = strings.ToLower(origin)
+	scheme, host, ok := strings.Cut(origin, "://")
+	if !ok {
+		return false
+	}
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if a == "*" || a == origin {
+			return true
+		}
+		pattern := a
+		if s, p, ok := strings.Cut(a, "://"); ok {
+			if s != scheme {
+				continue
+			}
+			pattern = p
+		}
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok && strings.HasSuffix(host, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
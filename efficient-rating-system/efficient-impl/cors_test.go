@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	allowed := []string{"https://*.example.com", "https://app.test.org"}
+	for origin, want := range map[string]bool{
+		"https://app.example.com":     true,
+		"https://a.b.example.com":     true,
+		"https://APP.Example.com":     true,
+		"https://example.com":         false,
+		"http://app.example.com":      false,
+		"https://evilexample.com":     false,
+		"https://example.com.evil.io": false,
+		"https://app.test.org":        true,
+		"https://x.app.test.org":      false,
+	} {
+		if got := originAllowed(origin, allowed); got != want {
+			t.Errorf("%s: allowed %v, want %v", origin, got, want)
+		}
+	}
+}
+
+func TestCORSWildcardSubdomain(t *testing.T) {
+	h := openTestDB(t, map[string]string{"CORS_ALLOWED_ORIGINS": "https://*.example.com"})
+	rec := serveTest(h, "GET", "/drivers/1", "", "Origin", "https://shop.example.com")
+	expectStatus(t, rec, http.StatusOK)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://shop.example.com" {
+		t.Fatalf("allowed origin is %q, want the subdomain reflected", got)
+	}
+	rec = serveTest(h, "GET", "/drivers/1", "", "Origin", "https://shop.example.org")
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("a foreign origin is allowed as %q", got)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// getDriversCSV exports every driver that is not deleted as CSV. The export
+// is built in memory so that Range requests can resume a download, the ETag
+// lets If-Range tell whether the data changed in between.
+func getDriversCSV(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	out := csv.NewWriter(&buf)
+	err := out.Write([]string{"id", "driver_info", "avg_rating"})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	err = eachDriver(driverQuery{Average: cfg.AggFunction, EntityType: entityType(r)}, func(driver Driver) error {
+		return out.Write([]string{driver.ID, driver.DriverInfo, strconv.FormatFloat(driver.AverageRating, 'f', cfg.AverageDecimals, 64)})
+	})
+	if err == nil {
+		out.Flush()
+		err = out.Error()
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	http.ServeContent(w, r, "drivers.csv", time.Time{}, bytes.NewReader(buf.Bytes()))
+}
+
+// getDistributionCSV exports how many ratings of each star every driver that
+// is not deleted got, one row per driver. Unlike the drivers export it is
+// streamed, flushing after every row, so an error halfway through aborts the
+// response as in streamDrivers.
+func getDistributionCSV(w http.ResponseWriter, r *http.Request) {
+	counts := make([]string, 0, maxRating)
+	header := []string{"id"}
+	for star := minRating; star <= maxRating; star++ {
+		counts = append(counts, "COALESCE(SUM(dr.rating = "+strconv.Itoa(star)+"), 0)")
+		header = append(header, strconv.Itoa(star))
+	}
+	row, err := srv.DB().Query(`SELECT d.id, `+strings.Join(counts, ", ")+`
+    FROM drivers d
+    LEFT JOIN driver_ratings dr ON dr.driver_id = d.id
+    WHERE d.deleted_at IS NULL AND d.entity_type = ?
+    GROUP BY d.id
+    ORDER BY d.id`, entityType(r))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	defer row.Close()
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	out := csv.NewWriter(w)
+	err = out.Write(header)
+	record := make([]string, len(header))
+	dest := make([]interface{}, len(record))
+	for i := range record {
+		dest[i] = &record[i]
+	}
+	for err == nil && row.Next() {
+		if err = row.Scan(dest...); err != nil {
+			break
+		}
+		if err = out.Write(record); err != nil {
+			break
+		}
+		out.Flush()
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if err == nil {
+		err = row.Err()
+	}
+	if err == nil {
+		out.Flush()
+		err = out.Error()
+	}
+	if err != nil {
+		log.Println("stream distribution:", err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDriversCSVRange(t *testing.T) {
+	h := openTestDB(t, nil)
+	full := serveTest(h, "GET", "/drivers.csv", "")
+	expectStatus(t, full, http.StatusOK)
+	body := full.Body.String()
+	etag := full.Header().Get("ETag")
+	rec := serveTest(h, "GET", "/drivers.csv", "", "Range", "bytes=10-19", "If-Range", etag)
+	expectStatus(t, rec, http.StatusPartialContent)
+	if got, want := rec.Header().Get("Content-Range"), fmt.Sprintf("bytes 10-19/%d", len(body)); got != want {
+		t.Fatalf("Content-Range is %q, want %q", got, want)
+	}
+	if rec.Body.String() != body[10:20] {
+		t.Fatalf("partial body is %q, want %q", rec.Body.String(), body[10:20])
+	}
+	// Once the data changes the range no longer applies.
+	rateTest(t, h, "1", "a", 5)
+	expectStatus(t, serveTest(h, "GET", "/drivers.csv", "", "Range", "bytes=10-19", "If-Range", etag), http.StatusOK)
+}
+
+func TestDistributionCSV(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 5)
+	rateTest(t, h, "1", "b", 5)
+	rateTest(t, h, "1", "c", 2)
+	rateTest(t, h, "2", "a", 1)
+	expectStatus(t, serveTest(h, "DELETE", "/drivers/4", ""), http.StatusNoContent)
+	rec := serveTest(h, "GET", "/stats/distribution.csv", "")
+	expectStatus(t, rec, http.StatusOK)
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != seedDriverCount {
+		t.Fatalf("%d lines, want a header and %d drivers", len(lines), seedDriverCount-1)
+	}
+	for i, want := range []string{"id,1,2,3,4,5", "1,0,1,0,0,2", "2,1,0,0,0,0", "3,0,0,0,0,0", "5,0,0,0,0,0"} {
+		if lines[i] != want {
+			t.Errorf("line %d is %q, want %q", i, lines[i], want)
+		}
+	}
+}
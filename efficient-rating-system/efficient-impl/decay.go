@@ -0,0 +1,57 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+)
+
+// avgDecayed is the exponentially time decayed mean, see decayedAverage.
+const avgDecayed = "decayed"
+
+// decayedAverage returns the SQL expression, with its arguments, of the mean
+// of the ratings filter selects where a rating weighs half as much every
+// cfg.DecayHalfLifeDays of age. The ages are taken from the newest rating:
+// decaying every weight by the same factor doesn't change the mean, so it
+// only moves when the driver gets a rating, and it can't underflow to 0/0.
+// It is NULL for unrated drivers.
+func decayedAverage(filter string, args []interface{}) (string, []interface{}) {
+	rate := math.Ln2 / float64(cfg.DecayHalfLifeDays)
+	return `(SELECT SUM(w * rating) / SUM(w)
+      FROM (SELECT rating, exp(? * (julianday(updated_at) - MAX(julianday(updated_at)) OVER ())) AS w
+        FROM driver_ratings WHERE ` + filter + `))`,
+		append([]interface{}{rate}, args...)
+}
+
+// getDriverAverages computes the average of the driver with every function
+// of aggFunctions and avgSmart, nil for the ones it has no value for.
+func getDriverAverages(driverId string) (map[string]*float64, error) {
+	names := append(append([]string{}, aggFunctions...), avgSmart)
+	query := "SELECT "
+	var args []interface{}
+	for i, name := range names {
+		avg, avgArgs := averageExpr("d", name)
+		if i > 0 {
+			query += ", "
+		}
+		query += avg
+		args = append(args, avgArgs...)
+	}
+	values := make([]sql.NullFloat64, len(names))
+	dest := make([]interface{}, len(names))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	err := srv.DB().QueryRow(query+" FROM drivers d WHERE d.id = ?", append(args, driverId)...).Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	averages := make(map[string]*float64, len(names))
+	for i, name := range names {
+		var v *float64
+		if values[i].Valid {
+			v = &values[i].Float64
+		}
+		averages[name] = v
+	}
+	return averages, nil
+}
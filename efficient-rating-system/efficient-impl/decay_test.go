@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDecayedAverage(t *testing.T) {
+	h := openTestDB(t, map[string]string{"DECAY_HALF_LIFE_DAYS": "10"})
+	rateTest(t, h, "1", "a", 5)
+	rateTest(t, h, "1", "b", 1)
+	rateTest(t, h, "1", "c", 3)
+	// 0, 10 and 20 days older than the newest rating: weights 1, 1/2, 1/4.
+	for user, at := range map[string]string{"a": "2024-01-21 12:00:00", "b": "2024-01-11 12:00:00", "c": "2024-01-01 12:00:00"} {
+		execTest(t, "UPDATE driver_ratings SET updated_at = ? WHERE driver_id = 1 AND user_id = ?", at, user)
+	}
+	const want = (5 + 1*0.5 + 3*0.25) / 1.75
+
+	rec := serveTest(h, "GET", "/drivers/1?averages=all", "")
+	expectStatus(t, rec, http.StatusOK)
+	var driver struct {
+		AverageRating float64             `json:"avg_rating"`
+		Averages      map[string]*float64 `json:"averages"`
+	}
+	decodeBody(t, rec, &driver)
+	var names []string
+	for name := range driver.Averages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	wantNames := append(append([]string{}, aggFunctions...), avgSmart)
+	sort.Strings(wantNames)
+	if strings.Join(names, " ") != strings.Join(wantNames, " ") {
+		t.Fatalf("averages has %v, want %v", names, wantNames)
+	}
+	decayed := driver.Averages[avgDecayed]
+	if decayed == nil || math.Abs(*decayed-want) > 1e-9 {
+		t.Fatalf("decayed average is %v, want %.4f", decayed, want)
+	}
+	if mean := driver.Averages[avgMean]; mean == nil || *mean != 3 || driver.AverageRating != 3 {
+		t.Fatalf("mean is %v, avg_rating %v, want 3 for both", mean, driver.AverageRating)
+	}
+
+	// The list ranks by the same value.
+	var list []Driver
+	rec = serveTest(h, "GET", "/drivers?avg=decayed&limit=1&sort=rating_desc", "")
+	expectStatus(t, rec, http.StatusOK)
+	decodeBody(t, rec, &list)
+	if len(list) != 1 || list[0].ID != "1" || math.Abs(list[0].AverageRating-want) > 1e-9 {
+		t.Fatalf("best decayed driver is %+v, want driver 1 at %.4f", list, want)
+	}
+
+	// An unrated driver has every name, without a value.
+	rec = serveTest(h, "GET", "/drivers/2?averages=all", "")
+	expectStatus(t, rec, http.StatusOK)
+	var unrated Driver
+	decodeBody(t, rec, &unrated)
+	if len(unrated.Averages) != len(wantNames) {
+		t.Fatalf("averages of an unrated driver are %v, want every name", unrated.Averages)
+	}
+	for name, v := range unrated.Averages {
+		if v != nil {
+			t.Fatalf("%s average of an unrated driver is %v, want null", name, *v)
+		}
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers/1?averages=some", ""), http.StatusBadRequest)
+}
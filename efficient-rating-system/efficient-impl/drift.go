@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// AggregateDrift is a driver whose stored rating_sum or rating_count doesn't
+// match its ratings. The deltas are what has to be added to the stored values
+// to fix them.
+type AggregateDrift struct {
+	ID          string `json:"id"`
+	StoredSum   int64  `json:"stored_sum"`
+	StoredCount int64  `json:"stored_count"`
+	ActualSum   int64  `json:"actual_sum"`
+	ActualCount int64  `json:"actual_count"`
+	SumDelta    int64  `json:"sum_delta"`
+	CountDelta  int64  `json:"count_delta"`
+}
+
+type DriftReport struct {
+	Count   int              `json:"count"`
+	Drivers []AggregateDrift `json:"drivers"`
+}
+
+// getAggregateDrift reports the drivers whose aggregates are out of sync,
+// nothing is fixed.
+func getAggregateDrift(w http.ResponseWriter, r *http.Request) {
+	list, err := getAggregateDriftList()
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(DriftReport{Count: len(list), Drivers: list})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getAggregateDriftList compares the aggregates of every driver, deleted ones
+// included, with the ones computed from its counted ratings.
+func getAggregateDriftList() ([]AggregateDrift, error) {
+	row, err := srv.DB().Query(`SELECT d.id, COALESCE(d.rating_sum, 0), COALESCE(d.rating_count, 0),
+      COALESCE(r.rating_sum, 0), COALESCE(r.rating_count, 0)
+    FROM drivers d
+    LEFT JOIN (SELECT driver_id, SUM(rating) AS rating_sum, COUNT(*) AS rating_count FROM ` + countedRatings() + ` GROUP BY driver_id) r
+      ON r.driver_id = d.id
+    WHERE COALESCE(d.rating_sum, 0) != COALESCE(r.rating_sum, 0) OR COALESCE(d.rating_count, 0) != COALESCE(r.rating_count, 0)
+    ORDER BY d.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []AggregateDrift{}
+	for row.Next() {
+		var drift AggregateDrift
+		err = row.Scan(&drift.ID, &drift.StoredSum, &drift.StoredCount, &drift.ActualSum, &drift.ActualCount)
+		if err != nil {
+			return nil, err
+		}
+		drift.SumDelta = drift.ActualSum - drift.StoredSum
+		drift.CountDelta = drift.ActualCount - drift.StoredCount
+		list = append(list, drift)
+	}
+	return list, row.Err()
+}
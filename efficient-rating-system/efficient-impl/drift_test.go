@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAggregateDrift(t *testing.T) {
+	h := openTestDB(t, adminEnv)
+	rateTest(t, h, "1", "a", 4)
+	rateTest(t, h, "2", "a", 3)
+	rateTest(t, h, "2", "b", 5)
+	execTest(t, "UPDATE drivers SET rating_sum = 10, rating_count = 3 WHERE id = 2")
+	rec := serveTest(h, "GET", "/admin/drift", "", adminAuth...)
+	expectStatus(t, rec, http.StatusOK)
+	var report DriftReport
+	decodeBody(t, rec, &report)
+	want := AggregateDrift{ID: "2", StoredSum: 10, StoredCount: 3, ActualSum: 8, ActualCount: 2, SumDelta: -2, CountDelta: -1}
+	if report.Count != 1 || len(report.Drivers) != 1 || report.Drivers[0] != want {
+		t.Fatalf("drift report is %+v, want only %+v", report, want)
+	}
+	// Reporting fixes nothing.
+	if sum, count := driverAggregates(t, "2"); sum != 10 || count != 3 {
+		t.Fatalf("aggregates are sum %d count %d after the report, want them as corrupted", sum, count)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// DriverInfo is the driver_info of a request body: the name, vehicle and
+// phone of the driver, and the other fields as they are sent. It is sent as
+// a JSON object like {"name": "Ann", "vehicle": "Prius"}, or as the same
+// object encoded in a string the way driver_info is returned, and stored as
+// its JSON.
+type DriverInfo struct {
+	Name    string
+	Vehicle string
+	Phone   string
+	// Extra are the other fields, like external_id.
+	Extra map[string]json.RawMessage
+}
+
+// driverInfoFields are the fields DriverInfo has in its own members.
+var driverInfoFields = []string{"name", "vehicle", "phone"}
+
+// maxDriverInfoLength is the longest name or vehicle, in characters.
+const maxDriverInfoLength = 100
+
+// phonePattern is a phone number with an optional leading +, its digits can
+// be grouped with spaces or dashes.
+var phonePattern = regexp.MustCompile(`^\+?[0-9][0-9 -]{4,18}[0-9]$`)
+
+// driverInfoError is a driver_info that can't be stored, its message is
+// returned to the client as is.
+type driverInfoError string
+
+func (e driverInfoError) Error() string {
+	return string(e)
+}
+
+func (v *DriverInfo) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		if s == "" {
+			*v = DriverInfo{}
+			return nil
+		}
+		data = []byte(s)
+	}
+	var fields map[string]json.RawMessage
+	if len(bytes.TrimSpace(data)) == 0 || bytes.TrimSpace(data)[0] != '{' || json.Unmarshal(data, &fields) != nil {
+		return driverInfoError("driver_info must be a JSON object or a string holding one")
+	}
+	info := DriverInfo{}
+	for _, name := range driverInfoFields {
+		raw, ok := fields[name]
+		if !ok {
+			continue
+		}
+		delete(fields, name)
+		var value string
+		if string(raw) != "null" && json.Unmarshal(raw, &value) != nil {
+			return driverInfoError(fmt.Sprintf("driver_info.%s must be a string", name))
+		}
+		switch name {
+		case "name":
+			info.Name = value
+		case "vehicle":
+			info.Vehicle = value
+		case "phone":
+			info.Phone = value
+		}
+	}
+	if len(fields) > 0 {
+		info.Extra = fields
+	}
+	*v = info
+	return nil
+}
+
+// MarshalJSON writes the fields that are set, in the order of their names.
+func (v DriverInfo) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]json.RawMessage, len(v.Extra)+len(driverInfoFields))
+	for name, raw := range v.Extra {
+		fields[name] = raw
+	}
+	for name, value := range map[string]string{"name": v.Name, "vehicle": v.Vehicle, "phone": v.Phone} {
+		if value == "" {
+			continue
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = raw
+	}
+	return json.Marshal(fields)
+}
+
+// validate checks the fields a driver_info sets: names and vehicles of at
+// most maxDriverInfoLength characters, and phone numbers.
+func (v DriverInfo) validate() error {
+	if utf8.RuneCountInString(v.Name) > maxDriverInfoLength {
+		return driverInfoError(fmt.Sprintf("driver_info.name must be at most %d characters", maxDriverInfoLength))
+	}
+	if utf8.RuneCountInString(v.Vehicle) > maxDriverInfoLength {
+		return driverInfoError(fmt.Sprintf("driver_info.vehicle must be at most %d characters", maxDriverInfoLength))
+	}
+	if v.Phone != "" && !phonePattern.MatchString(v.Phone) {
+		return driverInfoError("driver_info.phone must be a phone number like +15550100")
+	}
+	return nil
+}
+
+// decodeDriverInfo decodes a request body holding a DriverInfo into input
+// and returns the driver_info to store. The error is the message for the
+// client.
+func decodeDriverInfo(r *http.Request, input interface{}, info *DriverInfo) (string, error) {
+	err := json.NewDecoder(r.Body).Decode(input)
+	var infoErr driverInfoError
+	if errors.As(err, &infoErr) {
+		return "", infoErr
+	}
+	if err != nil {
+		return "", errors.New("invalid request body")
+	}
+	if err = info.validate(); err != nil {
+		return "", err
+	}
+	d, err := json.Marshal(info)
+	return string(d), err
+}
+
+// DriverUpdate is the body of PUT /drivers/{driver_id}.
+type DriverUpdate struct {
+	DriverInfo DriverInfo `json:"driver_info"`
+}
+
+// updateDriver replaces the driver_info of a driver, its ratings are kept.
+func updateDriver(w http.ResponseWriter, r *http.Request) {
+	driverId := mux.Vars(r)["driver_id"]
+	var input DriverUpdate
+	driverInfo, err := decodeDriverInfo(r, &input, &input.DriverInfo)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	found, deleted, err := getDriverState(driverId)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "driver not found")
+		return
+	}
+	if deleted {
+		writeError(w, http.StatusGone, "driver has been deleted")
+		return
+	}
+	if err = setDriverInfo(driverId, driverInfo, requestActor(r)); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	driver, err := getDriverByID(driverId, driverRead{})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if driver == nil {
+		writeError(w, http.StatusGone, "driver has been deleted")
+		return
+	}
+	d, err := json.Marshal(driver)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// setDriverInfo stores the driver_info of a driver that is not deleted,
+// along with the external id it carries.
+func setDriverInfo(driverId, driverInfo, actor string) error {
+	tx, err := srv.DB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`UPDATE drivers SET driver_info = ?, external_id = ? WHERE id = ? AND deleted_at IS NULL`,
+		driverInfo, nullString(externalID(driverInfo)), driverId)
+	if err != nil {
+		return err
+	}
+	err = recordAudit(tx, actor, "update", "driver", driverId, map[string]interface{}{"driver_info": driverInfo})
+	if err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	averageCache.forget(driverId)
+	return nil
+}
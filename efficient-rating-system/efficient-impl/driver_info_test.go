@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDriverInfoJSON(t *testing.T) {
+	const stored = `{"external_id":42,"name":"Ann","phone":"+15550100","vehicle":"Toyota Prius"}`
+	for _, body := range []string{
+		`{"name": "Ann", "vehicle": "Toyota Prius", "phone": "+15550100", "external_id": 42}`,
+		`"{\"phone\": \"+15550100\", \"external_id\": 42, \"vehicle\": \"Toyota Prius\", \"name\": \"Ann\"}"`,
+	} {
+		var info DriverInfo
+		if err := json.Unmarshal([]byte(body), &info); err != nil {
+			t.Fatalf("%s: %v", body, err)
+		}
+		if info.Name != "Ann" || info.Vehicle != "Toyota Prius" || info.Phone != "+15550100" || string(info.Extra["external_id"]) != "42" {
+			t.Fatalf("%s decoded to %+v", body, info)
+		}
+		d, err := json.Marshal(info)
+		if err != nil || string(d) != stored {
+			t.Fatalf("%s is stored as %s, %v, want %s", body, d, err, stored)
+		}
+	}
+	var empty DriverInfo
+	if d, err := json.Marshal(empty); err != nil || string(d) != "{}" {
+		t.Fatalf("an empty driver_info is stored as %s, %v, want {}", d, err)
+	}
+	for _, body := range []string{`{"name": 7}`, `{"phone": ["+15550100"]}`, `[]`, `"not json"`, `12`} {
+		var info DriverInfo
+		if err := json.Unmarshal([]byte(body), &info); err == nil {
+			t.Fatalf("%s decoded to %+v, want an error", body, info)
+		}
+	}
+}
+
+func TestDriverInfoValidation(t *testing.T) {
+	for _, info := range []DriverInfo{{}, {Name: "Ann", Phone: "+1 555-0100"}, {Vehicle: strings.Repeat("é", maxDriverInfoLength)}} {
+		if err := info.validate(); err != nil {
+			t.Fatalf("%+v is refused: %v", info, err)
+		}
+	}
+	for _, info := range []DriverInfo{{Name: strings.Repeat("a", maxDriverInfoLength+1)}, {Phone: "call me"}, {Phone: "12"}, {Phone: "+"}} {
+		if err := info.validate(); err == nil {
+			t.Fatalf("%+v is valid, want an error", info)
+		}
+	}
+
+	h := openTestDB(t, nil)
+	rec := serveTest(h, "POST", "/drivers", `{"driver_info": {"name": "Ann", "phone": "call me"}}`)
+	expectStatus(t, rec, http.StatusBadRequest)
+	if body := rec.Body.String(); !strings.Contains(body, "driver_info.phone") {
+		t.Fatalf("error is %s, want it to name driver_info.phone", body)
+	}
+	expectStatus(t, serveTest(h, "POST", "/drivers", `{"driver_info": {"name": 7}}`), http.StatusBadRequest)
+	expectStatus(t, serveTest(h, "PUT", "/drivers/1", `{"driver_info": "not json"}`), http.StatusBadRequest)
+
+	rec = serveTest(h, "PUT", "/drivers/1", `{"driver_info": {"vehicle": "Prius", "name": "Ann", "car_id": "c-1"}}`)
+	expectStatus(t, rec, http.StatusOK)
+	var driver Driver
+	decodeBody(t, rec, &driver)
+	if want := `{"car_id":"c-1","name":"Ann","vehicle":"Prius"}`; driver.DriverInfo != want {
+		t.Fatalf("driver_info is %s, want %s", driver.DriverInfo, want)
+	}
+}
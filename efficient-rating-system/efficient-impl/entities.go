@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// entityDriver is the entity type of the drivers, the one the /drivers
+// routes serve.
+const entityDriver = "driver"
+
+// entityType is the type of the entities a request is about, the
+// {entity_type} of the /entities routes and driver for the others.
+func entityType(r *http.Request) string {
+	if t := mux.Vars(r)["entity_type"]; t != "" {
+		return t
+	}
+	return entityDriver
+}
+
+// entityPath is where the entity is served, /drivers/{id} for the drivers.
+func entityPath(entityType, id string) string {
+	if entityType == entityDriver {
+		return "/drivers/" + id
+	}
+	return "/entities/" + entityType + "/" + id
+}
+
+// entityRoutes serves the entities of every type of cfg.EntityTypes under
+// /entities/{entity_type} with the handlers of the drivers, which are the
+// entities of type driver. scopeEntity passes {id} on as driver_id.
+func entityRoutes(r *mux.Router) {
+	e := r.PathPrefix("/entities/{entity_type}").Subrouter()
+	e.HandleFunc("", getDrivers).Methods("GET")
+	e.HandleFunc("", createDriver).Methods("POST")
+	e.Handle("/{id}/ratings", rateLimit(http.HandlerFunc(rate))).Methods("POST")
+	e.HandleFunc("/{id}/ratings", getDriverRatings).Methods("GET")
+	e.HandleFunc("/{id}/ratings/histogram", getDriverDistribution).Methods("GET")
+	e.HandleFunc("/{id}/ratings/{user_id}", getUserRating).Methods("GET")
+	e.HandleFunc("/{id}/ratings/{user_id}", deleteRating).Methods("DELETE")
+	e.Handle("/{id}/ratings/{user_id}/moderation", requireAdmin(http.HandlerFunc(moderateRating))).Methods("PATCH")
+	e.HandleFunc("/{id}/summary", getDriverSummary).Methods("GET")
+	e.HandleFunc("/{id}", getDriver).Methods("GET")
+	e.HandleFunc("/{id}", updateDriver).Methods("PUT")
+	e.HandleFunc("/{id}", deleteDriver).Methods("DELETE")
+}
+
+// scopeEntity keeps every route to the entities of its type: an unknown
+// {entity_type} is not found, and so is an entity of another type than the
+// route's, e.g. a restaurant under /drivers. Entities that don't exist are
+// left to the handlers.
+func scopeEntity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		want := entityType(r)
+		if !contains(cfg.EntityTypes, want) {
+			writeError(w, http.StatusNotFound, "unknown entity type "+want)
+			return
+		}
+		if id, ok := vars["id"]; ok {
+			scoped := map[string]string{"driver_id": id}
+			for k, v := range vars {
+				scoped[k] = v
+			}
+			r = mux.SetURLVars(r, scoped)
+		}
+		id := mux.Vars(r)["driver_id"]
+		// With drivers only there is nothing else to run into.
+		if id == "" || len(cfg.EntityTypes) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var got string
+		err := srv.DB().QueryRow("SELECT entity_type FROM drivers WHERE id = ?", id).Scan(&got)
+		if err != nil && err != sql.ErrNoRows {
+			writeInternalError(w, err)
+			return
+		}
+		if err == nil && got != want {
+			writeError(w, http.StatusNotFound, want+" not found")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
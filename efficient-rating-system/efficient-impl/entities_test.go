@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestEntityTypesDontMix gives two drivers and two restaurants the same
+// ratings and snapshots, and checks that the lists, aggregates, snapshots and
+// rankings of each type only ever show that type.
+func TestEntityTypesDontMix(t *testing.T) {
+	h := openTestDB(t, nil)
+	var restaurants []string
+	for _, name := range []string{"Bistro", "Diner"} {
+		rec := serveTest(h, "POST", "/entities/restaurant", `{"driver_info": {"name": "`+name+`"}}`)
+		expectStatus(t, rec, http.StatusCreated)
+		var restaurant Driver
+		decodeBody(t, rec, &restaurant)
+		restaurants = append(restaurants, restaurant.ID)
+	}
+	pairs := [][2]string{{"/drivers/1", "/entities/restaurant/" + restaurants[0]}, {"/drivers/2", "/entities/restaurant/" + restaurants[1]}}
+	rate := func(pair int, user, stars string) {
+		t.Helper()
+		for _, path := range pairs[pair] {
+			expectStatus(t, serveTest(h, "POST", path+"/ratings", `{"user_id": "`+user+`", "rating": `+stars+`}`), http.StatusOK)
+		}
+	}
+	rate(0, "a", "2")
+	rate(1, "a", "5")
+	rate(1, "b", "4")
+	if err := takeSnapshots(); err != nil {
+		t.Fatal(err)
+	}
+	// The first of each type goes from 2 to 3.5, the second falls from 4.5
+	// to 2.75.
+	rate(0, "b", "5")
+	rate(1, "c", "1")
+	rate(1, "d", "1")
+	if err := refreshTopDrivers(); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(target string) string {
+		t.Helper()
+		rec := serveTest(h, "GET", target, "")
+		expectStatus(t, rec, http.StatusOK)
+		var list []struct {
+			ID string `json:"id"`
+		}
+		decodeBody(t, rec, &list)
+		var ids []string
+		for _, d := range list {
+			ids = append(ids, d.ID)
+		}
+		sort.Strings(ids)
+		return strings.Join(ids, ",")
+	}
+	since := url.QueryEscape(time.Now().Add(-time.Hour).UTC().Format(time.RFC3339))
+	for target, want := range map[string]string{
+		"/drivers?min_count=1":                          "1,2",
+		"/drivers/leaderboard":                          "1,2",
+		"/drivers/ranked?formula=avg&limit=3":           "1,2,3",
+		"/drivers/top":                                  "1,2",
+		"/drivers/most-improved?since=" + since:         "1",
+		"/drivers/at-risk?threshold=3&window=1d":        "2",
+		"/entities/restaurant?min_count=1":              restaurants[0] + "," + restaurants[1],
+		"/entities/restaurant?min_count=1&sort=count":   restaurants[0] + "," + restaurants[1],
+		"/entities/courier":                             "",
+		"/drivers?min_count=1&sort=rating_desc&limit=3": "1,2",
+	} {
+		if got := ids(target); got != want {
+			t.Errorf("%s lists %q, want %q", target, got, want)
+		}
+	}
+
+	rec := serveTest(h, "GET", "/stats/driver-buckets", "")
+	expectStatus(t, rec, http.StatusOK)
+	var buckets map[string]int
+	decodeBody(t, rec, &buckets)
+	if buckets["3"] != 1 || buckets["4"] != 1 || buckets["1"]+buckets["2"]+buckets["5"] != 0 {
+		t.Errorf("driver buckets are %v, want one driver in 3 and one in 4", buckets)
+	}
+	rec = serveTest(h, "GET", "/drivers/tiers", "")
+	expectStatus(t, rec, http.StatusOK)
+	var tiers map[string][]Driver
+	decodeBody(t, rec, &tiers)
+	if len(tiers["3"]) != 1 || tiers["3"][0].ID != "2" || len(tiers["4"]) != 1 || tiers["4"][0].ID != "1" {
+		t.Errorf("driver tiers are %+v, want driver 2 in 3 and driver 1 in 4", tiers)
+	}
+
+	// Each type has its own aggregates, and none is served under the other.
+	for _, pair := range pairs {
+		for _, path := range pair {
+			rec := serveTest(h, "GET", path, "")
+			expectStatus(t, rec, http.StatusOK)
+			var entity Driver
+			decodeBody(t, rec, &entity)
+			if want := map[string]float64{pairs[0][0]: 3.5, pairs[0][1]: 3.5, pairs[1][0]: 2.75, pairs[1][1]: 2.75}[path]; entity.AverageRating != want {
+				t.Errorf("%s has average %v, want %v", path, entity.AverageRating, want)
+			}
+		}
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers/"+restaurants[0], ""), http.StatusNotFound)
+	expectStatus(t, serveTest(h, "GET", "/entities/restaurant/1", ""), http.StatusNotFound)
+	expectStatus(t, serveTest(h, "GET", "/entities/courier/"+restaurants[0], ""), http.StatusNotFound)
+}
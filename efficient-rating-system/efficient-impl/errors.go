@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// errorCode is the code of the error bodies of a status: validation_error
+// for 400, internal for 500 and the status text in snake case otherwise,
+// e.g. not_found or conflict.
+func errorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "validation_error"
+	case http.StatusInternalServerError:
+		return "internal"
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// recoverPanics answers a handler that panics with a 500 like any other
+// internal error, instead of net/http dropping the connection without a
+// response. http.ErrAbortHandler, which aborts a response on purpose, is let
+// through.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			log.Printf("panic serving %s %s (request %s): %v\n%s", r.Method, r.URL.Path, requestID(r), p, debug.Stack())
+			writeError(w, http.StatusInternalServerError, "internal error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func routeNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, "no such endpoint")
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// loggedRatings derives the current ratings from rating_events for
+// cfg.RatingEventLog: the latest event of every driver and user, unless it is
+// the tombstone a deleted rating leaves.
+const loggedRatings = `(SELECT driver_id, user_id, rating FROM rating_events e
+      WHERE rowid = (SELECT MAX(rowid) FROM rating_events WHERE driver_id = e.driver_id AND user_id = e.user_id)
+        AND rating IS NOT NULL)`
+
+// logRatingDeleted appends the tombstone of a deleted rating to the event
+// log, it is only kept with cfg.RatingEventLog.
+func logRatingDeleted(q dbtx, driverId, userId string) error {
+	if !cfg.RatingEventLog {
+		return nil
+	}
+	_, err := q.Exec("INSERT INTO rating_events (driver_id, user_id, rating) VALUES (?, ?, NULL)", driverId, userId)
+	return err
+}
+
+// RatingSubmission is one event of the log, Rating is null for the deletion
+// of the rating.
+type RatingSubmission struct {
+	Rating    *int      `json:"rating"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RatingHistory is every submission a user made for a driver, oldest first.
+type RatingHistory struct {
+	DriverID string             `json:"driver_id"`
+	UserID   string             `json:"user_id"`
+	Events   []RatingSubmission `json:"events"`
+}
+
+func getRatingHistory(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	events, err := getRatingEvents(params["driver_id"], storedUserID(params["user_id"]))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if len(events) == 0 {
+		writeError(w, http.StatusNotFound, "rating not found")
+		return
+	}
+	d, err := json.Marshal(RatingHistory{DriverID: params["driver_id"], UserID: params["user_id"], Events: events})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func getRatingEvents(driverId, userId string) ([]RatingSubmission, error) {
+	row, err := srv.DB().Query(`SELECT rating, created_at FROM rating_events
+    WHERE driver_id = ? AND user_id = ?
+    ORDER BY rowid`, driverId, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []RatingSubmission{}
+	for row.Next() {
+		var event RatingSubmission
+		var rating sql.NullInt64
+		if err = row.Scan(&rating, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if rating.Valid {
+			value := int(rating.Int64)
+			event.Rating = &value
+		}
+		list = append(list, event)
+	}
+	return list, row.Err()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRatingEventLog(t *testing.T) {
+	h := openTestDB(t, map[string]string{"RATING_EVENT_LOG": "true"})
+	rateTest(t, h, "1", "a", 2)
+	rateTest(t, h, "1", "a", 4)
+	rateTest(t, h, "1", "b", 5)
+	expectStatus(t, serveTest(h, "DELETE", "/drivers/1/ratings/b", ""), http.StatusNoContent)
+
+	history := func(userId string) []RatingSubmission {
+		t.Helper()
+		rec := serveTest(h, "GET", "/drivers/1/ratings/"+userId+"/history", "")
+		expectStatus(t, rec, http.StatusOK)
+		var body RatingHistory
+		decodeBody(t, rec, &body)
+		return body.Events
+	}
+	if events := history("a"); len(events) != 2 || *events[0].Rating != 2 || *events[1].Rating != 4 {
+		t.Fatalf("history of user a is %+v, want 2 then 4", events)
+	}
+	if events := history("b"); len(events) != 2 || *events[0].Rating != 5 || events[1].Rating != nil {
+		t.Fatalf("history of user b is %+v, want 5 then the deletion", events)
+	}
+	if sum, count := driverAggregates(t, "1"); sum != 4 || count != 1 {
+		t.Fatalf("driver 1 has a sum of %d over %d ratings, want only the latest rating of user a", sum, count)
+	}
+
+	// The aggregates come back from the log alone.
+	execTest(t, "DELETE FROM driver_ratings")
+	execTest(t, "UPDATE drivers SET rating_sum = 0, rating_count = 0")
+	if _, err := recomputeInBatches(cfg.RecomputeBatchSize); err != nil {
+		t.Fatal(err)
+	}
+	if sum, count := driverAggregates(t, "1"); sum != 4 || count != 1 {
+		t.Fatalf("driver 1 has a sum of %d over %d ratings recomputed from the log, want 4 over 1", sum, count)
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers/1/ratings/c/history", ""), http.StatusNotFound)
+}
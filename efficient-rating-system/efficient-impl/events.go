@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// eventPollInterval is how often the outbox is looked at when no write
+	// wakes the relay, and so how often a publish that failed is retried.
+	eventPollInterval = time.Second
+	// eventBatchSize bounds the events read from the outbox at once.
+	eventBatchSize = 100
+	// eventFlushTimeout is how long the broker has to acknowledge a batch.
+	eventFlushTimeout = 5 * time.Second
+)
+
+const (
+	ratingCreated = "RatingCreated"
+	ratingUpdated = "RatingUpdated"
+	ratingDeleted = "RatingDeleted"
+)
+
+// RatingEvent is published after a rating has been stored or deleted.
+// PreviousRating is set for a RatingUpdated and is the deleted rating of a
+// RatingDeleted, which has no Rating. RatingSum and RatingCount are the
+// aggregates of the driver after the change.
+type RatingEvent struct {
+	Type           string    `json:"type"`
+	DriverID       string    `json:"driver_id"`
+	UserID         string    `json:"user_id"`
+	Rating         int       `json:"rating,omitempty"`
+	PreviousRating *int      `json:"previous_rating,omitempty"`
+	RatingSum      int64     `json:"rating_sum"`
+	RatingCount    int64     `json:"rating_count"`
+	Source         string    `json:"source,omitempty"`
+	Region         string    `json:"region,omitempty"`
+	StoredAt       time.Time `json:"stored_at"`
+}
+
+// eventPublisher sends rating events, as JSON, to a message broker.
+type eventPublisher interface {
+	Publish(event []byte) error
+	// Flush returns once the broker has the events published so far, and
+	// an error when it can't tell that it has all of them.
+	Flush() error
+}
+
+// events is set up in main with the publisher of newPublisher, it is nil
+// when no broker is configured.
+var events *eventRelay
+
+// eventRelay publishes the events of the event_outbox table in the
+// background. The events are written to the outbox in the transaction of
+// their rating, so that none is lost when the broker is down or the process
+// dies, and deleted once the broker acknowledged them.
+type eventRelay struct {
+	publisher eventPublisher
+	wake      chan struct{}
+	closing   chan struct{}
+	stopped   chan struct{}
+}
+
+func newEventRelay(publisher eventPublisher) *eventRelay {
+	e := &eventRelay{
+		publisher: publisher,
+		wake:      make(chan struct{}, 1),
+		closing:   make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// record adds the event of a rating just written with q to the outbox. It
+// must be called in the transaction of the rating, and notify once it is
+// committed.
+func (e *eventRelay) record(q dbtx, r Rating) error {
+	if e == nil {
+		return nil
+	}
+	event := RatingEvent{Type: ratingCreated, DriverID: r.DriverID, UserID: r.UserID, Rating: r.Rating,
+		Source: r.Source, Region: r.Region, StoredAt: time.Now().UTC()}
+	// prev_rating is only set by the update branch of upsertRating.
+	var prev sql.NullInt64
+	err := q.QueryRow("SELECT prev_rating FROM driver_ratings WHERE driver_id = ? AND user_id = ?", r.DriverID, r.UserID).Scan(&prev)
+	if err != nil {
+		return err
+	}
+	if prev.Valid {
+		previous := int(prev.Int64)
+		event.Type, event.PreviousRating = ratingUpdated, &previous
+	}
+	return e.insert(q, event)
+}
+
+// recordDeleted adds the event of the rating of the user the driver just had
+// deleted with q, see record.
+func (e *eventRelay) recordDeleted(q dbtx, driverId, userId string, previous int) error {
+	if e == nil {
+		return nil
+	}
+	return e.insert(q, RatingEvent{Type: ratingDeleted, DriverID: driverId, UserID: userId, PreviousRating: &previous, StoredAt: time.Now().UTC()})
+}
+
+// insert completes the event with the aggregates of its driver and adds it
+// to the outbox.
+func (e *eventRelay) insert(q dbtx, event RatingEvent) error {
+	// The stored aggregates lag behind with coalesced or lazy updates, the
+	// ones of the event are computed from the ratings.
+	err := q.QueryRow("SELECT COALESCE(SUM(rating), 0), COUNT(*) FROM "+countedRatings()+" WHERE driver_id = ?", event.DriverID).
+		Scan(&event.RatingSum, &event.RatingCount)
+	if err != nil {
+		return err
+	}
+	d, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec("INSERT INTO event_outbox (payload) VALUES (?)", string(d))
+	return err
+}
+
+// notify wakes the relay up after events were committed.
+func (e *eventRelay) notify() {
+	if e == nil {
+		return
+	}
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stop makes a last attempt at publishing the outbox and stops the relay.
+// Events recorded afterwards stay in the outbox for the next start.
+func (e *eventRelay) stop() {
+	close(e.closing)
+	<-e.stopped
+}
+
+func (e *eventRelay) run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(eventPollInterval)
+	defer ticker.Stop()
+	for {
+		closing := false
+		select {
+		case <-e.wake:
+		case <-ticker.C:
+		case <-e.closing:
+			closing = true
+		}
+		if err := e.deliver(); err != nil {
+			log.Println("events: publish:", err)
+		}
+		if closing {
+			return
+		}
+	}
+}
+
+// deliver publishes the outbox in batches, in order, until it is empty or
+// publishing fails. The events of a batch are only deleted once the broker
+// acknowledged them with Flush, when it doesn't the whole batch is published
+// again on the next attempt. An event can so be published twice, e.g. when
+// the connection drops after the broker got it or the process dies before
+// it is deleted.
+func (e *eventRelay) deliver() error {
+	for {
+		ids, payloads, err := readOutbox()
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		for _, payload := range payloads {
+			if err = e.publisher.Publish(payload); err != nil {
+				return err
+			}
+		}
+		if err = e.publisher.Flush(); err != nil {
+			return err
+		}
+		if _, err = srv.DB().Exec("DELETE FROM event_outbox WHERE id <= ?", ids[len(ids)-1]); err != nil {
+			return err
+		}
+	}
+}
+
+// readOutbox returns the oldest events of the outbox.
+func readOutbox() ([]int64, [][]byte, error) {
+	row, err := srv.DB().Query("SELECT id, payload FROM event_outbox ORDER BY id LIMIT ?", eventBatchSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer row.Close()
+	var ids []int64
+	var payloads [][]byte
+	for row.Next() {
+		var id int64
+		var payload string
+		if err = row.Scan(&id, &payload); err != nil {
+			return nil, nil, err
+		}
+		ids, payloads = append(ids, id), append(payloads, []byte(payload))
+	}
+	return ids, payloads, row.Err()
+}
+
+// newPublisher returns the publisher of cfg.EventsBroker, nil when it is
+// empty.
+func newPublisher() (eventPublisher, error) {
+	switch cfg.EventsBroker {
+	case "":
+		return nil, nil
+	case "nats":
+		u, err := url.Parse(cfg.EventsURL)
+		if err != nil || u.Scheme != "nats" || u.Host == "" {
+			return nil, fmt.Errorf("EVENTS_URL must be a nats://host:port URL")
+		}
+		if cfg.EventsSubject == "" || strings.ContainsAny(cfg.EventsSubject, " \t\r\n") {
+			return nil, fmt.Errorf("EVENTS_SUBJECT must be a NATS subject without whitespace")
+		}
+		return &natsPublisher{addr: u.Host, subject: cfg.EventsSubject}, nil
+	}
+	return nil, fmt.Errorf("unknown EVENTS_BROKER %q", cfg.EventsBroker)
+}
+
+// natsPublisher publishes events as JSON messages with the plain text NATS
+// protocol. It connects on the first event and again after an error.
+type natsPublisher struct {
+	addr    string
+	subject string
+
+	mu   sync.Mutex
+	conn net.Conn
+	// pongs are the Flush calls waiting for the PONG of their PING, in the
+	// order of the PINGs.
+	pongs []chan error
+	// unflushed is set when events were published since the last PING,
+	// lost when the connection was closed before they were flushed, until
+	// Flush reports it.
+	unflushed, lost bool
+}
+
+func (p *natsPublisher) Publish(event []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(p.conn, "PUB %s %d\r\n%s\r\n", p.subject, len(event), event)
+	if err != nil {
+		p.drop(err)
+		return err
+	}
+	p.unflushed = true
+	return nil
+}
+
+// Flush sends a PING and waits for its PONG: the server answers in order, so
+// it has processed every PUB sent before. The events published on a
+// connection that has been closed since may be lost, they are reported.
+func (p *natsPublisher) Flush() error {
+	p.mu.Lock()
+	if p.lost {
+		p.lost = false
+		p.mu.Unlock()
+		return errors.New("nats: connection lost, events may not have been received")
+	}
+	if p.conn == nil {
+		p.mu.Unlock()
+		return nil
+	}
+	pong := make(chan error, 1)
+	if _, err := fmt.Fprint(p.conn, "PING\r\n"); err != nil {
+		p.drop(err)
+		p.lost = false
+		p.mu.Unlock()
+		return err
+	}
+	p.pongs = append(p.pongs, pong)
+	p.unflushed = false
+	conn := p.conn
+	p.mu.Unlock()
+	timer := time.NewTimer(eventFlushTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-pong:
+		return err
+	case <-timer.C:
+		p.mu.Lock()
+		if p.conn == conn {
+			p.drop(errors.New("nats: no PONG"))
+		}
+		p.lost = false
+		p.mu.Unlock()
+		return fmt.Errorf("nats: no PONG within %s", eventFlushTimeout)
+	}
+}
+
+// drop closes the connection after err, the Flush calls waiting on it fail
+// with err. p.mu must be held.
+func (p *natsPublisher) drop(err error) {
+	if p.conn == nil {
+		return
+	}
+	p.conn.Close()
+	p.conn = nil
+	p.lost = p.lost || p.unflushed
+	p.unflushed = false
+	for _, pong := range p.pongs {
+		pong <- err
+	}
+	p.pongs = nil
+}
+
+// connect reads the INFO the server greets with and sends CONNECT. The
+// server's PINGs are then answered in the background, it drops clients that
+// don't.
+func (p *natsPublisher) connect() error {
+	conn, err := net.DialTimeout("tcp", p.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	info, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(info, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("nats: unexpected greeting %q: %v", info, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	if _, err = fmt.Fprint(conn, "CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"articles\"}\r\n"); err != nil {
+		conn.Close()
+		return err
+	}
+	p.conn = conn
+	go p.readLoop(conn, r)
+	return nil
+}
+
+func (p *natsPublisher) readLoop(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			p.mu.Lock()
+			_, err = fmt.Fprint(conn, "PONG\r\n")
+			p.mu.Unlock()
+		case strings.HasPrefix(line, "PONG"):
+			p.mu.Lock()
+			if p.conn == conn && len(p.pongs) > 0 {
+				p.pongs[0] <- nil
+				p.pongs = p.pongs[1:]
+			}
+			p.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			log.Println("events: nats:", strings.TrimSpace(line))
+		}
+		if err != nil {
+			break
+		}
+	}
+	p.mu.Lock()
+	if p.conn == conn {
+		p.drop(errors.New("nats: connection closed"))
+	}
+	p.mu.Unlock()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// stubPublisher keeps the events it is given.
+type stubPublisher struct {
+	mu          sync.Mutex
+	events      []RatingEvent
+	failFlushes int
+}
+
+func (p *stubPublisher) Publish(event []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var e RatingEvent
+	if err := json.Unmarshal(event, &e); err != nil {
+		return err
+	}
+	p.events = append(p.events, e)
+	return nil
+}
+
+// Flush fails while failFlushes is positive, one failure per call.
+func (p *stubPublisher) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failFlushes > 0 {
+		p.failFlushes--
+		return errors.New("broker did not acknowledge")
+	}
+	return nil
+}
+
+func (p *stubPublisher) published() []RatingEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]RatingEvent(nil), p.events...)
+}
+
+func TestRatingEventsPublished(t *testing.T) {
+	h := openTestDB(t, nil)
+	stub := &stubPublisher{}
+	events = newEventRelay(stub)
+	rateTest(t, h, "1", "a", 3)
+	rateTest(t, h, "1", "a", 5)
+	eventually(t, func() bool { return len(stub.published()) == 2 })
+	list := stub.published()
+	if e := list[0]; e.Type != ratingCreated || e.DriverID != "1" || e.UserID != "a" || e.Rating != 3 || e.RatingCount != 1 {
+		t.Fatalf("first event is %+v, want a created rating of 3", e)
+	}
+	if e := list[1]; e.Type != ratingUpdated || e.PreviousRating == nil || *e.PreviousRating != 3 || e.RatingSum != 5 {
+		t.Fatalf("second event is %+v, want an update from 3 to 5", e)
+	}
+}
+
+func outboxSize(tb testing.TB) int {
+	tb.Helper()
+	var n int
+	if err := srv.DB().QueryRow("SELECT COUNT(*) FROM event_outbox").Scan(&n); err != nil {
+		tb.Fatal(err)
+	}
+	return n
+}
+
+// TestOutboxRedelivery checks that the events the broker didn't acknowledge
+// stay in the outbox and are published again.
+func TestOutboxRedelivery(t *testing.T) {
+	h := openTestDB(t, nil)
+	stub := &stubPublisher{failFlushes: 1}
+	// The relay isn't started, deliver is called here.
+	events = &eventRelay{publisher: stub, wake: make(chan struct{}, 1)}
+	defer func() { events = nil }()
+	rateTest(t, h, "1", "a", 3)
+	rateTest(t, h, "2", "a", 4)
+	if err := events.deliver(); err == nil {
+		t.Fatal("deliver succeeded without the acknowledgement")
+	}
+	if n := outboxSize(t); n != 2 {
+		t.Fatalf("outbox has %d events after the failed flush, want 2", n)
+	}
+	if err := events.deliver(); err != nil {
+		t.Fatal(err)
+	}
+	if n := outboxSize(t); n != 0 {
+		t.Fatalf("outbox has %d events once acknowledged, want none", n)
+	}
+	list := stub.published()
+	if len(list) != 4 || list[2].DriverID != "1" || list[3].DriverID != "2" {
+		t.Fatalf("published %+v, want both events twice in order", list)
+	}
+}
+
+// fakeNATS accepts NATS connections one at a time. The first one is closed
+// when it gets a PING, the next ones answer it with PONG. The payloads are
+// sent on pubs.
+func fakeNATS(t *testing.T, pubs chan<- string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for n := 0; ; n++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			fmt.Fprint(conn, "INFO {}\r\n")
+			r := bufio.NewReader(conn)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					break
+				}
+				if strings.HasPrefix(line, "PUB ") {
+					payload, _ := r.ReadString('\n')
+					pubs <- strings.TrimSpace(payload)
+				} else if strings.HasPrefix(line, "PING") {
+					if n == 0 {
+						break
+					}
+					fmt.Fprint(conn, "PONG\r\n")
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestNATSFlushWaitsForPong(t *testing.T) {
+	h := openTestDB(t, nil)
+	pubs := make(chan string, 10)
+	events = &eventRelay{publisher: &natsPublisher{addr: fakeNATS(t, pubs), subject: "ratings"}, wake: make(chan struct{}, 1)}
+	defer func() { events = nil }()
+	rateTest(t, h, "1", "a", 5)
+	// The first connection is closed instead of answering the PING.
+	if err := events.deliver(); err == nil {
+		t.Fatal("deliver succeeded without a PONG")
+	}
+	if n := outboxSize(t); n != 1 {
+		t.Fatalf("outbox has %d events without a PONG, want 1", n)
+	}
+	if err := events.deliver(); err != nil {
+		t.Fatal(err)
+	}
+	if n := outboxSize(t); n != 0 {
+		t.Fatalf("outbox has %d events after the PONG, want none", n)
+	}
+	for i := 0; i < 2; i++ {
+		var e RatingEvent
+		if err := json.Unmarshal([]byte(<-pubs), &e); err != nil || e.DriverID != "1" || e.Rating != 5 {
+			t.Fatalf("publish %d is %+v, %v, want the rating of driver 1", i, e, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxExternalIDs caps the ids of one GET /drivers/by-external.
+const maxExternalIDs = 100
+
+// ExternalDriver is the average of a driver looked up by its external id.
+type ExternalDriver struct {
+	ExternalID    string  `json:"external_id"`
+	ID            string  `json:"id"`
+	AverageRating float64 `json:"avg_rating"`
+}
+
+// externalID reads the "external_id" of a driver_info JSON object.
+func externalID(driverInfo string) string {
+	return infoField(driverInfo, "external_id")
+}
+
+// infoField reads a string or number field of a driver_info JSON object. It
+// is empty when driver_info is not a JSON object or does not have the field.
+func infoField(driverInfo, name string) string {
+	var info map[string]json.RawMessage
+	if json.Unmarshal([]byte(driverInfo), &info) != nil || len(info[name]) == 0 {
+		return ""
+	}
+	var v string
+	if json.Unmarshal(info[name], &v) == nil {
+		return v
+	}
+	var n json.Number
+	if json.Unmarshal(info[name], &n) == nil {
+		return n.String()
+	}
+	return ""
+}
+
+func getDriversByExternalID(w http.ResponseWriter, r *http.Request) {
+	var ids []string
+	for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 || len(ids) > maxExternalIDs {
+		writeError(w, http.StatusBadRequest, (&paramError{"ids", fmt.Sprintf("must have between 1 and %d comma separated ids", maxExternalIDs)}).Error())
+		return
+	}
+	list, err := getDriversByExternalIDList(ids)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(list)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getDriversByExternalIDList resolves external ids to drivers, unknown ids
+// are left out.
+func getDriversByExternalIDList(ids []string) ([]ExternalDriver, error) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	avg, avgArgs := averageExpr("d", cfg.AggFunction)
+	args = append(avgArgs, args...)
+	row, err := srv.DB().Query(`SELECT external_id, id, COALESCE(`+avg+`, 0) AS avg_rating
+    FROM drivers d
+    WHERE deleted_at IS NULL AND external_id IN (`+placeholders+`)
+    ORDER BY external_id, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []ExternalDriver{}
+	for row.Next() {
+		var driver ExternalDriver
+		err = row.Scan(&driver.ExternalID, &driver.ID, &driver.AverageRating)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, driver)
+	}
+	return list, row.Err()
+}
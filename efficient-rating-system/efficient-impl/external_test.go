@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestDriversByExternalID(t *testing.T) {
+	h := openTestDB(t, nil)
+	ids := map[string]string{}
+	for _, info := range []string{`{"external_id": "ext-1"}`, `{"external_id": 42}`, `{"name": "Ann"}`} {
+		rec := serveTest(h, "POST", "/drivers", `{"driver_info": `+info+`}`)
+		expectStatus(t, rec, http.StatusCreated)
+		var driver Driver
+		decodeBody(t, rec, &driver)
+		ids[info] = driver.ID
+	}
+	first, second := ids[`{"external_id": "ext-1"}`], ids[`{"external_id": 42}`]
+	rateTest(t, h, first, "a", 4)
+	rec := serveTest(h, "GET", "/drivers/by-external?ids=42,ext-1,missing", "")
+	expectStatus(t, rec, http.StatusOK)
+	var list []ExternalDriver
+	decodeBody(t, rec, &list)
+	want := fmt.Sprint([]ExternalDriver{{"42", second, 0}, {"ext-1", first, 4}})
+	if got := fmt.Sprint(list); got != want {
+		t.Fatalf("drivers by external id are %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import "net/url"
+
+// parseHasFields reads the has_field parameters of GET /drivers, each one is
+// a top level key of driver_info the listed drivers must have set.
+func parseHasFields(query url.Values) ([]string, error) {
+	fields := query["has_field"]
+	for _, field := range fields {
+		if !isFieldName(field) {
+			return nil, &paramError{"has_field", "must be a field name of letters, digits and underscores"}
+		}
+	}
+	return fields, nil
+}
+
+func isFieldName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// infoFieldExpr is the SQL expression of a driver_info field of the drivers
+// in alias, the path of the field is its argument. Queries reading driver_info
+// fields go through it: json_extract fails the whole query on a single legacy
+// driver_info that isn't JSON, here such a row reads NULL for every field.
+func infoFieldExpr(alias string) string {
+	return "CASE WHEN json_valid(" + alias + ".driver_info) THEN json_extract(" + alias + ".driver_info, ?) END"
+}
+
+// infoFieldPath is the argument of infoFieldExpr for a top level field,
+// checked with isFieldName.
+func infoFieldPath(field string) string {
+	return "$." + field
+}
+
+// hasFieldsCondition keeps the drivers whose driver_info has every field set
+// to something other than null. A driver_info that isn't JSON has no fields.
+func hasFieldsCondition(alias string, fields []string) (string, []interface{}) {
+	cond, args := "", []interface{}{}
+	for _, field := range fields {
+		cond += " AND " + infoFieldExpr(alias) + " IS NOT NULL"
+		args = append(args, infoFieldPath(field))
+	}
+	return cond, args
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxFormulaLength caps the formula of GET /drivers/ranked, which also bounds
+// how deep the parser recurses.
+const maxFormulaLength = 200
+
+// formulaVars are the values a formula is evaluated over for one driver.
+type formulaVars struct {
+	Avg, Count, Stddev float64
+}
+
+// formula is a parsed scoring formula. Only numbers, the variables of
+// formulaVars, + - * / ^, parentheses and the functions of formulaFuncs are
+// accepted, so evaluating one can't do anything else than compute a number.
+type formula interface {
+	eval(v formulaVars) float64
+}
+
+type formulaNumber float64
+
+func (n formulaNumber) eval(formulaVars) float64 { return float64(n) }
+
+type formulaVar string
+
+func (n formulaVar) eval(v formulaVars) float64 {
+	switch n {
+	case "avg":
+		return v.Avg
+	case "count":
+		return v.Count
+	default:
+		return v.Stddev
+	}
+}
+
+type formulaOp struct {
+	op          byte
+	left, right formula
+}
+
+func (n formulaOp) eval(v formulaVars) float64 {
+	l, r := n.left.eval(v), n.right.eval(v)
+	switch n.op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	case '/':
+		return l / r
+	default:
+		return math.Pow(l, r)
+	}
+}
+
+type formulaNeg struct{ arg formula }
+
+func (n formulaNeg) eval(v formulaVars) float64 { return -n.arg.eval(v) }
+
+type formulaCall struct {
+	fn   func(args []float64) float64
+	args []formula
+}
+
+func (n formulaCall) eval(v formulaVars) float64 {
+	args := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		args[i] = arg.eval(v)
+	}
+	return n.fn(args)
+}
+
+type formulaFunc struct {
+	arity int
+	fn    func(args []float64) float64
+}
+
+var formulaFuncs = map[string]formulaFunc{
+	"log":   {1, func(a []float64) float64 { return math.Log(a[0]) }},
+	"log10": {1, func(a []float64) float64 { return math.Log10(a[0]) }},
+	"sqrt":  {1, func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"abs":   {1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"min":   {2, func(a []float64) float64 { return math.Min(a[0], a[1]) }},
+	"max":   {2, func(a []float64) float64 { return math.Max(a[0], a[1]) }},
+}
+
+// parseFormula parses a scoring formula like avg*log(count+1). The usual
+// precedence applies, ^ binds tightest and is right associative.
+func parseFormula(s string) (formula, error) {
+	if len(s) > maxFormulaLength {
+		return nil, fmt.Errorf("is longer than %d characters", maxFormulaLength)
+	}
+	p := &formulaParser{s: s}
+	f, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos < len(p.s) {
+		return nil, fmt.Errorf("unexpected %q at %d", p.s[p.pos], p.pos)
+	}
+	return f, nil
+}
+
+type formulaParser struct {
+	s   string
+	pos int
+}
+
+func (p *formulaParser) skipSpace() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// accept consumes c if it is the next character.
+func (p *formulaParser) accept(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *formulaParser) expr() (formula, error) {
+	left, err := p.term()
+	for err == nil {
+		var op byte
+		if p.accept('+') {
+			op = '+'
+		} else if p.accept('-') {
+			op = '-'
+		} else {
+			return left, nil
+		}
+		var right formula
+		if right, err = p.term(); err == nil {
+			left = formulaOp{op, left, right}
+		}
+	}
+	return nil, err
+}
+
+func (p *formulaParser) term() (formula, error) {
+	left, err := p.unary()
+	for err == nil {
+		var op byte
+		if p.accept('*') {
+			op = '*'
+		} else if p.accept('/') {
+			op = '/'
+		} else {
+			return left, nil
+		}
+		var right formula
+		if right, err = p.unary(); err == nil {
+			left = formulaOp{op, left, right}
+		}
+	}
+	return nil, err
+}
+
+func (p *formulaParser) unary() (formula, error) {
+	if p.accept('-') {
+		arg, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return formulaNeg{arg}, nil
+	}
+	base, err := p.primary()
+	if err != nil || !p.accept('^') {
+		return base, err
+	}
+	exp, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	return formulaOp{'^', base, exp}, nil
+}
+
+func (p *formulaParser) primary() (formula, error) {
+	if p.accept('(') {
+		f, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(')') {
+			return nil, fmt.Errorf("missing ) at %d", p.pos)
+		}
+		return f, nil
+	}
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) && (isFormulaDigit(p.s[p.pos]) || p.s[p.pos] == '.') {
+		p.pos++
+	}
+	if p.pos > start {
+		n, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", p.s[start:p.pos])
+		}
+		return formulaNumber(n), nil
+	}
+	for p.pos < len(p.s) && (isFormulaLetter(p.s[p.pos]) || p.pos > start && isFormulaDigit(p.s[p.pos])) {
+		p.pos++
+	}
+	name := strings.ToLower(p.s[start:p.pos])
+	switch {
+	case name == "":
+		if p.pos == len(p.s) {
+			return nil, fmt.Errorf("unexpected end")
+		}
+		return nil, fmt.Errorf("unexpected %q at %d", p.s[p.pos], p.pos)
+	case name == "avg" || name == "count" || name == "stddev":
+		return formulaVar(name), nil
+	}
+	fn, ok := formulaFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown name %q, the variables are avg, count and stddev", name)
+	}
+	if !p.accept('(') {
+		return nil, fmt.Errorf("%s needs arguments in parentheses", name)
+	}
+	var args []formula
+	for len(args) < fn.arity {
+		if len(args) > 0 && !p.accept(',') {
+			return nil, fmt.Errorf("%s takes %d argument(s)", name, fn.arity)
+		}
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if !p.accept(')') {
+		return nil, fmt.Errorf("%s takes %d argument(s)", name, fn.arity)
+	}
+	return formulaCall{fn.fn, args}, nil
+}
+
+func isFormulaDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isFormulaLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' }
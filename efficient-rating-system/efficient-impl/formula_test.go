@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestParseFormula(t *testing.T) {
+	vars := formulaVars{Avg: 4, Count: 9, Stddev: 0.5}
+	for s, want := range map[string]float64{
+		"1 + 2 * 3":                          7,
+		"(1 + 2) * 3":                        9,
+		"avg * sqrt(count)":                  12,
+		"max(avg, count) - min(avg, stddev)": 8.5,
+		"-avg + 2^3":                         4,
+	} {
+		f, err := parseFormula(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if got := f.eval(vars); got != want {
+			t.Errorf("%s is %v, want %v", s, got, want)
+		}
+	}
+	for _, s := range []string{"", "avg +", "os.Exit(1)", "exec(avg)", "name", "avg; count", "min(avg)", "((avg)"} {
+		if _, err := parseFormula(s); err == nil {
+			t.Errorf("%q was accepted", s)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+//go:generate protoc -I proto --go_out=proto --go_opt=paths=source_relative --go-grpc_out=proto --go-grpc_opt=paths=source_relative rating.proto
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	ratingpb "github.com/djumanoff/articles/efficient-rating-system/naive-impl/proto"
+)
+
+// rpcServer serves the rating service on cfg.GRPCAddr, it is nil when
+// GRPC_ADDR is not set.
+var rpcServer *grpc.Server
+
+// ratingServer is the gRPC server of the rating service. Like the REST
+// handlers it only turns messages into the input of ratingService and back.
+type ratingServer struct {
+	ratingpb.UnimplementedRatingServiceServer
+}
+
+func newRPCServer() *grpc.Server {
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(trackRPC, recoverRPC))
+	ratingpb.RegisterRatingServiceServer(s, ratingServer{})
+	return s
+}
+
+// serveRPC starts rpcServer on addr, it runs until stopRPC.
+func serveRPC(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	rpcServer = newRPCServer()
+	go func() {
+		if err := rpcServer.Serve(ln); err != nil {
+			log.Println("grpc:", err)
+		}
+	}()
+	return nil
+}
+
+// stopRPC stops rpcServer the way serveUntil stops the HTTP server: the
+// calls in flight have until ctx is done to finish, the ones left are then
+// cancelled.
+func stopRPC(ctx context.Context) {
+	if rpcServer == nil {
+		return
+	}
+	stopped := make(chan struct{})
+	go func() {
+		rpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		rpcServer.Stop()
+	}
+}
+
+// trackRPC counts the calls being served along with the requests, see
+// Server.InFlight.
+func trackRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	srv.inFlight.Add(1)
+	defer srv.inFlight.Add(-1)
+	return handler(ctx, req)
+}
+
+// recoverRPC turns a panic into an internal error like recoverPanics does.
+func recoverRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("panic: %s: %v", info.FullMethod, p)
+			resp, err = nil, status.Error(codes.Internal, "internal error")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// rpcCaller is the caller of a call as ratingService reads it: a request
+// with the authorization metadata as its Authorization header, and the role
+// withRole would give it.
+func rpcCaller(ctx context.Context) *http.Request {
+	r := (&http.Request{URL: &url.URL{}, Header: http.Header{}}).WithContext(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		r.Header.Add("Authorization", v)
+	}
+	role := rolePublic
+	if adminAuthorized(r) {
+		role = roleAdmin
+	}
+	return r.WithContext(context.WithValue(ctx, roleKey{}, role))
+}
+
+// rpcQuery is the query a REST client would send for the fields of a
+// request, so that they are validated by the same parsers. Empty values and
+// zero numbers are left out, they stand for the defaults.
+func rpcQuery(pairs ...string) url.Values {
+	query := url.Values{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			query.Set(pairs[i], pairs[i+1])
+		}
+	}
+	return query
+}
+
+func rpcInt(n int32) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(int(n))
+}
+
+// rpcError is the status of err: the code of the HTTP status of a
+// serviceError or a paramError, an internal error otherwise.
+func rpcError(ctx context.Context, err error) error {
+	var perr *paramError
+	if errors.As(err, &perr) {
+		return status.Error(codes.InvalidArgument, perr.Error())
+	}
+	var serr *serviceError
+	if !errors.As(err, &serr) {
+		log.Printf("internal error: %v", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+	code := codes.Unknown
+	switch serr.Status {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound, http.StatusGone:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+		_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(int(serr.RetryAfter.Seconds())+1)))
+	}
+	return status.Error(code, serr.Message)
+}
+
+func (ratingServer) RateDriver(ctx context.Context, req *ratingpb.RateDriverRequest) (*ratingpb.RateDriverResponse, error) {
+	in := req.GetRating()
+	rating := Rating{
+		DriverID: req.GetDriverId(),
+		UserID:   in.GetUserId(),
+		Rating:   int(in.GetRating()),
+		Source:   in.GetSource(),
+		Comment:  in.GetComment(),
+		Region:   in.GetRegion(),
+		Tags:     in.GetTags(),
+	}
+	id, err := service.RateDriver(rpcCaller(ctx), rating, nil)
+	if err != nil {
+		return nil, rpcError(ctx, err)
+	}
+	return &ratingpb.RateDriverResponse{StatusId: id}, nil
+}
+
+func (ratingServer) ListDrivers(ctx context.Context, req *ratingpb.ListDriversRequest) (*ratingpb.ListDriversResponse, error) {
+	query := rpcQuery("limit", rpcInt(req.GetLimit()), "offset", rpcInt(req.GetOffset()), "sort", req.GetSort(),
+		"avg", req.GetAvg(), "min_count", rpcInt(req.GetMinCount()))
+	if req.MinRating != nil {
+		query.Set("min_rating", strconv.FormatFloat(req.GetMinRating(), 'f', -1, 64))
+	}
+	query["has_field"] = req.GetHasField()
+	params, err := parseListQuery(query, listOptions{
+		DefaultLimit: defaultDriversLimit,
+		MaxLimit:     maxDriversLimit,
+		Sorts:        driverSorts,
+		Averages:     averageOptions(),
+	})
+	var fields []string
+	if err == nil {
+		fields, err = parseHasFields(query)
+	}
+	var minAvg *float64
+	var minCount int
+	if err == nil {
+		minAvg, minCount, err = parseDriverMinimums(query)
+	}
+	if err != nil {
+		return nil, rpcError(ctx, err)
+	}
+	list, total, err := service.ListDrivers(driverQuery{
+		UserID:     storedUserID(req.GetUserId()),
+		Average:    params.Average,
+		Sort:       params.Sort,
+		Limit:      params.Limit,
+		Offset:     params.Offset,
+		HasFields:  fields,
+		MinRating:  minAvg,
+		MinCount:   minCount,
+		EntityType: entityDriver,
+	}, true)
+	if err == nil {
+		err = service.showDrivers(entityDriver, list, params)
+	}
+	if err != nil {
+		return nil, rpcError(ctx, err)
+	}
+	resp := &ratingpb.ListDriversResponse{Drivers: make([]*ratingpb.Driver, len(list)), Total: int32(total)}
+	for i := range list {
+		resp.Drivers[i] = rpcDriver(&list[i])
+	}
+	if params.Offset+params.Limit < total {
+		resp.NextOffset = int32(params.Offset + params.Limit)
+	}
+	return resp, nil
+}
+
+func (ratingServer) ListDriverRatings(ctx context.Context, req *ratingpb.ListDriverRatingsRequest) (*ratingpb.ListDriverRatingsResponse, error) {
+	query := rpcQuery("limit", rpcInt(req.GetLimit()), "before", req.GetBefore(), "min_rating", rpcInt(req.GetMinRating()))
+	if req.HasComment != nil {
+		query.Set("has_comment", strconv.FormatBool(req.GetHasComment()))
+	}
+	filter, err := parseRatingFilter(query)
+	var params listParams
+	if err == nil {
+		params, err = parseListQuery(query, listOptions{DefaultLimit: defaultFeedLimit, MaxLimit: maxFeedLimit, Before: true})
+	}
+	if err != nil {
+		return nil, rpcError(ctx, err)
+	}
+	page, err := service.ListDriverRatings(rpcCaller(ctx), req.GetDriverId(), filter, params.Before, params.Limit)
+	if err != nil {
+		return nil, rpcError(ctx, err)
+	}
+	resp := &ratingpb.ListDriverRatingsResponse{Ratings: make([]*ratingpb.Rating, len(page.Ratings)), Next: page.Next, Total: int32(page.Total)}
+	for i, rating := range page.Ratings {
+		resp.Ratings[i] = rpcRating(rating)
+	}
+	return resp, nil
+}
+
+func (ratingServer) GetDriver(ctx context.Context, req *ratingpb.GetDriverRequest) (*ratingpb.Driver, error) {
+	params, err := parseListQuery(rpcQuery("avg", req.GetAvg()), listOptions{Averages: averageOptions()})
+	if err != nil {
+		return nil, rpcError(ctx, err)
+	}
+	driver, err := service.GetDriver(entityDriver, req.GetDriverId(), driverRead{
+		UserID:      storedUserID(req.GetUserId()),
+		ExcludeUser: storedUserID(req.GetExcludeUser()),
+		Average:     params.Average,
+	})
+	if err != nil {
+		return nil, rpcError(ctx, err)
+	}
+	return rpcDriver(driver), nil
+}
+
+func rpcDriver(d *Driver) *ratingpb.Driver {
+	driver := &ratingpb.Driver{
+		Id:         d.ID,
+		DriverInfo: d.DriverInfo,
+		AvgRating:  d.AverageRating,
+		Confidence: d.Confidence,
+		TrustScore: d.TrustScore,
+	}
+	if d.UserRating != nil {
+		rating := int32(*d.UserRating)
+		driver.UserRating = &rating
+	}
+	return driver
+}
+
+func rpcRating(r Rating) *ratingpb.Rating {
+	rating := &ratingpb.Rating{
+		UserId:     r.UserID,
+		DriverId:   r.DriverID,
+		Rating:     int32(r.Rating),
+		Source:     r.Source,
+		Comment:    r.Comment,
+		Region:     r.Region,
+		Tags:       r.Tags,
+		Moderation: r.Moderation,
+	}
+	if r.CreatedAt != nil {
+		rating.CreatedAt = r.CreatedAt.UTC().Format(time.RFC3339)
+	}
+	if r.UpdatedAt != nil {
+		rating.UpdatedAt = r.UpdatedAt.UTC().Format(time.RFC3339)
+	}
+	return rating
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	ratingpb "github.com/djumanoff/articles/efficient-rating-system/naive-impl/proto"
+)
+
+// rpcTestClient serves the rating service in memory and returns a client of
+// it.
+func rpcTestClient(t *testing.T) ratingpb.RatingServiceClient {
+	ln := bufconn.Listen(1 << 20)
+	s := newRPCServer()
+	go s.Serve(ln)
+	t.Cleanup(s.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return ratingpb.NewRatingServiceClient(conn)
+}
+
+func expectCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Fatalf("call failed with %v, want %s", err, code)
+	}
+}
+
+func TestRPCRatingService(t *testing.T) {
+	h := openTestDB(t, nil)
+	client := rpcTestClient(t)
+	ctx := context.Background()
+	for _, r := range []*ratingpb.Rating{{UserId: "a", Rating: 4, Comment: "fine"}, {UserId: "b", Rating: 2}} {
+		if _, err := client.RateDriver(ctx, &ratingpb.RateDriverRequest{DriverId: "1", Rating: r}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := client.RateDriver(ctx, &ratingpb.RateDriverRequest{DriverId: "1", Rating: &ratingpb.Rating{UserId: "a", Rating: 9}})
+	expectCode(t, err, codes.InvalidArgument)
+	_, err = client.RateDriver(ctx, &ratingpb.RateDriverRequest{DriverId: "404", Rating: &ratingpb.Rating{UserId: "a", Rating: 3}})
+	expectCode(t, err, codes.NotFound)
+
+	driver, err := client.GetDriver(ctx, &ratingpb.GetDriverRequest{DriverId: "1", UserId: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if driver.AvgRating != 3 || driver.GetUserRating() != 4 || driver.TrustScore == nil {
+		t.Fatalf("driver is %v, want an average of 3, 4 from user a and a trust score", driver)
+	}
+	// REST reads the same driver through the same service.
+	rec := serveTest(h, "GET", "/drivers/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	var rest Driver
+	decodeBody(t, rec, &rest)
+	if rest.AverageRating != driver.AvgRating || *rest.TrustScore != driver.GetTrustScore() {
+		t.Fatalf("REST driver is %+v, gRPC one %v", rest, driver)
+	}
+	_, err = client.GetDriver(ctx, &ratingpb.GetDriverRequest{DriverId: "404"})
+	expectCode(t, err, codes.NotFound)
+
+	list, err := client.ListDrivers(ctx, &ratingpb.ListDriversRequest{Sort: sortRatingDesc, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Drivers) != 2 || list.Drivers[0].Id != "1" || list.Total != seedDriverCount || list.NextOffset != 2 {
+		t.Fatalf("drivers are %v, want 2 of %d starting with driver 1 and the next page at 2", list, seedDriverCount)
+	}
+	_, err = client.ListDrivers(ctx, &ratingpb.ListDriversRequest{Sort: "name"})
+	expectCode(t, err, codes.InvalidArgument)
+	_, err = client.ListDrivers(ctx, &ratingpb.ListDriversRequest{Limit: maxDriversLimit + 1})
+	expectCode(t, err, codes.InvalidArgument)
+
+	page, err := client.ListDriverRatings(ctx, &ratingpb.ListDriverRatingsRequest{DriverId: "1", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Ratings) != 1 || page.Total != 2 || page.Next == "" {
+		t.Fatalf("first page is %v, want 1 of 2 ratings and a next page", page)
+	}
+	older, err := client.ListDriverRatings(ctx, &ratingpb.ListDriverRatingsRequest{DriverId: "1", Limit: 1, Before: page.Next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(older.Ratings) != 1 || older.Ratings[0].UserId == page.Ratings[0].UserId || older.Next != "" {
+		t.Fatalf("second page is %v after %v, want the other rating and no next page", older, page)
+	}
+	hasComment := true
+	commented, err := client.ListDriverRatings(ctx, &ratingpb.ListDriverRatingsRequest{DriverId: "1", HasComment: &hasComment})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commented.Ratings) != 1 || commented.Ratings[0].Comment != "fine" || commented.Ratings[0].CreatedAt == "" {
+		t.Fatalf("commented ratings are %v, want the one of user a", commented)
+	}
+	_, err = client.ListDriverRatings(ctx, &ratingpb.ListDriverRatingsRequest{DriverId: "1", Before: "not a cursor"})
+	expectCode(t, err, codes.InvalidArgument)
+}
+
+// TestRPCIdentity checks that the rating of a call is the one of the subject
+// of its token, as with REST.
+func TestRPCIdentity(t *testing.T) {
+	openTestDB(t, map[string]string{"JWT_SECRET": "jwt-secret"})
+	client := rpcTestClient(t)
+	rating := &ratingpb.RateDriverRequest{DriverId: "2", Rating: &ratingpb.Rating{UserId: "mallory", Rating: 5}}
+	_, err := client.RateDriver(context.Background(), rating)
+	expectCode(t, err, codes.Unauthenticated)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+signTest(t, "jwt-secret", "ann"))
+	if _, err = client.RateDriver(ctx, rating); err != nil {
+		t.Fatal(err)
+	}
+	if _, count := driverAggregates(t, "2"); count != 1 {
+		t.Fatalf("driver 2 has %d ratings, want 1", count)
+	}
+	var user string
+	if err = srv.DB().QueryRow("SELECT user_id FROM driver_ratings WHERE driver_id = 2").Scan(&user); err != nil || user != "ann" {
+		t.Fatalf("rating is by %q, %v, want ann of the token", user, err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// schemaVersion is the version of the schema the code expects. It is stored
+// in the user_version pragma, next to schema_migrations, and must be bumped
+// along with every new file in migrations.
+const schemaVersion = 14
+
+type Health struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Readiness struct {
+	Health
+	SchemaVersion         int   `json:"schema_version"`
+	ExpectedSchemaVersion int   `json:"expected_schema_version"`
+	InFlightRequests      int64 `json:"in_flight_requests"`
+}
+
+func setSchemaVersion(q dbtx, version int) error {
+	_, err := q.Exec(fmt.Sprintf("PRAGMA user_version = %d", version))
+	return err
+}
+
+func getSchemaVersion(q dbtx) (int, error) {
+	var version int
+	err := q.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// healthz tells the process is up and can reach the database.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	health := Health{Status: "ok"}
+	status := http.StatusOK
+	if err := store.Ping(r.Context()); err != nil {
+		health.Status, health.Error = "unavailable", err.Error()
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, health)
+}
+
+// readyz reports ready only when the database schema is at the version the
+// code expects, so traffic isn't routed to an instance with a stale schema,
+// and not while the server is draining on shutdown.
+func readyz(w http.ResponseWriter, r *http.Request) {
+	health := Readiness{Health: Health{Status: "ready"}, ExpectedSchemaVersion: schemaVersion, InFlightRequests: srv.InFlight()}
+	status := http.StatusOK
+	version, err := getSchemaVersion(srv.DB())
+	health.SchemaVersion = version
+	if srv.Draining() {
+		health.Status, health.Error = "not ready", "shutting down"
+		status = http.StatusServiceUnavailable
+	} else if err != nil {
+		health.Status, health.Error = "not ready", err.Error()
+		status = http.StatusServiceUnavailable
+	} else if version != schemaVersion {
+		health.Status = "not ready"
+		health.Error = fmt.Sprintf("schema is at version %d, expected %d", version, schemaVersion)
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, health)
+}
+
+func writeHealth(w http.ResponseWriter, status int, health interface{}) {
+	d, err := json.Marshal(health)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
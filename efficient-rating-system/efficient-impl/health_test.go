@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReadyzWithStaleSchema(t *testing.T) {
+	h := openTestDB(t, nil)
+	expectStatus(t, serveTest(h, "GET", "/readyz", ""), http.StatusOK)
+	if err := setSchemaVersion(srv.DB(), schemaVersion-1); err != nil {
+		t.Fatal(err)
+	}
+	rec := serveTest(h, "GET", "/readyz", "")
+	expectStatus(t, rec, http.StatusServiceUnavailable)
+	var readiness Readiness
+	decodeBody(t, rec, &readiness)
+	if readiness.Status != "not ready" || readiness.SchemaVersion != schemaVersion-1 || readiness.ExpectedSchemaVersion != schemaVersion {
+		t.Fatalf("readiness is %+v, want not ready at version %d of %d", readiness, schemaVersion-1, schemaVersion)
+	}
+	expectStatus(t, serveTest(h, "GET", "/healthz", ""), http.StatusOK)
+}
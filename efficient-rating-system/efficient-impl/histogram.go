@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	minRating = 1
+	maxRating = 5
+)
+
+// getDriverDistribution returns how many ratings of each score from 1 to 5
+// the driver received, or with ?as=percent the share of each score out of
+// 100 (all zero when the driver has no ratings). Unknown drivers get 404.
+func getDriverDistribution(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	driverId := params["driver_id"]
+	as := r.URL.Query().Get("as")
+	if as != "" && as != "count" && as != "percent" {
+		writeError(w, http.StatusBadRequest, (&paramError{"as", "must be count or percent"}).Error())
+		return
+	}
+	found, _, err := getDriverState(driverId)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "driver not found")
+		return
+	}
+	histogram, err := getDriverRatingHistogram(driverId)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	var body interface{} = histogram
+	if as == "percent" {
+		body = histogramPercentages(histogram)
+	}
+	d, err := json.Marshal(body)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getDriverRatingHistogram counts the ratings of the driver per score, every
+// score from minRating to maxRating is present.
+func getDriverRatingHistogram(driverId string) (map[string]int, error) {
+	histogram := make(map[string]int, maxRating)
+	for score := minRating; score <= maxRating; score++ {
+		histogram[strconv.Itoa(score)] = 0
+	}
+	row, err := srv.DB().Query("SELECT rating, COUNT(*) FROM driver_ratings WHERE driver_id = ? GROUP BY rating", driverId)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	for row.Next() {
+		var score, count int
+		err = row.Scan(&score, &count)
+		if err != nil {
+			return nil, err
+		}
+		histogram[strconv.Itoa(score)] = count
+	}
+	return histogram, row.Err()
+}
+
+func histogramPercentages(histogram map[string]int) map[string]float64 {
+	total := 0
+	for _, count := range histogram {
+		total += count
+	}
+	percentages := make(map[string]float64, len(histogram))
+	for score, count := range histogram {
+		if total > 0 {
+			percentages[score] = float64(count) / float64(total) * 100
+		} else {
+			percentages[score] = 0
+		}
+	}
+	return percentages
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDistributionAsPercent(t *testing.T) {
+	h := openTestDB(t, nil)
+	for user, stars := range map[string]int{"a": 5, "b": 5, "c": 4} {
+		rateTest(t, h, "1", user, stars)
+	}
+	for driver, want := range map[string]float64{"1": 100, "2": 0} {
+		rec := serveTest(h, "GET", "/drivers/"+driver+"/ratings/histogram?as=percent", "")
+		expectStatus(t, rec, http.StatusOK)
+		var percentages map[string]float64
+		decodeBody(t, rec, &percentages)
+		total := 0.0
+		for _, p := range percentages {
+			total += p
+		}
+		if len(percentages) != maxRating || math.Abs(total-want) > 1e-9 {
+			t.Fatalf("percentages of driver %s are %v, summing to %v, want %v", driver, percentages, total, want)
+		}
+	}
+}
+
+func TestDriverRatingHistogram(t *testing.T) {
+	h := openTestDB(t, nil)
+	for user, stars := range map[string]int{"a": 2, "b": 3, "c": 3, "d": 5} {
+		rateTest(t, h, "1", user, stars)
+	}
+	rec := serveTest(h, "GET", "/drivers/1/ratings/histogram", "")
+	expectStatus(t, rec, http.StatusOK)
+	if got, want := strings.TrimSpace(rec.Body.String()), `{"1":0,"2":1,"3":2,"4":0,"5":1}`; got != want {
+		t.Fatalf("histogram is %s, want %s", got, want)
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers/404/ratings/histogram", ""), http.StatusNotFound)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// openHours is a daily window in a time zone, from start (included) to end
+// (excluded) in minutes after midnight. A window with end before start runs
+// past midnight, e.g. 22:00-06:00.
+type openHours struct {
+	start, end int
+	loc        *time.Location
+}
+
+// parseOpenHours parses a window like "09:00-18:00" in the named zone.
+func parseOpenHours(window, zone string) (*openHours, error) {
+	from, to, ok := strings.Cut(window, "-")
+	if !ok {
+		return nil, fmt.Errorf("RATING_HOURS: %q is not like 09:00-18:00", window)
+	}
+	var h openHours
+	var err error
+	if h.start, err = parseClock(from); err == nil {
+		h.end, err = parseClock(to)
+	}
+	if err != nil || h.start == h.end {
+		return nil, fmt.Errorf("RATING_HOURS: %q is not like 09:00-18:00", window)
+	}
+	h.loc, err = time.LoadLocation(zone)
+	if err != nil {
+		return nil, fmt.Errorf("RATING_TIMEZONE: %w", err)
+	}
+	return &h, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains tells whether t falls within the window, a nil window is always
+// open.
+func (h *openHours) contains(t time.Time) bool {
+	if h == nil {
+		return true
+	}
+	t = t.In(h.loc)
+	m := t.Hour()*60 + t.Minute()
+	if h.start < h.end {
+		return m >= h.start && m < h.end
+	}
+	return m >= h.start || m < h.end
+}
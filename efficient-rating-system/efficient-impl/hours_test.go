@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestOpenHoursContains(t *testing.T) {
+	night, err := parseOpenHours("22:00-06:00", "Asia/Almaty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	almaty, _ := time.LoadLocation("Asia/Almaty")
+	for clock, want := range map[string]bool{"21:59": false, "22:00": true, "03:00": true, "05:59": true, "06:00": false, "12:00": false} {
+		at, _ := time.ParseInLocation("2006-01-02 15:04", "2024-03-01 "+clock, almaty)
+		if got := night.contains(at.UTC()); got != want {
+			t.Errorf("%s in Almaty: open %v, want %v", clock, got, want)
+		}
+	}
+	if !(*openHours)(nil).contains(time.Now()) {
+		t.Error("without hours ratings are not accepted")
+	}
+}
+
+func TestRatingOutsideHours(t *testing.T) {
+	// A window of an hour starting an hour from now.
+	now := time.Now().UTC()
+	window := now.Add(time.Hour).Format("15:04") + "-" + now.Add(2*time.Hour).Format("15:04")
+	h := openTestDB(t, map[string]string{"RATING_HOURS": window})
+	rec := serveTest(h, "POST", "/drivers/1/ratings", `{"user_id": "a", "rating": 5}`)
+	expectStatus(t, rec, http.StatusForbidden)
+	if _, count := driverAggregates(t, "1"); count != 0 {
+		t.Fatal("the rating outside the hours was counted")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var driversPage = template.Must(template.New("drivers").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Drivers</title>
+</head>
+<body>
+<table>
+<thead><tr><th>Driver</th><th>Info</th><th>Average rating</th></tr></thead>
+<tbody>
+{{- range .}}
+<tr><td>{{.ID}}</td><td>{{.DriverInfo}}</td><td>{{printf "%.2f" .AverageRating}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+</body>
+</html>
+`))
+
+// prefersHTML tells whether the Accept header ranks text/html above JSON,
+// as browsers do. Clients that accept anything get JSON.
+func prefersHTML(r *http.Request) bool {
+	var html, jsonQ float64
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "text/html":
+			html = maxFloat(html, q)
+		case "application/json", "application/*":
+			jsonQ = maxFloat(jsonQ, q)
+		case "*/*":
+			html = maxFloat(html, q)
+			jsonQ = maxFloat(jsonQ, q)
+		}
+	}
+	return html > jsonQ
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func writeDriversHTML(w http.ResponseWriter, list []Driver) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := driversPage.Execute(w, list); err != nil {
+		log.Println(err)
+	}
+}
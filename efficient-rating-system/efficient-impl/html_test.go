@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDriversAsHTML(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 4)
+	execTest(t, `UPDATE drivers SET driver_info = '{"name": "<script>"}' WHERE id = 2`)
+	rec := serveTest(h, "GET", "/drivers?limit=3", "", "Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	expectStatus(t, rec, http.StatusOK)
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("content type is %q, want text/html", ct)
+	}
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, "<!DOCTYPE html>") || !strings.HasSuffix(strings.TrimSpace(body), "</html>") {
+		t.Fatalf("not an HTML document: %s", body)
+	}
+	if rows := strings.Count(body, "<tr><td>"); rows != 3 {
+		t.Fatalf("%d driver rows, want 3: %s", rows, body)
+	}
+	if !strings.Contains(body, "<tr><td>1</td><td>{}</td><td>4.00</td></tr>") || strings.Contains(body, "<script>") {
+		t.Fatalf("driver rows are not rendered escaped: %s", body)
+	}
+	rec = serveTest(h, "GET", "/drivers?limit=3", "", "Accept", "application/json")
+	expectStatus(t, rec, http.StatusOK)
+	var list []Driver
+	decodeBody(t, rec, &list)
+}
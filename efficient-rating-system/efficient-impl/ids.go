@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+)
+
+const (
+	driverIDInteger = "integer"
+	driverIDUUID    = "uuid"
+)
+
+// nextIntegerDriverID is the SQL for the id a new integer driver gets. With
+// uuid ids mixed in, MAX(id) alone would be a text one.
+const nextIntegerDriverID = "(SELECT COALESCE(MAX(id), 0) + 1 FROM drivers WHERE typeof(id) = 'integer')"
+
+// newDriverID is the id of a driver created through the API: NULL, for
+// nextIntegerDriverID to fill in, unless cfg.DriverIDType is uuid.
+func newDriverID() (sql.NullString, error) {
+	if cfg.DriverIDType != driverIDUUID {
+		return sql.NullString{}, nil
+	}
+	id, err := newUUID()
+	return sql.NullString{String: id, Valid: err == nil}, err
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	// maxImportLine is the longest line of an NDJSON import.
+	maxImportLine = 64 * 1024
+	// maxImportErrors caps the line errors listed in an ImportSummary.
+	maxImportErrors = 100
+)
+
+type ImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportSummary counts the lines of an import, blank lines are not counted.
+// Errors lists the first maxImportErrors failed lines.
+type ImportSummary struct {
+	Processed int           `json:"processed"`
+	Imported  int           `json:"imported"`
+	Failed    int           `json:"failed"`
+	Errors    []ImportError `json:"errors"`
+}
+
+// importRatingsStream applies an NDJSON body of ratings, one
+// {"driver_id", "user_id", "rating", ...} object per line, as it is read.
+// Ratings are committed every cfg.BatchSize lines so that a large import
+// neither holds the whole body in memory nor one long transaction. A bad
+// line is counted as failed and does not stop the import.
+func importRatingsStream(w http.ResponseWriter, r *http.Request) {
+	summary := ImportSummary{Errors: []ImportError{}}
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 4096), maxImportLine)
+	tx, err := srv.DB().Begin()
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	defer func() { tx.Rollback() }()
+	line, pending := 0, 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		summary.Processed++
+		if err := importRating(tx, text); err != nil {
+			summary.Failed++
+			if len(summary.Errors) < maxImportErrors {
+				summary.Errors = append(summary.Errors, ImportError{Line: line, Error: err.Error()})
+			}
+		} else {
+			summary.Imported++
+			pending++
+		}
+		if pending == cfg.BatchSize {
+			if err = tx.Commit(); err != nil {
+				writeInternalError(w, err)
+				return
+			}
+			averageCache.purge()
+			if tx, err = srv.DB().Begin(); err != nil {
+				writeInternalError(w, err)
+				return
+			}
+			pending = 0
+			log.Printf("import: %d lines processed, %d failed", summary.Processed, summary.Failed)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	averageCache.purge()
+	if err = scanner.Err(); err != nil {
+		// What was read so far is kept, the summary tells how far it got.
+		summary.Errors = append(summary.Errors, ImportError{Line: line + 1, Error: err.Error()})
+	}
+	log.Printf("import: done, %d lines processed, %d failed", summary.Processed, summary.Failed)
+	d, err := json.Marshal(summary)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// importRating writes the rating of one line in a savepoint, so that a line
+// failing halfway leaves nothing behind in the batch.
+func importRating(tx *sql.Tx, text string) error {
+	var rating Rating
+	if err := json.Unmarshal([]byte(text), &rating); err != nil {
+		return errors.New("invalid JSON")
+	}
+	if rating.DriverID == "" || rating.UserID == "" {
+		return errors.New("driver_id and user_id are required")
+	}
+	if err := validateRating(rating); err != nil {
+		return err
+	}
+	rating.UserID = storedUserID(rating.UserID)
+	var active bool
+	err := tx.QueryRow("SELECT deleted_at IS NULL FROM drivers WHERE id = ?", rating.DriverID).Scan(&active)
+	if err == sql.ErrNoRows || err == nil && !active {
+		return fmt.Errorf("driver %s not found", rating.DriverID)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("SAVEPOINT import_line"); err != nil {
+		return err
+	}
+	if err = writeRating(tx, rating); err != nil {
+		tx.Exec("ROLLBACK TO import_line")
+		return err
+	}
+	_, err = tx.Exec("RELEASE import_line")
+	return err
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestImportRatingsStream(t *testing.T) {
+	h := openTestDB(t, map[string]string{"ADMIN_TOKEN": "secret-token", "RATING_BATCH_SIZE": "2"})
+	body := `{"driver_id": "1", "user_id": "a", "rating": 5}
+{"driver_id": "1", "user_id": "b", "rating": 3}
+not json
+
+{"driver_id": "2", "user_id": "a", "rating": 7}
+{"driver_id": "2", "user_id": "b", "rating": 4}
+`
+	rec := serveTest(h, "POST", "/admin/ratings/import-stream", body, adminAuth...)
+	expectStatus(t, rec, http.StatusOK)
+	var summary ImportSummary
+	decodeBody(t, rec, &summary)
+	lines := []int{}
+	for _, e := range summary.Errors {
+		lines = append(lines, e.Line)
+	}
+	if summary.Processed != 5 || summary.Imported != 3 || summary.Failed != 2 || fmt.Sprint(lines) != "[3 5]" {
+		t.Fatalf("summary is %+v, want 5 lines processed, 3 imported and lines 3 and 5 failed", summary)
+	}
+	if sum, count := driverAggregates(t, "1"); sum != 8 || count != 2 {
+		t.Fatalf("aggregates of driver 1 are sum %d count %d, want 8 and 2", sum, count)
+	}
+	if sum, count := driverAggregates(t, "2"); sum != 4 || count != 1 {
+		t.Fatalf("aggregates of driver 2 are sum %d count %d, want 4 and 1", sum, count)
+	}
+}
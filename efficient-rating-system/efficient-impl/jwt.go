@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksRefreshInterval limits how often the key set is fetched again when a
+// token is signed with an unknown key.
+const jwksRefreshInterval = time.Minute
+
+// identity verifies the JWT of the rating requests, it is nil when neither
+// JWT_SECRET nor JWT_JWKS_URL is set and the user_id of the body is trusted.
+var identity *tokenVerifier
+
+// tokenVerifier checks HS256 tokens against a shared secret and RS256 tokens
+// against the RSA keys of a JWKS endpoint, picked by the kid of the token.
+type tokenVerifier struct {
+	secret  []byte
+	jwksURL string
+	issuer  string
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+func newTokenVerifier(secret, jwksURL, issuer string) *tokenVerifier {
+	return &tokenVerifier{secret: []byte(secret), jwksURL: jwksURL, issuer: issuer}
+}
+
+// subject returns the subject of the bearer token of the request.
+func (v *tokenVerifier) subject(r *http.Request) (string, error) {
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", errors.New("missing bearer token")
+	}
+	var methods []string
+	if len(v.secret) > 0 {
+		methods = append(methods, "HS256")
+	}
+	if v.jwksURL != "" {
+		methods = append(methods, "RS256")
+	}
+	options := []jwt.ParserOption{jwt.WithValidMethods(methods)}
+	if v.issuer != "" {
+		options = append(options, jwt.WithIssuer(v.issuer))
+	}
+	token, err := jwt.Parse(raw, v.key, options...)
+	if err != nil {
+		return "", err
+	}
+	sub, err := token.Claims.GetSubject()
+	if err == nil && sub == "" {
+		err = errors.New("token has no subject")
+	}
+	return sub, err
+}
+
+func (v *tokenVerifier) key(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return v.secret, nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key, ok := v.keys[kid]
+	if !ok && time.Since(v.fetched) > jwksRefreshInterval {
+		// The keys may have been rotated since they were last fetched.
+		if err := v.fetchKeys(); err != nil {
+			return nil, err
+		}
+		key, ok = v.keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+type jwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+// fetchKeys replaces the keys with the RSA keys served at jwksURL, the other
+// key types are ignored. v.mu must be held.
+func (v *tokenVerifier) fetchKeys() error {
+	v.fetched = time.Now()
+	client := http.Client{Timeout: 5 * time.Second}
+	res, err := client.Get(v.jwksURL)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwks: %s", res.Status)
+	}
+	var set jwks
+	if err = json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return fmt.Errorf("jwks: %w", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return fmt.Errorf("jwks: key %q: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return fmt.Errorf("jwks: key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	v.keys = keys
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func signTest(tb testing.TB, secret, subject string) string {
+	tb.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: subject}).SignedString([]byte(secret))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return token
+}
+
+func TestRatingUserFromToken(t *testing.T) {
+	h := openTestDB(t, map[string]string{"JWT_SECRET": "jwt-secret"})
+	body := `{"user_id": "mallory", "rating": 2}`
+	rec := serveTest(h, "POST", "/drivers/1/ratings", body, "Authorization", "Bearer "+signTest(t, "jwt-secret", "alice"))
+	expectStatus(t, rec, http.StatusOK)
+	var users []string
+	rows, err := srv.DB().Query("SELECT user_id FROM driver_ratings WHERE driver_id = '1'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var user string
+		if err = rows.Scan(&user); err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	if len(users) != 1 || users[0] != "alice" {
+		t.Fatalf("ratings stored for %v, want the subject of the token alone", users)
+	}
+	for _, header := range []string{"", "Bearer " + signTest(t, "other-secret", "mallory")} {
+		rec = serveTest(h, "POST", "/drivers/1/ratings", body, "Authorization", header)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("authorization %q: status %d, want 401", header, rec.Code)
+		}
+	}
+}
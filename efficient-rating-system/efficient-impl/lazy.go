@@ -0,0 +1,130 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lazy is set when lazy aggregates are enabled, see Config.LazyAggregateTTL.
+var lazy *lazyAggregates
+
+// lazyAggregates takes the aggregate update out of every write: a rating only
+// marks its driver as stale, and the rating_sum and rating_count of the stale
+// drivers are computed again from driver_ratings when a read comes in. The
+// result is kept for ttl, reads within it don't look at newer ratings.
+//
+// A stale driver is only known in memory, so every aggregate is computed
+// again on startup.
+type lazyAggregates struct {
+	mu        sync.Mutex
+	stale     map[string]struct{}
+	ttl       time.Duration
+	refreshed time.Time
+}
+
+func newLazyAggregates(ttl time.Duration) (*lazyAggregates, error) {
+	if err := recomputeAggregates(nil); err != nil {
+		return nil, err
+	}
+	return &lazyAggregates{stale: map[string]struct{}{}, ttl: ttl, refreshed: time.Now()}, nil
+}
+
+func (l *lazyAggregates) markStale(driverId string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stale[driverId] = struct{}{}
+}
+
+// refresh computes the aggregates of the stale drivers unless the last
+// refresh is younger than ttl. Computing them is idempotent, so drivers
+// marked by a write that was rolled back do no harm.
+func (l *lazyAggregates) refresh() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.stale) == 0 || time.Since(l.refreshed) < l.ttl {
+		return nil
+	}
+	ids := make([]string, 0, len(l.stale))
+	for driverId := range l.stale {
+		ids = append(ids, driverId)
+	}
+	if err := recomputeAggregates(ids); err != nil {
+		return err
+	}
+	l.stale = map[string]struct{}{}
+	l.refreshed = time.Now()
+	return nil
+}
+
+// reset recomputes the aggregates of every driver and forgets the stale
+// ones, which were of the database srv pointed at before.
+func (l *lazyAggregates) reset() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := recomputeAggregates(nil); err != nil {
+		return err
+	}
+	l.stale = map[string]struct{}{}
+	l.refreshed = time.Now()
+	return nil
+}
+
+// recomputeAggregates sets rating_sum and rating_count of the given drivers,
+// or of all of them when ids is nil, from their counted ratings.
+func recomputeAggregates(ids []string) error {
+	query := `UPDATE drivers
+      SET rating_sum = (SELECT COALESCE(SUM(rating), 0) FROM ` + countedRatings() + ` WHERE driver_id = drivers.id),
+        rating_count = (SELECT COUNT(*) FROM ` + countedRatings() + ` WHERE driver_id = drivers.id)`
+	args := make([]interface{}, len(ids))
+	if ids != nil {
+		query += " WHERE id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")"
+		for i, id := range ids {
+			args[i] = id
+		}
+	}
+	if _, err := srv.DB().Exec(query, args...); err != nil {
+		return err
+	}
+	if ids == nil {
+		averageCache.purge()
+	} else {
+		averageCache.forget(ids...)
+	}
+	return nil
+}
+
+// markStaleDrivers marks the drivers the query selects the ids of as stale
+// for the lazy aggregates, and returns how many there are.
+func markStaleDrivers(q dbtx, query string, args ...interface{}) (int64, error) {
+	row, err := q.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer row.Close()
+	var count int64
+	for row.Next() {
+		var driverId string
+		if err = row.Scan(&driverId); err != nil {
+			return 0, err
+		}
+		lazy.markStale(driverId)
+		count++
+	}
+	return count, row.Err()
+}
+
+// refreshLazyAggregates brings the aggregates up to date before a read. When
+// that fails the read is served from the aggregates as they are.
+func refreshLazyAggregates(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			if err := lazy.refresh(); err != nil {
+				log.Println("refresh aggregates:", err)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLazyAggregates(t *testing.T) {
+	h := openTestDB(t, map[string]string{"LAZY_AGGREGATES_TTL_MS": "50"})
+	rateTest(t, h, "1", "a", 2)
+	rateTest(t, h, "1", "b", 5)
+	if sum, count := driverAggregates(t, "1"); sum != 0 || count != 0 {
+		t.Fatalf("aggregates are sum %d count %d after the writes, want them left alone", sum, count)
+	}
+	time.Sleep(60 * time.Millisecond)
+	rec := serveTest(h, "GET", "/drivers/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	var driver Driver
+	decodeBody(t, rec, &driver)
+	if driver.AverageRating != 3.5 {
+		t.Fatalf("average is %v, want 3.5", driver.AverageRating)
+	}
+	if sum, count := driverAggregates(t, "1"); sum != 7 || count != 2 {
+		t.Fatalf("aggregates are sum %d count %d after the read, want 7 and 2", sum, count)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultLeaderboardSize = 10
+	maxLeaderboardSize     = 100
+)
+
+// LeaderboardEntry is the minimal projection of a driver a leaderboard widget
+// shows. Name is the name field of driver_info, null when there is none or
+// driver_info isn't JSON.
+type LeaderboardEntry struct {
+	ID            string  `json:"id"`
+	Name          *string `json:"name"`
+	AverageRating float64 `json:"avg_rating"`
+}
+
+func getLeaderboard(w http.ResponseWriter, r *http.Request) {
+	n := defaultLeaderboardSize
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		n, err = strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLeaderboardSize {
+			writeError(w, http.StatusBadRequest, (&paramError{"n", "must be a number between 1 and " + strconv.Itoa(maxLeaderboardSize)}).Error())
+			return
+		}
+	}
+	list, err := getLeaderboardList(entityType(r), n)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(list)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getLeaderboardList returns the n rated entities of entityType with the best
+// average, selecting nothing else than the columns of LeaderboardEntry.
+func getLeaderboardList(entityType string, n int) ([]LeaderboardEntry, error) {
+	avg, args := averageExpr("d", cfg.AggFunction)
+	args = append([]interface{}{infoFieldPath("name")}, args...)
+	row, err := srv.DB().Query(`SELECT d.id, `+infoFieldExpr("d")+`, `+avg+` AS avg_rating
+    FROM drivers d
+    WHERE d.rating_count > 0 AND d.deleted_at IS NULL AND d.entity_type = ?
+    ORDER BY avg_rating DESC, `+tieBreak("d")+`
+    LIMIT ?`, append(args, entityType, n)...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []LeaderboardEntry{}
+	for row.Next() {
+		var entry LeaderboardEntry
+		var name sql.NullString
+		err = row.Scan(&entry.ID, &name, &entry.AverageRating)
+		if err != nil {
+			return nil, err
+		}
+		if name.Valid {
+			entry.Name = &name.String
+		}
+		list = append(list, entry)
+	}
+	return list, row.Err()
+}
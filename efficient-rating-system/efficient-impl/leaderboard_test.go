@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestLeaderboard(t *testing.T) {
+	h := openTestDB(t, nil)
+	execTest(t, `UPDATE drivers SET driver_info = '{"name": "Ann", "car": "Prius"}' WHERE id = 3`)
+	execTest(t, `UPDATE drivers SET driver_info = 'not json' WHERE id = 1`)
+	rateTest(t, h, "1", "a", 4)
+	rateTest(t, h, "2", "a", 2)
+	rateTest(t, h, "3", "a", 5)
+
+	rec := serveTest(h, "GET", "/drivers/leaderboard?n=2", "")
+	expectStatus(t, rec, http.StatusOK)
+	// Nothing but the id, name and average.
+	var raw []map[string]interface{}
+	decodeBody(t, rec, &raw)
+	want := []map[string]interface{}{
+		{"id": "3", "name": "Ann", "avg_rating": 5.0},
+		{"id": "1", "name": nil, "avg_rating": 4.0},
+	}
+	if !reflect.DeepEqual(raw, want) {
+		t.Fatalf("leaderboard is %v, want %v", raw, want)
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers/leaderboard?n=0", ""), http.StatusBadRequest)
+}
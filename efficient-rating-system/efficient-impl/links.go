@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errLinkInvalid = errors.New("invalid rating link signature")
+	errLinkExpired = errors.New("rating link has expired")
+	errLinkUsed    = errors.New("rating link has already been used")
+)
+
+// RatingLinkRequest is the body of POST /admin/rating-links. TTLHours
+// defaults to cfg.RatingLinkTTL.
+type RatingLinkRequest struct {
+	DriverID string `json:"driver_id"`
+	UserID   string `json:"user_id"`
+	TTLHours int    `json:"ttl_hours"`
+}
+
+// RatingLink is a single use link to rate a driver as a user, URL is relative
+// to the service and is meant to be POSTed the rating.
+type RatingLink struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ratingLink is what a link carries in its query string, see sign.
+type ratingLink struct {
+	DriverID, UserID, Nonce string
+	Expires                 int64
+}
+
+func (l ratingLink) sign() string {
+	mac := hmac.New(sha256.New, []byte(cfg.RatingLinkSecret))
+	mac.Write([]byte(strings.Join([]string{l.DriverID, l.UserID, strconv.FormatInt(l.Expires, 10), l.Nonce}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// createRatingLink mints a signed rating link, for example for the "rate
+// your last trip" emails.
+func createRatingLink(w http.ResponseWriter, r *http.Request) {
+	if cfg.RatingLinkSecret == "" {
+		writeError(w, http.StatusNotFound, "rating links are disabled")
+		return
+	}
+	var req RatingLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DriverID == "" || req.UserID == "" || req.TTLHours < 0 {
+		writeError(w, http.StatusBadRequest, "body must be {\"driver_id\": \"...\", \"user_id\": \"...\", \"ttl_hours\": 24}")
+		return
+	}
+	ttl := cfg.RatingLinkTTL
+	if req.TTLHours > 0 {
+		ttl = time.Duration(req.TTLHours) * time.Hour
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	expires := time.Now().Add(ttl).UTC().Truncate(time.Second)
+	link := ratingLink{DriverID: req.DriverID, UserID: req.UserID, Nonce: hex.EncodeToString(nonce), Expires: expires.Unix()}
+	q := url.Values{}
+	q.Set("user_id", link.UserID)
+	q.Set("expires", strconv.FormatInt(link.Expires, 10))
+	q.Set("nonce", link.Nonce)
+	q.Set("sig", link.sign())
+	d, err := json.Marshal(RatingLink{
+		URL:       "/drivers/" + url.PathEscape(link.DriverID) + "/ratings?" + q.Encode(),
+		ExpiresAt: expires,
+	})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// checkRatingLink verifies the signed link a rating was submitted with and
+// returns the user it was issued for.
+func checkRatingLink(driverId string, q url.Values) (*ratingLink, error) {
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || cfg.RatingLinkSecret == "" {
+		return nil, errLinkInvalid
+	}
+	link := &ratingLink{DriverID: driverId, UserID: q.Get("user_id"), Nonce: q.Get("nonce"), Expires: expires}
+	if !hmac.Equal([]byte(link.sign()), []byte(q.Get("sig"))) {
+		return nil, errLinkInvalid
+	}
+	if time.Now().Unix() >= link.Expires {
+		return nil, errLinkExpired
+	}
+	return link, nil
+}
+
+// useRatingLink marks the link as used, it fails with errLinkUsed when it
+// already was.
+func useRatingLink(link *ratingLink) error {
+	res, err := srv.DB().Exec("INSERT INTO used_rating_links (nonce) VALUES (?) ON CONFLICT(nonce) DO NOTHING", link.Nonce)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		err = errLinkUsed
+	}
+	return err
+}
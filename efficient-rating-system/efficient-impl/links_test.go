@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRatingLinks(t *testing.T) {
+	h := openTestDB(t, map[string]string{"ADMIN_TOKEN": "secret-token", "RATING_LINK_SECRET": "link-secret"})
+	rec := serveTest(h, "POST", "/admin/rating-links", `{"driver_id": "1", "user_id": "ann"}`, adminAuth...)
+	expectStatus(t, rec, http.StatusCreated)
+	var link RatingLink
+	decodeBody(t, rec, &link)
+	// The link is for ann whoever the body says is rating.
+	expectStatus(t, serveTest(h, "POST", link.URL, `{"user_id": "bob", "rating": 4}`), http.StatusOK)
+	var user string
+	if err := srv.DB().QueryRow("SELECT user_id FROM driver_ratings WHERE driver_id = '1'").Scan(&user); err != nil {
+		t.Fatal(err)
+	}
+	if user != "ann" {
+		t.Fatalf("the link rated as %q, want ann", user)
+	}
+	expectStatus(t, serveTest(h, "POST", link.URL, `{"rating": 1}`), http.StatusConflict)
+
+	expired := ratingLink{DriverID: "1", UserID: "ann", Nonce: "old", Expires: time.Now().Add(-time.Minute).Unix()}
+	q := url.Values{"user_id": {expired.UserID}, "nonce": {expired.Nonce},
+		"expires": {strconv.FormatInt(expired.Expires, 10)}, "sig": {expired.sign()}}
+	expectStatus(t, serveTest(h, "POST", "/drivers/1/ratings?"+q.Encode(), `{"rating": 1}`), http.StatusGone)
+	q.Set("user_id", "bob")
+	expectStatus(t, serveTest(h, "POST", "/drivers/1/ratings?"+q.Encode(), `{"rating": 1}`), http.StatusForbidden)
+	if sum, count := driverAggregates(t, "1"); sum != 4 || count != 1 {
+		t.Fatalf("aggregates are sum %d count %d, want only the first use counted", sum, count)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listParams are the query parameters shared by the list endpoints, parsed
+// and validated by parseListParams.
+type listParams struct {
+	Limit  int
+	Offset int
+	Sort   string
+	Before *feedCursor
+	Since  *time.Time
+	// Precision is the number of decimals averages are rounded to, -1 when
+	// they are returned unrounded.
+	Precision int
+	Rounding  string
+	// Scale is the maximum averages are rescaled to, 0 keeps them out of
+	// maxRating stars.
+	Scale   float64
+	Average string
+}
+
+// listOptions tells parseListParams which parameters an endpoint accepts.
+// Parameters an endpoint doesn't accept are left at their zero value.
+type listOptions struct {
+	// DefaultLimit and MaxLimit enable limit and offset. Offset is rejected
+	// with Before, a cursor paginated list has no position to skip to.
+	DefaultLimit int
+	MaxLimit     int
+	// Sorts are the accepted sort values, the first one is the default.
+	Sorts  []string
+	Before bool
+	Since  bool
+	// Rounding enables precision, rounding and scale.
+	Rounding bool
+	// Averages are the accepted values of avg, the first one is the default.
+	Averages []string
+}
+
+// paramError is returned for an invalid query parameter, every list endpoint
+// reports it the same way: 400 with the message in the JSON error body.
+type paramError struct {
+	Name   string
+	Reason string
+}
+
+func (e *paramError) Error() string {
+	return fmt.Sprintf("invalid query parameter %q: %s", e.Name, e.Reason)
+}
+
+// names returns the parameters parseListParams reads with o, each of them
+// takes a single value.
+func (o listOptions) names() []string {
+	var names []string
+	if o.MaxLimit > 0 {
+		names = append(names, "limit", "offset")
+	}
+	if len(o.Sorts) > 0 {
+		names = append(names, "sort")
+	}
+	if o.Before {
+		names = append(names, "before")
+	}
+	if o.Since {
+		names = append(names, "since")
+	}
+	if o.Rounding {
+		names = append(names, "precision", "rounding", "scale")
+	}
+	if len(o.Averages) > 0 {
+		names = append(names, "avg")
+	}
+	return names
+}
+
+func parseListParams(r *http.Request, opts listOptions) (listParams, error) {
+	return parseListQuery(r.URL.Query(), opts)
+}
+
+// parseListQuery is parseListParams on the query alone, the gRPC server
+// validates its requests with it, see rpcQuery.
+func parseListQuery(query url.Values, opts listOptions) (listParams, error) {
+	var p listParams
+	// Query().Get takes the first value, a repeated one is most likely a
+	// client bug so it is rejected rather than ignored.
+	for _, name := range opts.names() {
+		if len(query[name]) > 1 {
+			return p, &paramError{name, "must be given only once"}
+		}
+	}
+	if opts.MaxLimit > 0 {
+		p.Limit = opts.DefaultLimit
+		if v := query.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > opts.MaxLimit {
+				return p, &paramError{"limit", fmt.Sprintf("must be a number between 1 and %d", opts.MaxLimit)}
+			}
+			p.Limit = n
+		}
+		if v := query.Get("offset"); v != "" {
+			if opts.Before {
+				return p, &paramError{"offset", "is not supported, page with before"}
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return p, &paramError{"offset", "must be a non-negative number"}
+			}
+			p.Offset = n
+		}
+	}
+	if len(opts.Sorts) > 0 {
+		p.Sort = opts.Sorts[0]
+		if v := query.Get("sort"); v != "" {
+			if !contains(opts.Sorts, v) {
+				return p, &paramError{"sort", fmt.Sprintf("must be one of %v", opts.Sorts)}
+			}
+			p.Sort = v
+		}
+	}
+	if opts.Before {
+		if v := query.Get("before"); v != "" {
+			c, err := parseFeedCursor(v)
+			if err != nil {
+				return p, &paramError{"before", "is not a valid cursor"}
+			}
+			p.Before = c
+		}
+	}
+	if opts.Since {
+		if v := query.Get("since"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return p, &paramError{"since", "must be an RFC 3339 timestamp"}
+			}
+			p.Since = &t
+		}
+	}
+	p.Precision = -1
+	if opts.Rounding {
+		if v := query.Get("precision"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || n > maxPrecision {
+				return p, &paramError{"precision", fmt.Sprintf("must be a number between 0 and %d", maxPrecision)}
+			}
+			p.Precision = n
+		}
+		p.Rounding = roundHalfAway
+		if v := query.Get("rounding"); v != "" {
+			if v != roundHalfAway && v != roundHalfEven {
+				return p, &paramError{"rounding", fmt.Sprintf("must be %s or %s", roundHalfAway, roundHalfEven)}
+			}
+			p.Rounding = v
+		}
+		if v := query.Get("scale"); v != "" {
+			s, err := strconv.ParseFloat(v, 64)
+			if err != nil || !(s > 0) || math.IsInf(s, 0) {
+				return p, &paramError{"scale", "must be a positive number"}
+			}
+			p.Scale = s
+		}
+	}
+	if len(opts.Averages) > 0 {
+		p.Average = opts.Averages[0]
+		if v := query.Get("avg"); v != "" {
+			if !contains(opts.Averages, v) {
+				return p, &paramError{"avg", fmt.Sprintf("must be one of %v", opts.Averages)}
+			}
+			p.Average = v
+		}
+	}
+	return p, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWindow parses a time window such as "7d", "12h" or "90m". Days are
+// accepted on top of the units time.ParseDuration understands.
+func parseWindow(name, v string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if n, ok := strings.CutSuffix(v, "d"); ok {
+		var days int
+		days, err = strconv.Atoi(n)
+		d = time.Duration(days) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(v)
+	}
+	if err != nil || d <= 0 {
+		return 0, &paramError{name, `must be a positive duration like "7d" or "12h"`}
+	}
+	return d, nil
+}
+
+// DriversPage is the response of GET /drivers with prefetch=true or
+// envelope=true, the page with what they add.
+type DriversPage struct {
+	Drivers []Driver `json:"drivers"`
+	*NextPageIDs
+	*PageSummary
+}
+
+// NextPageIDs are the ids of the drivers of the next page, for clients to
+// warm their caches.
+type NextPageIDs struct {
+	Prefetch []string `json:"prefetch"`
+}
+
+// PageSummary counts the drivers of all the pages, Next is the URL of the
+// following page and is empty on the last one.
+type PageSummary struct {
+	Total int    `json:"total"`
+	Next  string `json:"next,omitempty"`
+}
+
+// prefetchIDs returns the ids of the drivers read past the page of limit
+// drivers, at most one more page of them.
+func prefetchIDs(list []Driver, limit int) []string {
+	ids := []string{}
+	for i := limit; i < len(list) && i < 2*limit; i++ {
+		ids = append(ids, list[i].ID)
+	}
+	return ids
+}
+
+// pageLinks returns the Link header (RFC 5988) of the page of limit items at
+// offset out of total: the first and last pages, and the previous and next
+// ones when there are. The last page is on the grid of offset, so that
+// following next from the page ends up on it. The other parameters of r are
+// kept.
+func pageLinks(r *http.Request, offset, limit, total int) string {
+	last, shift := 0, offset%limit
+	if total > shift {
+		last = shift + (total-1-shift)/limit*limit
+	}
+	link := func(offset int, rel string) string {
+		return "<" + pageURL(r, offset, limit) + `>; rel="` + rel + `"`
+	}
+	links := []string{link(0, "first")}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, link(prev, "prev"))
+	}
+	if offset+limit < total {
+		links = append(links, link(offset+limit, "next"))
+	}
+	links = append(links, link(last, "last"))
+	return strings.Join(links, ", ")
+}
+
+// pageURL is the URL of r for the page of limit items at offset.
+func pageURL(r *http.Request, offset, limit int) string {
+	query := r.URL.Query()
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("offset", strconv.Itoa(offset))
+	return r.URL.Path + "?" + query.Encode()
+}
+
+// parseBoolParam reads an optional true or false query parameter.
+func parseBoolParam(query url.Values, name string) (bool, error) {
+	v := query.Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, &paramError{name, "must be true or false"}
+	}
+	return b, nil
+}
+
+// parseDriverMinimums reads the min_rating and min_count parameters of
+// GET /drivers, nil and 0 when they are not given.
+func parseDriverMinimums(query url.Values) (minAvg *float64, minCount int, err error) {
+	if v := query.Get("min_rating"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < minRating || f > maxRating {
+			return nil, 0, &paramError{"min_rating", fmt.Sprintf("must be a number between %d and %d", minRating, maxRating)}
+		}
+		minAvg = &f
+	}
+	if v := query.Get("min_count"); v != "" {
+		minCount, err = strconv.Atoi(v)
+		if err != nil || minCount < 0 {
+			return nil, 0, &paramError{"min_count", "must be a non-negative number"}
+		}
+	}
+	return minAvg, minCount, nil
+}
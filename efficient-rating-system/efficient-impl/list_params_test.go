@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestBadListParams checks that every list endpoint reports a bad parameter
+// the same way.
+func TestBadListParams(t *testing.T) {
+	h := openTestDB(t, nil)
+	tests := []struct {
+		target  string
+		message string
+	}{
+		{"/drivers?limit=abc", `invalid query parameter "limit": must be a number between 1 and 100`},
+		{"/drivers/ranked?limit=0", `invalid query parameter "limit": must be a number between 1 and 100`},
+		{"/drivers/1/ratings?limit=101", `invalid query parameter "limit": must be a number between 1 and 100`},
+		{"/drivers/1/top-raters?limit=-1", `invalid query parameter "limit": must be a number between 1 and 100`},
+		{"/drivers?offset=-1", `invalid query parameter "offset": must be a non-negative number`},
+		{"/drivers/ranked?offset=x", `invalid query parameter "offset": must be a non-negative number`},
+		{"/drivers/1/ratings?offset=10", `invalid query parameter "offset": is not supported, page with before`},
+		{"/drivers?limit=1&limit=2", `invalid query parameter "limit": must be given only once`},
+		{"/drivers/1/ratings?before=%21", `invalid query parameter "before": is not a valid cursor`},
+	}
+	for _, test := range tests {
+		rec := serveTest(h, "GET", test.target, "")
+		expectStatus(t, rec, http.StatusBadRequest)
+		var body map[string]string
+		decodeBody(t, rec, &body)
+		if body["code"] != errorCode(http.StatusBadRequest) || body["message"] != test.message {
+			t.Errorf("%s: code %q message %q, want %q", test.target, body["code"], body["message"], test.message)
+		}
+	}
+}
+
+func TestRepeatedListParams(t *testing.T) {
+	h := openTestDB(t, nil)
+	for target, name := range map[string]string{
+		"/drivers?offset=1&offset=2":                   "offset",
+		"/drivers?sort=id&sort=rating":                 "sort",
+		"/drivers?avg=mean&avg=median":                 "avg",
+		"/drivers?scale=10&scale=100":                  "scale",
+		"/drivers/1/ratings?limit=5&before=a&before=b": "before",
+	} {
+		rec := serveTest(h, "GET", target, "")
+		expectStatus(t, rec, http.StatusBadRequest)
+		var body map[string]string
+		decodeBody(t, rec, &body)
+		if want := (&paramError{name, "must be given only once"}).Error(); body["message"] != want {
+			t.Errorf("%s: message %q, want %q", target, body["message"], want)
+		}
+	}
+	// Only the parameters an endpoint reads are checked.
+	if got := fmt.Sprint(listOptions{MaxLimit: 10, Before: true}.names()); got != "[limit offset before]" {
+		t.Fatalf("checked parameters are %s, want [limit offset before]", got)
+	}
+}
+
+func TestPaginationLinkHeader(t *testing.T) {
+	h := openTestDB(t, map[string]string{"PAGINATION_LINKS": "true"})
+	link := func(offset, rel string) string {
+		return "</drivers?limit=10&offset=" + offset + "&sort=rating>; rel=\"" + rel + "\""
+	}
+	for target, want := range map[string][]string{
+		"/drivers?sort=rating&limit=10&offset=10": {link("0", "first"), link("0", "prev"), link("20", "next"), link("20", "last")},
+		"/drivers?sort=rating&limit=10":           {link("0", "first"), link("10", "next"), link("20", "last")},
+		"/drivers?sort=rating&limit=10&offset=20": {link("0", "first"), link("10", "prev"), link("20", "last")},
+		// Off the grid of the limit the pages stay on the grid of offset.
+		"/drivers?sort=rating&limit=10&offset=5": {link("0", "first"), link("0", "prev"), link("15", "next"), link("25", "last")},
+	} {
+		rec := serveTest(h, "GET", target, "")
+		expectStatus(t, rec, http.StatusOK)
+		if got := rec.Header().Get("Link"); got != strings.Join(want, ", ") {
+			t.Errorf("%s: Link is %s, want %s", target, got, strings.Join(want, ", "))
+		}
+	}
+}
+
+func TestDriversByCountWithMinimums(t *testing.T) {
+	h := openTestDB(t, nil)
+	for driver, stars := range map[string][]int{"1": {5, 5, 4}, "2": {4, 4}, "3": {4}, "4": {1, 2}} {
+		for i, s := range stars {
+			rateTest(t, h, driver, fmt.Sprintf("u%d", i), s)
+		}
+	}
+	ids := func(list []Driver) string {
+		var ids []string
+		for _, d := range list {
+			ids = append(ids, d.ID)
+		}
+		return strings.Join(ids, ",")
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		// Ties on the count are ordered by id.
+		{"sort=count_desc&min_count=1", "1,2,4,3"},
+		{"sort=count&min_count=1", "3,2,4,1"},
+		{"sort=count&limit=3", "5,6,7"},
+		// The minimums are inclusive.
+		{"min_count=2", "1,2,4"},
+		{"min_count=3", "1"},
+		{"min_count=4", ""},
+		{"min_rating=4", "1,2,3"},
+		{"min_rating=4&min_count=2", "1,2"},
+		{"min_rating=4.7", ""},
+		// Unrated drivers have no average to compare.
+		{"min_rating=1", "1,2,3,4"},
+	}
+	for _, test := range tests {
+		rec := serveTest(h, "GET", "/drivers?"+test.query, "")
+		expectStatus(t, rec, http.StatusOK)
+		var list []Driver
+		decodeBody(t, rec, &list)
+		if got := ids(list); got != test.want {
+			t.Errorf("%s: drivers %q, want %q", test.query, got, test.want)
+		}
+	}
+	for _, query := range []string{"min_rating=0.9", "min_rating=5.1", "min_rating=x", "min_count=-1", "min_count=1.5", "envelope=yes"} {
+		expectStatus(t, serveTest(h, "GET", "/drivers?"+query, ""), http.StatusBadRequest)
+	}
+
+	page := func(target string) DriversPage {
+		t.Helper()
+		rec := serveTest(h, "GET", target, "")
+		expectStatus(t, rec, http.StatusOK)
+		var page DriversPage
+		decodeBody(t, rec, &page)
+		return page
+	}
+	first := page("/drivers?min_count=1&sort=count_desc&limit=2&envelope=true")
+	want := "/drivers?envelope=true&limit=2&min_count=1&offset=2&sort=count_desc"
+	if ids(first.Drivers) != "1,2" || first.PageSummary == nil || first.Total != 4 || first.Next != want {
+		t.Fatalf("first page is %+v, want drivers 1,2 of 4 and next %s", first, want)
+	}
+	last := page(first.Next)
+	if ids(last.Drivers) != "4,3" || last.PageSummary == nil || last.Total != 4 || last.Next != "" {
+		t.Fatalf("last page is %+v, want drivers 4,3 of 4 without a next page", last)
+	}
+	if empty := page("/drivers?min_count=9&envelope=true"); empty.Drivers == nil || len(empty.Drivers) != 0 || empty.Total != 0 {
+		t.Fatalf("page without drivers is %+v, want an empty list and a total of 0", empty)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// commaLanguages are the languages written with a decimal comma, every other
+// language gets a decimal point.
+var commaLanguages = map[string]bool{
+	"bg": true, "ca": true, "cs": true, "da": true, "de": true, "el": true, "es": true, "et": true,
+	"fi": true, "fr": true, "hr": true, "hu": true, "id": true, "is": true, "it": true, "lt": true,
+	"lv": true, "nb": true, "nl": true, "nn": true, "no": true, "pl": true, "pt": true, "ro": true,
+	"ru": true, "sk": true, "sl": true, "sr": true, "sv": true, "tr": true, "uk": true, "vi": true,
+}
+
+// requestLocale returns the locale averages are displayed in: the locale
+// parameter, or else the preferred language of Accept-Language. It is empty
+// when the request asks for neither.
+func requestLocale(r *http.Request) string {
+	if locale := r.URL.Query().Get("locale"); locale != "" {
+		return locale
+	}
+	type lang struct {
+		tag string
+		q   float64
+	}
+	var langs []lang
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if tag = strings.TrimSpace(tag); tag != "" && tag != "*" && q > 0 {
+			langs = append(langs, lang{tag, q})
+		}
+	}
+	if len(langs) == 0 {
+		return ""
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	return langs[0].tag
+}
+
+// formatLocalized writes v like Driver.MarshalJSON writes avg_rating, with
+// the decimal separator of the locale, e.g. 4,5 for de-DE.
+func formatLocalized(v float64, locale string) string {
+	s := strconv.FormatFloat(v, 'f', cfg.AverageDecimals, 64)
+	language, _, _ := strings.Cut(strings.ToLower(locale), "-")
+	language, _, _ = strings.Cut(language, "_")
+	if commaLanguages[language] {
+		s = strings.Replace(s, ".", ",", 1)
+	}
+	return s
+}
+
+// localizeAverages sets the avg_rating_display of the drivers when the
+// request asks for a locale, see requestLocale.
+func localizeAverages(w http.ResponseWriter, r *http.Request, drivers ...*Driver) {
+	w.Header().Add("Vary", "Accept-Language")
+	locale := requestLocale(r)
+	if locale == "" {
+		return
+	}
+	for _, driver := range drivers {
+		driver.AverageDisplay = formatLocalized(driver.AverageRating, locale)
+	}
+}
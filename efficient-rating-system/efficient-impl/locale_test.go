@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestLocalizedAverage(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 4)
+	rateTest(t, h, "1", "b", 5)
+	tests := []struct {
+		query, language, want string
+	}{
+		{"?locale=de-DE", "", "4,5"},
+		{"", "de-DE,de;q=0.9,en;q=0.5", "4,5"},
+		{"?locale=en-US", "de-DE", "4.5"},
+		{"", "", ""},
+	}
+	for _, test := range tests {
+		var header []string
+		if test.language != "" {
+			header = []string{"Accept-Language", test.language}
+		}
+		rec := serveTest(h, "GET", "/drivers/1"+test.query, "", header...)
+		expectStatus(t, rec, http.StatusOK)
+		var driver Driver
+		decodeBody(t, rec, &driver)
+		if driver.AverageDisplay != test.want || driver.AverageRating != 4.5 {
+			t.Errorf("%q with Accept-Language %q: avg_rating %v shown as %q, want 4.5 shown as %q",
+				test.query, test.language, driver.AverageRating, driver.AverageDisplay, test.want)
+		}
+	}
+}
@@ -3,39 +3,94 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
-	_ "github.com/mattn/go-sqlite3" // Import go-sqlite3 library
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"time"
 )
 
-const dbFilePath = "./data.sqlite"
+const (
+	defaultDBPath     = "./data.sqlite"
+	defaultListenAddr = ":8080"
+)
+
+// timeFormat is the layout used for datetime columns, it matches the format
+// SQLite itself produces for CURRENT_TIMESTAMP so values compare as strings.
+const timeFormat = "2006-01-02 15:04:05"
+
+// maxCommentLength caps the optional comment of a rating, in bytes.
+const maxCommentLength = 1000
 
-var schemaSQL = []string{`CREATE TABLE IF NOT EXISTS drivers (
-  id integer PRIMARY KEY,
-  driver_info varchar(255),
-  rating_sum bigint,
-  rating_count bigint
-)`, `
-CREATE TABLE IF NOT EXISTS driver_ratings (
-  driver_id integer,
-  user_id varchar(255),
-  rating integer
-)`}
+var cfg Config
 
-var db *sql.DB
+// dbtx is implemented by both *sql.DB and *sql.Tx.
+type dbtx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Prepare(query string) (*sql.Stmt, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
 
 type Rating struct {
-	UserID   string `json:"user_id"`
-	DriverID string `json:"driver_id"`
-	Rating   int    `json:"rating"`
+	UserID    string     `json:"user_id"`
+	DriverID  string     `json:"driver_id"`
+	Rating    int        `json:"rating"`
+	Source    string     `json:"source,omitempty"`
+	Comment   string     `json:"comment,omitempty"`
+	Tags      ratingTags `json:"tags,omitempty"`
+	Region    string     `json:"region,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	// Moderation is visible or hidden, it is set by admins and only read.
+	Moderation string `json:"moderation,omitempty"`
+}
+
+// NewDriver is the body of a create driver request. Key optionally
+// identifies the driver on the client's side, see createDriver.
+type NewDriver struct {
+	Key        string     `json:"key"`
+	DriverInfo DriverInfo `json:"driver_info"`
 }
 
 type Driver struct {
 	ID            string  `json:"id"`
 	DriverInfo    string  `json:"driver_info"`
 	AverageRating float64 `json:"avg_rating"`
+	UserRating    *int    `json:"user_rating,omitempty"`
+	// Confidence labels how many ratings the average is based on, see
+	// confidence.
+	Confidence string `json:"confidence,omitempty"`
+	// Sources is only set by GET /drivers/{driver_id}?breakdown=source.
+	Sources map[string]GroupAverage `json:"sources,omitempty"`
+	// Averages is only set by GET /drivers/{driver_id}?averages=all, see
+	// getDriverAverages.
+	Averages map[string]*float64 `json:"averages,omitempty"`
+	// LatestRating is only set by GET /drivers?include=latest_rating, it
+	// stays nil for drivers without ratings.
+	LatestRating *LatestRating `json:"latest_rating,omitempty"`
+	// Window is only set by GET /drivers/{driver_id}?window=, the average
+	// and the confidence then only count the ratings created in it.
+	Window string `json:"window,omitempty"`
+	// AverageDisplay is avg_rating formatted for the locale the request asks
+	// for, see localizeAverages.
+	AverageDisplay string `json:"avg_rating_display,omitempty"`
+	// TrustScore is only set by GET /drivers and GET /drivers/{driver_id},
+	// see trustScore.
+	TrustScore *float64 `json:"trust_score,omitempty"`
+	// DriverInternals are only set for admins, see showInternals.
+	*DriverInternals
+}
+
+// LatestRating is the rating of a driver that was submitted or changed
+// last.
+type LatestRating struct {
+	Rating  int       `json:"rating"`
+	RatedAt time.Time `json:"rated_at"`
 }
 
 func rate(w http.ResponseWriter, r *http.Request) {
@@ -45,169 +100,1173 @@ func rate(w http.ResponseWriter, r *http.Request) {
 	var rating Rating
 	err := dec.Decode(&rating)
 	if err != nil {
-		panic(err)
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	rating.DriverID = driverId
+	var link *ratingLink
+	if r.URL.Query().Has("sig") {
+		link, err = checkRatingLink(driverId, r.URL.Query())
+		if err != nil {
+			status := http.StatusForbidden
+			if err == errLinkExpired {
+				status = http.StatusGone
+			}
+			writeError(w, status, err.Error())
+			return
+		}
 	}
-	err = createOrUpdateRating(driverId, rating.UserID, rating.Rating)
+	id, err := service.RateDriver(r, rating, link)
 	if err != nil {
-		panic(err)
+		writeServiceError(w, err)
+		return
+	}
+	if id != "" {
+		acceptRating(w, id)
+		return
 	}
 	w.WriteHeader(200)
 }
 
+// validateRating checks the value, the user id and the optional fields of a
+// rating.
+func validateRating(rating Rating) error {
+	if rating.Rating < 1 || rating.Rating > 5 {
+		return errors.New("rating must be between 1 and 5")
+	}
+	if rating.UserID == "" {
+		return errors.New("user_id is required")
+	}
+	if cfg.userIDPattern != nil && !cfg.userIDPattern.MatchString(rating.UserID) {
+		return fmt.Errorf("user_id must match %s", cfg.UserIDPattern)
+	}
+	if rating.Source != "" && !contains(cfg.RatingSources, rating.Source) {
+		return fmt.Errorf("source must be one of %v", cfg.RatingSources)
+	}
+	if rating.Region != "" && !contains(cfg.RatingRegions, rating.Region) {
+		return fmt.Errorf("region must be one of %v", cfg.RatingRegions)
+	}
+	if len(rating.Comment) > maxCommentLength {
+		return fmt.Errorf("comment must be at most %d bytes", maxCommentLength)
+	}
+	return validateTags(rating.Tags)
+}
+
+// nullString maps the empty string to NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// writeError responds with the status and a JSON body {"code", "message"},
+// with the code of errorCode. The message is repeated as "error" for the
+// clients that predate code.
+func writeError(w http.ResponseWriter, status int, message string) {
+	metrics.errorSent(errorCode(status))
+	d, err := json.Marshal(map[string]string{"code": errorCode(status), "message": message, "error": message})
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// writeInternalError logs err and responds with 500 and a generic JSON
+// error, the details are not leaked to the client. The log line carries the
+// id of the request, which the access log line of the request repeats.
+func writeInternalError(w http.ResponseWriter, err error) {
+	if ow, ok := w.(*observedWriter); ok {
+		ow.err = err
+		log.Printf("internal error: request %s: %v", ow.requestID, err)
+	} else {
+		log.Printf("internal error: %v", err)
+	}
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
 func getDrivers(w http.ResponseWriter, r *http.Request) {
-	list, err := getDriversList()
+	params, err := parseListParams(r, listOptions{
+		DefaultLimit: defaultDriversLimit,
+		MaxLimit:     maxDriversLimit,
+		Sorts:        driverSorts,
+		Rounding:     true,
+		Averages:     averageOptions(),
+	})
+	var tier int
+	var after string
+	if err == nil {
+		tier, after, err = parseTierPage(r.URL.Query(), params)
+	}
+	var fields []string
+	if err == nil {
+		fields, err = parseHasFields(r.URL.Query())
+	}
+	var minAvg *float64
+	var minCount int
+	if err == nil {
+		minAvg, minCount, err = parseDriverMinimums(r.URL.Query())
+	}
+	var envelope bool
+	if err == nil {
+		envelope, err = parseBoolParam(r.URL.Query(), "envelope")
+	}
 	if err != nil {
-		panic(err)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	d, err := json.Marshal(list)
+	q := driverQuery{
+		UserID:    storedUserID(r.URL.Query().Get("user_id")),
+		Average:   params.Average,
+		Sort:      params.Sort,
+		Limit:     params.Limit,
+		Offset:    params.Offset,
+		Tier:      tier,
+		After:     after,
+		HasFields: fields,
+		MinRating: minAvg,
+		MinCount:  minCount,
+
+		EntityType: entityType(r),
+	}
+	switch include := r.URL.Query().Get("include"); include {
+	case "":
+	case "latest_rating":
+		q.LatestRating = true
+	default:
+		writeError(w, http.StatusBadRequest, (&paramError{"include", "must be latest_rating"}).Error())
+		return
+	}
+	prefetch, err := parseBoolParam(r.URL.Query(), "prefetch")
 	if err != nil {
-		panic(err)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	html := prefersHTML(r)
+	if r.URL.Query().Get("stream") == "true" && !html {
+		streamDrivers(w, q, params)
+		return
+	}
+	prefetch = prefetch && !html
+	if prefetch {
+		// The next page is read along with this one.
+		q.Limit *= 2
+	}
+	if tier != 0 {
+		// One more driver tells whether there is a next page.
+		q.Limit++
+	}
+	envelope = envelope && !html
+	list, total, err := service.ListDrivers(q, envelope || tier == 0 && cfg.PaginationLinks)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	var next []string
+	if prefetch {
+		next = prefetchIDs(list, params.Limit)
+	}
+	var nextPage string
+	if tier != 0 {
+		if list, nextPage = nextTierPage(r, list, params.Limit); nextPage != "" {
+			w.Header().Set("Link", "<"+nextPage+`>; rel="next"`)
+		}
+	} else if len(list) > params.Limit {
+		list = list[:params.Limit]
+	}
+	if tier == 0 && cfg.PaginationLinks {
+		w.Header().Set("Link", pageLinks(r, params.Offset, params.Limit, total))
+	}
+	if tier == 0 && params.Offset+params.Limit < total {
+		nextPage = pageURL(r, params.Offset+params.Limit, params.Limit)
+	}
+	if html {
+		roundAverages(list, params)
+		writeDriversHTML(w, list)
+		return
+	}
+	if err = service.showDrivers(entityType(r), list, params); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	drivers := make([]*Driver, len(list))
+	for i := range list {
+		drivers[i] = &list[i]
+	}
+	localizeAverages(w, r, drivers...)
+	if err = showInternals(w, r, drivers...); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	var body interface{} = list
+	if prefetch || envelope {
+		page := DriversPage{Drivers: list}
+		if prefetch {
+			page.NextPageIDs = &NextPageIDs{Prefetch: next}
+		}
+		if envelope {
+			page.PageSummary = &PageSummary{Total: total, Next: nextPage}
+		}
+		body = page
+	}
+	d, err := json.Marshal(body)
+	if err != nil {
+		writeInternalError(w, err)
+		return
 	}
 	_, err = w.Write(d)
 	if err != nil {
-		panic(err)
+		log.Println(err)
 	}
-	w.WriteHeader(200)
 }
 
-func getDriverRatings(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	driverId := params["driver_id"]
-	list, err := getDriverRatingsList(driverId)
+// createDriver adds a driver. When the client sends `If-None-Match: *` along
+// with a key, repeating the create returns the driver made the first time
+// instead of a duplicate. Without the header a repeated key is a conflict.
+func createDriver(w http.ResponseWriter, r *http.Request) {
+	var input NewDriver
+	driverInfo, err := decodeDriverInfo(r, &input, &input.DriverInfo)
 	if err != nil {
-		panic(err)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	d, err := json.Marshal(list)
+	driver, created, err := store.CreateDriver(input.Key, driverInfo, entityType(r), requestActor(r))
 	if err != nil {
-		panic(err)
+		writeInternalError(w, err)
+		return
 	}
+	status := http.StatusCreated
+	if !created {
+		if r.Header.Get("If-None-Match") != "*" {
+			writeError(w, http.StatusConflict, "a driver with this key already exists")
+			return
+		}
+		status = http.StatusOK
+	}
+	d, err := json.Marshal(driver)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	w.Header().Set("Location", entityPath(entityType(r), driver.ID))
+	w.WriteHeader(status)
 	_, err = w.Write(d)
 	if err != nil {
-		panic(err)
+		log.Println(err)
 	}
-	w.WriteHeader(200)
 }
 
-func createTables() {
-	for _, q := range schemaSQL {
-		statement, err := db.Prepare(q) // Prepare SQL Statement
+func getDriver(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	driverId := params["driver_id"]
+	breakdown := r.URL.Query().Get("breakdown")
+	if breakdown != "" && breakdown != "source" {
+		writeError(w, http.StatusBadRequest, (&paramError{"breakdown", "must be source"}).Error())
+		return
+	}
+	averages := r.URL.Query().Get("averages")
+	if averages != "" && averages != "all" {
+		writeError(w, http.StatusBadRequest, (&paramError{"averages", "must be all"}).Error())
+		return
+	}
+	var since *time.Time
+	window := r.URL.Query().Get("window")
+	if window != "" {
+		d, err := parseWindow("window", window)
 		if err != nil {
-			log.Fatal(err.Error())
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
 		}
-		statement.Exec()
+		t := time.Now().Add(-d)
+		since = &t
+	}
+	p, err := parseListParams(r, listOptions{Averages: averageOptions()})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	excludeUser := storedUserID(r.URL.Query().Get("exclude_user"))
+	driver, err := service.GetDriver(entityType(r), driverId, driverRead{
+		UserID:      storedUserID(r.URL.Query().Get("user_id")),
+		ExcludeUser: excludeUser,
+		Since:       since,
+		Average:     p.Average,
+	})
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	driver.Window = window
+	localizeAverages(w, r, driver)
+	if err = showInternals(w, r, driver); err != nil {
+		writeInternalError(w, err)
+		return
 	}
-	for i := 1; i <= 30; i++ {
-		query := `INSERT INTO drivers (id, driver_info, rating_sum, rating_count) VALUES (?, ?, 0, 0)`
-		statement, err := db.Prepare(query) // Prepare statement.
-		// This is good to avoid SQL injections
+	if breakdown == "source" {
+		driver.Sources, err = getDriverSourceAverages(driverId, excludeUser)
 		if err != nil {
-			log.Fatal(err.Error())
+			writeInternalError(w, err)
+			return
 		}
-		_, err = statement.Exec(i, "{}")
-		if err != nil {
-			log.Fatal(err.Error())
+	}
+	if averages == "all" {
+		if driver.Averages, err = getDriverAverages(driverId); err != nil {
+			writeInternalError(w, err)
+			return
 		}
 	}
+	d, err := json.Marshal(driver)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func deleteDriver(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	driverId := params["driver_id"]
+	err := softDeleteDriver(driverId, requestActor(r))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	w.WriteHeader(204)
 }
 
-func createOrUpdateRating(driverId, userId string, rating int) error {
-	ratingObject, err := getRating(driverId, userId)
-	if ratingObject == nil && err == nil {
-		query := `INSERT INTO driver_ratings (driver_id, user_id, rating) VALUES (?, ?, ?)`
-		statement, err := db.Prepare(query) // Prepare statement.
-		// This is good to avoid SQL injections
+func deleteRating(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	// Admins take down the ratings of others, e.g. after confirmed abuse.
+	if callerRole(r) != roleAdmin && identity != nil {
+		// A user only retracts their own rating.
+		sub, err := identity.subject(r)
 		if err != nil {
-			return err
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "invalid token: "+err.Error())
+			return
 		}
-		_, err = statement.Exec(driverId, userId, rating)
-		if err != nil {
-			return err
+		if sub != params["user_id"] {
+			writeError(w, http.StatusForbidden, "only the rating's user or an admin can delete it")
+			return
 		}
-		query = `UPDATE drivers 
-      SET rating_sum = rating_sum + ?, 
-        rating_count = rating_count + 1 
-      WHERE id = ?`
-		statement, err = db.Prepare(query) // Prepare statement.
-		// This is good to avoid SQL injections
+	}
+	found, err := store.DeleteRating(params["driver_id"], storedUserID(params["user_id"]), requestActor(r))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "rating not found")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func getDriverRatings(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	driverId := params["driver_id"]
+	query := r.URL.Query()
+	filter, err := parseRatingFilter(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if query.Has("limit") || query.Has("before") || query.Has("offset") {
+		getDriverRatingsFeed(w, r, driverId, filter)
+		return
+	}
+	list, err := store.ListDriverRatings(driverId, filter, cfg.MaxRatingsPerDriver+1)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	showRatings(r, list)
+	var body interface{} = list
+	if len(list) > cfg.MaxRatingsPerDriver {
+		// Too many to return at once, send the newest ones as the first
+		// page of the feed so the client can fetch the rest with next.
+		page, err := service.ListDriverRatings(r, driverId, filter, nil, cfg.MaxRatingsPerDriver)
 		if err != nil {
-			return err
+			writeInternalError(w, err)
+			return
 		}
-		_, err = statement.Exec(rating, driverId)
+		page.Truncated = true
+		body = page
+	}
+	d, err := json.Marshal(body)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+	w.WriteHeader(200)
+}
+
+// parseRatingFilter reads the filter of GET /drivers/{driver_id}/ratings
+// from its query.
+func parseRatingFilter(query url.Values) (ratingFilter, error) {
+	var filter ratingFilter
+	if v := query.Get("has_comment"); v != "" {
+		b, err := strconv.ParseBool(v)
 		if err != nil {
-			return err
+			return filter, &paramError{"has_comment", "must be true or false"}
 		}
-	} else if ratingObject != nil {
-		query := `UPDATE driver_ratings SET rating = ? WHERE driver_id = ? AND user_id = ?`
-		statement, err := db.Prepare(query) // Prepare statement.
-		// This is good to avoid SQL injections
+		filter.HasComment = &b
+	}
+	if v := query.Get("changed_since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
 		if err != nil {
-			return err
+			return filter, &paramError{"changed_since", "must be an RFC 3339 timestamp"}
 		}
-		_, err = statement.Exec(rating, driverId, userId)
+		filter.ChangedSince = &t
+	}
+	if v := query.Get("min_rating"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < minRating || n > maxRating {
+			return filter, &paramError{"min_rating", fmt.Sprintf("must be a number between %d and %d", minRating, maxRating)}
+		}
+		filter.MinRating = n
+	}
+	return filter, nil
+}
+
+func createTables() {
+	if cfg.AutoMigrate {
+		if err := migrate(srv.DB()); err != nil {
+			log.Fatal(err.Error())
+		}
+	} else if version, err := getSchemaVersion(srv.DB()); err != nil || version != schemaVersion {
+		log.Fatalf("schema is at version %d, expected %d (%v), run the migrate up command first", version, schemaVersion, err)
+	}
+	if cfg.DisableSeed {
+		return
+	}
+	if err := seedDemoDrivers(cfg.SeedMode); err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+// seedDriverCount is the number of demo drivers created on startup, they get
+// ids 1 to seedDriverCount.
+const seedDriverCount = 30
+
+const (
+	seedSkip    = "skip"
+	seedReplace = "replace"
+	seedAppend  = "append"
+)
+
+// seedDemoDrivers adds the demo drivers to an empty database. When drivers
+// already exist the mode decides: skip leaves the data as it is, replace
+// drops the demo drivers with their ratings and creates them again, append
+// adds another set numbered after the existing drivers.
+func seedDemoDrivers(mode string) error {
+	tx, err := srv.DB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var count int
+	err = tx.QueryRow("SELECT COUNT(*) FROM drivers").Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		switch mode {
+		case seedSkip:
+			return nil
+		case seedReplace:
+			for _, q := range []string{
+				"DELETE FROM driver_ratings WHERE driver_id BETWEEN 1 AND ?",
+				"DELETE FROM driver_snapshots WHERE driver_id BETWEEN 1 AND ?",
+				"DELETE FROM drivers WHERE id BETWEEN 1 AND ?",
+			} {
+				if _, err = tx.Exec(q, seedDriverCount); err != nil {
+					return err
+				}
+			}
+			// Seed from id 1 again, even if there are drivers after the demo ones.
+			for i := int64(1); i <= seedDriverCount; i++ {
+				query := `INSERT INTO drivers (id, driver_info, rating_sum, rating_count) VALUES (?, ?, 0, 0)`
+				if _, err = tx.Exec(query, i, "{}"); err != nil {
+					return err
+				}
+			}
+			return tx.Commit()
+		}
+	}
+	if _, _, err = seedDrivers(tx, seedDriverCount); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// seedDrivers inserts count demo drivers without ratings, numbered after the
+// highest existing id, and returns the first and last inserted ids.
+func seedDrivers(q dbtx, count int) (first, last int64, err error) {
+	err = q.QueryRow("SELECT " + nextIntegerDriverID + " - 1").Scan(&last)
+	if err != nil {
+		return 0, 0, err
+	}
+	first = last + 1
+	query := `INSERT INTO drivers (id, driver_info, rating_sum, rating_count) VALUES (?, ?, 0, 0)`
+	statement, err := q.Prepare(query) // Prepare statement.
+	// This is good to avoid SQL injections
+	if err != nil {
+		return 0, 0, err
+	}
+	defer statement.Close()
+	for i := 0; i < count; i++ {
+		last++
+		_, err = statement.Exec(last, "{}")
 		if err != nil {
-			return err
+			return 0, 0, err
+		}
+	}
+	return first, last, nil
+}
+
+// createOrUpdateRating writes the rating and the aggregates of the driver in
+// one transaction, so that they can't disagree after a crash or between
+// concurrent submissions. A resubmission within cfg.DedupWindow is a no-op.
+func createOrUpdateRating(rating Rating) error {
+	tx, err := srv.DB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stored, err := storeRating(tx, rating)
+	if err != nil || !stored.ok {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	stored.apply(rating.DriverID)
+	averageCache.refresh(rating.DriverID)
+	events.notify()
+	metrics.ratingsStored(1)
+	return nil
+}
+
+// storedRating is the outcome of storeRating. ok is false for a dropped
+// resubmission, sum and count are what the rating adds to the buffered
+// aggregates.
+type storedRating struct {
+	ok         bool
+	sum, count int64
+}
+
+// apply adds the rating to the buffered aggregates, once its transaction is
+// committed: only committed ratings may reach them.
+func (s storedRating) apply(driverId string) {
+	if s.ok && aggregates != nil {
+		aggregates.add(driverId, s.sum, s.count)
+	}
+}
+
+// storeRating writes the rating and its event in q. Both createOrUpdateRating
+// and the batches of the write buffer go through it, so that a resubmission
+// within cfg.DedupWindow is dropped on either path.
+func storeRating(q dbtx, rating Rating) (storedRating, error) {
+	if cfg.DedupWindow > 0 {
+		same, err := isResubmission(q, rating)
+		if err != nil || same {
+			return storedRating{}, err
+		}
+	}
+	stored := storedRating{ok: true}
+	var err error
+	if aggregates != nil {
+		stored.sum, stored.count, err = upsertRating(q, rating)
+	} else {
+		err = writeRating(q, rating)
+	}
+	if err != nil {
+		return storedRating{}, err
+	}
+	if err = events.record(q, rating); err != nil {
+		return storedRating{}, err
+	}
+	return stored, nil
+}
+
+// isResubmission tells whether the user already gave the driver exactly this
+// rating, with the same source, comment, tags and region, less than
+// cfg.DedupWindow ago. Such a rating is a client retry and is dropped, it
+// would only touch updated_at.
+func isResubmission(q dbtx, r Rating) (bool, error) {
+	prev, err := getRating(q, r.DriverID, r.UserID)
+	if err != nil || prev == nil || prev.UpdatedAt == nil {
+		return false, err
+	}
+	same := prev.Rating == r.Rating && prev.Source == r.Source && prev.Comment == r.Comment && prev.Region == r.Region &&
+		fmt.Sprint(prev.Tags) == fmt.Sprint(r.Tags)
+	return same && time.Since(*prev.UpdatedAt) < cfg.DedupWindow, nil
+}
+
+// removeRating deletes the rating of the user and takes it out of the
+// aggregates of the driver. It returns false when there was no such rating.
+func removeRating(driverId, userId, actor string) (bool, error) {
+	tx, err := srv.DB().Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	rating, err := getRating(tx, driverId, userId)
+	if err != nil || rating == nil {
+		return false, err
+	}
+	_, err = tx.Exec("DELETE FROM driver_ratings WHERE driver_id = ? AND user_id = ?", driverId, userId)
+	if err != nil {
+		return false, err
+	}
+	if err = logRatingDeleted(tx, driverId, userId); err != nil {
+		return false, err
+	}
+	err = recordAudit(tx, actor, "delete", "rating", driverId+"/"+userId, map[string]interface{}{"previous_rating": rating.Rating})
+	if err != nil {
+		return false, err
+	}
+	if err = events.recordDeleted(tx, driverId, userId, rating.Rating); err != nil {
+		return false, err
+	}
+	sum, count := -int64(rating.Rating), int64(-1)
+	if lazy != nil {
+		lazy.markStale(driverId)
+	} else if aggregates == nil {
+		query := `UPDATE drivers 
+      SET rating_sum = rating_sum + ?, 
+        rating_count = rating_count + ? 
+      WHERE id = ?`
+		if _, err = tx.Exec(query, sum, count, driverId); err != nil {
+			return false, err
 		}
-		query = `UPDATE drivers 
-      SET rating_sum = rating_sum + ? 
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	if aggregates != nil {
+		aggregates.add(driverId, sum, count)
+	}
+	averageCache.refresh(driverId)
+	events.notify()
+	return true, nil
+}
+
+// writeRating stores the rating of the user and adjusts the aggregates of the
+// driver using q, which is either the database or a transaction. With lazy
+// aggregates the driver is only marked as stale.
+func writeRating(q dbtx, r Rating) error {
+	delta, added, err := upsertRating(q, r)
+	if err != nil {
+		return err
+	}
+	if lazy != nil {
+		lazy.markStale(r.DriverID)
+		return nil
+	}
+	query := `UPDATE drivers 
+      SET rating_sum = rating_sum + ?, 
+        rating_count = rating_count + ? 
       WHERE id = ?`
-		statement, err = db.Prepare(query) // Prepare statement.
-		// This is good to avoid SQL injections
-		if err != nil {
-			return err
+	_, err = q.Exec(query, delta, added, r.DriverID)
+	return err
+}
+
+// ratingUpdateSet is how the upserts of ratings replace an existing one. A
+// hidden comment stays hidden until the user writes another one.
+const ratingUpdateSet = `
+      prev_rating = driver_ratings.rating,
+      rating = excluded.rating,
+      source = excluded.source,
+      moderation = CASE WHEN excluded.comment IS driver_ratings.comment THEN driver_ratings.moderation ELSE 'visible' END,
+      comment = excluded.comment,
+      tags = excluded.tags,
+      region = excluded.region,
+      updated_at = CURRENT_TIMESTAMP`
+
+// upsertRating stores the rating of the user and returns how the aggregates
+// of the driver have to change: the difference to add to rating_sum, and 1 to
+// add to rating_count for a new rating or 0 for a replaced one. Ratings are
+// whole stars, so rating_sum is an exact integer that doesn't drift however
+// many updates it takes.
+//
+// The rating row is written with a single upsert that hands back the rating
+// it replaced, so two concurrent submissions from the same user can't both
+// take the insert path and count the user twice.
+func upsertRating(q dbtx, r Rating) (delta, added int64, err error) {
+	query := `INSERT INTO driver_ratings (driver_id, user_id, rating, source, comment, tags, region) VALUES (?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT(driver_id, user_id) DO UPDATE SET` + ratingUpdateSet + `
+    RETURNING prev_rating`
+	statement, err := q.Prepare(query) // Prepare statement.
+	// This is good to avoid SQL injections
+	if err != nil {
+		return 0, 0, err
+	}
+	defer statement.Close()
+	// prev_rating is only set by the update branch, NULL means a new rating.
+	var prev sql.NullInt64
+	err = statement.QueryRow(r.DriverID, r.UserID, r.Rating, nullString(r.Source), nullString(r.Comment), r.Tags, nullString(r.Region)).Scan(&prev)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !prev.Valid && cfg.ArchiveKeepAverage {
+		if prev, err = unarchiveRating(q, r.DriverID, r.UserID); err != nil {
+			return 0, 0, err
 		}
-		_, err = statement.Exec(rating-ratingObject.Rating, driverId)
+	}
+	delta, added = int64(r.Rating), 1
+	if prev.Valid {
+		delta, added = int64(r.Rating)-prev.Int64, 0
+	}
+	// Every submission is logged, including the ones replacing a rating.
+	_, err = q.Exec("INSERT INTO rating_events (driver_id, user_id, rating) VALUES (?, ?, ?)", r.DriverID, r.UserID, r.Rating)
+	if err != nil {
+		return 0, 0, err
+	}
+	action, detail := "create", map[string]interface{}{"rating": r.Rating}
+	if prev.Valid {
+		action, detail["previous_rating"] = "update", prev.Int64
+	}
+	if err = recordAudit(q, r.UserID, action, "rating", r.DriverID+"/"+r.UserID, detail); err != nil {
+		return 0, 0, err
+	}
+	return delta, added, nil
+}
+
+// insertDriver adds a driver, or another entity of type entity, without
+// ratings. If key is not empty and an entity with that key already exists,
+// that entity is returned with created set to false.
+func insertDriver(key, driverInfo, entity, actor string) (driver *Driver, created bool, err error) {
+	clientKey := nullString(key)
+	id, err := newDriverID()
+	if err != nil {
+		return nil, false, err
+	}
+	tx, err := srv.DB().Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+	query := `INSERT INTO drivers (id, driver_info, rating_sum, rating_count, client_key, external_id, entity_type)
+    VALUES (COALESCE(?, ` + nextIntegerDriverID + `), ?, 0, 0, ?, ?, ?)
+    ON CONFLICT(client_key) DO NOTHING`
+	statement, err := tx.Prepare(query)
+	if err != nil {
+		return nil, false, err
+	}
+	defer statement.Close()
+	res, err := statement.Exec(id, driverInfo, clientKey, nullString(externalID(driverInfo)), entity)
+	if err != nil {
+		return nil, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+	if n == 0 {
+		driver = &Driver{}
+		err = tx.QueryRow(`SELECT id, driver_info, COALESCE(CAST(rating_sum AS REAL)/rating_count, 0) FROM drivers WHERE client_key = ?`,
+			key).Scan(&driver.ID, &driver.DriverInfo, &driver.AverageRating)
 		if err != nil {
-			return err
+			return nil, false, err
 		}
+		return driver, false, nil
 	}
-	return err
+	rowId, err := res.LastInsertId()
+	if err != nil {
+		return nil, false, err
+	}
+	driver = &Driver{DriverInfo: driverInfo}
+	err = tx.QueryRow("SELECT id FROM drivers WHERE rowid = ?", rowId).Scan(&driver.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	err = recordAudit(tx, actor, "create", entity, driver.ID, map[string]interface{}{"driver_info": driverInfo})
+	if err != nil {
+		return nil, false, err
+	}
+	return driver, true, tx.Commit()
+}
+
+// softDeleteDriver marks the driver as deleted, the row and its ratings are
+// kept but the driver no longer shows up in the list and can't be rated.
+func softDeleteDriver(driverId, actor string) error {
+	tx, err := srv.DB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `UPDATE drivers SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL`
+	res, err := tx.Exec(query, time.Now().UTC().Format(timeFormat), driverId)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	if err = recordAudit(tx, actor, "delete", "driver", driverId, nil); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	averageCache.forget(driverId)
+	return nil
+}
+
+// getDriverState tells whether the driver exists and whether it has been
+// soft deleted.
+func getDriverState(driverId string) (found, deleted bool, err error) {
+	err = srv.DB().QueryRow("SELECT deleted_at IS NOT NULL FROM drivers WHERE id = ?", driverId).Scan(&deleted)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	return err == nil, deleted, err
+}
+
+// ratingFilter narrows the ratings of a driver GET
+// /drivers/{driver_id}/ratings lists. HasComment keeps the ratings with (or
+// without) a comment that isn't hidden, ChangedSince the ones created or updated since then,
+// MinRating the ones of at least that many stars.
+type ratingFilter struct {
+	HasComment   *bool
+	ChangedSince *time.Time
+	MinRating    int
+}
+
+// condition returns the SQL condition, with its arguments, to add to the
+// WHERE of a driver_ratings query. It is empty when nothing is filtered.
+func (f ratingFilter) condition() (string, []interface{}) {
+	cond, args := "", []interface{}{}
+	if f.HasComment != nil && *f.HasComment {
+		cond += " AND comment IS NOT NULL AND comment != '' AND moderation != 'hidden'"
+	} else if f.HasComment != nil {
+		cond += " AND (comment IS NULL OR comment = '' OR moderation = 'hidden')"
+	}
+	if f.ChangedSince != nil {
+		cond += " AND updated_at >= ?"
+		args = append(args, f.ChangedSince.UTC().Format(timeFormat))
+	}
+	if f.MinRating > 0 {
+		cond += " AND rating >= ?"
+		args = append(args, f.MinRating)
+	}
+	return cond, args
+}
+
+// driverRead tells getDriverByID what to read besides the stored driver,
+// the zero value reads it as it is cached.
+type driverRead struct {
+	// UserID, when not empty, adds the rating that user gave to the driver,
+	// like driverQuery.UserID does in the list.
+	UserID string
+	// The rating of ExcludeUser, if any, is taken out of the average, and
+	// with Since only the ratings created since then count.
+	ExcludeUser string
+	Since       *time.Time
+	// Average is the avg to compute instead of cfg.AggFunction.
+	Average string
+}
+
+// getDriverByID returns nil when the driver does not exist or is deleted.
+func getDriverByID(driverId string, opts driverRead) (*Driver, error) {
+	if opts == (driverRead{}) {
+		return cachedDriver(driverId)
+	}
+	return readDriver(driverId, opts)
 }
 
-func getRating(driverId, userId string) (*Rating, error) {
-	row, err := db.Query("SELECT rating FROM driver_ratings WHERE driver_id = ? AND user_id = ?", driverId, userId)
+// readDriver is getDriverByID from the database.
+func readDriver(driverId string, opts driverRead) (*Driver, error) {
+	excludeUser, since := opts.ExcludeUser, opts.Since
+	var driver Driver
+	// A NULL user_id never matches, so without a user the joins are no-ops.
+	var count int
+	var userRating sql.NullInt64
+	avg := "CAST(d.rating_sum - COALESCE(x.rating, 0) AS REAL)/(d.rating_count - (x.rating IS NOT NULL))"
+	countExpr := "d.rating_count - (x.rating IS NOT NULL)"
+	var args []interface{}
+	fn := opts.Average
+	if fn == "" {
+		fn = cfg.AggFunction
+	}
+	if fn != avgMean || since != nil {
+		avg, args = ratingsAverageExpr("d", fn, excludeUser, since)
+	}
+	if since != nil {
+		// The stored aggregates cover every rating, the ones of the window
+		// are counted from driver_ratings.
+		filter, filterArgs := ratingsFilter("d", excludeUser, since)
+		countExpr, args = "(SELECT COUNT(*) FROM driver_ratings WHERE "+filter+")", append(args, filterArgs...)
+	}
+	args = append(args, nullString(excludeUser), nullString(opts.UserID), driverId)
+	err := srv.DB().QueryRow(`SELECT d.id, d.driver_info, COALESCE(`+avg+`, 0),
+      `+countExpr+`, ur.rating
+    FROM drivers d
+    LEFT JOIN driver_ratings x ON x.driver_id = d.id AND x.user_id = ?
+    LEFT JOIN driver_ratings ur ON ur.driver_id = d.id AND ur.user_id = ?
+    WHERE d.id = ? AND d.deleted_at IS NULL`, args...).Scan(&driver.ID, &driver.DriverInfo, &driver.AverageRating, &count, &userRating)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	driver.Confidence = confidence(count)
+	if userRating.Valid {
+		rating := int(userRating.Int64)
+		driver.UserRating = &rating
+	}
+	return &driver, nil
+}
+
+// getDriverOwner returns the user id of the driver, read from the
+// cfg.SelfRatingField field of its driver_info.
+func getDriverOwner(driverId string) (string, error) {
+	var driverInfo sql.NullString
+	err := srv.DB().QueryRow("SELECT driver_info FROM drivers WHERE id = ?", driverId).Scan(&driverInfo)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return infoField(driverInfo.String, cfg.SelfRatingField), nil
+}
+
+func getRating(q dbtx, driverId, userId string) (*Rating, error) {
+	row, err := q.Query("SELECT driver_id, user_id, rating, COALESCE(source, ''), COALESCE(comment, ''), tags, COALESCE(region, ''), created_at, updated_at, moderation FROM driver_ratings WHERE driver_id = ? AND user_id = ?", driverId, userId)
 	if err != nil {
 		return nil, err
 	}
 	defer row.Close()
 	for row.Next() { // Iterate and fetch the records from result cursor
-		var rating int
-		err = row.Scan(&rating)
+		var rating Rating
+		err = row.Scan(&rating.DriverID, &rating.UserID, &rating.Rating, &rating.Source, &rating.Comment, &rating.Tags, &rating.Region, &rating.CreatedAt, &rating.UpdatedAt, &rating.Moderation)
 		if err != nil {
 			return nil, err
 		}
-		return &Rating{userId, driverId, rating}, nil
+		return &rating, nil
+	}
+	return nil, row.Err()
+}
+
+// driverQuery holds the options of a drivers list query.
+type driverQuery struct {
+	// UserID, when not empty, adds the rating that user gave to each driver.
+	UserID string
+	// Average selects how avg_rating is computed, see the avg* constants.
+	Average string
+	// LatestRating adds the latest rating of each driver.
+	LatestRating bool
+	// Sort is one of the sort* constants, Limit and Offset select the page.
+	Sort   string
+	Limit  int
+	Offset int
+	// Tier, when not 0, keeps the drivers of that tier with an id after
+	// After, see parseTierPage.
+	Tier  int
+	After string
+	// HasFields keeps the drivers with these driver_info fields set.
+	HasFields []string
+	// MinRating, when set, keeps the rated drivers with at least that
+	// average, MinCount the drivers with at least that many ratings.
+	MinRating *float64
+	MinCount  int
+	// EntityType keeps the entities of that type, the drivers when empty.
+	EntityType string
+}
+
+const (
+	defaultDriversLimit = 20
+	maxDriversLimit     = 100
+
+	sortID         = "id"
+	sortRating     = "rating"
+	sortRatingDesc = "rating_desc"
+	sortCount      = "count"
+	sortCountDesc  = "count_desc"
+)
+
+// driverSorts are the sorts of the drivers list, sortID is the default.
+var driverSorts = []string{sortID, sortRating, sortRatingDesc, sortCount, sortCountDesc, sortBlended}
+
+// driverOrder returns the ORDER BY clause of the sort and its arguments, ties
+// on the average are broken by tieBreak so that pages don't overlap.
+func driverOrder(sort string) (string, []interface{}) {
+	switch sort {
+	case sortRating:
+		return "avg_rating, " + tieBreak("r"), nil
+	case sortRatingDesc:
+		return "avg_rating DESC, " + tieBreak("r"), nil
+	case sortBlended:
+		score, args := blendedScore("r")
+		return score + " DESC, " + tieBreak("r"), args
+	case sortCount:
+		return "r.rating_count, r.id", nil
+	case sortCountDesc:
+		return "r.rating_count DESC, r.id", nil
+	}
+	return "r.id", nil
+}
+
+const (
+	tieBreakCount = "count"
+	tieBreakID    = "id"
+)
+
+// tieBreak orders the drivers in alias that rank the same, according to
+// cfg.TieBreak: most ratings first and then by id, or by id only.
+func tieBreak(alias string) string {
+	if cfg.TieBreak == tieBreakID {
+		return alias + ".id"
 	}
-	return nil, nil
+	return alias + ".rating_count DESC, " + alias + ".id"
 }
 
-func getDriversList() ([]Driver, error) {
-	row, err := db.Query("SELECT r.id, r.driver_info, COALESCE(r.rating_sum/r.rating_count, 0) AS avg_rating FROM drivers r")
+// getDriversList returns the page of the drivers that are not deleted
+// selected by q.
+func getDriversList(q driverQuery) ([]Driver, error) {
+	list := []Driver{}
+	err := eachDriver(q, func(driver Driver) error {
+		list = append(list, driver)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return list, nil
+}
+
+// countDrivers counts the drivers that are not deleted q selects, all the
+// pages of getDriversList together. A tier is counted from its start.
+func countDrivers(q driverQuery) (int, error) {
+	q.Sort, q.Limit, q.Offset, q.After = sortID, 0, 0, ""
+	query, args := driversSQL(q)
+	var total int
+	err := srv.DB().QueryRow("SELECT COUNT(*) FROM ("+query+")", args...).Scan(&total)
+	return total, err
+}
+
+// driversSQL returns the query of the drivers q selects, with its arguments.
+func driversSQL(q driverQuery) (string, []interface{}) {
+	// rating_sum and rating_count are integers, averageExpr casts the sum so
+	// that the mean isn't truncated by an integer division.
+	expr, args := averageExpr("r", q.Average)
+	if q.Average == avgTrusted {
+		cond, condArgs := trustedRaterCondition("tr")
+		expr = "(SELECT AVG(tr.rating) FROM driver_ratings tr WHERE tr.driver_id = r.id AND " + cond + ")"
+		args = condArgs
+	}
+	// Unrated drivers have a NULL average, shown as 0.
+	avg := "COALESCE(" + expr + ", 0)"
+	// A NULL user_id never matches, so without a user the join is a no-op.
+	args = append(args, nullString(q.UserID))
+	entity := q.EntityType
+	if entity == "" {
+		entity = entityDriver
+	}
+	args = append(args, entity)
+	order, orderArgs := driverOrder(q.Sort)
+	limit := q.Limit
+	if limit == 0 {
+		limit = -1 // no limit
+	}
+	where := ""
+	if q.Tier != 0 {
+		cond, condArgs := tierCondition(q.Tier)
+		where, args = cond, append(args, condArgs...)
+		if q.After != "" {
+			where += " AND r.id > ?"
+			args = append(args, q.After)
+		}
+	}
+	if len(q.HasFields) > 0 {
+		cond, condArgs := hasFieldsCondition("r", q.HasFields)
+		where, args = where+cond, append(args, condArgs...)
+	}
+	if q.MinRating != nil {
+		where, args = where+" AND r.rating_count > 0 AND avg_rating >= ?", append(args, *q.MinRating)
+	}
+	if q.MinCount > 0 {
+		where, args = where+" AND r.rating_count >= ?", append(args, q.MinCount)
+	}
+	args = append(append(args, orderArgs...), limit, q.Offset)
+	latest, latestJoin := "NULL, NULL", ""
+	if q.LatestRating {
+		latest = "lr.rating, lr.updated_at"
+		latestJoin = `
+    LEFT JOIN driver_ratings lr ON lr.rowid = (SELECT rowid FROM driver_ratings
+      WHERE driver_id = r.id ORDER BY updated_at DESC, rowid DESC LIMIT 1)`
+	}
+	return `SELECT r.id, r.driver_info, ` + avg + ` AS avg_rating, r.rating_count, ur.rating, ` + latest + `
+    FROM drivers r
+    LEFT JOIN driver_ratings ur ON ur.driver_id = r.id AND ur.user_id = ?` + latestJoin + `
+    WHERE r.deleted_at IS NULL AND r.entity_type = ?` + where + `
+    ORDER BY ` + order + `
+    LIMIT ? OFFSET ?`, args
+}
+
+// eachDriver calls fn for every driver of getDriversList as it is read from
+// the database, stopping at the first error.
+func eachDriver(q driverQuery, fn func(Driver) error) error {
+	query, args := driversSQL(q)
+	row, err := srv.DB().Query(query, args...)
+	if err != nil {
+		return err
+	}
 	defer row.Close()
-	var list []Driver
 	for row.Next() { // Iterate and fetch the records from result cursor
 		var driver Driver
-		err = row.Scan(&driver.ID, &driver.DriverInfo, &driver.AverageRating)
+		var userRating, latestRating sql.NullInt64
+		var latestAt sql.NullTime
+		var count int
+		err = row.Scan(&driver.ID, &driver.DriverInfo, &driver.AverageRating, &count, &userRating, &latestRating, &latestAt)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if latestRating.Valid {
+			driver.LatestRating = &LatestRating{Rating: int(latestRating.Int64), RatedAt: latestAt.Time}
+		}
+		driver.Confidence = confidence(count)
+		if userRating.Valid {
+			rating := int(userRating.Int64)
+			driver.UserRating = &rating
+		}
+		if err = fn(driver); err != nil {
+			return err
 		}
-		list = append(list, driver)
 	}
-	return list, nil
+	return row.Err()
 }
 
-func getDriverRatingsList(driverId string) ([]Rating, error) {
-	row, err := db.Query("SELECT driver_id, user_id, rating FROM driver_ratings WHERE driver_id = ?", driverId)
+// getDriverRatingsList returns at most limit ratings of the driver selected
+// by filter.
+func getDriverRatingsList(driverId string, filter ratingFilter, limit int) ([]Rating, error) {
+	cond, args := filter.condition()
+	args = append([]interface{}{driverId}, append(args, limit)...)
+	row, err := srv.DB().Query("SELECT driver_id, user_id, rating, COALESCE(source, ''), COALESCE(comment, ''), tags, COALESCE(region, ''), created_at, updated_at, moderation FROM driver_ratings WHERE driver_id = ?"+cond+" LIMIT ?", args...)
 	if err != nil {
 		return nil, err
 	}
 	defer row.Close()
-	var list []Rating
+	list := []Rating{}
 	for row.Next() { // Iterate and fetch the records from result cursor
 		var rating Rating
-		err = row.Scan(&rating.DriverID, &rating.UserID, &rating.Rating)
+		err = row.Scan(&rating.DriverID, &rating.UserID, &rating.Rating, &rating.Source, &rating.Comment, &rating.Tags, &rating.Region, &rating.CreatedAt, &rating.UpdatedAt, &rating.Moderation)
 		if err != nil {
 			return nil, err
 		}
@@ -220,22 +1279,212 @@ func getDriverRatingsList(driverId string) ([]Rating, error) {
 main function
 */
 func main() {
-	os.Remove(dbFilePath)
-	file, err := os.Create(dbFilePath)
+	var err error
+	cfg, err = loadConfig()
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if cfg.DBDriver != dbDriverSQLite {
+			log.Fatalf("migrate is for DB_DRIVER=%s, the %s schema is applied on start", dbDriverSQLite, cfg.DBDriver)
+		}
+		if err = runMigrateCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 {
+		log.Fatalf("unknown command %q, the only one is migrate", os.Args[1])
+	}
+	if cfg.DBDriver != dbDriverSQLite {
+		if err = serveCore(); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	file.Close()
-	db, _ = sql.Open("sqlite3", dbFilePath)
-	defer db.Close()
+	if cfg.ResetDB {
+		log.Println("ARTICLES_RESET_DB is set, deleting", cfg.DBPath)
+		if err = os.Remove(cfg.DBPath); err != nil && !os.IsNotExist(err) {
+			log.Fatal(err)
+		}
+	}
+	db, err := openDB(cfg.DBPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv.SwapDB(db)
+	defer func() { srv.DB().Close() }()
 	createTables()
+	go snapshotLoop()
+	go topDriversLoop(cfg.TopDriversInterval)
+	if err = initServices(); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.ArchiveAfter > 0 {
+		go archiveLoop()
+	}
+	handler, err := newRouter()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.GRPCAddr != "" {
+		if err = serveRPC(cfg.GRPCAddr); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err = serve(cfg.ListenAddr, handler); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// initServices sets up what the handlers share besides the database from
+// cfg, every part that is off is left nil. srv must already point at the
+// migrated database.
+func initServices() error {
+	if aggregates != nil {
+		aggregates.stop()
+	}
+	identity, userRateLimit, aggregates, averageCache = nil, nil, nil, nil
+	lazy, userIDs, events, ratingBuffer = nil, nil, nil, nil
+	var err error
+	if cfg.JWTSecret != "" || cfg.JWTJWKSURL != "" {
+		identity = newTokenVerifier(cfg.JWTSecret, cfg.JWTJWKSURL, cfg.JWTIssuer)
+	}
+	if cfg.UserRateLimit > 0 {
+		userRateLimit = newUserLimiter(cfg.UserRateLimit, cfg.UserRateLimitBurst)
+	}
+	if cfg.AggregateInterval > 0 {
+		aggregates = newAggregateBuffer(cfg.AggregateInterval)
+	}
+	if cfg.AverageCacheBackend == cacheRedis {
+		backend, err := newRedisCache(cfg.RedisURL, cfg.AverageCacheTTL)
+		if err != nil {
+			return err
+		}
+		averageCache = newDriverCache(backend)
+	} else if cfg.AverageCacheSize > 0 {
+		averageCache = newDriverCache(newLRUCache(cfg.AverageCacheSize))
+	}
+	if cfg.RatingEventLog && cfg.LazyAggregateTTL == 0 {
+		// The aggregates are only a cache of the log, they are rebuilt
+		// from it on startup. Lazy aggregates do the same.
+		if _, err := recomputeInBatches(cfg.RecomputeBatchSize); err != nil {
+			return err
+		}
+	}
+	if cfg.LazyAggregateTTL > 0 {
+		lazy, err = newLazyAggregates(cfg.LazyAggregateTTL)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.UserIDKey != "" {
+		userIDs, err = newUserIDCipher(cfg.UserIDKey)
+		if err != nil {
+			return err
+		}
+	}
+	publisher, err := newPublisher()
+	if err != nil {
+		return err
+	}
+	if publisher != nil {
+		events = newEventRelay(publisher)
+	}
+	if cfg.BatchInterval > 0 {
+		ratingBuffer = newWriteBuffer(cfg.BatchInterval, cfg.BatchSize)
+	}
+	return nil
+}
 
+// newRouter registers the routes with their middleware.
+func newRouter() (http.Handler, error) {
 	r := mux.NewRouter()
-	r.HandleFunc("/drivers/{driver_id}/ratings", rate).Methods("POST")
+	r.NotFoundHandler = http.HandlerFunc(routeNotFound)
+	r.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowed)
+	r.Use(observe)
+	r.Use(recoverPanics)
+	r.Use(requestTimeouts)
+	r.Use(withRole)
+	r.Use(scopeEntity)
+	if lazy != nil {
+		r.Use(refreshLazyAggregates)
+	}
+	if cfg.ChaosDelay > 0 {
+		log.Println("chaos: delaying responses by", cfg.ChaosDelay)
+		r.Use(chaosDelay(cfg.ChaosDelay))
+	}
+	r.HandleFunc("/healthz", healthz).Methods("GET")
+	r.HandleFunc("/readyz", readyz).Methods("GET")
+	r.HandleFunc("/metrics", getMetrics).Methods("GET")
+	r.Handle("/drivers/{driver_id}/ratings", rateLimit(http.HandlerFunc(rate))).Methods("POST")
 	r.HandleFunc("/drivers", getDrivers).Methods("GET")
+	r.HandleFunc("/drivers", createDriver).Methods("POST")
+	r.HandleFunc("/drivers.csv", getDriversCSV).Methods("GET")
+	r.HandleFunc("/drivers/unrated-by", getDriversUnratedBy).Methods("POST")
+	r.HandleFunc("/drivers/most-improved", getMostImprovedDrivers).Methods("GET")
+	r.HandleFunc("/drivers/at-risk", getAtRiskDrivers).Methods("GET")
+	r.HandleFunc("/drivers/tiers", getDriverTiers).Methods("GET")
+	r.HandleFunc("/drivers/leaderboard", getLeaderboard).Methods("GET")
+	r.HandleFunc("/drivers/top", getTopDrivers).Methods("GET")
+	r.HandleFunc("/drivers/ranked", getRankedDrivers).Methods("GET")
+	r.HandleFunc("/drivers/by-external", getDriversByExternalID).Methods("GET")
 	r.HandleFunc("/drivers/{driver_id}/ratings", getDriverRatings).Methods("GET")
+	r.HandleFunc("/ratings/status/{id}", getRatingStatus).Methods("GET")
+	r.HandleFunc("/drivers/{driver_id}/ratings/histogram", getDriverDistribution).Methods("GET")
+	r.HandleFunc("/drivers/{driver_id}/ratings/{user_id}", getUserRating).Methods("GET")
+	r.HandleFunc("/drivers/{driver_id}/ratings/{user_id}/history", getRatingHistory).Methods("GET")
+	r.HandleFunc("/drivers/{driver_id}/ratings/{user_id}", deleteRating).Methods("DELETE")
+	r.Handle("/drivers/{driver_id}/ratings/{user_id}/moderation", requireAdmin(http.HandlerFunc(moderateRating))).Methods("PATCH")
+	r.HandleFunc("/drivers/{driver_id}/velocity", getDriverVelocity).Methods("GET")
+	r.HandleFunc("/drivers/{driver_id}/monthly", getDriverMonthly).Methods("GET")
+	r.HandleFunc("/drivers/{driver_id}/trend", getDriverTrend).Methods("GET")
+	r.HandleFunc("/drivers/{driver_id}/sources", getDriverSources).Methods("GET")
+	r.HandleFunc("/drivers/{driver_id}/by-region", getDriverRegions).Methods("GET")
+	r.HandleFunc("/drivers/{driver_id}/to-next-star", getDriverToNextStar).Methods("GET")
+	r.HandleFunc("/drivers/{driver_id}/what-if", getDriverWhatIf).Methods("GET")
+	r.HandleFunc("/drivers/{a}/correlation/{b}", getDriverCorrelation).Methods("GET")
+	r.HandleFunc("/drivers/{driver_id}/top-raters", getDriverTopRaters).Methods("GET")
+	r.HandleFunc("/drivers/{driver_id}/summary", getDriverSummary).Methods("GET")
+	r.HandleFunc("/drivers/{driver_id}/reliability", getDriverReliability).Methods("GET")
+	r.HandleFunc("/drivers/{driver_id}", getDriver).Methods("GET")
+	r.HandleFunc("/drivers/{driver_id}", updateDriver).Methods("PUT")
+	r.HandleFunc("/drivers/{driver_id}", deleteDriver).Methods("DELETE")
+	r.HandleFunc("/stats/exclude/{driver_id}", getStatsExcludingDriver).Methods("GET")
+	r.HandleFunc("/stats/driver-buckets", getDriverBuckets).Methods("GET")
+	r.HandleFunc("/stats/distribution.csv", getDistributionCSV).Methods("GET")
+	r.HandleFunc("/users/{a}/similarity/{b}", getUserSimilarity).Methods("GET")
+	r.HandleFunc("/users/{user_id}/rated-bitmap", getUserRatedBitmap).Methods("GET")
+	r.HandleFunc("/users/{user_id}/ratings", getUserRatings).Methods("GET")
+	r.HandleFunc("/users/{user_id}/average", getUserAverage).Methods("GET")
+	r.HandleFunc("/users/{user_id}/social-recommendations", getSocialRecommendations).Methods("POST")
+	r.HandleFunc("/match", matchDriver).Methods("POST")
+	r.Handle("/users/{user_id}/ratings", requireAdmin(http.HandlerFunc(deleteUserRatings))).Methods("DELETE")
+	r.Handle("/drivers/{driver_id}/recompute", requireAdmin(http.HandlerFunc(recomputeDriver))).Methods("POST")
+	r.Handle("/ratings/batch", requireAdmin(http.HandlerFunc(postRatingsBatch))).Methods("POST")
+	entityRoutes(r)
 
-	if err := http.ListenAndServe(":8080", r); err != nil {
-		log.Fatal(err)
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(requireAdmin)
+	admin.HandleFunc("/seed", seed).Methods("POST")
+	admin.HandleFunc("/config", getConfig).Methods("GET")
+	admin.HandleFunc("/audit", getAuditLog).Methods("GET")
+	admin.HandleFunc("/cache", getCacheStats).Methods("GET")
+	admin.HandleFunc("/db-stats", getDBStats).Methods("GET")
+	admin.HandleFunc("/drift", getAggregateDrift).Methods("GET")
+	admin.HandleFunc("/recompute", recompute).Methods("POST")
+	admin.HandleFunc("/rate-volume", getRateVolume).Methods("GET")
+	admin.HandleFunc("/failover", failover).Methods("POST")
+	admin.HandleFunc("/rating-links", createRatingLink).Methods("POST")
+	admin.HandleFunc("/drivers/suspicious", getSuspiciousDrivers).Methods("GET")
+	admin.HandleFunc("/drivers/polarizing", getPolarizingDrivers).Methods("GET")
+	admin.HandleFunc("/drivers/{driver_id}/status", setDriverStatus).Methods("PUT")
+	admin.HandleFunc("/drivers/{driver_id}/recompute", recomputeDriver).Methods("POST")
+	admin.HandleFunc("/ratings/import-stream", importRatingsStream).Methods("POST")
+	admin.HandleFunc("/ratings/archived", getArchivedRatings).Methods("GET")
+
+	if err := checkRouteTimeouts(r); err != nil {
+		return nil, err
 	}
+	return cors(r), nil
 }
@@ -0,0 +1,686 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testConfig loads the configuration from env alone, so that the variables
+// set where the tests run don't change their outcome. The database is a new
+// file of the test.
+func testConfig(tb testing.TB, env map[string]string) Config {
+	tb.Helper()
+	c := envConfig(tb, env)
+	c.DBPath = filepath.Join(tb.TempDir(), "test.sqlite")
+	return c
+}
+
+// envConfig loads the configuration from env alone.
+func envConfig(tb testing.TB, env map[string]string) Config {
+	tb.Helper()
+	getenv = func(name string) string { return env[name] }
+	defer func() { getenv = os.Getenv }()
+	c, err := loadConfig()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return c
+}
+
+// openTestDB points srv at a new database with the demo drivers, sets up the
+// services env turns on and returns the router. The log is silenced, the
+// migrations would get between the benchmark results.
+func openTestDB(tb testing.TB, env map[string]string) http.Handler {
+	tb.Helper()
+	log.SetOutput(io.Discard)
+	tb.Cleanup(func() { log.SetOutput(os.Stderr) })
+	cfg = testConfig(tb, env)
+	db, err := openDB(cfg.DBPath)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	srv.SwapDB(db)
+	tb.Cleanup(func() { db.Close() })
+	createTables()
+	if err = initServices(); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if ratingBuffer != nil {
+			ratingBuffer.stop()
+		}
+		if events != nil {
+			events.stop()
+		}
+		if aggregates != nil {
+			aggregates.stop()
+		}
+	})
+	h, err := newRouter()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return h
+}
+
+// serveTest serves a request with h, header holds name, value pairs.
+func serveTest(h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// expectStatus fails the test unless rec has the status.
+func expectStatus(tb testing.TB, rec *httptest.ResponseRecorder, status int) {
+	tb.Helper()
+	if rec.Code != status {
+		tb.Fatalf("status %d, want %d: %s", rec.Code, status, rec.Body.String())
+	}
+}
+
+// decodeBody unmarshals the JSON body of rec into v.
+func decodeBody(tb testing.TB, rec *httptest.ResponseRecorder, v interface{}) {
+	tb.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		tb.Fatalf("%v: %s", err, rec.Body.String())
+	}
+}
+
+// rateTest posts a rating through h and expects it to be accepted.
+func rateTest(tb testing.TB, h http.Handler, driverId, userId string, rating int) {
+	tb.Helper()
+	body, _ := json.Marshal(Rating{UserID: userId, Rating: rating})
+	expectStatus(tb, serveTest(h, "POST", "/drivers/"+driverId+"/ratings", string(body)), http.StatusOK)
+}
+
+// execTest runs statements on the database of the test.
+func execTest(tb testing.TB, query string, args ...interface{}) {
+	tb.Helper()
+	if _, err := srv.DB().Exec(query, args...); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func driverAggregates(tb testing.TB, driverId string) (sum, count int64) {
+	tb.Helper()
+	err := srv.DB().QueryRow("SELECT rating_sum, rating_count FROM drivers WHERE id = ?", driverId).Scan(&sum, &count)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return sum, count
+}
+
+func TestRateDeletedDriver(t *testing.T) {
+	h := openTestDB(t, nil)
+	expectStatus(t, serveTest(h, "DELETE", "/drivers/3", ""), http.StatusNoContent)
+	rec := serveTest(h, "POST", "/drivers/3/ratings", `{"user_id": "a", "rating": 5}`)
+	expectStatus(t, rec, http.StatusGone)
+	if sum, count := driverAggregates(t, "3"); sum != 0 || count != 0 {
+		t.Fatalf("aggregates of the deleted driver are sum %d count %d, want 0", sum, count)
+	}
+}
+
+func TestDriverWithUserRating(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 4)
+	for _, target := range []string{"/drivers?limit=1", "/drivers/1"} {
+		for user, want := range map[string]*int{"a": intPtr(4), "b": nil, "": nil} {
+			rec := serveTest(h, "GET", target+sep(target)+"user_id="+user, "")
+			expectStatus(t, rec, http.StatusOK)
+			var driver Driver
+			if target == "/drivers/1" {
+				decodeBody(t, rec, &driver)
+			} else {
+				var list []Driver
+				decodeBody(t, rec, &list)
+				driver = list[0]
+			}
+			if (driver.UserRating == nil) != (want == nil) || want != nil && *driver.UserRating != *want {
+				t.Fatalf("%s for user %q: user_rating is %v, want %v", target, user, driver.UserRating, want)
+			}
+		}
+	}
+}
+
+func TestCreateDriverWithKeyTwice(t *testing.T) {
+	h := openTestDB(t, nil)
+	body := `{"key": "fleet-7", "driver_info": {"name": "Ann"}}`
+	first := serveTest(h, "POST", "/drivers", body, "If-None-Match", "*")
+	expectStatus(t, first, http.StatusCreated)
+	again := serveTest(h, "POST", "/drivers", body, "If-None-Match", "*")
+	expectStatus(t, again, http.StatusOK)
+	var created, existing Driver
+	decodeBody(t, first, &created)
+	decodeBody(t, again, &existing)
+	if existing.ID != created.ID {
+		t.Fatalf("the repeated create returned driver %s, want %s", existing.ID, created.ID)
+	}
+	expectStatus(t, serveTest(h, "POST", "/drivers", body), http.StatusConflict)
+	var count int
+	if err := srv.DB().QueryRow("SELECT COUNT(*) FROM drivers WHERE client_key = 'fleet-7'").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("%d drivers with the key, want 1", count)
+	}
+}
+
+func TestDriverRatingsOverTheCap(t *testing.T) {
+	h := openTestDB(t, map[string]string{"MAX_RATINGS_PER_DRIVER": "2"})
+	rateTest(t, h, "1", "a", 5)
+	rateTest(t, h, "1", "b", 4)
+	rec := serveTest(h, "GET", "/drivers/1/ratings", "")
+	expectStatus(t, rec, http.StatusOK)
+	var list []Rating
+	decodeBody(t, rec, &list)
+	if len(list) != 2 {
+		t.Fatalf("%d ratings up to the cap, want 2", len(list))
+	}
+	rateTest(t, h, "1", "c", 3)
+	rec = serveTest(h, "GET", "/drivers/1/ratings", "")
+	expectStatus(t, rec, http.StatusOK)
+	var page RatingsPage
+	decodeBody(t, rec, &page)
+	if !page.Truncated || len(page.Ratings) != 2 || page.Next == "" || page.Total != 3 {
+		t.Fatalf("over the cap got %d ratings of %d, truncated %v, next %q, want 2 of 3 with a next cursor",
+			len(page.Ratings), page.Total, page.Truncated, page.Next)
+	}
+}
+
+func TestSeedModes(t *testing.T) {
+	tests := []struct {
+		mode    string
+		drivers int
+		rated   int64
+	}{
+		{seedSkip, seedDriverCount + 1, 1},
+		// The demo drivers lose their ratings, the one created after them stays.
+		{seedReplace, seedDriverCount + 1, 0},
+		{seedAppend, 2*seedDriverCount + 1, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			h := openTestDB(t, nil)
+			rateTest(t, h, "1", "a", 4)
+			expectStatus(t, serveTest(h, "POST", "/drivers", `{"driver_info": {"name": "Ann"}}`), http.StatusCreated)
+			if err := seedDemoDrivers(test.mode); err != nil {
+				t.Fatal(err)
+			}
+			var drivers int
+			if err := srv.DB().QueryRow("SELECT COUNT(*) FROM drivers").Scan(&drivers); err != nil {
+				t.Fatal(err)
+			}
+			if _, rated := driverAggregates(t, "1"); drivers != test.drivers || rated != test.rated {
+				t.Fatalf("%d drivers, driver 1 with %d ratings, want %d and %d", drivers, rated, test.drivers, test.rated)
+			}
+		})
+	}
+}
+
+func TestDisableSeed(t *testing.T) {
+	openTestDB(t, map[string]string{"DISABLE_SEED": "true", "SEED_MODE": seedAppend})
+	var count int
+	if err := srv.DB().QueryRow("SELECT COUNT(*) FROM drivers").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("%d drivers seeded, want none", count)
+	}
+}
+
+func TestDriverExcludingUser(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 1)
+	rateTest(t, h, "1", "b", 4)
+	rateTest(t, h, "1", "c", 5)
+	for user, want := range map[string]float64{"a": 4.5, "b": 3, "z": 10.0 / 3, "": 10.0 / 3} {
+		rec := serveTest(h, "GET", "/drivers/1?exclude_user="+user, "")
+		expectStatus(t, rec, http.StatusOK)
+		var driver Driver
+		decodeBody(t, rec, &driver)
+		if driver.AverageRating != want {
+			t.Fatalf("average without %q is %v, want %v", user, driver.AverageRating, want)
+		}
+	}
+}
+
+func TestSelfRatingRejected(t *testing.T) {
+	h := openTestDB(t, map[string]string{"SELF_RATING_FIELD": "user_id"})
+	execTest(t, `UPDATE drivers SET driver_info = '{"user_id": "ann"}' WHERE id = 1`)
+	expectStatus(t, serveTest(h, "POST", "/drivers/1/ratings", `{"user_id": "ann", "rating": 5}`), http.StatusForbidden)
+	if _, count := driverAggregates(t, "1"); count != 0 {
+		t.Fatalf("the self-rating was counted")
+	}
+	rateTest(t, h, "1", "bob", 5)
+	rateTest(t, h, "2", "ann", 5)
+}
+
+func TestDriversAverageIsFractional(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 5)
+	rateTest(t, h, "1", "b", 4)
+	rateTest(t, h, "1", "c", 4)
+	rec := serveTest(h, "GET", "/drivers?limit=2", "")
+	expectStatus(t, rec, http.StatusOK)
+	var list []Driver
+	decodeBody(t, rec, &list)
+	if len(list) != 2 || list[0].AverageRating != 13.0/3 || list[1].AverageRating != 0 {
+		t.Fatalf("drivers are %+v, want driver 1 at 13/3 and driver 2 at 0", list)
+	}
+}
+
+func TestEmptyListsAreArrays(t *testing.T) {
+	h := openTestDB(t, map[string]string{"DISABLE_SEED": "true"})
+	rec := serveTest(h, "GET", "/drivers", "")
+	expectStatus(t, rec, http.StatusOK)
+	if body := strings.TrimSpace(rec.Body.String()); body != "[]" {
+		t.Fatalf("the drivers list of an empty database is %s, want []", body)
+	}
+}
+
+func TestConcurrentRatingsFromManyUsers(t *testing.T) {
+	h := openTestDB(t, nil)
+	var wg sync.WaitGroup
+	for u := 0; u < 50; u++ {
+		wg.Add(1)
+		go func(u int) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"user_id": "u%d", "rating": %d}`, u, u%5+1)
+			if rec := serveTest(h, "POST", "/drivers/4/ratings", body); rec.Code != http.StatusOK {
+				t.Errorf("status %d: %s", rec.Code, rec.Body.String())
+			}
+		}(u)
+	}
+	wg.Wait()
+	var rows, stars int64
+	if err := srv.DB().QueryRow("SELECT COUNT(*), SUM(rating) FROM driver_ratings WHERE driver_id = '4'").Scan(&rows, &stars); err != nil {
+		t.Fatal(err)
+	}
+	if sum, count := driverAggregates(t, "4"); rows != 50 || sum != stars || count != rows {
+		t.Fatalf("%d ratings summing to %d, aggregates are sum %d count %d, want 50 matching ones", rows, stars, sum, count)
+	}
+}
+
+func TestRateValidation(t *testing.T) {
+	h := openTestDB(t, nil)
+	tests := []struct {
+		driver, body string
+		status       int
+		message      string
+	}{
+		{"1", `{"user_id": "a", "rating": 5}`, http.StatusOK, ""},
+		{"1", `{"user_id": "a", "rating": 1000}`, http.StatusBadRequest, "rating must be between 1 and 5"},
+		{"1", `{"user_id": "a", "rating": -5}`, http.StatusBadRequest, "rating must be between 1 and 5"},
+		{"1", `{"user_id": "a", "rating": `, http.StatusBadRequest, "invalid JSON body"},
+		{"1", `{"rating": 3}`, http.StatusBadRequest, "user_id is required"},
+		{"404", `{"user_id": "a", "rating": 3}`, http.StatusNotFound, "driver not found"},
+	}
+	for _, test := range tests {
+		rec := serveTest(h, "POST", "/drivers/"+test.driver+"/ratings", test.body)
+		expectStatus(t, rec, test.status)
+		if test.message == "" {
+			continue
+		}
+		var body map[string]string
+		decodeBody(t, rec, &body)
+		if body["error"] != test.message {
+			t.Errorf("%s: error %q, want %q", test.body, body["error"], test.message)
+		}
+	}
+	if sum, count := driverAggregates(t, "1"); sum != 5 || count != 1 {
+		t.Fatalf("aggregates are sum %d count %d, want only the valid rating", sum, count)
+	}
+}
+
+func TestDeleteRating(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 5)
+	rateTest(t, h, "1", "b", 2)
+	expectStatus(t, serveTest(h, "DELETE", "/drivers/1/ratings/b", ""), http.StatusNoContent)
+	var rows int
+	if err := srv.DB().QueryRow("SELECT COUNT(*) FROM driver_ratings WHERE driver_id = '1' AND user_id = 'b'").Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 0 {
+		t.Fatal("the deleted rating is still stored")
+	}
+	expectStatus(t, serveTest(h, "DELETE", "/drivers/1/ratings/b", ""), http.StatusNotFound)
+	if sum, count := driverAggregates(t, "1"); sum != 5 || count != 1 {
+		t.Fatalf("aggregates are sum %d count %d, want 5 and 1", sum, count)
+	}
+	rec := serveTest(h, "GET", "/drivers/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	var driver Driver
+	decodeBody(t, rec, &driver)
+	if driver.AverageRating != 5 {
+		t.Fatalf("average after the delete is %v, want 5", driver.AverageRating)
+	}
+}
+
+// TestDeleteRatingAuthorization checks that with tokens a rating is deleted
+// by its user or an admin only, and that the deletion is reverted from the
+// aggregates and published once.
+func TestDeleteRatingAuthorization(t *testing.T) {
+	h := openTestDB(t, map[string]string{"JWT_SECRET": "jwt-secret", "ADMIN_TOKEN": "secret-token"})
+	as := func(user string) []string {
+		return []string{"Authorization", "Bearer " + signTest(t, "jwt-secret", user)}
+	}
+	rateAs := func(user string, rating int) {
+		t.Helper()
+		body := fmt.Sprintf(`{"rating": %d}`, rating)
+		expectStatus(t, serveTest(h, "POST", "/drivers/1/ratings", body, as(user)...), http.StatusOK)
+	}
+	rateAs("alice", 4)
+	rateAs("bob", 4)
+	rateAs("alice", 2)
+	// The relay isn't started, the outbox keeps the events.
+	events = &eventRelay{publisher: &stubPublisher{}, wake: make(chan struct{}, 1)}
+	defer func() { events = nil }()
+
+	expectStatus(t, serveTest(h, "DELETE", "/drivers/1/ratings/alice", ""), http.StatusUnauthorized)
+	expectStatus(t, serveTest(h, "DELETE", "/drivers/1/ratings/alice", "", as("bob")...), http.StatusForbidden)
+	if sum, count := driverAggregates(t, "1"); sum != 6 || count != 2 {
+		t.Fatalf("aggregates are sum %d count %d after the refused deletes, want 6 and 2", sum, count)
+	}
+	expectStatus(t, serveTest(h, "DELETE", "/drivers/1/ratings/alice", "", as("alice")...), http.StatusNoContent)
+	expectStatus(t, serveTest(h, "DELETE", "/drivers/1/ratings/alice", "", as("alice")...), http.StatusNotFound)
+	expectStatus(t, serveTest(h, "DELETE", "/drivers/1/ratings/bob", "", adminAuth...), http.StatusNoContent)
+	if sum, count := driverAggregates(t, "1"); sum != 0 || count != 0 {
+		t.Fatalf("aggregates are sum %d count %d after the deletes, want 0 and 0", sum, count)
+	}
+	var deleted []string
+	rows, err := srv.DB().Query("SELECT payload FROM event_outbox ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var payload []byte
+		var e RatingEvent
+		if err = rows.Scan(&payload); err != nil {
+			t.Fatal(err)
+		}
+		if err = json.Unmarshal(payload, &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Type != ratingDeleted || e.PreviousRating == nil {
+			t.Fatalf("outbox has %+v, want deletions only", e)
+		}
+		deleted = append(deleted, fmt.Sprintf("%s:%d", e.UserID, *e.PreviousRating))
+	}
+	if got := strings.Join(deleted, " "); got != "alice:2 bob:4" {
+		t.Fatalf("deleted events are %q, want one per deleted rating", got)
+	}
+}
+
+func TestCreateDriverWithUUID(t *testing.T) {
+	h := openTestDB(t, map[string]string{"DRIVER_ID_TYPE": driverIDUUID})
+	rec := serveTest(h, "POST", "/drivers", `{"driver_info": {"name": "Ann"}}`)
+	expectStatus(t, rec, http.StatusCreated)
+	var created Driver
+	decodeBody(t, rec, &created)
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(created.ID) {
+		t.Fatalf("the new driver has id %q, want a UUID", created.ID)
+	}
+	rateTest(t, h, created.ID, "a", 4)
+	rec = serveTest(h, "GET", "/drivers/"+created.ID, "")
+	expectStatus(t, rec, http.StatusOK)
+	var driver Driver
+	decodeBody(t, rec, &driver)
+	if driver.ID != created.ID || driver.AverageRating != 4 {
+		t.Fatalf("got driver %s at %v, want %s at 4", driver.ID, driver.AverageRating, created.ID)
+	}
+	// The integer ids of the drivers from before still work.
+	rateTest(t, h, "1", "a", 5)
+}
+
+func TestRestartKeepsRatings(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 4)
+	// A restart opens the same file, migrates it and seeds it again.
+	db, err := openDB(cfg.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SwapDB(db)
+	t.Cleanup(func() { db.Close() })
+	createTables()
+	var drivers int
+	if err := srv.DB().QueryRow("SELECT COUNT(*) FROM drivers").Scan(&drivers); err != nil {
+		t.Fatal(err)
+	}
+	if sum, count := driverAggregates(t, "1"); drivers != seedDriverCount || sum != 4 || count != 1 {
+		t.Fatalf("%d drivers, driver 1 with sum %d count %d after the restart, want %d and the rating kept",
+			drivers, sum, count, seedDriverCount)
+	}
+}
+
+func TestGetDriver(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "7", "a", 3)
+	rec := serveTest(h, "GET", "/drivers/7", "")
+	expectStatus(t, rec, http.StatusOK)
+	var driver Driver
+	decodeBody(t, rec, &driver)
+	if driver.ID != "7" || driver.AverageRating != 3 || !json.Valid([]byte(driver.DriverInfo)) {
+		t.Fatalf("got %+v, want driver 7 at 3 with its info", driver)
+	}
+	rec = serveTest(h, "GET", "/drivers/404", "")
+	expectStatus(t, rec, http.StatusNotFound)
+	var body map[string]string
+	decodeBody(t, rec, &body)
+	if body["error"] != "driver not found" {
+		t.Fatalf("error %q, want driver not found", body["error"])
+	}
+}
+
+func TestDriversWithLatestRating(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 2)
+	rateTest(t, h, "1", "b", 5)
+	execTest(t, "UPDATE driver_ratings SET updated_at = '2099-01-01 00:00:00' WHERE driver_id = '1' AND user_id = 'a'")
+	rec := serveTest(h, "GET", "/drivers?limit=2&include=latest_rating", "")
+	expectStatus(t, rec, http.StatusOK)
+	var list []Driver
+	decodeBody(t, rec, &list)
+	if len(list) != 2 || list[0].LatestRating == nil || list[0].LatestRating.Rating != 2 || list[1].LatestRating != nil {
+		t.Fatalf("drivers are %+v, want the rating of a as the latest of driver 1 and none for driver 2", list)
+	}
+	if want := time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC); !list[0].LatestRating.RatedAt.Equal(want) {
+		t.Fatalf("latest rating at %s, want %s", list[0].LatestRating.RatedAt, want)
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers?include=everything", ""), http.StatusBadRequest)
+}
+
+func TestDriversSortAndPage(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 2)
+	rateTest(t, h, "2", "a", 4)
+	rateTest(t, h, "3", "a", 5)
+	ids := func(target string) string {
+		t.Helper()
+		rec := serveTest(h, "GET", target, "")
+		expectStatus(t, rec, http.StatusOK)
+		var list []Driver
+		decodeBody(t, rec, &list)
+		ids := []string{}
+		for _, driver := range list {
+			ids = append(ids, driver.ID)
+		}
+		return strings.Join(ids, ",")
+	}
+	tests := []struct{ target, want string }{
+		{"/drivers", "1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16,17,18,19,20"},
+		{"/drivers?sort=rating_desc&limit=3", "3,2,1"},
+		// The unrated drivers come first, ordered by id.
+		{"/drivers?sort=rating&limit=3&offset=27", "1,2,3"},
+		{"/drivers?limit=5&offset=10", "11,12,13,14,15"},
+	}
+	for _, test := range tests {
+		if got := ids(test.target); got != test.want {
+			t.Errorf("%s lists %s, want %s", test.target, got, test.want)
+		}
+	}
+	for _, target := range []string{"/drivers?sort=name", "/drivers?limit=x", "/drivers?offset=x"} {
+		expectStatus(t, serveTest(h, "GET", target, ""), http.StatusBadRequest)
+	}
+}
+
+func TestRankingTieBreak(t *testing.T) {
+	for mode, want := range map[string]string{tieBreakCount: "5,7,3", tieBreakID: "3,5,7"} {
+		t.Run(mode, func(t *testing.T) {
+			h := openTestDB(t, map[string]string{"RANKING_TIE_BREAK": mode})
+			// All three average 4, drivers 5 and 7 with two ratings.
+			rateTest(t, h, "3", "a", 4)
+			rateTest(t, h, "5", "a", 4)
+			rateTest(t, h, "5", "b", 4)
+			rateTest(t, h, "7", "a", 3)
+			rateTest(t, h, "7", "b", 5)
+			rec := serveTest(h, "GET", "/drivers?sort=rating_desc&limit=3", "")
+			expectStatus(t, rec, http.StatusOK)
+			var list []Driver
+			decodeBody(t, rec, &list)
+			ids := []string{}
+			for _, driver := range list {
+				ids = append(ids, driver.ID)
+			}
+			if got := strings.Join(ids, ","); got != want {
+				t.Fatalf("ranked %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestManyUpdatesDontDrift(t *testing.T) {
+	h := openTestDB(t, nil)
+	users := []string{"a", "b", "c"}
+	for i := 0; i < 300; i++ {
+		rateTest(t, h, "1", users[i%len(users)], i%5+1)
+	}
+	// The last ratings of a, b and c are 3, 4 and 5.
+	if sum, count := driverAggregates(t, "1"); sum != 12 || count != 3 {
+		t.Fatalf("aggregates are sum %d count %d after the updates, want exactly 12 and 3", sum, count)
+	}
+	rec := serveTest(h, "GET", "/drivers/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	var driver Driver
+	decodeBody(t, rec, &driver)
+	if driver.AverageRating != 4 {
+		t.Fatalf("average is %v, want 4", driver.AverageRating)
+	}
+}
+
+func TestDriverAverageOverWindow(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 1)
+	rateTest(t, h, "1", "b", 4)
+	rateTest(t, h, "1", "c", 5)
+	old := time.Now().Add(-40 * 24 * time.Hour).UTC().Format(timeFormat)
+	execTest(t, "UPDATE driver_ratings SET created_at = ? WHERE user_id = 'a'", old)
+	for target, want := range map[string]float64{"/drivers/1?window=30d": 4.5, "/drivers/1?window=60d": 10.0 / 3, "/drivers/1": 10.0 / 3} {
+		rec := serveTest(h, "GET", target, "")
+		expectStatus(t, rec, http.StatusOK)
+		var driver Driver
+		decodeBody(t, rec, &driver)
+		if driver.AverageRating != want {
+			t.Fatalf("%s: average is %v, want %v", target, driver.AverageRating, want)
+		}
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers/1?window=soon", ""), http.StatusBadRequest)
+}
+
+func TestDriverRatingsChangedSince(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 3)
+	rateTest(t, h, "1", "b", 3)
+	rateTest(t, h, "1", "c", 3)
+	before := time.Now().Add(-2 * time.Hour).UTC()
+	execTest(t, "UPDATE driver_ratings SET created_at = ?, updated_at = ?", before.Format(timeFormat), before.Format(timeFormat))
+	rateTest(t, h, "1", "b", 5)
+	since := url.QueryEscape(before.Add(time.Hour).Format(time.RFC3339))
+	rec := serveTest(h, "GET", "/drivers/1/ratings?changed_since="+since, "")
+	expectStatus(t, rec, http.StatusOK)
+	var list []Rating
+	decodeBody(t, rec, &list)
+	if len(list) != 1 || list[0].UserID != "b" || list[0].Rating != 5 {
+		t.Fatalf("ratings changed since %s are %+v, want only the update of b", since, list)
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers/1/ratings?changed_since=yesterday", ""), http.StatusBadRequest)
+}
+
+func TestIdenticalResubmissionIsNoop(t *testing.T) {
+	h := openTestDB(t, map[string]string{"RATING_DEDUP_WINDOW_MS": "60000"})
+	rateTest(t, h, "1", "a", 4)
+	recent := time.Now().Add(-10 * time.Second).UTC().Format(timeFormat)
+	execTest(t, "UPDATE driver_ratings SET updated_at = ?", recent)
+	updatedAt := func() string {
+		t.Helper()
+		var at string
+		if err := srv.DB().QueryRow("SELECT updated_at FROM driver_ratings WHERE driver_id = '1' AND user_id = 'a'").Scan(&at); err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+	first := updatedAt()
+	rateTest(t, h, "1", "a", 4)
+	if got := updatedAt(); got != first {
+		t.Fatalf("the resubmission moved updated_at from %s to %s", first, got)
+	}
+	rateTest(t, h, "1", "a", 5)
+	if got := updatedAt(); got == first {
+		t.Fatal("a new value didn't update the rating")
+	}
+	if sum, count := driverAggregates(t, "1"); sum != 5 || count != 1 {
+		t.Fatalf("aggregates are sum %d count %d, want 5 and 1", sum, count)
+	}
+}
+
+func TestUserIDPattern(t *testing.T) {
+	h := openTestDB(t, map[string]string{"USER_ID_PATTERN": "[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}"})
+	rateTest(t, h, "1", "0b6f3c2e-5d1a-4c7e-9f2b-8a1d3e4f5a6b", 4)
+	// The pattern has to match the whole id.
+	for _, userId := range []string{"alice", "0b6f3c2e-5d1a-4c7e-9f2b-8a1d3e4f5a6b-x"} {
+		rec := serveTest(h, "POST", "/drivers/1/ratings", `{"user_id": "`+userId+`", "rating": 4}`)
+		expectStatus(t, rec, http.StatusBadRequest)
+		var body map[string]string
+		decodeBody(t, rec, &body)
+		if !strings.HasPrefix(body["error"], "user_id must match") {
+			t.Errorf("%s: error %q, want the user id rejected", userId, body["error"])
+		}
+	}
+	if sum, count := driverAggregates(t, "1"); sum != 4 || count != 1 {
+		t.Fatalf("driver 1 has a sum of %d over %d ratings, want only the valid rating", sum, count)
+	}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+// sep is what joins another query parameter to target.
+func sep(target string) string {
+	if strings.Contains(target, "?") {
+		return "&"
+	}
+	return "?"
+}
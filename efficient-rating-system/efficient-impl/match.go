@@ -0,0 +1,104 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// MatchPreferences is the body of POST /match. MinRating is the lowest score
+// the rider accepts, any when omitted. Region, when set, only matches drivers
+// rated in that region, scored by those ratings.
+type MatchPreferences struct {
+	MinRating *float64 `json:"min_rating"`
+	Region    string   `json:"region"`
+}
+
+// Match is the driver picked for a rider, Score is the average it was picked
+// by and RatingCount how many ratings it is made of.
+type Match struct {
+	ID          string  `json:"id"`
+	DriverInfo  string  `json:"driver_info"`
+	Score       float64 `json:"score"`
+	RatingCount int     `json:"rating_count"`
+}
+
+func matchDriver(w http.ResponseWriter, r *http.Request) {
+	var prefs MatchPreferences
+	err := json.NewDecoder(r.Body).Decode(&prefs)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if prefs.MinRating != nil && (*prefs.MinRating < minRating || *prefs.MinRating > maxRating) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("min_rating must be between %d and %d", minRating, maxRating))
+		return
+	}
+	if prefs.Region != "" && !contains(cfg.RatingRegions, prefs.Region) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("region must be one of %v", cfg.RatingRegions))
+		return
+	}
+	match, err := findMatch(entityType(r), prefs)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if match == nil {
+		writeError(w, http.StatusNotFound, "no matching driver")
+		return
+	}
+	d, err := json.Marshal(match)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// findMatch returns the best scored entity of entityType eligible for prefs,
+// nil when there is none. Only rated drivers that are active and not deleted
+// are eligible.
+// Without a region the score is the average of cfg.AggFunction, with one the
+// mean of the ratings given in the region. Ties are broken like rankings.
+func findMatch(entityType string, prefs MatchPreferences) (*Match, error) {
+	floor := 0.0
+	if prefs.MinRating != nil {
+		floor = *prefs.MinRating
+	}
+	var query string
+	var args []interface{}
+	if prefs.Region == "" {
+		avg, avgArgs := averageExpr("d", cfg.AggFunction)
+		query = `SELECT d.id, d.driver_info, ` + avg + ` AS score, d.rating_count
+    FROM drivers d
+    WHERE d.rating_count > 0 AND d.deleted_at IS NULL AND d.status = ? AND d.entity_type = ?
+      AND ` + avg + ` >= ?
+    ORDER BY score DESC, ` + tieBreak("d") + `
+    LIMIT 1`
+		args = append(append(append(avgArgs, driverActive, entityType), avgArgs...), floor)
+	} else {
+		query = `SELECT d.id, d.driver_info, AVG(r.rating) AS score, COUNT(*) AS region_count
+    FROM driver_ratings r
+    JOIN drivers d ON d.id = r.driver_id
+    WHERE r.region = ? AND d.deleted_at IS NULL AND d.status = ? AND d.entity_type = ?
+    GROUP BY d.id
+    HAVING score >= ?
+    ORDER BY score DESC, region_count DESC, d.id
+    LIMIT 1`
+		args = []interface{}{prefs.Region, driverActive, entityType, floor}
+	}
+	var match Match
+	err := srv.DB().QueryRow(query, args...).Scan(&match.ID, &match.DriverInfo, &match.Score, &match.RatingCount)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &match, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestMatchDriver(t *testing.T) {
+	h := openTestDB(t, map[string]string{"ADMIN_TOKEN": "secret-token", "RATING_REGIONS": "north,south"})
+	for _, r := range []struct {
+		driver, user, region string
+		stars                int
+	}{
+		{"1", "a", "north", 5},
+		{"2", "a", "north", 3}, {"2", "b", "south", 5}, {"2", "c", "south", 5},
+		{"3", "a", "north", 4}, {"3", "b", "north", 4},
+		{"4", "a", "south", 2},
+	} {
+		body := fmt.Sprintf(`{"user_id": %q, "rating": %d, "region": %q}`, r.user, r.stars, r.region)
+		expectStatus(t, serveTest(h, "POST", "/drivers/"+r.driver+"/ratings", body), http.StatusOK)
+	}
+	// Driver 1 would be the best match everywhere if it weren't suspended.
+	expectStatus(t, serveTest(h, "PUT", "/admin/drivers/1/status", `{"status": "suspended"}`, adminAuth...), http.StatusOK)
+
+	for _, test := range []struct {
+		prefs, want string
+		count       int
+	}{
+		{`{}`, "2", 3},
+		{`{"region": "north"}`, "3", 2},
+		{`{"region": "south", "min_rating": 4}`, "2", 2},
+	} {
+		rec := serveTest(h, "POST", "/match", test.prefs)
+		expectStatus(t, rec, http.StatusOK)
+		var match Match
+		decodeBody(t, rec, &match)
+		if match.ID != test.want || match.RatingCount != test.count {
+			t.Errorf("%s: matched %+v, want driver %s over %d ratings", test.prefs, match, test.want, test.count)
+		}
+	}
+	expectStatus(t, serveTest(h, "POST", "/match", `{"min_rating": 4.5}`), http.StatusNotFound)
+	expectStatus(t, serveTest(h, "POST", "/match", `{"region": "west"}`), http.StatusBadRequest)
+	expectStatus(t, serveTest(h, "POST", "/match", `{"min_rating": 6}`), http.StatusBadRequest)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the request duration
+// histogram.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// maxRequestIDLength bounds the X-Request-ID a client may pass on, longer
+// ones are replaced.
+const maxRequestIDLength = 64
+
+// accessLog writes the JSON access log lines, without the timestamp prefix
+// of the standard logger since every line carries its own.
+var accessLog = log.New(os.Stderr, "", 0)
+
+// metrics is what GET /metrics exports besides the database pool.
+var metrics = newMetricSet()
+
+type routeKey struct {
+	method string
+	route  string
+}
+
+type statusKey struct {
+	routeKey
+	status int
+}
+
+type durationHistogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// metricSet counts the requests per route and status, their durations per
+// route, the ratings written and the error bodies sent per code.
+type metricSet struct {
+	mu             sync.Mutex
+	requests       map[statusKey]uint64
+	durations      map[routeKey]*durationHistogram
+	errors         map[string]uint64
+	ratingsWritten uint64
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{
+		requests:  map[statusKey]uint64{},
+		durations: map[routeKey]*durationHistogram{},
+		errors:    map[string]uint64{},
+	}
+}
+
+func (m *metricSet) observeRequest(key routeKey, status int, took time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[statusKey{key, status}]++
+	h := m.durations[key]
+	if h == nil {
+		h = &durationHistogram{counts: make([]uint64, len(durationBuckets))}
+		m.durations[key] = h
+	}
+	seconds := took.Seconds()
+	if i := sort.SearchFloat64s(durationBuckets, seconds); i < len(durationBuckets) {
+		h.counts[i]++
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// ratingsStored counts ratings once they are committed.
+func (m *metricSet) ratingsStored(n int) {
+	m.mu.Lock()
+	m.ratingsWritten += uint64(n)
+	m.mu.Unlock()
+}
+
+// errorSent counts an error body by its code, see errorCode.
+func (m *metricSet) errorSent(code string) {
+	m.mu.Lock()
+	m.errors[code]++
+	m.mu.Unlock()
+}
+
+// requestIDKey is the context key of the id observe gives a request.
+type requestIDKey struct{}
+
+// requestID returns the id observe gave r, empty when it didn't run.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// observedWriter remembers the status and size of a response, and the
+// internal error behind it for the access log.
+type observedWriter struct {
+	http.ResponseWriter
+	requestID string
+	status    int
+	bytes     int
+	err       error
+}
+
+func (w *observedWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *observedWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Flush keeps the streaming endpoints streaming behind the wrapper.
+func (w *observedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *observedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// AccessLogEntry is one line of the access log.
+type AccessLogEntry struct {
+	Time       string  `json:"time"`
+	RequestID  string  `json:"request_id"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Route      string  `json:"route"`
+	Status     int     `json:"status"`
+	Bytes      int     `json:"bytes"`
+	DurationMs float64 `json:"duration_ms"`
+	Remote     string  `json:"remote"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// observe gives every request an id, the X-Request-ID of the client when it
+// sends a usable one, and returns it in the same header. The id is on the
+// context for the handlers and logged with internal errors. Once the request
+// is served its route, status and duration are counted, and logged as JSON
+// with cfg.AccessLog.
+func observe(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			var err error
+			if id, err = newUUID(); err != nil {
+				writeInternalError(w, err)
+				return
+			}
+		}
+		w.Header().Set("X-Request-ID", id)
+		ow := &observedWriter{ResponseWriter: w, requestID: id}
+		next.ServeHTTP(ow, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+		took := time.Since(start)
+		if ow.status == 0 {
+			ow.status = http.StatusOK
+		}
+		key := routeKey{method: r.Method, route: routeTemplate(r)}
+		metrics.observeRequest(key, ow.status, took)
+		if !cfg.AccessLog {
+			return
+		}
+		entry := AccessLogEntry{
+			Time:       start.UTC().Format(time.RFC3339Nano),
+			RequestID:  id,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Route:      key.route,
+			Status:     ow.status,
+			Bytes:      ow.bytes,
+			DurationMs: float64(took.Microseconds()) / 1000,
+			Remote:     r.RemoteAddr,
+			UserAgent:  r.UserAgent(),
+		}
+		if ow.err != nil {
+			entry.Error = ow.err.Error()
+		}
+		d, err := json.Marshal(entry)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		accessLog.Println(string(d))
+	})
+}
+
+// validRequestID accepts the ids of clients that are short printable ASCII,
+// they end up in logs and headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// routeTemplate is the path template of the matched route, the paths
+// themselves would make a label per driver.
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return "unmatched"
+}
+
+// getMetrics exports the metrics in the Prometheus text format.
+func getMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	metrics.write(&b)
+	writeDBMetrics(&b)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if _, err := fmt.Fprint(w, b.String()); err != nil {
+		log.Println(err)
+	}
+}
+
+func (m *metricSet) write(b *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b.WriteString("# HELP articles_http_requests_total Requests served, by route and status.\n")
+	b.WriteString("# TYPE articles_http_requests_total counter\n")
+	statuses := make([]statusKey, 0, len(m.requests))
+	for key := range m.requests {
+		statuses = append(statuses, key)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].routeKey != statuses[j].routeKey {
+			return routeLess(statuses[i].routeKey, statuses[j].routeKey)
+		}
+		return statuses[i].status < statuses[j].status
+	})
+	for _, key := range statuses {
+		fmt.Fprintf(b, "articles_http_requests_total{method=%q,route=%q,status=\"%d\"} %d\n", key.method, key.route, key.status, m.requests[key])
+	}
+
+	b.WriteString("# HELP articles_http_request_duration_seconds Time taken to serve requests, by route.\n")
+	b.WriteString("# TYPE articles_http_request_duration_seconds histogram\n")
+	routes := make([]routeKey, 0, len(m.durations))
+	for key := range m.durations {
+		routes = append(routes, key)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routeLess(routes[i], routes[j]) })
+	for _, key := range routes {
+		h := m.durations[key]
+		labels := fmt.Sprintf("method=%q,route=%q", key.method, key.route)
+		var cumulative uint64
+		for i, bound := range durationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(b, "articles_http_request_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels, bound, cumulative)
+		}
+		fmt.Fprintf(b, "articles_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(b, "articles_http_request_duration_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(b, "articles_http_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	b.WriteString("# HELP articles_http_requests_in_flight Requests being served.\n")
+	b.WriteString("# TYPE articles_http_requests_in_flight gauge\n")
+	fmt.Fprintf(b, "articles_http_requests_in_flight %d\n", srv.InFlight())
+
+	b.WriteString("# HELP articles_errors_total Error responses sent, by error code.\n")
+	b.WriteString("# TYPE articles_errors_total counter\n")
+	codes := make([]string, 0, len(m.errors))
+	for code := range m.errors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Fprintf(b, "articles_errors_total{code=%q} %d\n", code, m.errors[code])
+	}
+
+	b.WriteString("# HELP articles_ratings_written_total Ratings committed to the database.\n")
+	b.WriteString("# TYPE articles_ratings_written_total counter\n")
+	fmt.Fprintf(b, "articles_ratings_written_total %d\n", m.ratingsWritten)
+}
+
+func routeLess(a, b routeKey) bool {
+	if a.route != b.route {
+		return a.route < b.route
+	}
+	return a.method < b.method
+}
+
+// writeDBMetrics exports the connection pool statistics of the current
+// database handle, see GET /admin/db-stats for the same as JSON.
+func writeDBMetrics(b *strings.Builder) {
+	s := srv.DB().Stats()
+	gauges := []struct {
+		name, help string
+		value      int
+	}{
+		{"articles_db_max_open_connections", "Maximum number of open connections to the database.", s.MaxOpenConnections},
+		{"articles_db_open_connections", "Open connections to the database.", s.OpenConnections},
+		{"articles_db_in_use_connections", "Connections in use.", s.InUse},
+		{"articles_db_idle_connections", "Idle connections.", s.Idle},
+	}
+	for _, g := range gauges {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", g.name, g.help, g.name, g.name, g.value)
+	}
+	fmt.Fprintf(b, "# HELP articles_db_wait_count_total Connections waited for.\n# TYPE articles_db_wait_count_total counter\narticles_db_wait_count_total %d\n", s.WaitCount)
+	fmt.Fprintf(b, "# HELP articles_db_wait_duration_seconds_total Time spent waiting for connections.\n# TYPE articles_db_wait_duration_seconds_total counter\narticles_db_wait_duration_seconds_total %g\n", s.WaitDuration.Seconds())
+}
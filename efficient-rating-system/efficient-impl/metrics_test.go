@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	h := openTestDB(t, nil)
+	rec := serveTest(h, "GET", "/drivers/1", "", "X-Request-ID", "req-42")
+	expectStatus(t, rec, http.StatusOK)
+	if got := rec.Header().Get("X-Request-ID"); got != "req-42" {
+		t.Fatalf("X-Request-ID is %q, want the one sent back", got)
+	}
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	seen := map[string]bool{}
+	// Missing, not printable and too long ids are replaced.
+	for _, sent := range []string{"", "two words", strings.Repeat("x", maxRequestIDLength+1)} {
+		var header []string
+		if sent != "" {
+			header = []string{"X-Request-ID", sent}
+		}
+		rec = serveTest(h, "GET", "/drivers/1", "", header...)
+		got := rec.Header().Get("X-Request-ID")
+		if !uuid.MatchString(got) || seen[got] {
+			t.Fatalf("X-Request-ID for %q is %q, want a new uuid", sent, got)
+		}
+		seen[got] = true
+	}
+}
+
+// metricValue returns the value of the sample of GET /metrics whose name and
+// labels are series, 0 when there is none yet.
+func metricValue(tb testing.TB, h http.Handler, series string) float64 {
+	tb.Helper()
+	rec := serveTest(h, "GET", "/metrics", "")
+	expectStatus(tb, rec, http.StatusOK)
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), series+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func TestMetricsCountRequests(t *testing.T) {
+	h := openTestDB(t, nil)
+	// The metrics live as long as the process, only the changes are checked.
+	found := `articles_http_requests_total{method="GET",route="/drivers/{driver_id}",status="200"}`
+	missing := `articles_http_requests_total{method="GET",route="/drivers/{driver_id}",status="404"}`
+	written := "articles_ratings_written_total"
+	before := map[string]float64{}
+	for _, series := range []string{found, missing, written} {
+		before[series] = metricValue(t, h, series)
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers/1", ""), http.StatusOK)
+	expectStatus(t, serveTest(h, "GET", "/drivers/2", ""), http.StatusOK)
+	expectStatus(t, serveTest(h, "GET", "/drivers/404", ""), http.StatusNotFound)
+	rateTest(t, h, "1", "a", 4)
+	for series, want := range map[string]float64{found: 2, missing: 1, written: 1} {
+		if got := metricValue(t, h, series) - before[series]; got != want {
+			t.Errorf("%s went up by %v, want %v", series, got, want)
+		}
+	}
+	if metricValue(t, h, `articles_http_request_duration_seconds_count{method="GET",route="/drivers/{driver_id}"}`) < 3 {
+		t.Error("the durations of the requests weren't observed")
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// baseSchemaVersion is the oldest schema version migrations start from, the
+// database was wiped on every start before it so no older one is kept.
+const baseSchemaVersion = 7
+
+// migrationFiles holds the migrations, a NNNN_name.up.sql file taking the
+// schema to version NNNN and a NNNN_name.down.sql one undoing it. A change to
+// the schema is a new pair of files, and a bump of schemaVersion.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+const schemaMigrationsSQL = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version integer PRIMARY KEY,
+  name varchar(255),
+  applied_at datetime DEFAULT CURRENT_TIMESTAMP
+)`
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// loadMigrations reads the embedded migrations in version order. They must
+// go from baseSchemaVersion to schemaVersion without gaps, each with both
+// directions.
+func loadMigrations() ([]migration, error) {
+	paths, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*migration{}
+	for _, path := range paths {
+		file := strings.TrimPrefix(path, "migrations/")
+		number, rest, ok := strings.Cut(file, "_")
+		version, err := strconv.Atoi(number)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_name.up.sql", file)
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &migration{version: version}
+			byVersion[version] = m
+		}
+		d, err := migrationFiles.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case strings.HasSuffix(rest, ".up.sql"):
+			m.name, m.up = strings.TrimSuffix(rest, ".up.sql"), string(d)
+		case strings.HasSuffix(rest, ".down.sql"):
+			m.down = string(d)
+		default:
+			return nil, fmt.Errorf("migration %s: must end in .up.sql or .down.sql", file)
+		}
+	}
+	list := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].version < list[j].version })
+	for i, m := range list {
+		if m.version != baseSchemaVersion+i {
+			return nil, fmt.Errorf("migration %d: expected version %d, versions must follow each other from %d", m.version, baseSchemaVersion+i, baseSchemaVersion)
+		}
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d: needs both an up and a down file", m.version)
+		}
+	}
+	if len(list) == 0 || list[len(list)-1].version != schemaVersion {
+		return nil, fmt.Errorf("the last migration must be schemaVersion %d", schemaVersion)
+	}
+	return list, nil
+}
+
+// migrate creates the schema in a new database and brings an existing one
+// up to date, it is safe to run on every start.
+func migrate(db *sql.DB) error {
+	return migrateUp(db, schemaVersion)
+}
+
+// migrateUp applies the migrations after the current version up to target,
+// each in its own transaction.
+func migrateUp(db *sql.DB, target int) error {
+	list, version, err := prepareMigrations(db)
+	if err != nil {
+		return err
+	}
+	if target > schemaVersion {
+		return fmt.Errorf("there is no schema version %d, the latest is %d", target, schemaVersion)
+	}
+	for _, m := range list {
+		if m.version <= version || m.version > target {
+			continue
+		}
+		log.Printf("migrating schema to version %d (%s)", m.version, m.name)
+		err = inTx(db, func(tx *sql.Tx) error {
+			if err := execScript(tx, m.up); err != nil {
+				return err
+			}
+			if _, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.version, m.name); err != nil {
+				return err
+			}
+			return setSchemaVersion(tx, m.version)
+		})
+		if err != nil {
+			return fmt.Errorf("migration to version %d: %w", m.version, err)
+		}
+	}
+	return nil
+}
+
+// migrateDown undoes the last steps migrations applied, newest first.
+func migrateDown(db *sql.DB, steps int) error {
+	list, version, err := prepareMigrations(db)
+	if err != nil {
+		return err
+	}
+	for ; steps > 0 && version >= baseSchemaVersion; steps-- {
+		m := list[version-baseSchemaVersion]
+		log.Printf("migrating schema down from version %d (%s)", m.version, m.name)
+		err = inTx(db, func(tx *sql.Tx) error {
+			if err := execScript(tx, m.down); err != nil {
+				return err
+			}
+			if _, err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.version); err != nil {
+				return err
+			}
+			// Below the base there is no schema left, version 0 is the
+			// empty database.
+			previous := m.version - 1
+			if previous < baseSchemaVersion {
+				previous = 0
+			}
+			return setSchemaVersion(tx, previous)
+		})
+		if err != nil {
+			return fmt.Errorf("migration down from version %d: %w", m.version, err)
+		}
+		version = m.version - 1
+	}
+	return nil
+}
+
+// prepareMigrations loads the migrations, creates schema_migrations and
+// returns the current version. A database migrated before schema_migrations
+// existed only has its version in the user_version pragma, the migrations up
+// to it are recorded as applied.
+func prepareMigrations(db *sql.DB) ([]migration, int, error) {
+	list, err := loadMigrations()
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err = db.Exec(schemaMigrationsSQL); err != nil {
+		return nil, 0, err
+	}
+	var version int
+	err = db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil || version > 0 {
+		return list, version, err
+	}
+	version, err = getSchemaVersion(db)
+	if err != nil || version == 0 {
+		return list, 0, err
+	}
+	if version < baseSchemaVersion || version > schemaVersion {
+		return nil, 0, fmt.Errorf("can't migrate schema version %d, start with ARTICLES_RESET_DB=1 to recreate the database", version)
+	}
+	for _, m := range list[:version-baseSchemaVersion+1] {
+		if _, err = db.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.version, m.name); err != nil {
+			return nil, 0, err
+		}
+	}
+	return list, version, nil
+}
+
+func inTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// execScript runs the statements of a migration file one by one, not every
+// driver takes several in one Exec. Statements end with a ; at the end of a
+// line.
+func execScript(q dbtx, script string) error {
+	for _, stmt := range strings.Split(script, ";\n") {
+		if strings.TrimSpace(stripSQLComments(stmt)) == "" {
+			continue
+		}
+		if _, err := q.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func stripSQLComments(stmt string) string {
+	lines := strings.Split(stmt, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// MigrationStatus is a line of migrate status.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt string
+}
+
+// migrationStatus lists every migration with when it was applied, empty for
+// the pending ones.
+func migrationStatus(db *sql.DB) ([]MigrationStatus, error) {
+	list, _, err := prepareMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int]string{}
+	row, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	for row.Next() {
+		var version int
+		var at string
+		if err = row.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	if err = row.Err(); err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, len(list))
+	for i, m := range list {
+		status[i] = MigrationStatus{Version: m.version, Name: m.name, AppliedAt: applied[m.version]}
+	}
+	return status, nil
+}
+
+// runMigrateCommand is the migrate subcommand, which changes the schema of
+// cfg.DBPath without serving:
+//
+//	main migrate up [version]   apply the pending migrations, up to version
+//	main migrate down [steps]   undo the last migration, or the last steps
+//	main migrate status         list the migrations and when they were applied
+func runMigrateCommand(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: migrate up [version] | down [steps] | status")
+	}
+	n := 0
+	if len(args) == 2 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+			return fmt.Errorf("migrate %s: %q must be a positive number", args[0], args[1])
+		}
+	}
+	db, err := openDB(cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	switch args[0] {
+	case "up":
+		if n == 0 {
+			n = schemaVersion
+		}
+		return migrateUp(db, n)
+	case "down":
+		if n == 0 {
+			n = 1
+		}
+		return migrateDown(db, n)
+	case "status":
+		if len(args) == 2 {
+			return fmt.Errorf("migrate status takes no argument")
+		}
+		status, err := migrationStatus(db)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != "" {
+				applied = "applied " + s.AppliedAt
+			}
+			fmt.Printf("%04d %-20s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %q, use up, down or status", args[0])
+}
@@ -0,0 +1,5 @@
+DROP TABLE used_rating_links;
+DROP TABLE rating_events;
+DROP TABLE driver_snapshots;
+DROP TABLE driver_ratings;
+DROP TABLE drivers;
@@ -0,0 +1,58 @@
+-- The schema the database had when migrations started, older databases
+-- were wiped on every start.
+
+-- drivers.id is declared int rather than integer so that it isn't the rowid
+-- and can hold the uuid ids of DRIVER_ID_TYPE=uuid next to integer ones.
+CREATE TABLE IF NOT EXISTS drivers (
+  id int PRIMARY KEY,
+  driver_info varchar(255),
+  rating_sum bigint,
+  rating_count bigint,
+  deleted_at datetime,
+  client_key varchar(255) UNIQUE,
+  external_id varchar(255)
+);
+
+CREATE INDEX IF NOT EXISTS drivers_external_id
+  ON drivers (external_id);
+
+CREATE TABLE IF NOT EXISTS driver_ratings (
+  driver_id integer,
+  user_id varchar(255),
+  rating integer,
+  source varchar(32),
+  created_at datetime DEFAULT CURRENT_TIMESTAMP,
+  updated_at datetime DEFAULT CURRENT_TIMESTAMP,
+  prev_rating integer,
+  comment text,
+  region varchar(32),
+  UNIQUE (driver_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS driver_ratings_driver_id_created_at
+  ON driver_ratings (driver_id, created_at);
+
+CREATE TABLE IF NOT EXISTS driver_snapshots (
+  driver_id integer,
+  rating_sum bigint,
+  rating_count bigint,
+  created_at datetime
+);
+
+CREATE INDEX IF NOT EXISTS driver_snapshots_driver_id_created_at
+  ON driver_snapshots (driver_id, created_at);
+
+CREATE TABLE IF NOT EXISTS rating_events (
+  driver_id integer,
+  user_id varchar(255),
+  rating integer,
+  created_at datetime DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS rating_events_driver_id_user_id
+  ON rating_events (driver_id, user_id);
+
+CREATE TABLE IF NOT EXISTS used_rating_links (
+  nonce varchar(64) PRIMARY KEY,
+  used_at datetime DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+  id integer PRIMARY KEY,
+  actor varchar(255),
+  action varchar(16),
+  entity varchar(32),
+  entity_id varchar(255),
+  detail text,
+  created_at datetime DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS archived_ratings (
+  driver_id integer,
+  user_id varchar(255),
+  rating integer,
+  source varchar(32),
+  comment text,
+  region varchar(32),
+  created_at datetime,
+  updated_at datetime,
+  archived_at datetime DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS archived_ratings_driver_id_user_id
+  ON archived_ratings (driver_id, user_id);
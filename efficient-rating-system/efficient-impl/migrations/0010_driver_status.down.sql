@@ -0,0 +1 @@
+ALTER TABLE drivers DROP COLUMN status;
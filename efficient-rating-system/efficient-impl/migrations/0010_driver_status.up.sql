@@ -0,0 +1 @@
+ALTER TABLE drivers ADD COLUMN status varchar(16) NOT NULL DEFAULT 'active';
@@ -0,0 +1,6 @@
+-- Rating events waiting to be published, see eventRelay.
+CREATE TABLE IF NOT EXISTS event_outbox (
+  id integer PRIMARY KEY,
+  payload text,
+  created_at datetime DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+DROP TABLE top_drivers;
@@ -0,0 +1,8 @@
+-- The materialized ranking of the rated drivers, position 1 being the best.
+-- refreshTopDrivers rebuilds it every TOP_DRIVERS_REFRESH_MS.
+CREATE TABLE IF NOT EXISTS top_drivers (
+  position integer PRIMARY KEY,
+  driver_id varchar(255) NOT NULL,
+  avg_rating real NOT NULL,
+  rating_count integer NOT NULL
+);
@@ -0,0 +1,4 @@
+ALTER TABLE archived_ratings DROP COLUMN moderation;
+ALTER TABLE archived_ratings DROP COLUMN tags;
+ALTER TABLE driver_ratings DROP COLUMN moderation;
+ALTER TABLE driver_ratings DROP COLUMN tags;
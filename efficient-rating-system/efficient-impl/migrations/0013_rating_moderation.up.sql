@@ -0,0 +1,5 @@
+-- tags is a JSON array of RATING_TAGS, moderation visible or hidden.
+ALTER TABLE driver_ratings ADD COLUMN tags text;
+ALTER TABLE driver_ratings ADD COLUMN moderation varchar(16) NOT NULL DEFAULT 'visible';
+ALTER TABLE archived_ratings ADD COLUMN tags text;
+ALTER TABLE archived_ratings ADD COLUMN moderation varchar(16) NOT NULL DEFAULT 'visible';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS drivers_entity_type;
+ALTER TABLE drivers DROP COLUMN entity_type;
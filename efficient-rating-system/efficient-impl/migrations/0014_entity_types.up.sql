@@ -0,0 +1,3 @@
+-- Drivers are one of the rateable entities, ENTITY_TYPES lists the others.
+ALTER TABLE drivers ADD COLUMN entity_type varchar(32) NOT NULL DEFAULT 'driver';
+CREATE INDEX IF NOT EXISTS drivers_entity_type ON drivers (entity_type, id);
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// schemaShape lists the tables of db with their columns, and the indexes,
+// one per line in name order.
+func schemaShape(tb testing.TB, db *sql.DB) string {
+	tb.Helper()
+	rows, err := db.Query(`SELECT type, name FROM sqlite_master
+    WHERE type IN ('table', 'index') AND name NOT LIKE 'sqlite_%' AND name != 'schema_migrations'`)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var tables, lines []string
+	for rows.Next() {
+		var kind, name string
+		if err = rows.Scan(&kind, &name); err != nil {
+			tb.Fatal(err)
+		}
+		if kind == "index" {
+			lines = append(lines, "index "+name)
+		} else {
+			tables = append(tables, name)
+		}
+	}
+	rows.Close()
+	for _, table := range tables {
+		var columns []string
+		rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		for rows.Next() {
+			var column string
+			if err = rows.Scan(&column); err != nil {
+				tb.Fatal(err)
+			}
+			columns = append(columns, column)
+		}
+		rows.Close()
+		lines = append(lines, "table "+table+"("+strings.Join(columns, ", ")+")")
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// checkSchemaVersion fails unless both user_version and schema_migrations
+// say version.
+func checkSchemaVersion(tb testing.TB, db *sql.DB, version int) {
+	tb.Helper()
+	got, err := getSchemaVersion(db)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var recorded int
+	if err = db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&recorded); err != nil {
+		tb.Fatal(err)
+	}
+	if got != version || recorded != version {
+		tb.Fatalf("schema version is %d, %d in schema_migrations, want %d", got, recorded, version)
+	}
+}
+
+// TestMigrateDownAndUp runs the migrate command down to baseSchemaVersion one
+// step at a time and back up, each migration must undo exactly what it does.
+func TestMigrateDownAndUp(t *testing.T) {
+	cfg = envConfig(t, map[string]string{"DB_PATH": filepath.Join(t.TempDir(), "ratings.sqlite")})
+	db, err := openDB(cfg.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err = runMigrateCommand([]string{"up"}); err != nil {
+		t.Fatal(err)
+	}
+	checkSchemaVersion(t, db, schemaVersion)
+	// What each migration adds, gone once it is undone.
+	added := map[int]string{
+		8:  "table audit_log(",
+		9:  "table archived_ratings(",
+		10: ", status",
+		11: "table event_outbox(",
+		12: "table top_drivers(",
+		13: ", moderation",
+		14: "index drivers_entity_type",
+	}
+	shapes := map[int]string{schemaVersion: schemaShape(t, db)}
+	for version := schemaVersion; version > baseSchemaVersion; version-- {
+		if !strings.Contains(shapes[version], added[version]) {
+			t.Fatalf("schema at version %d lacks %q:\n%s", version, added[version], shapes[version])
+		}
+		if err = runMigrateCommand([]string{"down"}); err != nil {
+			t.Fatal(err)
+		}
+		checkSchemaVersion(t, db, version-1)
+		shapes[version-1] = schemaShape(t, db)
+		if strings.Contains(shapes[version-1], added[version]) {
+			t.Fatalf("schema at version %d still has %q:\n%s", version-1, added[version], shapes[version-1])
+		}
+	}
+	if !strings.Contains(shapes[baseSchemaVersion], "table drivers(") || !strings.Contains(shapes[baseSchemaVersion], "table driver_ratings(") {
+		t.Fatalf("base schema lacks the drivers and their ratings:\n%s", shapes[baseSchemaVersion])
+	}
+	for version := baseSchemaVersion + 1; version <= schemaVersion; version++ {
+		if err = runMigrateCommand([]string{"up", fmt.Sprint(version)}); err != nil {
+			t.Fatal(err)
+		}
+		checkSchemaVersion(t, db, version)
+		if got := schemaShape(t, db); got != shapes[version] {
+			t.Fatalf("schema migrated up to version %d is\n%s\nwant\n%s", version, got, shapes[version])
+		}
+	}
+}
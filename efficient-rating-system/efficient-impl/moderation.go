@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	moderationVisible = "visible"
+	moderationHidden  = "hidden"
+)
+
+// ratingTags are the reasons a rating is tagged with, stored as a JSON array
+// in driver_ratings.tags.
+type ratingTags []string
+
+func (t ratingTags) Value() (driver.Value, error) {
+	if len(t) == 0 {
+		return nil, nil
+	}
+	d, err := json.Marshal([]string(t))
+	return string(d), err
+}
+
+func (t *ratingTags) Scan(src interface{}) error {
+	var d []byte
+	switch v := src.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case string:
+		d = []byte(v)
+	case []byte:
+		d = v
+	default:
+		return fmt.Errorf("tags: unexpected %T", src)
+	}
+	return json.Unmarshal(d, (*[]string)(t))
+}
+
+// validateTags checks the tags of a rating against cfg.RatingTags, each may
+// only be given once.
+func validateTags(tags ratingTags) error {
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		if !contains(cfg.RatingTags, tag) {
+			return fmt.Errorf("tags must be among %v", cfg.RatingTags)
+		}
+		if seen[tag] {
+			return fmt.Errorf("tag %s is given twice", tag)
+		}
+		seen[tag] = true
+	}
+	return nil
+}
+
+// hideModerated blanks the comments admins hid, for everyone but admins. The
+// rating itself still shows and counts.
+func hideModerated(r *http.Request, rating *Rating) {
+	if rating.Moderation == moderationHidden && !adminAuthorized(r) {
+		rating.Comment = ""
+	}
+}
+
+type Moderation struct {
+	Status string `json:"status"`
+}
+
+// moderateRating sets the moderation status of a rating, hidden to take an
+// abusive comment out of the public listings and visible to put it back.
+func moderateRating(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	var input Moderation
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil || input.Status != moderationVisible && input.Status != moderationHidden {
+		writeError(w, http.StatusBadRequest, `body must be {"status": "visible"} or {"status": "hidden"}`)
+		return
+	}
+	rating, err := updateModeration(params["driver_id"], storedUserID(params["user_id"]), input.Status, requestActor(r))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if rating == nil {
+		writeError(w, http.StatusNotFound, "rating not found")
+		return
+	}
+	rating.UserID = shownUserID(r, rating.UserID)
+	d, err := json.Marshal(rating)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// updateModeration sets the moderation status of the rating of the user and
+// returns the rating, nil when there is none. updated_at is left alone, the
+// rating itself doesn't change.
+func updateModeration(driverId, userId, status, actor string) (*Rating, error) {
+	tx, err := srv.DB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rating, err := getRating(tx, driverId, userId)
+	if err != nil || rating == nil {
+		return nil, err
+	}
+	_, err = tx.Exec("UPDATE driver_ratings SET moderation = ? WHERE driver_id = ? AND user_id = ?", status, driverId, userId)
+	if err != nil {
+		return nil, err
+	}
+	err = recordAudit(tx, actor, "update", "rating", driverId+"/"+userId, map[string]interface{}{"moderation": status, "previous_moderation": rating.Moderation})
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	rating.Moderation = status
+	return rating, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestModerateRating(t *testing.T) {
+	h := openTestDB(t, adminEnv)
+	expectStatus(t, serveTest(h, "POST", "/drivers/1/ratings", `{"user_id": "a", "rating": 1, "comment": "rude"}`), http.StatusOK)
+	expectStatus(t, serveTest(h, "POST", "/drivers/1/ratings", `{"user_id": "b", "rating": 5, "comment": "kind"}`), http.StatusOK)
+	const path = "/drivers/1/ratings/a/moderation"
+	expectStatus(t, serveTest(h, "PATCH", path, `{"status": "hidden"}`), http.StatusUnauthorized)
+	for _, body := range []string{`{"status": "deleted"}`, `{"status": ""}`, `{}`, `hidden`} {
+		expectStatus(t, serveTest(h, "PATCH", path, body, adminAuth...), http.StatusBadRequest)
+	}
+	expectStatus(t, serveTest(h, "PATCH", "/drivers/1/ratings/c/moderation", `{"status": "hidden"}`, adminAuth...), http.StatusNotFound)
+	ratings := func(target string, auth ...string) map[string]Rating {
+		t.Helper()
+		rec := serveTest(h, "GET", target, "", auth...)
+		expectStatus(t, rec, http.StatusOK)
+		var list []Rating
+		decodeBody(t, rec, &list)
+		byUser := map[string]Rating{}
+		for _, r := range list {
+			byUser[r.UserID] = r
+		}
+		return byUser
+	}
+	if got := ratings("/drivers/1/ratings"); got["a"].Moderation != moderationVisible || got["a"].Comment != "rude" {
+		t.Fatalf("rating of a is %+v before the moderation, want its comment visible", got["a"])
+	}
+
+	rec := serveTest(h, "PATCH", path, `{"status": "hidden"}`, adminAuth...)
+	expectStatus(t, rec, http.StatusOK)
+	var rating Rating
+	decodeBody(t, rec, &rating)
+	if rating.Moderation != moderationHidden || rating.Rating != 1 {
+		t.Fatalf("moderation returned %+v, want the hidden rating of 1", rating)
+	}
+	// Hiding twice changes nothing.
+	expectStatus(t, serveTest(h, "PATCH", path, `{"status": "hidden"}`, adminAuth...), http.StatusOK)
+	got := ratings("/drivers/1/ratings")
+	if a := got["a"]; a.Moderation != moderationHidden || a.Comment != "" || a.Rating != 1 {
+		t.Fatalf("rating of a is %+v, want its rating without the comment", a)
+	}
+	if got = ratings("/drivers/1/ratings", adminAuth...); got["a"].Comment != "rude" {
+		t.Fatalf("admins see the rating of a as %+v, want its comment", got["a"])
+	}
+	if got = ratings("/drivers/1/ratings?has_comment=true"); len(got) != 1 || got["b"].Comment != "kind" {
+		t.Fatalf("ratings with a comment are %+v, want only the one of b", got)
+	}
+	// The rating still counts.
+	rec = serveTest(h, "GET", "/drivers/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	var driver Driver
+	decodeBody(t, rec, &driver)
+	if driver.AverageRating != 3 {
+		t.Fatalf("average with the hidden comment is %v, want 3", driver.AverageRating)
+	}
+
+	expectStatus(t, serveTest(h, "PATCH", path, `{"status": "visible"}`, adminAuth...), http.StatusOK)
+	if got = ratings("/drivers/1/ratings"); got["a"].Moderation != moderationVisible || got["a"].Comment != "rude" {
+		t.Fatalf("rating of a is %+v once visible again, want its comment", got["a"])
+	}
+	// A new comment is moderated anew, the same one stays hidden.
+	expectStatus(t, serveTest(h, "PATCH", path, `{"status": "hidden"}`, adminAuth...), http.StatusOK)
+	expectStatus(t, serveTest(h, "POST", "/drivers/1/ratings", `{"user_id": "a", "rating": 2, "comment": "rude"}`), http.StatusOK)
+	if got = ratings("/drivers/1/ratings"); got["a"].Moderation != moderationHidden {
+		t.Fatalf("rating of a is %+v after a new rating with the same comment, want it hidden", got["a"])
+	}
+	expectStatus(t, serveTest(h, "POST", "/drivers/1/ratings", `{"user_id": "a", "rating": 2, "comment": "late"}`), http.StatusOK)
+	if got = ratings("/drivers/1/ratings"); got["a"].Moderation != moderationVisible || got["a"].Comment != "late" {
+		t.Fatalf("rating of a is %+v after a new comment, want it visible", got["a"])
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type MonthlyCount struct {
+	Month string `json:"month"`
+	Count int    `json:"count"`
+}
+
+// getDriverMonthly returns how many ratings the driver received per calendar
+// month, oldest first. Months without ratings are left out. Unknown drivers
+// get 404.
+func getDriverMonthly(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	driverId := params["driver_id"]
+	found, _, err := getDriverState(driverId)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "driver not found")
+		return
+	}
+	list, err := getDriverMonthlyCounts(driverId)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(list)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getDriverMonthlyCounts groups the ratings of the driver by the UTC month
+// they were created in, updating a rating doesn't move it to another month.
+func getDriverMonthlyCounts(driverId string) ([]MonthlyCount, error) {
+	row, err := srv.DB().Query(`SELECT strftime('%Y-%m', created_at) AS month, COUNT(*)
+    FROM driver_ratings
+    WHERE driver_id = ?
+    GROUP BY month
+    ORDER BY month`, driverId)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []MonthlyCount{}
+	for row.Next() {
+		var month MonthlyCount
+		if err = row.Scan(&month.Month, &month.Count); err != nil {
+			return nil, err
+		}
+		list = append(list, month)
+	}
+	return list, row.Err()
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestDriverMonthly(t *testing.T) {
+	h := openTestDB(t, nil)
+	for user, created := range map[string]string{"a": "2026-01-05 10:00:00", "b": "2026-01-31 23:59:59", "c": "2026-03-01 00:00:00"} {
+		rateTest(t, h, "1", user, 4)
+		execTest(t, "UPDATE driver_ratings SET created_at = ? WHERE user_id = ?", created, user)
+	}
+	rec := serveTest(h, "GET", "/drivers/1/monthly", "")
+	expectStatus(t, rec, http.StatusOK)
+	var months []MonthlyCount
+	decodeBody(t, rec, &months)
+	if got := fmt.Sprint(months); got != "[{2026-01 2} {2026-03 1}]" {
+		t.Fatalf("monthly counts are %s, want 2 in January and 1 in March", got)
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers/404/monthly", ""), http.StatusNotFound)
+}
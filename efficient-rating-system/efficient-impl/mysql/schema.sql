@@ -0,0 +1,37 @@
+-- The schema of the MySQL storage, applied on every start. It holds the
+-- tables of the core routes only, see Storage. Timestamps are UTC, the
+-- connections of openMySQL set their time zone to it.
+
+-- driver_ids hands out the integer ids, MySQL has no sequences.
+CREATE TABLE IF NOT EXISTS driver_ids (
+  id bigint NOT NULL AUTO_INCREMENT PRIMARY KEY
+);
+
+-- id is text so that integer and uuid ids (DRIVER_ID_TYPE) can be mixed.
+CREATE TABLE IF NOT EXISTS drivers (
+  id varchar(64) NOT NULL PRIMARY KEY,
+  driver_info text,
+  rating_sum bigint NOT NULL DEFAULT 0,
+  rating_count bigint NOT NULL DEFAULT 0,
+  deleted_at datetime(6),
+  client_key varchar(255) UNIQUE,
+  status varchar(16) NOT NULL DEFAULT 'active',
+  entity_type varchar(32) NOT NULL DEFAULT 'driver'
+);
+
+CREATE TABLE IF NOT EXISTS driver_ratings (
+  driver_id varchar(64) NOT NULL,
+  user_id varchar(255) NOT NULL,
+  rating integer NOT NULL,
+  prev_rating integer,
+  source varchar(32),
+  comment text,
+  tags text,
+  region varchar(32),
+  moderation varchar(16) NOT NULL DEFAULT 'visible',
+  created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  updated_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  PRIMARY KEY (driver_id, user_id),
+  INDEX driver_ratings_driver_id_created_at (driver_id, created_at),
+  FOREIGN KEY (driver_id) REFERENCES drivers (id)
+);
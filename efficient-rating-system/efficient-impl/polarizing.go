@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPolarizingMinCount = 10
+	defaultPolarizingMinScore = 0.5
+)
+
+// PolarizingDriver is a driver rated mostly 1 or 5 stars and seldom in
+// between. Polarization is twice the share of the rarer of the two ends: 1
+// when half of the ratings are 1s and the other half 5s, 0 when either end has
+// none.
+type PolarizingDriver struct {
+	ID           string  `json:"id"`
+	DriverInfo   string  `json:"driver_info"`
+	RatingCount  int     `json:"rating_count"`
+	Ones         int     `json:"ones"`
+	Fives        int     `json:"fives"`
+	Polarization float64 `json:"polarization"`
+}
+
+func getPolarizingDrivers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	minCount := defaultPolarizingMinCount
+	if v := query.Get("min_count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 2 {
+			writeError(w, http.StatusBadRequest, (&paramError{"min_count", "must be a number of at least 2"}).Error())
+			return
+		}
+		minCount = n
+	}
+	minScore := defaultPolarizingMinScore
+	if v := query.Get("min_score"); v != "" {
+		s, err := strconv.ParseFloat(v, 64)
+		if err != nil || s < 0 || s > 1 {
+			writeError(w, http.StatusBadRequest, (&paramError{"min_score", "must be a number between 0 and 1"}).Error())
+			return
+		}
+		minScore = s
+	}
+	list, err := getPolarizingDriversList(entityType(r), minCount, minScore)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(list)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getPolarizingDriversList returns the entities of entityType with at least
+// minCount ratings whose polarization is at least minScore, most polarizing
+// first. It reads the ratings themselves, not the aggregates.
+func getPolarizingDriversList(entityType string, minCount int, minScore float64) ([]PolarizingDriver, error) {
+	row, err := srv.DB().Query(`SELECT d.id, d.driver_info, COUNT(*), SUM(r.rating = 1) AS ones, SUM(r.rating = 5) AS fives,
+      2.0 * MIN(SUM(r.rating = 1), SUM(r.rating = 5)) / COUNT(*) AS polarization
+    FROM drivers d
+    JOIN driver_ratings r ON r.driver_id = d.id
+    WHERE d.deleted_at IS NULL AND d.entity_type = ?
+    GROUP BY d.id
+    HAVING COUNT(*) >= ? AND polarization >= ?
+    ORDER BY polarization DESC, COUNT(*) DESC, d.id`, entityType, minCount, minScore)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []PolarizingDriver{}
+	for row.Next() {
+		var driver PolarizingDriver
+		err = row.Scan(&driver.ID, &driver.DriverInfo, &driver.RatingCount, &driver.Ones, &driver.Fives, &driver.Polarization)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, driver)
+	}
+	return list, row.Err()
+}
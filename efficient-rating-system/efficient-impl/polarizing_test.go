@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestPolarizingDrivers(t *testing.T) {
+	h := openTestDB(t, adminEnv)
+	// Driver 1 is rated 1 or 5 by everyone, driver 2 mostly 3 and driver 3
+	// has too few ratings to tell.
+	for i := 0; i < 10; i++ {
+		user := fmt.Sprintf("u%d", i)
+		rateTest(t, h, "1", user, 1+4*(i%2))
+		stars := 3
+		if i == 0 {
+			stars = 5
+		}
+		rateTest(t, h, "2", user, stars)
+	}
+	rateTest(t, h, "3", "a", 1)
+	rateTest(t, h, "3", "b", 5)
+
+	rec := serveTest(h, "GET", "/admin/drivers/polarizing", "", adminAuth...)
+	expectStatus(t, rec, http.StatusOK)
+	var list []PolarizingDriver
+	decodeBody(t, rec, &list)
+	if len(list) != 1 || list[0].ID != "1" || list[0].Ones != 5 || list[0].Fives != 5 || list[0].Polarization != 1 {
+		t.Fatalf("polarizing drivers are %+v, want only driver 1 with a polarization of 1", list)
+	}
+	expectStatus(t, serveTest(h, "GET", "/admin/drivers/polarizing?min_count=1", "", adminAuth...), http.StatusBadRequest)
+}
@@ -0,0 +1,36 @@
+-- The schema of the Postgres storage, applied on every start. It holds the
+-- tables of the core routes only, see Storage. Timestamps are UTC without a
+-- time zone, like the CURRENT_TIMESTAMP of SQLite.
+
+CREATE SEQUENCE IF NOT EXISTS driver_ids;
+
+-- id is text so that integer and uuid ids (DRIVER_ID_TYPE) can be mixed,
+-- integer ones come from driver_ids.
+CREATE TABLE IF NOT EXISTS drivers (
+  id varchar(64) PRIMARY KEY,
+  driver_info text,
+  rating_sum bigint NOT NULL DEFAULT 0,
+  rating_count bigint NOT NULL DEFAULT 0,
+  deleted_at timestamp,
+  client_key varchar(255) UNIQUE,
+  status varchar(16) NOT NULL DEFAULT 'active',
+  entity_type varchar(32) NOT NULL DEFAULT 'driver'
+);
+
+CREATE TABLE IF NOT EXISTS driver_ratings (
+  driver_id varchar(64) NOT NULL REFERENCES drivers (id),
+  user_id varchar(255) NOT NULL,
+  rating integer NOT NULL,
+  prev_rating integer,
+  source varchar(32),
+  comment text,
+  tags text,
+  region varchar(32),
+  moderation varchar(16) NOT NULL DEFAULT 'visible',
+  created_at timestamp NOT NULL DEFAULT (now() AT TIME ZONE 'utc'),
+  updated_at timestamp NOT NULL DEFAULT (now() AT TIME ZONE 'utc'),
+  PRIMARY KEY (driver_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS driver_ratings_driver_id_created_at
+  ON driver_ratings (driver_id, created_at);
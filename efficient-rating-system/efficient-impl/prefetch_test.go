@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestPrefetchIsTheNextPage(t *testing.T) {
+	h := openTestDB(t, nil)
+	for _, driver := range []string{"4", "7", "2", "9", "5"} {
+		rateTest(t, h, driver, "a", 5)
+	}
+	ids := func(list []Driver) []string {
+		ids := []string{}
+		for _, driver := range list {
+			ids = append(ids, driver.ID)
+		}
+		return ids
+	}
+
+	rec := serveTest(h, "GET", "/drivers?sort=rating_desc&limit=3&offset=3&prefetch=true", "")
+	expectStatus(t, rec, http.StatusOK)
+	var page DriversPage
+	decodeBody(t, rec, &page)
+	rec = serveTest(h, "GET", "/drivers?sort=rating_desc&limit=3&offset=6", "")
+	expectStatus(t, rec, http.StatusOK)
+	var next []Driver
+	decodeBody(t, rec, &next)
+	if len(page.Drivers) != 3 || !reflect.DeepEqual(page.Prefetch, ids(next)) {
+		t.Fatalf("page is %v with prefetch %v, want 3 drivers and the next page %v", ids(page.Drivers), page.Prefetch, ids(next))
+	}
+
+	// The last page has nothing to prefetch.
+	rec = serveTest(h, "GET", "/drivers?limit=5&offset=25&prefetch=true", "")
+	expectStatus(t, rec, http.StatusOK)
+	page = DriversPage{}
+	decodeBody(t, rec, &page)
+	if len(page.Drivers) != 5 || page.Prefetch == nil || len(page.Prefetch) != 0 {
+		t.Fatalf("last page is %v with prefetch %v, want 5 drivers and none to prefetch", ids(page.Drivers), page.Prefetch)
+	}
+
+	// Without prefetch the response stays a plain list.
+	rec = serveTest(h, "GET", "/drivers?limit=3", "")
+	var plain []Driver
+	decodeBody(t, rec, &plain)
+	if len(plain) != 3 {
+		t.Fatalf("drivers without prefetch are %v, want a list of 3", ids(plain))
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers?prefetch=maybe", ""), http.StatusBadRequest)
+}
@@ -0,0 +1,236 @@
+// Rating service, the gRPC counterpart of the REST endpoints of the same name.
+// The messages mirror the JSON bodies: driver_info is the same JSON string and
+// the timestamps are RFC 3339 strings. The request fields are validated like
+// the query parameters of the same names.
+//
+// The Go code in this directory is generated from this file, see "gRPC" in
+// the README.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: rating.proto
+
+package ratingpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	RatingService_RateDriver_FullMethodName        = "/articles.rating.v1.RatingService/RateDriver"
+	RatingService_ListDrivers_FullMethodName       = "/articles.rating.v1.RatingService/ListDrivers"
+	RatingService_ListDriverRatings_FullMethodName = "/articles.rating.v1.RatingService/ListDriverRatings"
+	RatingService_GetDriver_FullMethodName         = "/articles.rating.v1.RatingService/GetDriver"
+)
+
+// RatingServiceClient is the client API for RatingService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RatingServiceClient interface {
+	// POST /drivers/{driver_id}/ratings
+	RateDriver(ctx context.Context, in *RateDriverRequest, opts ...grpc.CallOption) (*RateDriverResponse, error)
+	// GET /drivers
+	ListDrivers(ctx context.Context, in *ListDriversRequest, opts ...grpc.CallOption) (*ListDriversResponse, error)
+	// GET /drivers/{driver_id}/ratings?limit=&before=
+	ListDriverRatings(ctx context.Context, in *ListDriverRatingsRequest, opts ...grpc.CallOption) (*ListDriverRatingsResponse, error)
+	// GET /drivers/{driver_id}
+	GetDriver(ctx context.Context, in *GetDriverRequest, opts ...grpc.CallOption) (*Driver, error)
+}
+
+type ratingServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRatingServiceClient(cc grpc.ClientConnInterface) RatingServiceClient {
+	return &ratingServiceClient{cc}
+}
+
+func (c *ratingServiceClient) RateDriver(ctx context.Context, in *RateDriverRequest, opts ...grpc.CallOption) (*RateDriverResponse, error) {
+	out := new(RateDriverResponse)
+	err := c.cc.Invoke(ctx, RatingService_RateDriver_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ratingServiceClient) ListDrivers(ctx context.Context, in *ListDriversRequest, opts ...grpc.CallOption) (*ListDriversResponse, error) {
+	out := new(ListDriversResponse)
+	err := c.cc.Invoke(ctx, RatingService_ListDrivers_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ratingServiceClient) ListDriverRatings(ctx context.Context, in *ListDriverRatingsRequest, opts ...grpc.CallOption) (*ListDriverRatingsResponse, error) {
+	out := new(ListDriverRatingsResponse)
+	err := c.cc.Invoke(ctx, RatingService_ListDriverRatings_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ratingServiceClient) GetDriver(ctx context.Context, in *GetDriverRequest, opts ...grpc.CallOption) (*Driver, error) {
+	out := new(Driver)
+	err := c.cc.Invoke(ctx, RatingService_GetDriver_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RatingServiceServer is the server API for RatingService service.
+// All implementations must embed UnimplementedRatingServiceServer
+// for forward compatibility
+type RatingServiceServer interface {
+	// POST /drivers/{driver_id}/ratings
+	RateDriver(context.Context, *RateDriverRequest) (*RateDriverResponse, error)
+	// GET /drivers
+	ListDrivers(context.Context, *ListDriversRequest) (*ListDriversResponse, error)
+	// GET /drivers/{driver_id}/ratings?limit=&before=
+	ListDriverRatings(context.Context, *ListDriverRatingsRequest) (*ListDriverRatingsResponse, error)
+	// GET /drivers/{driver_id}
+	GetDriver(context.Context, *GetDriverRequest) (*Driver, error)
+	mustEmbedUnimplementedRatingServiceServer()
+}
+
+// UnimplementedRatingServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedRatingServiceServer struct {
+}
+
+func (UnimplementedRatingServiceServer) RateDriver(context.Context, *RateDriverRequest) (*RateDriverResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RateDriver not implemented")
+}
+func (UnimplementedRatingServiceServer) ListDrivers(context.Context, *ListDriversRequest) (*ListDriversResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDrivers not implemented")
+}
+func (UnimplementedRatingServiceServer) ListDriverRatings(context.Context, *ListDriverRatingsRequest) (*ListDriverRatingsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDriverRatings not implemented")
+}
+func (UnimplementedRatingServiceServer) GetDriver(context.Context, *GetDriverRequest) (*Driver, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDriver not implemented")
+}
+func (UnimplementedRatingServiceServer) mustEmbedUnimplementedRatingServiceServer() {}
+
+// UnsafeRatingServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RatingServiceServer will
+// result in compilation errors.
+type UnsafeRatingServiceServer interface {
+	mustEmbedUnimplementedRatingServiceServer()
+}
+
+func RegisterRatingServiceServer(s grpc.ServiceRegistrar, srv RatingServiceServer) {
+	s.RegisterService(&RatingService_ServiceDesc, srv)
+}
+
+func _RatingService_RateDriver_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RateDriverRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RatingServiceServer).RateDriver(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RatingService_RateDriver_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RatingServiceServer).RateDriver(ctx, req.(*RateDriverRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RatingService_ListDrivers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDriversRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RatingServiceServer).ListDrivers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RatingService_ListDrivers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RatingServiceServer).ListDrivers(ctx, req.(*ListDriversRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RatingService_ListDriverRatings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDriverRatingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RatingServiceServer).ListDriverRatings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RatingService_ListDriverRatings_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RatingServiceServer).ListDriverRatings(ctx, req.(*ListDriverRatingsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RatingService_GetDriver_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDriverRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RatingServiceServer).GetDriver(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RatingService_GetDriver_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RatingServiceServer).GetDriver(ctx, req.(*GetDriverRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// RatingService_ServiceDesc is the grpc.ServiceDesc for RatingService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RatingService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "articles.rating.v1.RatingService",
+	HandlerType: (*RatingServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RateDriver",
+			Handler:    _RatingService_RateDriver_Handler,
+		},
+		{
+			MethodName: "ListDrivers",
+			Handler:    _RatingService_ListDrivers_Handler,
+		},
+		{
+			MethodName: "ListDriverRatings",
+			Handler:    _RatingService_ListDriverRatings_Handler,
+		},
+		{
+			MethodName: "GetDriver",
+			Handler:    _RatingService_GetDriver_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "rating.proto",
+}
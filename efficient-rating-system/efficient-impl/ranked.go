@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+)
+
+// RankedDriver is a driver with the score a formula gave it. Score is null
+// when the formula has no finite value for the driver, e.g. log(count) of an
+// unrated driver.
+type RankedDriver struct {
+	ID            string   `json:"id"`
+	DriverInfo    string   `json:"driver_info"`
+	AverageRating float64  `json:"avg_rating"`
+	RatingCount   int      `json:"rating_count"`
+	Stddev        float64  `json:"stddev"`
+	Score         *float64 `json:"score"`
+}
+
+func getRankedDrivers(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListParams(r, listOptions{DefaultLimit: defaultDriversLimit, MaxLimit: maxDriversLimit})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	expr := r.URL.Query().Get("formula")
+	if expr == "" {
+		writeError(w, http.StatusBadRequest, (&paramError{"formula", "is required"}).Error())
+		return
+	}
+	f, err := parseFormula(expr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, (&paramError{"formula", err.Error()}).Error())
+		return
+	}
+	list, err := getRankedDriversList(entityType(r), f, params.Limit, params.Offset)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(list)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getRankedDriversList scores every entity of entityType that is not deleted
+// with f and returns a page of them, highest score first. The mean and the
+// count come from the stored aggregates, the (population) standard deviation
+// from the ratings. Drivers without a score come last.
+func getRankedDriversList(entityType string, f formula, limit, offset int) ([]RankedDriver, error) {
+	row, err := srv.DB().Query(`SELECT d.id, d.driver_info, COALESCE(CAST(d.rating_sum AS REAL)/d.rating_count, 0), d.rating_count,
+      COALESCE(MAX(s.squares - s.mean * s.mean, 0), 0)
+    FROM drivers d
+    LEFT JOIN (SELECT driver_id, AVG(rating * rating) AS squares, AVG(rating) AS mean FROM driver_ratings GROUP BY driver_id) s
+      ON s.driver_id = d.id
+    WHERE d.deleted_at IS NULL AND d.entity_type = ?
+    ORDER BY `+tieBreak("d"), entityType)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []RankedDriver{}
+	for row.Next() {
+		var driver RankedDriver
+		var variance float64
+		err = row.Scan(&driver.ID, &driver.DriverInfo, &driver.AverageRating, &driver.RatingCount, &variance)
+		if err != nil {
+			return nil, err
+		}
+		driver.Stddev = math.Sqrt(variance)
+		score := f.eval(formulaVars{Avg: driver.AverageRating, Count: float64(driver.RatingCount), Stddev: driver.Stddev})
+		if !math.IsNaN(score) && !math.IsInf(score, 0) {
+			driver.Score = &score
+		}
+		list = append(list, driver)
+	}
+	if err = row.Err(); err != nil {
+		return nil, err
+	}
+	// The sort is stable so that equal scores keep the order of tieBreak.
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i].Score, list[j].Score
+		return a != nil && (b == nil || *a > *b)
+	})
+	if offset > len(list) {
+		offset = len(list)
+	}
+	list = list[offset:]
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
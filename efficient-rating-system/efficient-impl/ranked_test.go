@@ -0,0 +1,36 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestRankedDrivers(t *testing.T) {
+	h := openTestDB(t, nil)
+	for u := 0; u < 5; u++ {
+		rateTest(t, h, "1", "u"+strconv.Itoa(u), 4)
+	}
+	rateTest(t, h, "2", "a", 5)
+	rateTest(t, h, "3", "a", 5)
+	rateTest(t, h, "3", "b", 5)
+	rec := serveTest(h, "GET", "/drivers/ranked?limit=3&formula="+url.QueryEscape("avg*log(count+1)"), "")
+	expectStatus(t, rec, http.StatusOK)
+	var list []RankedDriver
+	decodeBody(t, rec, &list)
+	want := []struct {
+		id    string
+		score float64
+	}{{"1", 4 * math.Log(6)}, {"3", 5 * math.Log(3)}, {"2", 5 * math.Log(2)}}
+	if len(list) != len(want) {
+		t.Fatalf("%d drivers ranked, want %d", len(list), len(want))
+	}
+	for i, w := range want {
+		if list[i].ID != w.id || list[i].Score == nil || math.Abs(*list[i].Score-w.score) > 1e-9 {
+			t.Fatalf("ranked %+v at %d, want driver %s scoring %v", list[i], i, w.id, w.score)
+		}
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers/ranked?formula="+url.QueryEscape("system(avg)"), ""), http.StatusBadRequest)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	rateLimitReject   = "reject"
+	rateLimitThrottle = "throttle"
+)
+
+// clientLimiter counts the requests of every client address in fixed one
+// minute windows.
+type clientLimiter struct {
+	mu     sync.Mutex
+	window time.Time
+	counts map[string]int
+}
+
+// take counts a request of client and returns how many it made in the
+// current window, this one included.
+func (l *clientLimiter) take(client string, now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if window := now.Truncate(time.Minute); !window.Equal(l.window) {
+		// The counts of the last window are of no use anymore.
+		l.window, l.counts = window, map[string]int{}
+	}
+	l.counts[client]++
+	return l.counts[client]
+}
+
+// throttleDelay is how long a request is held with cfg.RateLimitMode set to
+// throttle: nothing in the first half of the limit, then growing linearly to
+// cfg.RateLimitMaxDelay for the last request the limit allows.
+func throttleDelay(count int) time.Duration {
+	soft := cfg.RateLimit / 2
+	if count <= soft {
+		return 0
+	}
+	return cfg.RateLimitMaxDelay * time.Duration(count-soft) / time.Duration(cfg.RateLimit-soft)
+}
+
+// rateLimit allows every client address cfg.RateLimit requests to next per
+// minute, the ones over it get a 429. In throttle mode clients nearing the
+// limit are slowed down first, see throttleDelay. The address is the one the
+// connection comes from, behind a proxy all clients share it.
+func rateLimit(next http.Handler) http.Handler {
+	if cfg.RateLimit == 0 {
+		return next
+	}
+	limiter := &clientLimiter{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		now := time.Now()
+		count := limiter.take(client, now)
+		if count > cfg.RateLimit {
+			retry := now.Truncate(time.Minute).Add(time.Minute).Sub(now)
+			w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+			writeError(w, http.StatusTooManyRequests, "too many requests, try again later")
+			return
+		}
+		if cfg.RateLimitMode == rateLimitThrottle {
+			if d := throttleDelay(count); d > 0 && !holdRequest(w, r, d) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// userRateLimit is the token bucket of every user rating, it is nil unless
+// cfg.UserRateLimit is set.
+var userRateLimit *userLimiter
+
+// userLimiter holds a token bucket of burst tokens per user, refilled at rate
+// tokens per second. A bucket that has been full for a minute is forgotten,
+// it is the same as a new one.
+type userLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+func newUserLimiter(rate float64, burst int) *userLimiter {
+	return &userLimiter{rate: rate, burst: float64(burst), buckets: map[string]*tokenBucket{}, swept: time.Now()}
+}
+
+// take spends a token of the user. When there is none left it returns how
+// long until there is one.
+func (l *userLimiter) take(userId string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) > time.Minute {
+		full := time.Duration(l.burst / l.rate * float64(time.Second))
+		for id, b := range l.buckets {
+			if now.Sub(b.at) > full+time.Minute {
+				delete(l.buckets, id)
+			}
+		}
+		l.swept = now
+	}
+	b, ok := l.buckets[userId]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, at: now}
+		l.buckets[userId] = b
+	}
+	b.tokens += now.Sub(b.at).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.at = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestThrottleNearRateLimit(t *testing.T) {
+	h := openTestDB(t, map[string]string{
+		"RATE_LIMIT_PER_MINUTE":   "4",
+		"RATE_LIMIT_MODE":         "throttle",
+		"RATE_LIMIT_MAX_DELAY_MS": "200",
+	})
+	// The limiter counts per minute, don't let the window roll over midway.
+	if left := time.Until(time.Now().Truncate(time.Minute).Add(time.Minute)); left < time.Second {
+		time.Sleep(left)
+	}
+	// The first half of the limit goes through right away, the rest is
+	// delayed by 100ms and then 200ms but not rejected.
+	var took []time.Duration
+	for _, user := range []string{"a", "b", "c", "d"} {
+		start := time.Now()
+		rateTest(t, h, "1", user, 4)
+		took = append(took, time.Since(start))
+	}
+	if took[0] >= 100*time.Millisecond || took[1] >= 100*time.Millisecond {
+		t.Fatalf("ratings within half of the limit took %s and %s, want no delay", took[0], took[1])
+	}
+	if took[2] < 100*time.Millisecond || took[3] < 200*time.Millisecond {
+		t.Fatalf("ratings near the limit took %s and %s, want at least 100ms and 200ms", took[2], took[3])
+	}
+	expectStatus(t, serveTest(h, "POST", "/drivers/1/ratings", `{"user_id":"e","rating":4}`), http.StatusTooManyRequests)
+}
+
+func TestUserLimiterTake(t *testing.T) {
+	l := newUserLimiter(0.5, 2)
+	start := time.Now()
+	for i, step := range []struct {
+		user  string
+		after time.Duration
+		ok    bool
+		retry time.Duration
+	}{
+		// The burst, then a token every 2s.
+		{"a", 0, true, 0},
+		{"a", 0, true, 0},
+		{"a", 0, false, 2 * time.Second},
+		{"b", 0, true, 0},
+		{"a", time.Second, false, time.Second},
+		{"a", 2 * time.Second, true, 0},
+		{"a", 2 * time.Second, false, 2 * time.Second},
+		// The bucket refills up to the burst only.
+		{"a", 10 * time.Second, true, 0},
+		{"a", 10 * time.Second, true, 0},
+		{"a", 10 * time.Second, false, 2 * time.Second},
+	} {
+		ok, retry := l.take(step.user, start.Add(step.after))
+		if ok != step.ok || retry != step.retry {
+			t.Fatalf("step %d: user %s at +%s got %v and %s, want %v and %s", i, step.user, step.after, ok, retry, step.ok, step.retry)
+		}
+	}
+}
+
+func TestUserRateLimitRejects(t *testing.T) {
+	h := openTestDB(t, map[string]string{"USER_RATE_LIMIT_RPS": "0.01", "USER_RATE_LIMIT_BURST": "1"})
+	rateTest(t, h, "1", "a", 4)
+	rec := serveTest(h, "POST", "/drivers/2/ratings", `{"user_id": "a", "rating": 2}`)
+	expectStatus(t, rec, http.StatusTooManyRequests)
+	// Almost 100s until the next token, rounded up.
+	if retry, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || retry < 99 || retry > 101 {
+		t.Fatalf("Retry-After is %q, want about 100", rec.Header().Get("Retry-After"))
+	}
+	if _, count := driverAggregates(t, "2"); count != 0 {
+		t.Fatal("the refused rating was stored")
+	}
+	rateTest(t, h, "2", "b", 2)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultFeedLimit = 20
+	maxFeedLimit     = 100
+)
+
+// RatingsPage is one page of the reverse chronological ratings feed. Next is
+// the cursor to pass as `before` to get the following (older) page, it is
+// empty on the last page. Total counts the ratings of all the pages.
+// Truncated is set when the page is returned in place of a full list that was
+// over the configured maximum.
+type RatingsPage struct {
+	Ratings   []Rating `json:"ratings"`
+	Next      string   `json:"next,omitempty"`
+	Total     int      `json:"total"`
+	Truncated bool     `json:"truncated,omitempty"`
+}
+
+// feedCursor points at a rating by its creation time and rowid, rowid breaks
+// ties between ratings created within the same second.
+type feedCursor struct {
+	CreatedAt string
+	RowID     int64
+}
+
+func (c feedCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.CreatedAt + "|" + strconv.FormatInt(c.RowID, 10)))
+}
+
+func parseFeedCursor(s string) (*feedCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	createdAt, rowId, ok := strings.Cut(string(b), "|")
+	if !ok {
+		return nil, errors.New("invalid cursor")
+	}
+	id, err := strconv.ParseInt(rowId, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &feedCursor{CreatedAt: createdAt, RowID: id}, nil
+}
+
+func getDriverRatingsFeed(w http.ResponseWriter, r *http.Request, driverId string, filter ratingFilter) {
+	params, err := parseListParams(r, listOptions{DefaultLimit: defaultFeedLimit, MaxLimit: maxFeedLimit, Before: true})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, err := service.ListDriverRatings(r, driverId, filter, params.Before, params.Limit)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(page)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getDriverRatingsPage returns up to limit ratings of the driver, newest
+// first, starting right after the before cursor (or from the newest rating
+// when before is nil), selected by filter.
+func getDriverRatingsPage(driverId string, filter ratingFilter, before *feedCursor, limit int) (*RatingsPage, error) {
+	cond, args := filter.condition()
+	q := `SELECT rowid, driver_id, user_id, rating, COALESCE(source, ''), COALESCE(comment, ''), tags, COALESCE(region, ''), created_at, updated_at, moderation FROM driver_ratings WHERE driver_id = ?` + cond
+	args = append([]interface{}{driverId}, args...)
+	if before != nil {
+		q += ` AND (created_at, rowid) < (?, ?)`
+		args = append(args, before.CreatedAt, before.RowID)
+	}
+	// One extra row tells whether there is a next page.
+	q += ` ORDER BY created_at DESC, rowid DESC LIMIT ?`
+	args = append(args, limit+1)
+	row, err := srv.DB().Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	page := &RatingsPage{Ratings: []Rating{}}
+	var last feedCursor
+	for row.Next() {
+		if len(page.Ratings) == limit {
+			page.Next = last.String()
+			break
+		}
+		var rating Rating
+		err = row.Scan(&last.RowID, &rating.DriverID, &rating.UserID, &rating.Rating, &rating.Source, &rating.Comment, &rating.Tags, &rating.Region, &rating.CreatedAt, &rating.UpdatedAt, &rating.Moderation)
+		if err != nil {
+			return nil, err
+		}
+		if rating.CreatedAt != nil {
+			last.CreatedAt = rating.CreatedAt.UTC().Format(timeFormat)
+		}
+		page.Ratings = append(page.Ratings, rating)
+	}
+	return page, row.Err()
+}
+
+// countDriverRatings counts the ratings of the driver selected by filter.
+func countDriverRatings(driverId string, filter ratingFilter) (int, error) {
+	cond, args := filter.condition()
+	var total int
+	err := srv.DB().QueryRow("SELECT COUNT(*) FROM driver_ratings WHERE driver_id = ?"+cond, append([]interface{}{driverId}, args...)...).Scan(&total)
+	return total, err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRatingsFeedWalksBackInTime(t *testing.T) {
+	h := openTestDB(t, nil)
+	for i := 0; i < 5; i++ {
+		rateTest(t, h, "1", "u"+strconv.Itoa(i), i%5+1)
+	}
+	// The oldest ones are the earliest, rowid breaks the ties of a second.
+	execTest(t, "UPDATE driver_ratings SET created_at = datetime('now', '-' || (5 - CAST(substr(user_id, 2) AS INTEGER)) || ' minutes')")
+	var users []string
+	target := "/drivers/1/ratings?limit=2"
+	for pages := 0; target != ""; pages++ {
+		if pages == 3 {
+			t.Fatal("more than 3 pages of 2 for 5 ratings")
+		}
+		rec := serveTest(h, "GET", target, "")
+		expectStatus(t, rec, http.StatusOK)
+		var page RatingsPage
+		decodeBody(t, rec, &page)
+		if page.Total != 5 {
+			t.Fatalf("total is %d, want 5", page.Total)
+		}
+		for _, rating := range page.Ratings {
+			users = append(users, rating.UserID)
+		}
+		target = ""
+		if page.Next != "" {
+			target = "/drivers/1/ratings?limit=2&before=" + page.Next
+		}
+	}
+	want := []string{"u4", "u3", "u2", "u1", "u0"}
+	if fmt.Sprint(users) != fmt.Sprint(want) {
+		t.Fatalf("feed is %v, want %v", users, want)
+	}
+}
+
+func TestRatingsWithComment(t *testing.T) {
+	h := openTestDB(t, nil)
+	for user, comment := range map[string]string{"a": "friendly", "b": "", "c": "on time"} {
+		body := fmt.Sprintf(`{"user_id": %q, "rating": 4, "comment": %q}`, user, comment)
+		expectStatus(t, serveTest(h, "POST", "/drivers/1/ratings", body), http.StatusOK)
+	}
+	rateTest(t, h, "1", "d", 4)
+	for _, target := range []string{"/drivers/1/ratings?has_comment=true", "/drivers/1/ratings?has_comment=true&limit=10"} {
+		rec := serveTest(h, "GET", target, "")
+		expectStatus(t, rec, http.StatusOK)
+		var list []Rating
+		if strings.Contains(target, "limit") {
+			var page RatingsPage
+			decodeBody(t, rec, &page)
+			list = page.Ratings
+		} else {
+			decodeBody(t, rec, &list)
+		}
+		users := []string{}
+		for _, r := range list {
+			users = append(users, r.UserID)
+		}
+		sort.Strings(users)
+		if got := fmt.Sprint(users); got != "[a c]" {
+			t.Fatalf("%s: ratings of %s, want the commented ones of [a c]", target, got)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type RecomputeReport struct {
+	Drivers    int   `json:"drivers"`
+	Batches    int   `json:"batches"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// recompute computes the aggregates of every driver again from its counted
+// ratings, e.g. after GET /admin/drift reported some, and reports how far it
+// got. Deleted drivers are included.
+func recompute(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
+	report, err := recomputeInBatches(cfg.RecomputeBatchSize)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	report.DurationMs = time.Since(started).Milliseconds()
+	err = recordAudit(srv.DB(), requestActor(r), "update", "driver", "*", map[string]interface{}{"recomputed": report.Drivers})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(report)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// DriverAggregates are the stored aggregates of a driver once
+// POST /admin/drivers/{driver_id}/recompute computed them again.
+type DriverAggregates struct {
+	ID          string `json:"id"`
+	RatingSum   int64  `json:"rating_sum"`
+	RatingCount int64  `json:"rating_count"`
+}
+
+// recomputeDriver computes the aggregates of a single driver again, for when
+// GET /admin/drift reports only a few of them.
+func recomputeDriver(w http.ResponseWriter, r *http.Request) {
+	driverId := mux.Vars(r)["driver_id"]
+	found, _, err := getDriverState(driverId)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "driver not found")
+		return
+	}
+	if err = recomputeBatch([]string{driverId}); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	result := DriverAggregates{ID: driverId}
+	err = srv.DB().QueryRow("SELECT rating_sum, rating_count FROM drivers WHERE id = ?", driverId).Scan(&result.RatingSum, &result.RatingCount)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	err = recordAudit(srv.DB(), requestActor(r), "update", "driver", driverId, map[string]interface{}{"recomputed": 1})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(result)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// recomputeInBatches goes through the drivers by rowid, size at a time. Every
+// batch is its own statement and so its own transaction, ratings written in
+// between don't wait for the whole pass.
+func recomputeInBatches(size int) (RecomputeReport, error) {
+	var report RecomputeReport
+	var after int64
+	for {
+		ids, last, err := nextDriverIDs(after, size)
+		if err != nil || len(ids) == 0 {
+			return report, err
+		}
+		if err = recomputeBatch(ids); err != nil {
+			return report, err
+		}
+		report.Drivers += len(ids)
+		report.Batches++
+		log.Printf("recompute: batch %d done, %d drivers so far", report.Batches, report.Drivers)
+		after = last
+	}
+}
+
+// recomputeBatch computes the aggregates of ids again. With coalesced
+// aggregate updates the changes pending for them are dropped, the ratings
+// they come from are already counted. Ratings committed while the batch runs
+// only add theirs once it is done.
+func recomputeBatch(ids []string) error {
+	if aggregates == nil {
+		return recomputeAggregates(ids)
+	}
+	aggregates.mu.Lock()
+	defer aggregates.mu.Unlock()
+	if err := recomputeAggregates(ids); err != nil {
+		return err
+	}
+	for _, driverId := range ids {
+		delete(aggregates.pending, driverId)
+	}
+	return nil
+}
+
+// nextDriverIDs returns the ids of up to limit drivers after the given
+// rowid, deleted ones included, and the rowid of the last one. The rowid is
+// the cursor because ids can be numbers or strings, see Config.DriverIDType.
+func nextDriverIDs(after int64, limit int) ([]string, int64, error) {
+	row, err := srv.DB().Query(`SELECT rowid, id FROM drivers WHERE rowid > ? ORDER BY rowid LIMIT ?`, after, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer row.Close()
+	list := []string{}
+	last := after
+	for row.Next() {
+		var driverId string
+		if err = row.Scan(&last, &driverId); err != nil {
+			return nil, 0, err
+		}
+		list = append(list, driverId)
+	}
+	return list, last, row.Err()
+}
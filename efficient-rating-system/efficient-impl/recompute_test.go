@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestRecomputeInBatches(t *testing.T) {
+	h := openTestDB(t, map[string]string{"ADMIN_TOKEN": "secret-token", "RECOMPUTE_BATCH_SIZE": "7"})
+	for id := 1; id <= seedDriverCount; id++ {
+		for u := 0; u < id%4; u++ {
+			rateTest(t, h, strconv.Itoa(id), "u"+strconv.Itoa(u), u+2)
+		}
+	}
+	execTest(t, "UPDATE drivers SET rating_sum = 99, rating_count = 99")
+	rec := serveTest(h, "POST", "/admin/recompute", "", adminAuth...)
+	expectStatus(t, rec, http.StatusOK)
+	var report RecomputeReport
+	decodeBody(t, rec, &report)
+	if report.Drivers != seedDriverCount || report.Batches != 5 {
+		t.Fatalf("recomputed %d drivers in %d batches, want %d in 5", report.Drivers, report.Batches, seedDriverCount)
+	}
+	drift, err := getAggregateDriftList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift) != 0 {
+		t.Fatalf("drivers still drifting after the recompute: %+v", drift)
+	}
+	// Driver 3 has the ratings 2, 3 and 4.
+	if sum, count := driverAggregates(t, "3"); sum != 9 || count != 3 {
+		t.Fatalf("aggregates of driver 3 are sum %d count %d, want 9 and 3", sum, count)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// RegionBreakdown averages the ratings of a driver per region, every
+// configured region is listed even when it has no ratings.
+type RegionBreakdown struct {
+	DriverID string                  `json:"driver_id"`
+	Regions  map[string]GroupAverage `json:"regions"`
+}
+
+func getDriverRegions(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	driverId := params["driver_id"]
+	regions, err := getDriverGroupAverages(driverId, "region", cfg.RatingRegions, "")
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(RegionBreakdown{DriverID: driverId, Regions: regions})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestDriverAverageByRegion(t *testing.T) {
+	h := openTestDB(t, map[string]string{"RATING_REGIONS": "north,south,east"})
+	for _, r := range []struct {
+		user, region string
+		stars        int
+	}{{"a", "north", 5}, {"b", "north", 4}, {"c", "south", 2}} {
+		body := fmt.Sprintf(`{"user_id": %q, "rating": %d, "region": %q}`, r.user, r.stars, r.region)
+		expectStatus(t, serveTest(h, "POST", "/drivers/1/ratings", body), http.StatusOK)
+	}
+	expectStatus(t, serveTest(h, "POST", "/drivers/1/ratings", `{"user_id": "d", "rating": 1, "region": "west"}`), http.StatusBadRequest)
+	rec := serveTest(h, "GET", "/drivers/1/by-region", "")
+	expectStatus(t, rec, http.StatusOK)
+	var breakdown RegionBreakdown
+	decodeBody(t, rec, &breakdown)
+	want := map[string]GroupAverage{"north": {2, floatPtr(4.5)}, "south": {1, floatPtr(2)}, "east": {0, nil}}
+	for region, avg := range want {
+		got, ok := breakdown.Regions[region]
+		if !ok || got.Count != avg.Count || (got.AverageRating == nil) != (avg.AverageRating == nil) ||
+			avg.AverageRating != nil && *got.AverageRating != *avg.AverageRating {
+			t.Fatalf("region %s is %+v, want %d ratings averaging %v", region, got, avg.Count, avg.AverageRating)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// minReliabilityRatings is the fewest ratings split-half reliability is
+// computed from, two per half.
+const minReliabilityRatings = 4
+
+// Reliability compares the averages of two halves of the ratings of a
+// driver. The ratings are taken in the order they were submitted and dealt
+// alternately into the halves, so that both cover the same period. Stability
+// is 1 when the halves agree and 0 when they are as far apart as the rating
+// scale allows. The averages and scores are null with fewer than
+// minReliabilityRatings ratings.
+type Reliability struct {
+	DriverID    string   `json:"driver_id"`
+	RatingCount int      `json:"rating_count"`
+	FirstHalf   *float64 `json:"first_half_avg"`
+	SecondHalf  *float64 `json:"second_half_avg"`
+	Difference  *float64 `json:"difference"`
+	Stability   *float64 `json:"stability"`
+}
+
+func getDriverReliability(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	driverId := params["driver_id"]
+	found, _, err := getDriverState(driverId)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "driver not found")
+		return
+	}
+	reliability, err := getDriverReliabilityByID(driverId)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(reliability)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func getDriverReliabilityByID(driverId string) (*Reliability, error) {
+	row, err := srv.DB().Query("SELECT rating FROM driver_ratings WHERE driver_id = ? ORDER BY created_at, rowid", driverId)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	var sums [2]float64
+	var counts [2]int
+	for row.Next() {
+		var rating float64
+		if err = row.Scan(&rating); err != nil {
+			return nil, err
+		}
+		half := (counts[0] + counts[1]) % 2
+		sums[half] += rating
+		counts[half]++
+	}
+	if err = row.Err(); err != nil {
+		return nil, err
+	}
+	reliability := &Reliability{DriverID: driverId, RatingCount: counts[0] + counts[1]}
+	if reliability.RatingCount < minReliabilityRatings {
+		return reliability, nil
+	}
+	first, second := sums[0]/float64(counts[0]), sums[1]/float64(counts[1])
+	difference := math.Abs(first - second)
+	stability := 1 - difference/(maxRating-minRating)
+	reliability.FirstHalf, reliability.SecondHalf = &first, &second
+	reliability.Difference, reliability.Stability = &difference, &stability
+	return reliability, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestDriverReliability(t *testing.T) {
+	h := openTestDB(t, nil)
+	// Dealt alternately the halves are 5, 5, 4 and 3, 1.
+	for i, stars := range []int{5, 3, 5, 1, 4} {
+		rateTest(t, h, "1", "u"+strconv.Itoa(i), stars)
+	}
+	rec := serveTest(h, "GET", "/drivers/1/reliability", "")
+	expectStatus(t, rec, http.StatusOK)
+	var got Reliability
+	decodeBody(t, rec, &got)
+	if got.RatingCount != 5 || got.FirstHalf == nil || got.SecondHalf == nil || got.Difference == nil || got.Stability == nil {
+		t.Fatalf("reliability is %+v, want it computed from 5 ratings", got)
+	}
+	for _, v := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"first half", *got.FirstHalf, 14.0 / 3},
+		{"second half", *got.SecondHalf, 2},
+		{"difference", *got.Difference, 8.0 / 3},
+		{"stability", *got.Stability, 1.0 / 3},
+	} {
+		if math.Abs(v.got-v.want) > 1e-9 {
+			t.Errorf("%s is %v, want %v", v.name, v.got, v.want)
+		}
+	}
+
+	// Too few ratings leave the scores out.
+	rateTest(t, h, "2", "a", 5)
+	rec = serveTest(h, "GET", "/drivers/2/reliability", "")
+	expectStatus(t, rec, http.StatusOK)
+	got = Reliability{}
+	decodeBody(t, rec, &got)
+	if got.RatingCount != 1 || got.Stability != nil || got.FirstHalf != nil {
+		t.Fatalf("reliability with one rating is %+v, want no scores", got)
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers/404/reliability", ""), http.StatusNotFound)
+}
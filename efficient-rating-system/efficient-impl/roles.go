@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	roleAdmin  = "admin"
+	rolePublic = "public"
+)
+
+type roleKey struct{}
+
+// withRole stores the role of the caller in the request context: admin for
+// a request carrying the admin token, public for everyone else.
+func withRole(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := rolePublic
+		if adminAuthorized(r) {
+			role = roleAdmin
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), roleKey{}, role)))
+	})
+}
+
+// callerRole returns the role withRole found for r, public when it didn't
+// run.
+func callerRole(r *http.Request) string {
+	if role, ok := r.Context().Value(roleKey{}).(string); ok {
+		return role
+	}
+	return rolePublic
+}
+
+// DriverInternals are the fields of a driver only admins are shown: the
+// stored aggregates and when the driver was last rated, null when never.
+type DriverInternals struct {
+	RatingSum   int64      `json:"rating_sum"`
+	RatingCount int64      `json:"rating_count"`
+	LastRatedAt *time.Time `json:"last_rated_at"`
+}
+
+// showInternals sets the internals of the drivers when r comes from an
+// admin, they are left out of the response otherwise.
+func showInternals(w http.ResponseWriter, r *http.Request, drivers ...*Driver) error {
+	w.Header().Add("Vary", "Authorization")
+	if callerRole(r) != roleAdmin || len(drivers) == 0 {
+		return nil
+	}
+	byID := make(map[string]*Driver, len(drivers))
+	args := make([]interface{}, len(drivers))
+	for i, driver := range drivers {
+		byID[driver.ID] = driver
+		args[i] = driver.ID
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(drivers)), ", ")
+	row, err := srv.DB().Query(`SELECT d.id, COALESCE(d.rating_sum, 0), COALESCE(d.rating_count, 0), lr.updated_at
+    FROM drivers d
+    LEFT JOIN driver_ratings lr ON lr.rowid = (SELECT rowid FROM driver_ratings
+      WHERE driver_id = d.id ORDER BY updated_at DESC, rowid DESC LIMIT 1)
+    WHERE d.id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return err
+	}
+	defer row.Close()
+	for row.Next() {
+		var driverId string
+		var internals DriverInternals
+		var lastRated sql.NullTime
+		err = row.Scan(&driverId, &internals.RatingSum, &internals.RatingCount, &lastRated)
+		if err != nil {
+			return err
+		}
+		if lastRated.Valid {
+			internals.LastRatedAt = &lastRated.Time
+		}
+		if driver := byID[driverId]; driver != nil {
+			driver.DriverInternals = &internals
+		}
+	}
+	return row.Err()
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDriverInternalsForAdmins(t *testing.T) {
+	h := openTestDB(t, adminEnv)
+	rateTest(t, h, "1", "a", 4)
+	rateTest(t, h, "1", "b", 5)
+
+	rec := serveTest(h, "GET", "/drivers/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	var public map[string]interface{}
+	decodeBody(t, rec, &public)
+	for _, field := range []string{"rating_sum", "rating_count", "last_rated_at"} {
+		if _, ok := public[field]; ok {
+			t.Fatalf("public driver %v has %s", public, field)
+		}
+	}
+
+	rec = serveTest(h, "GET", "/drivers/1", "", adminAuth...)
+	expectStatus(t, rec, http.StatusOK)
+	var admin Driver
+	decodeBody(t, rec, &admin)
+	if admin.ID != "1" || admin.DriverInternals == nil || admin.RatingSum != 9 || admin.RatingCount != 2 || admin.LastRatedAt == nil {
+		t.Fatalf("driver for admins is %+v with internals %+v, want a sum of 9 over 2 ratings and when it was last rated", admin, admin.DriverInternals)
+	}
+	if vary := rec.Header().Values("Vary"); !contains(vary, "Authorization") {
+		t.Fatalf("Vary is %q, want it to name Authorization", vary)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+const (
+	// roundHalfAway rounds halves away from zero, 2.5 becomes 3.
+	roundHalfAway = "half_away"
+	// roundHalfEven is banker's rounding, halves go to the even neighbour
+	// so 2.5 becomes 2 and 3.5 becomes 4.
+	roundHalfEven = "half_even"
+
+	maxPrecision = 6
+)
+
+// roundAverage rounds v to the given number of decimals using the rounding
+// mode, a negative precision leaves v as it is.
+func roundAverage(v float64, precision int, mode string) float64 {
+	if precision < 0 {
+		return v
+	}
+	scale := math.Pow(10, float64(precision))
+	if mode == roundHalfEven {
+		return math.RoundToEven(v*scale) / scale
+	}
+	return math.Round(v*scale) / scale
+}
+
+// roundAverages applies presentAverage to the average of every driver.
+func roundAverages(list []Driver, params listParams) {
+	for i := range list {
+		list[i].AverageRating = presentAverage(list[i].AverageRating, params)
+	}
+}
+
+// presentAverage maps the average v linearly from 0-maxRating to 0-Scale,
+// so 4.5 becomes 90 at a scale of 100, and then rounds it.
+func presentAverage(v float64, params listParams) float64 {
+	if params.Scale > 0 {
+		v = v * params.Scale / maxRating
+	}
+	return roundAverage(v, params.Precision, params.Rounding)
+}
+
+// MarshalJSON writes avg_rating as a plain decimal, never in scientific
+// notation, with cfg.AverageDecimals decimals when it is not negative.
+func (d Driver) MarshalJSON() ([]byte, error) {
+	type plain Driver
+	return json.Marshal(struct {
+		plain
+		AverageRating json.Number `json:"avg_rating"`
+	}{plain(d), json.Number(strconv.FormatFloat(d.AverageRating, 'f', cfg.AverageDecimals, 64))})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRoundAverage(t *testing.T) {
+	tests := []struct {
+		v         float64
+		precision int
+		mode      string
+		want      float64
+	}{
+		{2.5, 0, roundHalfEven, 2},
+		{3.5, 0, roundHalfEven, 4},
+		{2.5, 0, roundHalfAway, 3},
+		{2.25, 1, roundHalfEven, 2.2},
+		{2.25, -1, roundHalfEven, 2.25},
+	}
+	for _, test := range tests {
+		if got := roundAverage(test.v, test.precision, test.mode); got != test.want {
+			t.Errorf("%v at precision %d %s is %v, want %v", test.v, test.precision, test.mode, got, test.want)
+		}
+	}
+}
+
+func TestDriversRoundedHalfEven(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 2)
+	rateTest(t, h, "1", "b", 3)
+	for mode, want := range map[string]float64{roundHalfEven: 2, roundHalfAway: 3} {
+		rec := serveTest(h, "GET", "/drivers?limit=1&precision=0&rounding="+mode, "")
+		expectStatus(t, rec, http.StatusOK)
+		var list []Driver
+		decodeBody(t, rec, &list)
+		if list[0].AverageRating != want {
+			t.Fatalf("2.5 rounded %s is %v, want %v", mode, list[0].AverageRating, want)
+		}
+	}
+}
+
+func TestDriverAverageWithoutExponent(t *testing.T) {
+	openTestDB(t, nil)
+	for _, avg := range []float64{1e-7, 4.999999999999999, 1e21, 0} {
+		d, err := json.Marshal(Driver{ID: "1", AverageRating: avg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var driver map[string]json.RawMessage
+		if err = json.Unmarshal(d, &driver); err != nil {
+			t.Fatal(err)
+		}
+		if raw := string(driver["avg_rating"]); strings.ContainsAny(raw, "eE") {
+			t.Fatalf("avg_rating of %v is written as %s", avg, raw)
+		}
+	}
+}
+
+func TestDriverAverageWithFixedDecimals(t *testing.T) {
+	h := openTestDB(t, map[string]string{"AVG_DECIMALS": "2"})
+	rateTest(t, h, "1", "a", 4)
+	rateTest(t, h, "1", "b", 5)
+	rec := serveTest(h, "GET", "/drivers/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	if body := rec.Body.String(); !strings.Contains(body, `"avg_rating":4.50`) {
+		t.Fatalf("avg_rating is not written with 2 decimals: %s", body)
+	}
+}
+
+func TestDriversOnScale(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 4)
+	rateTest(t, h, "1", "b", 5)
+	for target, want := range map[string]float64{"/drivers?limit=1&scale=100": 90, "/drivers?limit=1": 4.5, "/drivers?limit=1&scale=10": 9} {
+		rec := serveTest(h, "GET", target, "")
+		expectStatus(t, rec, http.StatusOK)
+		var list []Driver
+		decodeBody(t, rec, &list)
+		if len(list) != 1 || list[0].AverageRating != want {
+			t.Fatalf("%s: got %+v, want an average of %v", target, list, want)
+		}
+	}
+	for _, scale := range []string{"0", "-5", "x"} {
+		expectStatus(t, serveTest(h, "GET", "/drivers?scale="+scale, ""), http.StatusBadRequest)
+	}
+}
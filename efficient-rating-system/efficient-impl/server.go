@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+// Server holds the database handle shared by the handlers. The handle is
+// behind an atomic pointer so that it can be swapped to a new primary while
+// requests are being served, every query loads the current one through DB.
+type Server struct {
+	db       atomic.Pointer[sql.DB]
+	inFlight atomic.Int64
+	draining atomic.Bool
+}
+
+var srv = &Server{}
+
+func (s *Server) DB() *sql.DB {
+	return s.db.Load()
+}
+
+// SwapDB makes new the handle used by all subsequent queries. Queries already
+// running on the previous handle are not interrupted, closing it is left to
+// the caller.
+func (s *Server) SwapDB(new *sql.DB) *sql.DB {
+	return s.db.Swap(new)
+}
+
+type Failover struct {
+	DBPath string `json:"db_path"`
+}
+
+// failover switches the service to the database at the given path. It must
+// already have the schema the code expects, the previous database is closed
+// once its running queries are done. The buffered aggregate changes go to the
+// previous database, the lazy aggregates are recomputed in the new one.
+func failover(w http.ResponseWriter, r *http.Request) {
+	var f Failover
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil || f.DBPath == "" {
+		writeError(w, http.StatusBadRequest, "body must be {\"db_path\": \"...\"}")
+		return
+	}
+	next, err := openPrimary(f.DBPath)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if aggregates != nil {
+		// The pending changes are of ratings in the previous database.
+		if err = aggregates.flush(); err != nil {
+			log.Println("failover: flush aggregates:", err)
+		}
+	}
+	prev := srv.SwapDB(next)
+	if aggregates != nil {
+		// Whatever failed or came in during the swap is of the previous
+		// database too, it must not reach the new one.
+		if n := aggregates.drop(); n > 0 {
+			log.Printf("failover: dropped the pending aggregates of %d drivers", n)
+		}
+	}
+	if lazy != nil {
+		if err = lazy.reset(); err != nil {
+			log.Println("failover: recompute aggregates:", err)
+		}
+	}
+	// The cached averages were read from the previous database.
+	averageCache.purge()
+	log.Println("failover: switched database to", f.DBPath)
+	go prev.Close()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// openPrimary opens the database at path and checks that it can take over.
+func openPrimary(path string) (*sql.DB, error) {
+	next, err := openDB(path)
+	if err != nil {
+		return nil, err
+	}
+	version, err := getSchemaVersion(next)
+	if err == nil && version != schemaVersion {
+		err = fmt.Errorf("schema version is %d, expected %d", version, schemaVersion)
+	}
+	if err != nil {
+		next.Close()
+		return nil, err
+	}
+	return next, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// failoverTarget creates a database to fail over to, with the schema and the
+// demo drivers but none of the ratings, and returns its path.
+func failoverTarget(tb testing.TB) string {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), "primary.sqlite")
+	next, err := openDB(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	prev := srv.SwapDB(next)
+	createTables()
+	srv.SwapDB(prev)
+	next.Close()
+	return path
+}
+
+func TestFailoverWhileServing(t *testing.T) {
+	// The cached average of driver 1 must not outlive the failover.
+	h := openTestDB(t, map[string]string{"ADMIN_TOKEN": "secret-token", "AVERAGE_CACHE_SIZE": "10"})
+	rateTest(t, h, "1", "a", 5)
+	expectStatus(t, serveTest(h, "GET", "/drivers/1", ""), http.StatusOK)
+	path := failoverTarget(t)
+
+	var wg sync.WaitGroup
+	var stop atomic.Bool
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for !stop.Load() {
+			if rec := serveTest(h, "GET", "/drivers?limit=1", ""); rec.Code != http.StatusOK {
+				t.Errorf("read during the failover: status %d: %s", rec.Code, rec.Body.String())
+				return
+			}
+		}
+	}()
+	body, _ := json.Marshal(Failover{DBPath: path})
+	expectStatus(t, serveTest(h, "POST", "/admin/failover", string(body), adminAuth...), http.StatusNoContent)
+	stop.Store(true)
+	wg.Wait()
+	t.Cleanup(func() { srv.DB().Close() })
+
+	rec := serveTest(h, "GET", "/drivers/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	var driver Driver
+	decodeBody(t, rec, &driver)
+	if driver.AverageRating != 0 {
+		t.Fatalf("driver 1 has average %v after the failover, want it read from the new database", driver.AverageRating)
+	}
+}
+
+// TestFailoverFlushesAggregates checks that the buffered aggregate changes
+// are applied to the database the ratings were written to, not the new one.
+func TestFailoverFlushesAggregates(t *testing.T) {
+	h := openTestDB(t, map[string]string{"ADMIN_TOKEN": "secret-token", "AGGREGATE_FLUSH_INTERVAL_MS": "60000"})
+	rateTest(t, h, "1", "a", 5)
+	path := failoverTarget(t)
+	body, _ := json.Marshal(Failover{DBPath: path})
+	expectStatus(t, serveTest(h, "POST", "/admin/failover", string(body), adminAuth...), http.StatusNoContent)
+	t.Cleanup(func() { srv.DB().Close() })
+	// Anything left would be flushed now.
+	aggregates.stop()
+	if sum, count := driverAggregates(t, "1"); sum != 0 || count != 0 {
+		t.Fatalf("driver 1 has %d stars in %d ratings in the new database, want none", sum, count)
+	}
+	prev, err := openDB(cfg.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prev.Close()
+	var sum, count int64
+	if err = prev.QueryRow("SELECT rating_sum, rating_count FROM drivers WHERE id = 1").Scan(&sum, &count); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 5 || count != 1 {
+		t.Fatalf("driver 1 has %d stars in %d ratings in the previous database, want 5 in 1", sum, count)
+	}
+}
+
+// TestFailoverResetsLazyAggregates checks that the lazy aggregates of the new
+// database are recomputed, whatever was stale in the previous one.
+func TestFailoverResetsLazyAggregates(t *testing.T) {
+	h := openTestDB(t, map[string]string{"ADMIN_TOKEN": "secret-token", "LAZY_AGGREGATES_TTL_MS": "60000"})
+	rateTest(t, h, "1", "a", 5)
+	// The new database has a rating its aggregates don't count yet, as the
+	// copy of a database with lazy aggregates may.
+	path := failoverTarget(t)
+	next, err := openDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = next.Exec("INSERT INTO driver_ratings (driver_id, user_id, rating) VALUES (2, 'b', 3)"); err != nil {
+		t.Fatal(err)
+	}
+	next.Close()
+	body, _ := json.Marshal(Failover{DBPath: path})
+	expectStatus(t, serveTest(h, "POST", "/admin/failover", string(body), adminAuth...), http.StatusNoContent)
+	t.Cleanup(func() { srv.DB().Close() })
+	if len(lazy.stale) != 0 {
+		t.Fatalf("%d drivers of the previous database are still stale", len(lazy.stale))
+	}
+	for id, want := range map[string][2]int64{"1": {0, 0}, "2": {3, 1}} {
+		if sum, count := driverAggregates(t, id); sum != want[0] || count != want[1] {
+			t.Errorf("driver %s has %d stars in %d ratings, want %d in %d", id, sum, count, want[0], want[1])
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ratingService is the rating service of proto/rating.proto, shared by the
+// REST handlers and the gRPC server: rating a driver, reading it and listing
+// the drivers and their ratings. Both parse their request into the input of
+// a method and write out what it returns, the rules live here.
+//
+// caller is the request the service tells the caller from, by its bearer
+// token and role. The gRPC server makes one out of the metadata of the call,
+// see rpcCaller.
+type ratingService struct{}
+
+var service ratingService
+
+// serviceError is an error the caller is told about, with the HTTP status it
+// stands for. writeServiceError and rpcError turn it into a response.
+type serviceError struct {
+	Status  int
+	Message string
+	// RetryAfter is when a rate limited caller can try again.
+	RetryAfter time.Duration
+}
+
+func (e *serviceError) Error() string {
+	return e.Message
+}
+
+// writeServiceError writes err, a serviceError or a paramError, as the
+// handlers write their errors. Anything else is an internal error.
+func writeServiceError(w http.ResponseWriter, err error) {
+	var perr *paramError
+	if errors.As(err, &perr) {
+		writeError(w, http.StatusBadRequest, perr.Error())
+		return
+	}
+	var serr *serviceError
+	if !errors.As(err, &serr) {
+		writeInternalError(w, err)
+		return
+	}
+	switch serr.Status {
+	case http.StatusUnauthorized:
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	case http.StatusTooManyRequests:
+		w.Header().Set("Retry-After", strconv.Itoa(int(serr.RetryAfter.Seconds())+1))
+	}
+	writeError(w, serr.Status, serr.Message)
+}
+
+// errSelfRating refuses the rating of a driver by the user owning it, see
+// Config.SelfRatingField.
+var errSelfRating = &serviceError{Status: http.StatusForbidden, Message: "drivers can't rate themselves"}
+
+// ratableDriver tells why the driver can't be rated, and otherwise returns
+// its owner when cfg.SelfRatingField is set. A rating alone and one of a
+// batch fail the same way.
+func ratableDriver(entityType, driverId string) (owner string, err error) {
+	state, err := store.DriverState(driverId)
+	if err != nil {
+		return "", err
+	}
+	if !state.Found {
+		return "", &serviceError{Status: http.StatusNotFound, Message: "driver not found"}
+	}
+	if state.EntityType != entityType {
+		// Like scopeEntity, for the callers without an entity route.
+		return "", &serviceError{Status: http.StatusNotFound, Message: entityType + " not found"}
+	}
+	if state.Deleted {
+		return "", &serviceError{Status: http.StatusGone, Message: "driver has been deleted"}
+	}
+	if state.Status == driverSuspended {
+		return "", &serviceError{Status: http.StatusConflict, Message: "driver is suspended"}
+	}
+	if cfg.SelfRatingField == "" {
+		return "", nil
+	}
+	return getDriverOwner(driverId)
+}
+
+// RateDriver submits the rating of rating.UserID, or of the subject of the
+// token of caller when tokens are verified, or of the user link was issued
+// to when it is not nil. It returns the id of the status to poll with
+// cfg.AsyncRatings, and "" otherwise.
+func (ratingService) RateDriver(caller *http.Request, rating Rating, link *ratingLink) (string, error) {
+	if !cfg.ratingWindow.contains(time.Now()) {
+		return "", &serviceError{Status: http.StatusForbidden, Message: fmt.Sprintf("ratings are only accepted during %s (%s)", cfg.RatingHours, cfg.RatingTimezone)}
+	}
+	var err error
+	if link != nil {
+		// A signed link is issued for one user, it stands in for the token.
+		rating.UserID = link.UserID
+	} else if identity != nil {
+		// The body can't be trusted to tell who is rating.
+		rating.UserID, err = identity.subject(caller)
+		if err != nil {
+			return "", &serviceError{Status: http.StatusUnauthorized, Message: "invalid token: " + err.Error()}
+		}
+	}
+	if err = validateRating(rating); err != nil {
+		return "", &serviceError{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	if userRateLimit != nil {
+		// The user is only known once the token, link or body is read, so
+		// this can't be a middleware like rateLimit.
+		if ok, retry := userRateLimit.take(rating.UserID, time.Now()); !ok {
+			return "", &serviceError{Status: http.StatusTooManyRequests, Message: "too many ratings from this user, try again later", RetryAfter: retry}
+		}
+	}
+	owner, err := ratableDriver(entityType(caller), rating.DriverID)
+	if err != nil {
+		return "", err
+	}
+	if owner != "" && owner == rating.UserID {
+		return "", errSelfRating
+	}
+	rating.UserID = storedUserID(rating.UserID)
+	if link != nil {
+		err = useRatingLink(link)
+		if err == errLinkUsed {
+			return "", &serviceError{Status: http.StatusConflict, Message: err.Error()}
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if ratingBuffer != nil {
+		if !cfg.AsyncRatings {
+			ratingBuffer.add(rating, "")
+			return "", nil
+		}
+		id, err := ratingStatuses.start()
+		if err != nil {
+			return "", err
+		}
+		ratingBuffer.add(rating, id)
+		return id, nil
+	}
+	return "", store.CreateOrUpdateRating(rating)
+}
+
+// GetDriver reads a driver that is not deleted, with its trust score when it
+// is an entity of entityType.
+func (ratingService) GetDriver(entityType, driverId string, opts driverRead) (*Driver, error) {
+	if err := checkAverage(opts.Average); err != nil {
+		return nil, err
+	}
+	// The default average leaves the read cached.
+	if opts.Average == cfg.AggFunction {
+		opts.Average = ""
+	}
+	driver, err := store.GetDriver(driverId, opts)
+	if err != nil {
+		return nil, err
+	}
+	if driver == nil {
+		return nil, &serviceError{Status: http.StatusNotFound, Message: "driver not found"}
+	}
+	if err = addTrustScores(entityType, driver); err != nil {
+		return nil, err
+	}
+	return driver, nil
+}
+
+// ListDrivers reads the drivers q selects, and counts them on all the pages
+// when total is set. The drivers are returned as stored, see showDrivers.
+func (ratingService) ListDrivers(q driverQuery, total bool) ([]Driver, int, error) {
+	if err := checkAverage(q.Average); err != nil {
+		return nil, 0, err
+	}
+	list, err := store.ListDrivers(q)
+	if err != nil {
+		return nil, 0, err
+	}
+	var count int
+	if total {
+		if count, err = countDrivers(q); err != nil {
+			return nil, 0, err
+		}
+	}
+	return list, count, nil
+}
+
+// showDrivers gets the entities of entityType of a page of ListDrivers ready
+// to be shown: their averages rounded as params asks and their trust scores
+// set. The extra drivers ListDrivers read to page through the list are left
+// out before, the rounded averages can't be paged from.
+func (ratingService) showDrivers(entityType string, list []Driver, params listParams) error {
+	roundAverages(list, params)
+	drivers := make([]*Driver, len(list))
+	for i := range list {
+		drivers[i] = &list[i]
+	}
+	return addTrustScores(entityType, drivers...)
+}
+
+// ListDriverRatings returns up to limit ratings of the driver selected by
+// filter, newest first and older than before when it is not nil, as caller
+// is allowed to see them.
+func (ratingService) ListDriverRatings(caller *http.Request, driverId string, filter ratingFilter, before *feedCursor, limit int) (*RatingsPage, error) {
+	page, err := getDriverRatingsPage(driverId, filter, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	if page.Total, err = countDriverRatings(driverId, filter); err != nil {
+		return nil, err
+	}
+	showRatings(caller, page.Ratings)
+	return page, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// track counts the requests being served, see Server.InFlight.
+func (s *Server) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// InFlight is the number of requests being served.
+func (s *Server) InFlight() int64 {
+	return s.inFlight.Load()
+}
+
+// Draining tells whether the server is shutting down, readyz then turns
+// traffic away.
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// serve listens on addr until SIGINT or SIGTERM, see serveUntil. A second
+// signal kills the process.
+func serve(addr string, handler http.Handler) error {
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-signals.Done()
+		stop()
+	}()
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return serveUntil(signals, ln, handler)
+}
+
+// serveUntil serves on ln until ctx is done, then stops accepting
+// connections and lets the requests in flight finish, along with the calls
+// of rpcServer. Requests still running after cfg.DrainTimeout have their
+// context cancelled and their connections closed, and are given up to
+// handlerExitTimeout to return. Queued ratings, pending aggregate changes and
+// rating events are flushed after that, before returning.
+func serveUntil(ctx context.Context, ln net.Listener, handler http.Handler) error {
+	base, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := &http.Server{
+		Handler:           srv.track(handler),
+		BaseContext:       func(net.Listener) context.Context { return base },
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- server.Serve(ln) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	srv.draining.Store(true)
+	log.Printf("shutdown: draining %d in-flight requests for up to %s", srv.InFlight(), cfg.DrainTimeout)
+	drain, cancelDrain := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+	defer cancelDrain()
+	go logDrain(drain)
+	rpcStopped := make(chan struct{})
+	go func() {
+		stopRPC(drain)
+		close(rpcStopped)
+	}()
+	err := server.Shutdown(drain)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("shutdown: drain timeout, cancelling %d requests", srv.InFlight())
+		cancel()
+		err = server.Close()
+	}
+	<-rpcStopped
+	// Close and Stop don't wait for the handlers, the cancelled ones may
+	// still be adding ratings and aggregate changes that would be lost once
+	// the buffers are stopped.
+	if !waitInFlight(handlerExitTimeout) {
+		log.Printf("shutdown: %d requests still running after %s, their writes may be lost", srv.InFlight(), handlerExitTimeout)
+	}
+	if ratingBuffer != nil {
+		ratingBuffer.stop()
+	}
+	if events != nil {
+		events.stop()
+	}
+	// Last, the ratings flushed above add to the aggregates.
+	if aggregates != nil {
+		aggregates.stop()
+	}
+	log.Println("shutdown: done")
+	return err
+}
+
+// handlerExitTimeout is how long serveUntil waits for the cancelled
+// requests to return.
+const handlerExitTimeout = 5 * time.Second
+
+// waitInFlight waits up to timeout for the requests and calls being served
+// to return, and tells whether they did.
+func waitInFlight(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for srv.InFlight() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// logDrain logs the number of requests left every second until ctx is done.
+func logDrain(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			log.Printf("shutdown: %d requests in flight", srv.InFlight())
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestShutdownDrainsSlowRequest(t *testing.T) {
+	openTestDB(t, map[string]string{"SHUTDOWN_DRAIN_TIMEOUT_MS": "2000"})
+	t.Cleanup(func() { srv.draining.Store(false) })
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		io.WriteString(w, "done")
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+	served := make(chan error, 1)
+	go func() { served <- serveUntil(ctx, ln, slow) }()
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String() + "/")
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		done <- result{res.StatusCode, string(body), err}
+	}()
+	eventually(t, func() bool { return srv.InFlight() == 1 })
+	start := time.Now()
+	shutdown()
+
+	select {
+	case res := <-done:
+		if res.err != nil || res.status != http.StatusOK || res.body != "done" {
+			t.Fatalf("slow request got %d %q, %v, want it served", res.status, res.body, res.err)
+		}
+	case <-time.After(cfg.DrainTimeout):
+		t.Fatal("the slow request didn't finish within the drain window")
+	}
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= cfg.DrainTimeout {
+		t.Fatalf("shutdown took %s, want it within the drain window of %s", elapsed, cfg.DrainTimeout)
+	}
+	if !srv.Draining() {
+		t.Fatal("the server isn't draining after the shutdown")
+	}
+}
+
+// TestShutdownWaitsForCancelledRequest checks that a request still running
+// after the drain window has its rating written before the buffers are
+// stopped, and counted in the aggregates.
+func TestShutdownWaitsForCancelledRequest(t *testing.T) {
+	openTestDB(t, map[string]string{
+		"SHUTDOWN_DRAIN_TIMEOUT_MS":   "100",
+		"RATING_BATCH_INTERVAL_MS":    "60000",
+		"AGGREGATE_FLUSH_INTERVAL_MS": "60000",
+	})
+	t.Cleanup(func() { srv.draining.Store(false) })
+	stuck := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		time.Sleep(200 * time.Millisecond)
+		ratingBuffer.add(Rating{DriverID: "1", UserID: "late", Rating: 5}, "")
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+	served := make(chan error, 1)
+	go func() { served <- serveUntil(ctx, ln, stuck) }()
+	go func() {
+		if res, err := http.Get("http://" + ln.Addr().String() + "/"); err == nil {
+			res.Body.Close()
+		}
+	}()
+	eventually(t, func() bool { return srv.InFlight() == 1 })
+	shutdown()
+	<-served
+	// serveUntil stopped it.
+	ratingBuffer = nil
+	if srv.InFlight() != 0 {
+		t.Fatalf("%d requests in flight after the shutdown, want none", srv.InFlight())
+	}
+	if _, count := driverAggregates(t, "1"); count != 1 {
+		t.Fatalf("driver 1 has %d ratings, want the one of the cancelled request", count)
+	}
+	select {
+	case <-aggregates.stopped:
+	default:
+		t.Fatal("the aggregate buffer still runs after the shutdown")
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+// avgSmart shrinks a recency weighted mean towards the prior of the bayesian
+// average, see smartAverage.
+const avgSmart = "smart"
+
+// smartAverage returns the SQL expression, with its arguments, of avg=smart
+// for the drivers in alias. Every rating weighs H / (H + age in days), with H
+// cfg.SmartHalfLifeDays: 1 when given right now, 1/2 when H days old and 1/4
+// at 3H. The weighted ratings are then pooled with cfg.PriorWeight ratings of
+// priorMean like in bayesianAverage:
+//
+//	(prior * PriorWeight + sum(w * rating)) / (PriorWeight + sum(w))
+//
+// so that drivers rated little, or only long ago, lean towards the prior. It
+// is the prior for unrated drivers, NULL when PriorWeight is 0 too.
+func smartAverage(alias string) (string, []interface{}) {
+	return smartRatingsAverage("driver_id = "+alias+".id", nil)
+}
+
+// smartRatingsAverage is smartAverage over the ratings filter selects, see
+// ratingsFilter.
+func smartRatingsAverage(filter string, args []interface{}) (string, []interface{}) {
+	halfLife := float64(cfg.SmartHalfLifeDays)
+	return `(SELECT (? * ? + COALESCE(SUM(w * rating), 0)) / (? + COALESCE(SUM(w), 0))
+      FROM (SELECT rating, ? / (? + MAX(julianday('now') - julianday(updated_at), 0)) AS w
+        FROM driver_ratings WHERE ` + filter + `))`,
+		append([]interface{}{priorMean(), cfg.PriorWeight, float64(cfg.PriorWeight), halfLife, halfLife}, args...)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSmartAverageBetweenPlainAndBayesian(t *testing.T) {
+	h := openTestDB(t, map[string]string{"PRIOR_MEAN": "3", "PRIOR_WEIGHT": "2", "SMART_HALF_LIFE_DAYS": "10"})
+	// Two recent 5s and two 3s given a half life ago, which weigh 1/2.
+	for user, stars := range map[string]int{"a": 5, "b": 5, "c": 3, "d": 3} {
+		rateTest(t, h, "1", user, stars)
+	}
+	old := time.Now().UTC().Add(-10 * 24 * time.Hour).Format(timeFormat)
+	execTest(t, "UPDATE driver_ratings SET updated_at = ? WHERE user_id IN ('c', 'd')", old)
+	average := func(avg string) float64 {
+		t.Helper()
+		rec := serveTest(h, "GET", "/drivers/1?avg="+avg, "")
+		expectStatus(t, rec, http.StatusOK)
+		var driver Driver
+		decodeBody(t, rec, &driver)
+		return driver.AverageRating
+	}
+	plain, bayesian, smart := average("mean"), average("bayesian"), average("smart")
+	// (3*2 + 5 + 5 + 3/2 + 3/2) / (2 + 1 + 1 + 1/2 + 1/2)
+	if math.Abs(smart-3.8) > 1e-3 {
+		t.Fatalf("smart average is %v, want 3.8", smart)
+	}
+	if !(bayesian < smart && smart < plain) {
+		t.Fatalf("smart average is %v, want it between the bayesian %v and the plain %v", smart, bayesian, plain)
+	}
+}
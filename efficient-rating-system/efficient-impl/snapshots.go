@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// snapshotInterval is how often the aggregates of every driver are copied
+// into driver_snapshots.
+const snapshotInterval = time.Hour
+
+// snapshotRetention is how long snapshots are kept, the largest since or
+// window the endpoints comparing with them accept.
+const snapshotRetention = 90 * 24 * time.Hour
+
+type ImprovedDriver struct {
+	ID              string  `json:"id"`
+	DriverInfo      string  `json:"driver_info"`
+	AverageRating   float64 `json:"avg_rating"`
+	PreviousAverage float64 `json:"previous_avg_rating"`
+	Delta           float64 `json:"delta"`
+}
+
+func getMostImprovedDrivers(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListParams(r, listOptions{Since: true})
+	if err == nil && params.Since == nil {
+		err = &paramError{"since", "is required"}
+	}
+	if err == nil && time.Since(*params.Since) > snapshotRetention {
+		err = &paramError{"since", "must be within the last 90 days, older snapshots are deleted"}
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	list, err := getMostImprovedDriversList(entityType(r), *params.Since)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(list)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// AtRiskDriver is a driver whose average fell below the threshold, WindowHigh
+// is its best average among the snapshots of the window.
+type AtRiskDriver struct {
+	ID            string  `json:"id"`
+	DriverInfo    string  `json:"driver_info"`
+	AverageRating float64 `json:"avg_rating"`
+	WindowHigh    float64 `json:"window_high_avg_rating"`
+}
+
+const defaultAtRiskWindow = "7d"
+
+func getAtRiskDrivers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	threshold, err := strconv.ParseFloat(query.Get("threshold"), 64)
+	if err != nil || threshold < minRating || threshold > maxRating {
+		writeError(w, http.StatusBadRequest, (&paramError{"threshold", "must be a number between 1 and 5"}).Error())
+		return
+	}
+	window := query.Get("window")
+	if window == "" {
+		window = defaultAtRiskWindow
+	}
+	d, err := parseWindow("window", window)
+	if err == nil && d > snapshotRetention {
+		err = &paramError{"window", "must be at most 90d, older snapshots are deleted"}
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	list, err := getAtRiskDriversList(entityType(r), threshold, time.Now().Add(-d))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	res, err := json.Marshal(list)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(res)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// snapshotLoop records driver aggregates once on startup and then every
+// snapshotInterval.
+func snapshotLoop() {
+	for {
+		if err := takeSnapshots(); err != nil {
+			log.Println("snapshot:", err)
+		}
+		time.Sleep(snapshotInterval)
+	}
+}
+
+// takeSnapshots records the aggregates of every driver and deletes the
+// snapshots older than snapshotRetention.
+func takeSnapshots() error {
+	tx, err := srv.DB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `INSERT INTO driver_snapshots (driver_id, rating_sum, rating_count, created_at)
+    SELECT id, rating_sum, rating_count, ? FROM drivers`
+	statement, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	now := time.Now().UTC()
+	if _, err = statement.Exec(now.Format(timeFormat)); err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM driver_snapshots WHERE created_at < ?", now.Add(-snapshotRetention).Format(timeFormat))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// getMostImprovedDriversList compares the current average of every entity
+// of entityType with the earliest snapshot taken since the given time, and
+// returns the ones whose average went up, biggest improvement first.
+func getMostImprovedDriversList(entityType string, since time.Time) ([]ImprovedDriver, error) {
+	row, err := srv.DB().Query(`SELECT d.id, d.driver_info,
+      CAST(d.rating_sum AS REAL)/d.rating_count AS avg_rating,
+      CAST(s.rating_sum AS REAL)/s.rating_count AS previous_avg_rating
+    FROM drivers d
+    JOIN driver_snapshots s ON s.rowid = (
+      SELECT s2.rowid FROM driver_snapshots s2
+      WHERE s2.driver_id = d.id AND s2.created_at >= ? AND s2.rating_count > 0
+      ORDER BY s2.created_at, s2.rowid LIMIT 1
+    )
+    WHERE d.rating_count > 0 AND d.deleted_at IS NULL AND d.entity_type = ?
+      AND CAST(d.rating_sum AS REAL)/d.rating_count > CAST(s.rating_sum AS REAL)/s.rating_count
+    ORDER BY avg_rating - previous_avg_rating DESC, `+tieBreak("d"), since.UTC().Format(timeFormat), entityType)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []ImprovedDriver{}
+	for row.Next() {
+		var driver ImprovedDriver
+		err = row.Scan(&driver.ID, &driver.DriverInfo, &driver.AverageRating, &driver.PreviousAverage)
+		if err != nil {
+			return nil, err
+		}
+		driver.Delta = driver.AverageRating - driver.PreviousAverage
+		list = append(list, driver)
+	}
+	return list, row.Err()
+}
+
+// getAtRiskDriversList returns the entities of entityType whose current
+// average is below threshold while at least one snapshot taken since the
+// given time was at or above it, i.e. the ones that crossed below the
+// threshold in the window. The biggest drop comes first.
+func getAtRiskDriversList(entityType string, threshold float64, since time.Time) ([]AtRiskDriver, error) {
+	row, err := srv.DB().Query(`SELECT d.id, d.driver_info,
+      CAST(d.rating_sum AS REAL)/d.rating_count AS avg_rating,
+      MAX(CAST(s.rating_sum AS REAL)/s.rating_count) AS window_high
+    FROM drivers d
+    JOIN driver_snapshots s ON s.driver_id = d.id AND s.created_at >= ? AND s.rating_count > 0
+    WHERE d.rating_count > 0 AND d.deleted_at IS NULL AND d.entity_type = ?
+      AND CAST(d.rating_sum AS REAL)/d.rating_count < ?
+    GROUP BY d.id
+    HAVING window_high >= ?
+    ORDER BY window_high - avg_rating DESC, `+tieBreak("d"), since.UTC().Format(timeFormat), entityType, threshold, threshold)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []AtRiskDriver{}
+	for row.Next() {
+		var driver AtRiskDriver
+		err = row.Scan(&driver.ID, &driver.DriverInfo, &driver.AverageRating, &driver.WindowHigh)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, driver)
+	}
+	return list, row.Err()
+}
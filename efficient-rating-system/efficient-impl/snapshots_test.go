@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestMostImprovedDrivers(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 2)
+	rateTest(t, h, "2", "a", 4)
+	if err := takeSnapshots(); err != nil {
+		t.Fatal(err)
+	}
+	// Driver 1 goes from 2 to 3.5, driver 2 from 4 to 4.5.
+	rateTest(t, h, "1", "b", 5)
+	rateTest(t, h, "2", "b", 5)
+	since := url.QueryEscape(time.Now().Add(-time.Hour).UTC().Format(time.RFC3339))
+	rec := serveTest(h, "GET", "/drivers/most-improved?since="+since, "")
+	expectStatus(t, rec, http.StatusOK)
+	var list []ImprovedDriver
+	decodeBody(t, rec, &list)
+	if len(list) != 2 || list[0].ID != "1" || list[0].Delta != 1.5 || list[1].ID != "2" {
+		t.Fatalf("most improved drivers are %+v, want 1 (+1.5) then 2", list)
+	}
+}
+
+func TestMostImprovedSinceBeyondRetention(t *testing.T) {
+	h := openTestDB(t, nil)
+	since := url.QueryEscape(time.Now().Add(-snapshotRetention - time.Hour).UTC().Format(time.RFC3339))
+	expectStatus(t, serveTest(h, "GET", "/drivers/most-improved?since="+since, ""), http.StatusBadRequest)
+}
+
+func TestTakeSnapshotsPrunesOldOnes(t *testing.T) {
+	openTestDB(t, nil)
+	old := time.Now().Add(-snapshotRetention - time.Hour).UTC().Format(timeFormat)
+	execTest(t, "INSERT INTO driver_snapshots (driver_id, rating_sum, rating_count, created_at) VALUES (1, 4, 1, ?)", old)
+	if err := takeSnapshots(); err != nil {
+		t.Fatal(err)
+	}
+	var stale, fresh int
+	err := srv.DB().QueryRow("SELECT COUNT(*) FILTER (WHERE created_at = ?), COUNT(*) FROM driver_snapshots", old).Scan(&stale, &fresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale != 0 || fresh != seedDriverCount {
+		t.Fatalf("%d old snapshots left and %d in all, want 0 and %d", stale, fresh, seedDriverCount)
+	}
+}
+
+func TestAtRiskDrivers(t *testing.T) {
+	h := openTestDB(t, nil)
+	days := func(n int) string { return time.Now().Add(-time.Duration(n) * 24 * time.Hour).UTC().Format(timeFormat) }
+	// Driver 1 fell from 4 to 2 within the window, driver 2 from 5 to 2
+	// before it and driver 3 was at 2 all along.
+	for _, s := range []struct {
+		driver   string
+		sum, age int
+	}{{"1", 4, 3}, {"1", 3, 1}, {"2", 5, 10}, {"3", 2, 3}} {
+		execTest(t, "INSERT INTO driver_snapshots (driver_id, rating_sum, rating_count, created_at) VALUES (?, ?, 1, ?)", s.driver, s.sum, days(s.age))
+	}
+	for _, driver := range []string{"1", "2", "3"} {
+		rateTest(t, h, driver, "a", 2)
+	}
+	rec := serveTest(h, "GET", "/drivers/at-risk?threshold=3.0&window=7d", "")
+	expectStatus(t, rec, http.StatusOK)
+	var list []AtRiskDriver
+	decodeBody(t, rec, &list)
+	if len(list) != 1 || list[0].ID != "1" || list[0].WindowHigh != 4 || list[0].AverageRating != 2 {
+		t.Fatalf("drivers at risk are %+v, want driver 1 down from 4 to 2", list)
+	}
+}
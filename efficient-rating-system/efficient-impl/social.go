@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const defaultSocialThreshold = 4
+
+// SocialQuery is the body of POST /users/{user_id}/social-recommendations.
+// Threshold is the average the group has to give a driver, 4 when omitted.
+type SocialQuery struct {
+	FriendIDs []string `json:"friend_ids"`
+	Threshold *float64 `json:"threshold"`
+}
+
+// SocialRecommendation is a driver with the average the group gave it and how
+// many of the group rated it.
+type SocialRecommendation struct {
+	ID           string  `json:"id"`
+	DriverInfo   string  `json:"driver_info"`
+	GroupAverage float64 `json:"group_avg"`
+	GroupRatings int     `json:"group_ratings"`
+}
+
+func getSocialRecommendations(w http.ResponseWriter, r *http.Request) {
+	userId := mux.Vars(r)["user_id"]
+	var query SocialQuery
+	err := json.NewDecoder(r.Body).Decode(&query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if len(query.FriendIDs) == 0 || len(query.FriendIDs) >= maxCohortSize {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("friend_ids must have between 1 and %d items", maxCohortSize-1))
+		return
+	}
+	threshold := float64(defaultSocialThreshold)
+	if query.Threshold != nil {
+		threshold = *query.Threshold
+	}
+	if threshold < minRating || threshold > maxRating {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("threshold must be between %d and %d", minRating, maxRating))
+		return
+	}
+	group := storedUserIDs(append([]string{userId}, query.FriendIDs...))
+	list, err := getSocialRecommendationsList(group, threshold)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(list)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getSocialRecommendationsList returns the drivers the users of the group
+// rated at least threshold on average, the best first. Only the ratings of the
+// group count, the user's own included.
+func getSocialRecommendationsList(group []string, threshold float64) ([]SocialRecommendation, error) {
+	args := make([]interface{}, len(group), len(group)+1)
+	for i, id := range group {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(group)), ", ")
+	row, err := srv.DB().Query(`SELECT d.id, d.driver_info, AVG(r.rating) AS group_avg, COUNT(*) AS group_ratings
+    FROM driver_ratings r
+    JOIN drivers d ON d.id = r.driver_id
+    WHERE d.deleted_at IS NULL AND r.user_id IN (`+placeholders+`)
+    GROUP BY d.id
+    HAVING group_avg >= ?
+    ORDER BY group_avg DESC, group_ratings DESC, d.id`, append(args, threshold)...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []SocialRecommendation{}
+	for row.Next() {
+		var rec SocialRecommendation
+		err = row.Scan(&rec.ID, &rec.DriverInfo, &rec.GroupAverage, &rec.GroupRatings)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rec)
+	}
+	return list, row.Err()
+}
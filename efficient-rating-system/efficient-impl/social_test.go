@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestSocialRecommendations(t *testing.T) {
+	h := openTestDB(t, nil)
+	for _, r := range []struct {
+		driver, user string
+		stars        int
+	}{
+		// Driver 1 gets 5 and 4 from the group, driver 2 averages 3.5 in the
+		// group, driver 3 is only loved by a stranger and driver 4 by one
+		// friend.
+		{"1", "me", 5}, {"1", "ann", 4},
+		{"2", "me", 2}, {"2", "bob", 5},
+		{"3", "eve", 5}, {"3", "me", 1},
+		{"4", "bob", 5},
+	} {
+		rateTest(t, h, r.driver, r.user, r.stars)
+	}
+	rec := serveTest(h, "POST", "/users/me/social-recommendations", `{"friend_ids": ["ann", "bob"], "threshold": 4}`)
+	expectStatus(t, rec, http.StatusOK)
+	var list []SocialRecommendation
+	decodeBody(t, rec, &list)
+	got := []string{}
+	for _, d := range list {
+		got = append(got, fmt.Sprintf("%s:%v/%d", d.ID, d.GroupAverage, d.GroupRatings))
+	}
+	if fmt.Sprint(got) != "[4:5/1 1:4.5/2]" {
+		t.Fatalf("recommended %v, want driver 4 at 5 then driver 1 at 4.5", got)
+	}
+	expectStatus(t, serveTest(h, "POST", "/users/me/social-recommendations", `{"threshold": 9}`), http.StatusBadRequest)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// unknownSource groups the ratings submitted without a source.
+const unknownSource = "unknown"
+
+// SourceBreakdown counts the ratings of a driver per source, every
+// configured source is listed even when it has no ratings.
+type SourceBreakdown struct {
+	DriverID string         `json:"driver_id"`
+	Sources  map[string]int `json:"sources"`
+}
+
+func getDriverSources(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	driverId := params["driver_id"]
+	breakdown, err := getDriverSourceBreakdown(driverId)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(breakdown)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func getDriverSourceBreakdown(driverId string) (*SourceBreakdown, error) {
+	breakdown := &SourceBreakdown{DriverID: driverId, Sources: map[string]int{}}
+	for _, source := range cfg.RatingSources {
+		breakdown.Sources[source] = 0
+	}
+	row, err := srv.DB().Query("SELECT COALESCE(source, ?), COUNT(*) FROM driver_ratings WHERE driver_id = ? GROUP BY source",
+		unknownSource, driverId)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	for row.Next() {
+		var source string
+		var count int
+		err = row.Scan(&source, &count)
+		if err != nil {
+			return nil, err
+		}
+		breakdown.Sources[source] = count
+	}
+	return breakdown, row.Err()
+}
+
+// GroupAverage is the average of the ratings of a driver from one source or
+// region, AverageRating is null when the group has no ratings.
+type GroupAverage struct {
+	Count         int      `json:"count"`
+	AverageRating *float64 `json:"avg_rating"`
+}
+
+// getDriverSourceAverages averages the ratings of a driver per source, like
+// getDriverSourceBreakdown every configured source is listed. The rating of
+// excludeUser, if any, is left out.
+func getDriverSourceAverages(driverId, excludeUser string) (map[string]GroupAverage, error) {
+	return getDriverGroupAverages(driverId, "source", cfg.RatingSources, excludeUser)
+}
+
+// getDriverGroupAverages averages the ratings of a driver per value of the
+// given driver_ratings column, listing every one of groups. Ratings without
+// a value are grouped under unknownSource.
+func getDriverGroupAverages(driverId, column string, groups []string, excludeUser string) (map[string]GroupAverage, error) {
+	averages := map[string]GroupAverage{}
+	for _, group := range groups {
+		averages[group] = GroupAverage{}
+	}
+	row, err := srv.DB().Query("SELECT COALESCE("+column+", ?), COUNT(*), AVG(rating) FROM driver_ratings WHERE driver_id = ? AND user_id IS NOT ? GROUP BY "+column,
+		unknownSource, driverId, nullString(excludeUser))
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	for row.Next() {
+		var group string
+		var average GroupAverage
+		err = row.Scan(&group, &average.Count, &average.AverageRating)
+		if err != nil {
+			return nil, err
+		}
+		averages[group] = average
+	}
+	return averages, row.Err()
+}
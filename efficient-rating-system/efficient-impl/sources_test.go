@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestDriverSources(t *testing.T) {
+	h := openTestDB(t, nil)
+	for user, source := range map[string]string{"a": "app", "b": "app", "c": "web"} {
+		body := fmt.Sprintf(`{"user_id": %q, "rating": 4, "source": %q}`, user, source)
+		expectStatus(t, serveTest(h, "POST", "/drivers/1/ratings", body), http.StatusOK)
+	}
+	rateTest(t, h, "1", "d", 4)
+	expectStatus(t, serveTest(h, "POST", "/drivers/1/ratings", `{"user_id": "e", "rating": 4, "source": "fax"}`), http.StatusBadRequest)
+	rec := serveTest(h, "GET", "/drivers/1/sources", "")
+	expectStatus(t, rec, http.StatusOK)
+	var breakdown SourceBreakdown
+	decodeBody(t, rec, &breakdown)
+	want := "map[app:2 sms:0 unknown:1 web:1]"
+	if got := fmt.Sprint(breakdown.Sources); got != want {
+		t.Fatalf("sources are %s, want %s", got, want)
+	}
+}
+
+func TestDriverAverageBySource(t *testing.T) {
+	h := openTestDB(t, nil)
+	for _, r := range []struct {
+		user, source string
+		stars        int
+	}{{"a", "app", 5}, {"b", "app", 2}, {"c", "web", 4}} {
+		body := fmt.Sprintf(`{"user_id": %q, "rating": %d, "source": %q}`, r.user, r.stars, r.source)
+		expectStatus(t, serveTest(h, "POST", "/drivers/1/ratings", body), http.StatusOK)
+	}
+	rec := serveTest(h, "GET", "/drivers/1?breakdown=source", "")
+	expectStatus(t, rec, http.StatusOK)
+	var driver Driver
+	decodeBody(t, rec, &driver)
+	want := map[string]*float64{"app": floatPtr(3.5), "web": floatPtr(4), "sms": nil}
+	for source, avg := range want {
+		got, ok := driver.Sources[source]
+		if !ok || (got.AverageRating == nil) != (avg == nil) || avg != nil && *got.AverageRating != *avg {
+			t.Fatalf("average of %s is %+v, want %v", source, got, avg)
+		}
+	}
+	if driver.Sources["sms"].Count != 0 || driver.Sources["app"].Count != 2 {
+		t.Fatalf("source counts are %+v", driver.Sources)
+	}
+}
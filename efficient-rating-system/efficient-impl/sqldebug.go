@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// openDB opens the SQLite file at path. With cfg.SQLDebug every statement is
+// logged through a wrapper around the driver, without it the driver is used
+// as it is and the logging costs nothing.
+func openDB(path string) (*sql.DB, error) {
+	db, err := sql.Open(sqliteDriver, sqliteDSN(path))
+	if err != nil || !cfg.SQLDebug {
+		return db, err
+	}
+	drv := db.Driver()
+	db.Close()
+	return sql.OpenDB(debugConnector{drv: drv, dsn: sqliteDSN(path)}), nil
+}
+
+// logStatement logs a statement with its arguments. Strings and blobs are
+// redacted, they carry user ids, comments, driver info and link nonces.
+func logStatement(query string, args []driver.NamedValue, err error) {
+	shown := make([]string, len(args))
+	for i, arg := range args {
+		switch v := arg.Value.(type) {
+		case string:
+			shown[i] = fmt.Sprintf("[REDACTED %d bytes]", len(v))
+		case []byte:
+			shown[i] = fmt.Sprintf("[REDACTED %d bytes]", len(v))
+		default:
+			shown[i] = fmt.Sprint(v)
+		}
+	}
+	status := "ok"
+	if err != nil {
+		status = err.Error()
+	}
+	log.Printf("sql: %s [%s] %s", strings.Join(strings.Fields(query), " "), strings.Join(shown, ", "), status)
+}
+
+type debugConnector struct {
+	drv driver.Driver
+	dsn string
+}
+
+func (c debugConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.drv.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return debugConn{conn}, nil
+}
+
+func (c debugConnector) Driver() driver.Driver { return c.drv }
+
+// debugConn hands out statements that log themselves. It implements neither
+// ExecerContext nor QueryerContext, so database/sql prepares every query.
+type debugConn struct {
+	driver.Conn
+}
+
+func (c debugConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c debugConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		logStatement(query, nil, err)
+		return nil, err
+	}
+	return debugStmt{Stmt: stmt, query: query}, nil
+}
+
+func (c debugConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c debugConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+type debugStmt struct {
+	driver.Stmt
+	query string
+}
+
+func (s debugStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	var res driver.Result
+	var err error
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		res, err = e.ExecContext(ctx, args)
+	} else {
+		res, err = s.Stmt.Exec(namedValues(args))
+	}
+	logStatement(s.query, args, err)
+	return res, err
+}
+
+func (s debugStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	var rows driver.Rows
+	var err error
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = q.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Stmt.Query(namedValues(args))
+	}
+	logStatement(s.query, args, err)
+	return rows, err
+}
+
+func namedValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
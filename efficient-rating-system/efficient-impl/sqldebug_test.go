@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// lockedBuffer is a log output that can be read while goroutines log.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSQLDebugLogsRatingStatements(t *testing.T) {
+	for _, debug := range []bool{true, false} {
+		env := map[string]string{}
+		if debug {
+			env["SQL_DEBUG"] = "true"
+		}
+		h := openTestDB(t, env)
+		var out lockedBuffer
+		log.SetOutput(&out)
+		rateTest(t, h, "1", "secret-user", 4)
+		logged := out.String()
+		wrote := strings.Contains(logged, "sql: INSERT INTO driver_ratings") && strings.Contains(logged, "sql: UPDATE drivers")
+		if wrote != debug {
+			t.Fatalf("with SQL_DEBUG=%v the rating logged:\n%s", debug, logged)
+		}
+		if strings.Contains(logged, "secret-user") || debug && !strings.Contains(logged, "[REDACTED 11 bytes]") {
+			t.Fatalf("the user id wasn't redacted:\n%s", logged)
+		}
+	}
+}
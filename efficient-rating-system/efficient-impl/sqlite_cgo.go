@@ -0,0 +1,27 @@
+//go:build cgo
+
+package main
+
+import (
+	"database/sql"
+	"math"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriver is go-sqlite3 with the exp function the decayed average
+// needs, go-sqlite3 is built without the math functions of SQLite.
+const sqliteDriver = "sqlite3_exp"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("exp", math.Exp, true)
+		},
+	})
+}
+
+// sqliteDSN returns the data source name for the SQLite file at path.
+func sqliteDSN(path string) string {
+	return "file:" + path + "?_busy_timeout=5000"
+}
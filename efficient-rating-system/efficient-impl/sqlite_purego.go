@@ -0,0 +1,16 @@
+//go:build !cgo
+
+package main
+
+import (
+	_ "modernc.org/sqlite" // Pure Go SQLite, used when CGO is not available
+)
+
+// sqliteDriver is the database/sql driver name registered by modernc.org/sqlite.
+const sqliteDriver = "sqlite"
+
+// sqliteDSN returns the data source name for the SQLite file at path,
+// modernc.org/sqlite takes pragmas as _pragma parameters.
+func sqliteDSN(path string) string {
+	return "file:" + path + "?_pragma=busy_timeout(5000)"
+}
@@ -0,0 +1,26 @@
+//go:build !cgo
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestPureGoSQLite runs with CGO_ENABLED=0 go test, the service must work on
+// modernc.org/sqlite alone.
+func TestPureGoSQLite(t *testing.T) {
+	h := openTestDB(t, nil)
+	if driver := fmt.Sprintf("%T", srv.DB().Driver()); driver != "*sqlite.Driver" {
+		t.Fatalf("the database is opened with %s, want modernc.org/sqlite", driver)
+	}
+	var version string
+	if err := srv.DB().QueryRow("SELECT sqlite_version()").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	rateTest(t, h, "1", "a", 5)
+	rateTest(t, h, "1", "b", 2)
+	if sum, count := driverAggregates(t, "1"); sum != 7 || count != 2 {
+		t.Fatalf("aggregates are sum %d count %d on SQLite %s, want 7 and 2", sum, count, version)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// NextStar tells how many maxRating ratings it takes for the rounded average
+// of a driver to reach NextStar. At the top star NextStar stays at Star and
+// AtMax is set.
+type NextStar struct {
+	DriverID      string  `json:"driver_id"`
+	AverageRating float64 `json:"avg_rating"`
+	Star          int     `json:"star"`
+	NextStar      int     `json:"next_star"`
+	RatingsNeeded int     `json:"ratings_needed"`
+	AtMax         bool    `json:"at_max"`
+}
+
+func getDriverToNextStar(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	driverId := params["driver_id"]
+	var sum, count int64
+	err := srv.DB().QueryRow("SELECT rating_sum, rating_count FROM drivers WHERE id = ? AND deleted_at IS NULL AND entity_type = ?", driverId, entityType(r)).Scan(&sum, &count)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "driver not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	next := toNextStar(sum, count)
+	next.DriverID = driverId
+	d, err := json.Marshal(next)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// toNextStar computes the smallest k such that (sum + k*maxRating) / (count
+// + k) rounds to the next star. The average rounds half away from zero, so
+// the next star s is reached at s - 0.5; with both sides doubled that is
+//
+//	2*(sum + k*max) >= (2s - 1)*(count + k)
+//
+// which keeps the computation in integers. An unrated driver is one rating
+// away from the top star.
+func toNextStar(sum, count int64) NextStar {
+	if count == 0 {
+		return NextStar{NextStar: maxRating, RatingsNeeded: 1}
+	}
+	avg := float64(sum) / float64(count)
+	star := int(math.Round(avg))
+	next := NextStar{AverageRating: avg, Star: star}
+	if star >= maxRating {
+		next.NextStar, next.AtMax = star, true
+		return next
+	}
+	next.NextStar = star + 1
+	threshold := int64(2*next.NextStar - 1)
+	num := threshold*count - 2*sum
+	den := 2*maxRating - threshold
+	next.RatingsNeeded = int((num + den - 1) / den)
+	return next
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+)
+
+// TestToNextStar checks toNextStar against adding ratings of maxRating one
+// at a time until the rounded average goes up.
+func TestToNextStar(t *testing.T) {
+	for count := int64(1); count <= 20; count++ {
+		for sum := count * minRating; sum <= count*maxRating; sum++ {
+			next := toNextStar(sum, count)
+			star := int(math.Round(float64(sum) / float64(count)))
+			if star == maxRating {
+				if !next.AtMax || next.RatingsNeeded != 0 {
+					t.Fatalf("sum %d count %d: %+v, want at max", sum, count, next)
+				}
+				continue
+			}
+			k := int64(0)
+			for int(math.Round(float64(sum+k*maxRating)/float64(count+k))) == star {
+				k++
+			}
+			if next.NextStar != star+1 || next.RatingsNeeded != int(k) {
+				t.Fatalf("sum %d count %d: %+v, want %d ratings to %d stars", sum, count, next, k, star+1)
+			}
+		}
+	}
+}
+
+func TestDriverToNextStar(t *testing.T) {
+	h := openTestDB(t, nil)
+	execTest(t, "UPDATE drivers SET rating_sum = 10, rating_count = 10 WHERE id = 1")
+	rec := serveTest(h, "GET", "/drivers/1/to-next-star", "")
+	expectStatus(t, rec, http.StatusOK)
+	var next NextStar
+	decodeBody(t, rec, &next)
+	// (10 + 2*5) / 12 rounds to 2, (10 + 5) / 11 doesn't.
+	if next.DriverID != "1" || next.Star != 1 || next.NextStar != 2 || next.RatingsNeeded != 2 {
+		t.Fatalf("to the next star: %+v, want 2 ratings from 1 to 2 stars", next)
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers/999/to-next-star", ""), http.StatusNotFound)
+}
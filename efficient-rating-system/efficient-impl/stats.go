@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type PlatformStats struct {
+	ExcludedDriverID string  `json:"excluded_driver_id,omitempty"`
+	AverageRating    float64 `json:"avg_rating"`
+	RatingCount      int64   `json:"rating_count"`
+}
+
+func getStatsExcludingDriver(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	driverId := params["driver_id"]
+	stats, err := getPlatformStatsExcluding(entityType(r), driverId)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(stats)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getPlatformStatsExcluding returns the average over all ratings of all
+// entities of entityType as if the given driver and its ratings didn't
+// exist. It only reads the stored aggregates, nothing is written.
+func getPlatformStatsExcluding(entityType, driverId string) (*PlatformStats, error) {
+	stats := &PlatformStats{ExcludedDriverID: driverId}
+	err := srv.DB().QueryRow(`SELECT COALESCE(CAST(SUM(rating_sum) AS REAL)/SUM(rating_count), 0), COALESCE(SUM(rating_count), 0)
+    FROM drivers WHERE id != ? AND deleted_at IS NULL AND entity_type = ?`, driverId, entityType).Scan(&stats.AverageRating, &stats.RatingCount)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
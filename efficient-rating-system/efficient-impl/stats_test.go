@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestStatsExcludingDriver(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 1)
+	rateTest(t, h, "1", "b", 1)
+	rateTest(t, h, "2", "a", 5)
+	rateTest(t, h, "3", "a", 4)
+	rateTest(t, h, "3", "b", 2)
+	rec := serveTest(h, "GET", "/stats/exclude/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	var stats PlatformStats
+	decodeBody(t, rec, &stats)
+	// Without driver 1: (5 + 4 + 2) / 3.
+	if stats.ExcludedDriverID != "1" || stats.RatingCount != 3 || stats.AverageRating != 11.0/3 {
+		t.Fatalf("stats are %+v, want 3 ratings averaging %v", stats, 11.0/3)
+	}
+	var count int
+	if err := srv.DB().QueryRow("SELECT rating_count FROM drivers WHERE id = 1").Scan(&count); err != nil || count != 2 {
+		t.Fatalf("driver 1 has %d ratings (%v) after the preview, want 2", count, err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	driverActive    = "active"
+	driverSuspended = "suspended"
+)
+
+// DriverStatus is the body and the response of PUT
+// /admin/drivers/{driver_id}/status. A suspended driver is still listed and
+// can be read as usual, it only doesn't take new ratings.
+type DriverStatus struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+func setDriverStatus(w http.ResponseWriter, r *http.Request) {
+	driverId := mux.Vars(r)["driver_id"]
+	var input DriverStatus
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil || input.Status != driverActive && input.Status != driverSuspended {
+		writeError(w, http.StatusBadRequest, `body must be {"status": "active"} or {"status": "suspended"}`)
+		return
+	}
+	found, err := updateDriverStatus(driverId, input.Status, requestActor(r))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "driver not found")
+		return
+	}
+	d, err := json.Marshal(DriverStatus{ID: driverId, Status: input.Status})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// updateDriverStatus sets the status of a driver that is not deleted. It
+// returns false when there is no such driver.
+func updateDriverStatus(driverId, status, actor string) (bool, error) {
+	tx, err := srv.DB().Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE drivers SET status = ? WHERE id = ? AND deleted_at IS NULL", status, driverId)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	if err = recordAudit(tx, actor, "update", "driver", driverId, map[string]interface{}{"status": status}); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSuspendedDriver(t *testing.T) {
+	h := openTestDB(t, adminEnv)
+	rateTest(t, h, "3", "a", 4)
+	rec := serveTest(h, "PUT", "/admin/drivers/3/status", `{"status": "suspended"}`, adminAuth...)
+	expectStatus(t, rec, http.StatusOK)
+	if got := rec.Body.String(); got != `{"id":"3","status":"suspended"}` {
+		t.Fatalf("response is %s", got)
+	}
+	expectStatus(t, serveTest(h, "POST", "/drivers/3/ratings", `{"user_id": "b", "rating": 1}`), http.StatusConflict)
+	if sum, count := driverAggregates(t, "3"); sum != 4 || count != 1 {
+		t.Fatalf("aggregates are sum %d count %d, want the rating rejected", sum, count)
+	}
+	rec = serveTest(h, "GET", "/drivers/3", "")
+	expectStatus(t, rec, http.StatusOK)
+	var driver Driver
+	decodeBody(t, rec, &driver)
+	if driver.AverageRating != 4 {
+		t.Fatalf("the suspended driver reads %+v", driver)
+	}
+	rec = serveTest(h, "GET", "/drivers?limit=3&offset=2", "")
+	expectStatus(t, rec, http.StatusOK)
+	var list []Driver
+	decodeBody(t, rec, &list)
+	if len(list) == 0 || list[0].ID != "3" {
+		t.Fatalf("the suspended driver isn't listed: %+v", list)
+	}
+	expectStatus(t, serveTest(h, "PUT", "/admin/drivers/3/status", `{"status": "active"}`, adminAuth...), http.StatusOK)
+	rateTest(t, h, "3", "b", 1)
+	expectStatus(t, serveTest(h, "PUT", "/admin/drivers/3/status", `{"status": "gone"}`, adminAuth...), http.StatusBadRequest)
+	expectStatus(t, serveTest(h, "PUT", "/admin/drivers/404/status", `{"status": "active"}`, adminAuth...), http.StatusNotFound)
+}
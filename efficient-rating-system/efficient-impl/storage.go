@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Storage is what the core routes need from the database: drivers, their
+// ratings and the aggregates kept along with them. The handlers of those
+// routes go through store rather than srv.DB(), so that they run on any
+// backend. The other features are written in SQLite's dialect and query
+// srv.DB() directly, they are only served on sqliteStorage.
+type Storage interface {
+	// CreateDriver adds a driver, or another entity of type entity, without
+	// ratings. A driver with the same key, when not empty, is returned
+	// instead with created set to false.
+	CreateDriver(key, driverInfo, entity, actor string) (driver *Driver, created bool, err error)
+	// DriverState tells whether the driver exists, of which entity type,
+	// whether it is deleted and whether it can be rated.
+	DriverState(driverId string) (driverState, error)
+	// GetDriver returns nil when the driver does not exist or is deleted.
+	GetDriver(driverId string, opts driverRead) (*Driver, error)
+	// ListDrivers returns the page of the drivers that are not deleted
+	// selected by q.
+	ListDrivers(q driverQuery) ([]Driver, error)
+	// CreateOrUpdateRating writes the rating and the aggregates of the
+	// driver in one transaction.
+	CreateOrUpdateRating(rating Rating) error
+	// GetRating returns nil when the user didn't rate the driver.
+	GetRating(driverId, userId string) (*Rating, error)
+	// ListDriverRatings returns at most limit ratings of the driver selected
+	// by filter.
+	ListDriverRatings(driverId string, filter ratingFilter, limit int) ([]Rating, error)
+	// DeleteRating takes the rating out of the aggregates of the driver, it
+	// returns false when there was no such rating.
+	DeleteRating(driverId, userId, actor string) (bool, error)
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// driverState is what rating a driver depends on.
+type driverState struct {
+	Found      bool
+	Deleted    bool
+	Status     string
+	EntityType string
+}
+
+// errNotSupported is returned by a Storage asked for a feature only the
+// SQLite storage has.
+var errNotSupported = errors.New("not supported by this database, it needs DB_DRIVER=sqlite")
+
+// store is the storage of the core routes, sqliteStorage unless DB_DRIVER
+// says otherwise.
+var store Storage = sqliteStorage{}
+
+// openStorage opens the storage cfg.DBDriver names. The SQLite one is
+// opened by main, it goes through srv for the failover.
+func openStorage() (Storage, error) {
+	switch cfg.DBDriver {
+	case dbDriverPostgres:
+		return openPostgres(cfg.DBDSN)
+	case dbDriverMySQL:
+		return openMySQL(cfg.DBDSN)
+	}
+	return sqliteStorage{}, nil
+}
+
+// sqliteStorage is the Storage of the SQLite database srv points at, with
+// every feature of the service: the write buffer, the coalesced and lazy
+// aggregates, the cache, the audit log and the events.
+type sqliteStorage struct{}
+
+func (sqliteStorage) CreateDriver(key, driverInfo, entity, actor string) (*Driver, bool, error) {
+	return insertDriver(key, driverInfo, entity, actor)
+}
+
+func (sqliteStorage) DriverState(driverId string) (driverState, error) {
+	state := driverState{Found: true}
+	err := srv.DB().QueryRow("SELECT deleted_at IS NOT NULL, status, entity_type FROM drivers WHERE id = ?", driverId).Scan(&state.Deleted, &state.Status, &state.EntityType)
+	if err == sql.ErrNoRows {
+		return driverState{}, nil
+	}
+	return state, err
+}
+
+func (sqliteStorage) GetDriver(driverId string, opts driverRead) (*Driver, error) {
+	return getDriverByID(driverId, opts)
+}
+
+func (sqliteStorage) ListDrivers(q driverQuery) ([]Driver, error) {
+	return getDriversList(q)
+}
+
+func (sqliteStorage) CreateOrUpdateRating(rating Rating) error {
+	return createOrUpdateRating(rating)
+}
+
+func (sqliteStorage) GetRating(driverId, userId string) (*Rating, error) {
+	return getRating(srv.DB(), driverId, userId)
+}
+
+func (sqliteStorage) ListDriverRatings(driverId string, filter ratingFilter, limit int) ([]Rating, error) {
+	return getDriverRatingsList(driverId, filter, limit)
+}
+
+func (sqliteStorage) DeleteRating(driverId, userId, actor string) (bool, error) {
+	return removeRating(driverId, userId, actor)
+}
+
+func (sqliteStorage) Ping(ctx context.Context) error {
+	return srv.DB().PingContext(ctx)
+}
+
+// Close leaves the database to main, which closes whichever one srv points
+// at last.
+func (sqliteStorage) Close() error {
+	return nil
+}
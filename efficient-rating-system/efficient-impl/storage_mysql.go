@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql" // MySQL driver of DB_DRIVER=mysql
+)
+
+// mysqlSchema is applied by openMySQL on every start, it only creates what
+// is missing.
+//
+//go:embed mysql/schema.sql
+var mysqlSchema string
+
+// mysqlStorage is the Storage of DB_DRIVER=mysql, it works like
+// postgresStorage. MySQL has neither RETURNING nor sequences: the upsert
+// reads prev_rating back in its transaction, the integer ids come from the
+// driver_ids table.
+type mysqlStorage struct {
+	db *sql.DB
+}
+
+// openMySQL opens the database of dsn, in the go-sql-driver format
+// (user:password@tcp(host:3306)/db). Its connections read and write UTC,
+// whatever dsn says.
+func openMySQL(dsn string) (*mysqlStorage, error) {
+	c, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	c.ParseTime = true
+	c.Loc = time.UTC
+	if c.Params == nil {
+		c.Params = map[string]string{}
+	}
+	c.Params["time_zone"] = "'+00:00'"
+	connector, err := mysql.NewConnector(c)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(connector)
+	// The driver runs one statement per Exec.
+	for _, statement := range strings.Split(mysqlSchema, ";") {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+		if _, err = db.Exec(statement); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &mysqlStorage{db: db}, nil
+}
+
+// myAverage is the mean of the drivers in alias, NULL when unrated. The
+// float factor keeps MySQL from rounding a decimal division.
+func myAverage(alias string) string {
+	return alias + ".rating_sum * 1E0 / NULLIF(" + alias + ".rating_count, 0)"
+}
+
+func (s *mysqlStorage) CreateDriver(key, driverInfo, entity, actor string) (*Driver, bool, error) {
+	id, err := newDriverID()
+	if err != nil {
+		return nil, false, err
+	}
+	if !id.Valid {
+		res, err := s.db.Exec("INSERT INTO driver_ids () VALUES ()")
+		if err != nil {
+			return nil, false, err
+		}
+		n, err := res.LastInsertId()
+		if err != nil {
+			return nil, false, err
+		}
+		id = sql.NullString{String: strconv.FormatInt(n, 10), Valid: true}
+	}
+	// The no-op update of a taken key affects no row.
+	res, err := s.db.Exec(`INSERT INTO drivers (id, driver_info, client_key, entity_type)
+    VALUES (?, ?, ?, ?)
+    ON DUPLICATE KEY UPDATE id = id`, id.String, driverInfo, nullString(key), entity)
+	if err != nil {
+		return nil, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+	if n == 1 {
+		return &Driver{ID: id.String, DriverInfo: driverInfo}, true, nil
+	}
+	// The key is taken, the driver made with it is returned.
+	driver := &Driver{}
+	err = s.db.QueryRow(`SELECT id, driver_info, COALESCE(`+myAverage("d")+`, 0) FROM drivers d WHERE client_key = ?`,
+		key).Scan(&driver.ID, &driver.DriverInfo, &driver.AverageRating)
+	if err != nil {
+		return nil, false, err
+	}
+	return driver, false, nil
+}
+
+func (s *mysqlStorage) DriverState(driverId string) (driverState, error) {
+	state := driverState{Found: true}
+	err := s.db.QueryRow("SELECT deleted_at IS NOT NULL, status, entity_type FROM drivers WHERE id = ?", driverId).Scan(&state.Deleted, &state.Status, &state.EntityType)
+	if err == sql.ErrNoRows {
+		return driverState{}, nil
+	}
+	return state, err
+}
+
+// GetDriver supports the same options as postgresStorage.GetDriver.
+func (s *mysqlStorage) GetDriver(driverId string, opts driverRead) (*Driver, error) {
+	if opts.ExcludeUser != "" || opts.Since != nil || opts.Average != "" && opts.Average != avgMean {
+		return nil, errNotSupported
+	}
+	var driver Driver
+	var count int
+	var userRating sql.NullInt64
+	err := s.db.QueryRow(`SELECT d.id, d.driver_info, COALESCE(`+myAverage("d")+`, 0), d.rating_count, ur.rating
+    FROM drivers d
+    LEFT JOIN driver_ratings ur ON ur.driver_id = d.id AND ur.user_id = ?
+    WHERE d.id = ? AND d.deleted_at IS NULL`, nullString(opts.UserID), driverId).Scan(&driver.ID, &driver.DriverInfo, &driver.AverageRating, &count, &userRating)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	driver.Confidence = confidence(count)
+	if userRating.Valid {
+		rating := int(userRating.Int64)
+		driver.UserRating = &rating
+	}
+	return &driver, nil
+}
+
+// ListDrivers supports the same queries as postgresStorage.ListDrivers.
+func (s *mysqlStorage) ListDrivers(q driverQuery) ([]Driver, error) {
+	if q.Tier != 0 || len(q.HasFields) > 0 || q.LatestRating || q.Sort == sortBlended || q.Average != "" && q.Average != avgMean {
+		return nil, errNotSupported
+	}
+	entity := q.EntityType
+	if entity == "" {
+		entity = entityDriver
+	}
+	args := []interface{}{nullString(q.UserID), entity}
+	where := ""
+	if q.MinRating != nil {
+		where, args = where+" AND r.rating_count > 0 AND "+myAverage("r")+" >= ?", append(args, *q.MinRating)
+	}
+	if q.MinCount > 0 {
+		where, args = where+" AND r.rating_count >= ?", append(args, q.MinCount)
+	}
+	order := textIDOrder("r")
+	switch q.Sort {
+	case sortRating:
+		order = "avg_rating, " + textTieBreak("r")
+	case sortRatingDesc:
+		order = "avg_rating DESC, " + textTieBreak("r")
+	case sortCount:
+		order = "r.rating_count, " + textIDOrder("r")
+	case sortCountDesc:
+		order = "r.rating_count DESC, " + textIDOrder("r")
+	}
+	// OFFSET needs a LIMIT, the largest one stands for none.
+	limit := " LIMIT 18446744073709551615"
+	if q.Limit > 0 {
+		limit, args = " LIMIT ?", append(args, q.Limit)
+	}
+	args = append(args, q.Offset)
+	row, err := s.db.Query(`SELECT r.id, r.driver_info, COALESCE(`+myAverage("r")+`, 0) AS avg_rating, r.rating_count, ur.rating
+    FROM drivers r
+    LEFT JOIN driver_ratings ur ON ur.driver_id = r.id AND ur.user_id = ?
+    WHERE r.deleted_at IS NULL AND r.entity_type = ?`+where+`
+    ORDER BY `+order+limit+`
+    OFFSET ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []Driver{}
+	for row.Next() {
+		var driver Driver
+		var userRating sql.NullInt64
+		var count int
+		if err = row.Scan(&driver.ID, &driver.DriverInfo, &driver.AverageRating, &count, &userRating); err != nil {
+			return nil, err
+		}
+		driver.Confidence = confidence(count)
+		if userRating.Valid {
+			rating := int(userRating.Int64)
+			driver.UserRating = &rating
+		}
+		list = append(list, driver)
+	}
+	return list, row.Err()
+}
+
+// CreateOrUpdateRating upserts the rating like postgresStorage does. The
+// update branch sets prev_rating, the assignments run left to right, and
+// the row stays locked until the transaction ends, so reading prev_rating
+// back tells what this upsert replaced.
+func (s *mysqlStorage) CreateOrUpdateRating(r Rating) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`INSERT INTO driver_ratings (driver_id, user_id, rating, source, comment, tags, region)
+    VALUES (?, ?, ?, ?, ?, ?, ?)
+    ON DUPLICATE KEY UPDATE
+      prev_rating = rating,
+      rating = VALUES(rating),
+      source = VALUES(source),
+      moderation = CASE WHEN VALUES(comment) <=> comment THEN moderation ELSE 'visible' END,
+      comment = VALUES(comment),
+      tags = VALUES(tags),
+      region = VALUES(region),
+      updated_at = CURRENT_TIMESTAMP(6)`, r.DriverID, r.UserID, r.Rating, nullString(r.Source), nullString(r.Comment), r.Tags, nullString(r.Region))
+	if err != nil {
+		return err
+	}
+	var prev sql.NullInt64
+	err = tx.QueryRow("SELECT prev_rating FROM driver_ratings WHERE driver_id = ? AND user_id = ?", r.DriverID, r.UserID).Scan(&prev)
+	if err != nil {
+		return err
+	}
+	delta, added := int64(r.Rating), 1
+	if prev.Valid {
+		delta, added = int64(r.Rating)-prev.Int64, 0
+	}
+	_, err = tx.Exec("UPDATE drivers SET rating_sum = rating_sum + ?, rating_count = rating_count + ? WHERE id = ?", delta, added, r.DriverID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *mysqlStorage) GetRating(driverId, userId string) (*Rating, error) {
+	rating, err := scanStoredRating(s.db.QueryRow("SELECT "+storedRatingColumns+" FROM driver_ratings WHERE driver_id = ? AND user_id = ?", driverId, userId))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rating, nil
+}
+
+func (s *mysqlStorage) ListDriverRatings(driverId string, filter ratingFilter, limit int) ([]Rating, error) {
+	cond, args := filter.condition()
+	args = append([]interface{}{driverId}, append(args, limit)...)
+	row, err := s.db.Query("SELECT "+storedRatingColumns+" FROM driver_ratings WHERE driver_id = ?"+cond+" ORDER BY created_at, user_id LIMIT ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []Rating{}
+	for row.Next() {
+		rating, err := scanStoredRating(row)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rating)
+	}
+	return list, row.Err()
+}
+
+func (s *mysqlStorage) DeleteRating(driverId, userId, actor string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var rating int64
+	err = tx.QueryRow("SELECT rating FROM driver_ratings WHERE driver_id = ? AND user_id = ? FOR UPDATE", driverId, userId).Scan(&rating)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err = tx.Exec("DELETE FROM driver_ratings WHERE driver_id = ? AND user_id = ?", driverId, userId); err != nil {
+		return false, err
+	}
+	_, err = tx.Exec("UPDATE drivers SET rating_sum = rating_sum - ?, rating_count = rating_count - 1 WHERE id = ?", rating, driverId)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (s *mysqlStorage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *mysqlStorage) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq" // Postgres driver of DB_DRIVER=postgres
+)
+
+// postgresSchema is applied by openPostgres on every start, it only creates
+// what is missing.
+//
+//go:embed postgres/schema.sql
+var postgresSchema string
+
+// postgresStorage is the Storage of DB_DRIVER=postgres. It keeps the
+// aggregates in the same transaction as the ratings like the SQLite storage
+// does, averages are the mean of the ratings.
+type postgresStorage struct {
+	db *sql.DB
+}
+
+func openPostgres(dsn string) (*postgresStorage, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = db.Exec(postgresSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &postgresStorage{db: db}, nil
+}
+
+// rebind numbers the ? placeholders of query the way Postgres wants them,
+// $1, $2 and so on, so that conditions like ratingFilter.condition are
+// shared with SQLite. The queries have no ? in their literals.
+func rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c != '?' {
+			b.WriteRune(c)
+			continue
+		}
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+// pgAverage is the mean of the drivers in alias, NULL when unrated.
+func pgAverage(alias string) string {
+	return alias + ".rating_sum::float8 / NULLIF(" + alias + ".rating_count, 0)"
+}
+
+// textIDOrder orders the text ids of Postgres and MySQL the way SQLite orders
+// the int column: integer ids by value, as long as they have no leading
+// zeros, before uuid ones.
+func textIDOrder(alias string) string {
+	return "length(" + alias + ".id), " + alias + ".id"
+}
+
+// textTieBreak is tieBreak with textIDOrder.
+func textTieBreak(alias string) string {
+	if cfg.TieBreak == tieBreakID {
+		return textIDOrder(alias)
+	}
+	return alias + ".rating_count DESC, " + textIDOrder(alias)
+}
+
+func (s *postgresStorage) CreateDriver(key, driverInfo, entity, actor string) (*Driver, bool, error) {
+	id, err := newDriverID()
+	if err != nil {
+		return nil, false, err
+	}
+	driver := &Driver{DriverInfo: driverInfo}
+	err = s.db.QueryRow(`INSERT INTO drivers (id, driver_info, client_key, entity_type)
+    VALUES (COALESCE($1, nextval('driver_ids')::text), $2, $3, $4)
+    ON CONFLICT (client_key) DO NOTHING
+    RETURNING id`, id, driverInfo, nullString(key), entity).Scan(&driver.ID)
+	if err == sql.ErrNoRows {
+		// The key is taken, the driver made with it is returned.
+		driver = &Driver{}
+		err = s.db.QueryRow(`SELECT id, driver_info, COALESCE(`+pgAverage("d")+`, 0) FROM drivers d WHERE client_key = $1`,
+			key).Scan(&driver.ID, &driver.DriverInfo, &driver.AverageRating)
+		if err != nil {
+			return nil, false, err
+		}
+		return driver, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return driver, true, nil
+}
+
+func (s *postgresStorage) DriverState(driverId string) (driverState, error) {
+	state := driverState{Found: true}
+	err := s.db.QueryRow("SELECT deleted_at IS NOT NULL, status, entity_type FROM drivers WHERE id = $1", driverId).Scan(&state.Deleted, &state.Status, &state.EntityType)
+	if err == sql.ErrNoRows {
+		return driverState{}, nil
+	}
+	return state, err
+}
+
+// GetDriver reads the stored aggregates, with the rating of opts.UserID. The
+// other options need the ratings themselves, see readDriver.
+func (s *postgresStorage) GetDriver(driverId string, opts driverRead) (*Driver, error) {
+	if opts.ExcludeUser != "" || opts.Since != nil || opts.Average != "" && opts.Average != avgMean {
+		return nil, errNotSupported
+	}
+	var driver Driver
+	var count int
+	var userRating sql.NullInt64
+	err := s.db.QueryRow(`SELECT d.id, d.driver_info, COALESCE(`+pgAverage("d")+`, 0), d.rating_count, ur.rating
+    FROM drivers d
+    LEFT JOIN driver_ratings ur ON ur.driver_id = d.id AND ur.user_id = $1
+    WHERE d.id = $2 AND d.deleted_at IS NULL`, nullString(opts.UserID), driverId).Scan(&driver.ID, &driver.DriverInfo, &driver.AverageRating, &count, &userRating)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	driver.Confidence = confidence(count)
+	if userRating.Valid {
+		rating := int(userRating.Int64)
+		driver.UserRating = &rating
+	}
+	return &driver, nil
+}
+
+// ListDrivers supports the sorts but blended, and the minimums. Tiers,
+// driver_info fields, latest ratings and averages other than the mean are
+// SQLite only.
+func (s *postgresStorage) ListDrivers(q driverQuery) ([]Driver, error) {
+	if q.Tier != 0 || len(q.HasFields) > 0 || q.LatestRating || q.Sort == sortBlended || q.Average != "" && q.Average != avgMean {
+		return nil, errNotSupported
+	}
+	entity := q.EntityType
+	if entity == "" {
+		entity = entityDriver
+	}
+	args := []interface{}{nullString(q.UserID), entity}
+	where := ""
+	if q.MinRating != nil {
+		where, args = where+" AND r.rating_count > 0 AND "+pgAverage("r")+" >= ?", append(args, *q.MinRating)
+	}
+	if q.MinCount > 0 {
+		where, args = where+" AND r.rating_count >= ?", append(args, q.MinCount)
+	}
+	order := textIDOrder("r")
+	switch q.Sort {
+	case sortRating:
+		order = "avg_rating, " + textTieBreak("r")
+	case sortRatingDesc:
+		order = "avg_rating DESC, " + textTieBreak("r")
+	case sortCount:
+		order = "r.rating_count, " + textIDOrder("r")
+	case sortCountDesc:
+		order = "r.rating_count DESC, " + textIDOrder("r")
+	}
+	limit := ""
+	if q.Limit > 0 {
+		limit, args = " LIMIT ?", append(args, q.Limit)
+	}
+	args = append(args, q.Offset)
+	row, err := s.db.Query(rebind(`SELECT r.id, r.driver_info, COALESCE(`+pgAverage("r")+`, 0) AS avg_rating, r.rating_count, ur.rating
+    FROM drivers r
+    LEFT JOIN driver_ratings ur ON ur.driver_id = r.id AND ur.user_id = ?
+    WHERE r.deleted_at IS NULL AND r.entity_type = ?`+where+`
+    ORDER BY `+order+limit+`
+    OFFSET ?`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []Driver{}
+	for row.Next() {
+		var driver Driver
+		var userRating sql.NullInt64
+		var count int
+		if err = row.Scan(&driver.ID, &driver.DriverInfo, &driver.AverageRating, &count, &userRating); err != nil {
+			return nil, err
+		}
+		driver.Confidence = confidence(count)
+		if userRating.Valid {
+			rating := int(userRating.Int64)
+			driver.UserRating = &rating
+		}
+		list = append(list, driver)
+	}
+	return list, row.Err()
+}
+
+// CreateOrUpdateRating upserts the rating like upsertRating: prev_rating is
+// only set by the update branch, so that two concurrent first ratings of a
+// user can't both count as new.
+func (s *postgresStorage) CreateOrUpdateRating(r Rating) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var prev sql.NullInt64
+	err = tx.QueryRow(`INSERT INTO driver_ratings (driver_id, user_id, rating, source, comment, tags, region)
+    VALUES ($1, $2, $3, $4, $5, $6, $7)
+    ON CONFLICT (driver_id, user_id) DO UPDATE SET
+      prev_rating = driver_ratings.rating,
+      rating = excluded.rating,
+      source = excluded.source,
+      moderation = CASE WHEN excluded.comment IS NOT DISTINCT FROM driver_ratings.comment THEN driver_ratings.moderation ELSE 'visible' END,
+      comment = excluded.comment,
+      tags = excluded.tags,
+      region = excluded.region,
+      updated_at = now() AT TIME ZONE 'utc'
+    RETURNING prev_rating`, r.DriverID, r.UserID, r.Rating, nullString(r.Source), nullString(r.Comment), r.Tags, nullString(r.Region)).Scan(&prev)
+	if err != nil {
+		return err
+	}
+	delta, added := int64(r.Rating), 1
+	if prev.Valid {
+		delta, added = int64(r.Rating)-prev.Int64, 0
+	}
+	_, err = tx.Exec("UPDATE drivers SET rating_sum = rating_sum + $1, rating_count = rating_count + $2 WHERE id = $3", delta, added, r.DriverID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// storedRatingColumns are the columns scanStoredRating reads, the same on
+// Postgres and MySQL.
+const storedRatingColumns = "driver_id, user_id, rating, COALESCE(source, ''), COALESCE(comment, ''), tags, COALESCE(region, ''), created_at, updated_at, moderation"
+
+func scanStoredRating(row interface{ Scan(...interface{}) error }) (Rating, error) {
+	var rating Rating
+	var created, updated time.Time
+	err := row.Scan(&rating.DriverID, &rating.UserID, &rating.Rating, &rating.Source, &rating.Comment, &rating.Tags, &rating.Region, &created, &updated, &rating.Moderation)
+	// The columns hold UTC without a time zone.
+	created, updated = created.UTC(), updated.UTC()
+	rating.CreatedAt, rating.UpdatedAt = &created, &updated
+	return rating, err
+}
+
+func (s *postgresStorage) GetRating(driverId, userId string) (*Rating, error) {
+	rating, err := scanStoredRating(s.db.QueryRow("SELECT "+storedRatingColumns+" FROM driver_ratings WHERE driver_id = $1 AND user_id = $2", driverId, userId))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rating, nil
+}
+
+func (s *postgresStorage) ListDriverRatings(driverId string, filter ratingFilter, limit int) ([]Rating, error) {
+	cond, args := filter.condition()
+	args = append([]interface{}{driverId}, append(args, limit)...)
+	row, err := s.db.Query(rebind("SELECT "+storedRatingColumns+" FROM driver_ratings WHERE driver_id = ?"+cond+" ORDER BY created_at, user_id LIMIT ?"), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []Rating{}
+	for row.Next() {
+		rating, err := scanStoredRating(row)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rating)
+	}
+	return list, row.Err()
+}
+
+func (s *postgresStorage) DeleteRating(driverId, userId, actor string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var rating int64
+	err = tx.QueryRow("DELETE FROM driver_ratings WHERE driver_id = $1 AND user_id = $2 RETURNING rating", driverId, userId).Scan(&rating)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.Exec("UPDATE drivers SET rating_sum = rating_sum - $1, rating_count = rating_count - 1 WHERE id = $2", rating, driverId)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (s *postgresStorage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *postgresStorage) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+// testStorage runs the same ratings through s and checks what every Storage
+// has to read back.
+func testStorage(t *testing.T, s Storage) {
+	driver, created, err := s.CreateDriver("k1", `{"name": "Ann"}`, entityDriver, "test")
+	if err != nil || !created {
+		t.Fatalf("create driver: %v, created %v", err, created)
+	}
+	again, created, err := s.CreateDriver("k1", `{}`, entityDriver, "test")
+	if err != nil || created || again.ID != driver.ID {
+		t.Fatalf("create driver with a used key gave %+v, created %v, %v, want driver %s back", again, created, err, driver.ID)
+	}
+	other, _, err := s.CreateDriver("", `{}`, entityDriver, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []Rating{
+		{DriverID: driver.ID, UserID: "a", Rating: 2},
+		{DriverID: driver.ID, UserID: "b", Rating: 4, Comment: "fine", Tags: ratingTags{"clean"}},
+		{DriverID: driver.ID, UserID: "a", Rating: 5},
+		{DriverID: other.ID, UserID: "a", Rating: 3},
+	} {
+		if err = s.CreateOrUpdateRating(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := s.GetDriver(driver.ID, driverRead{UserID: "a"})
+	if err != nil || got == nil || got.AverageRating != 4.5 || got.UserRating == nil || *got.UserRating != 5 {
+		t.Fatalf("driver is %+v, %v, want an average of 4.5 and 5 from user a", got, err)
+	}
+	rating, err := s.GetRating(driver.ID, "b")
+	if err != nil || rating == nil || rating.Rating != 4 || rating.Comment != "fine" || len(rating.Tags) != 1 || rating.CreatedAt == nil {
+		t.Fatalf("rating of user b is %+v, %v, want 4 with its comment and tag", rating, err)
+	}
+	list, err := s.ListDrivers(driverQuery{Sort: sortRatingDesc, Limit: 2})
+	if err != nil || len(list) != 2 || list[0].ID != driver.ID || list[1].ID != other.ID {
+		t.Fatalf("drivers by rating are %+v, %v, want %s then %s", list, err, driver.ID, other.ID)
+	}
+	ratings, err := s.ListDriverRatings(driver.ID, ratingFilter{MinRating: 5}, 10)
+	if err != nil || len(ratings) != 1 || ratings[0].UserID != "a" {
+		t.Fatalf("ratings of 5 stars are %+v, %v, want the one of user a", ratings, err)
+	}
+
+	found, err := s.DeleteRating(driver.ID, "a", "test")
+	if err != nil || !found {
+		t.Fatalf("delete rating: %v, found %v", err, found)
+	}
+	if found, err = s.DeleteRating(driver.ID, "a", "test"); err != nil || found {
+		t.Fatalf("delete a deleted rating: %v, found %v", err, found)
+	}
+	if got, err = s.GetDriver(driver.ID, driverRead{}); err != nil || got.AverageRating != 4 {
+		t.Fatalf("driver after the deletion is %+v, %v, want an average of 4", got, err)
+	}
+	state, err := s.DriverState(driver.ID)
+	if err != nil || !state.Found || state.Deleted || state.Status != driverActive {
+		t.Fatalf("state of driver %s is %+v, %v, want found and active", driver.ID, state, err)
+	}
+	if state, err = s.DriverState("404"); err != nil || state.Found {
+		t.Fatalf("state of a missing driver is %+v, %v, want not found", state, err)
+	}
+}
+
+func TestSQLiteStorage(t *testing.T) {
+	openTestDB(t, map[string]string{"DISABLE_SEED": "true"})
+	testStorage(t, sqliteStorage{})
+}
+
+// TestPostgresStorage runs against the database of TEST_POSTGRES_DSN, its
+// tables are emptied first.
+func TestPostgresStorage(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	cfg = envConfig(t, nil)
+	s, err := openPostgres(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err = s.db.Exec("TRUNCATE driver_ratings, drivers"); err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, s)
+	if _, err = s.ListDrivers(driverQuery{Tier: 1}); err != errNotSupported {
+		t.Fatalf("tiers on postgres gave %v, want %v", err, errNotSupported)
+	}
+}
+
+// TestMySQLStorage runs against the database of TEST_MYSQL_DSN, its tables
+// are emptied first.
+func TestMySQLStorage(t *testing.T) {
+	dsn := os.Getenv("TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("TEST_MYSQL_DSN is not set")
+	}
+	cfg = envConfig(t, nil)
+	s, err := openMySQL(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, table := range []string{"driver_ratings", "drivers"} {
+		if _, err = s.db.Exec("DELETE FROM " + table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testStorage(t, s)
+	if _, err = s.ListDrivers(driverQuery{Tier: 1}); err != errNotSupported {
+		t.Fatalf("tiers on mysql gave %v, want %v", err, errNotSupported)
+	}
+}
+
+func TestOpenMySQLBadDSN(t *testing.T) {
+	if _, err := openMySQL("not a dsn"); err == nil {
+		t.Fatal("opened a DSN without a database, want an error")
+	}
+}
+
+func TestRebind(t *testing.T) {
+	got := rebind("SELECT * FROM driver_ratings WHERE driver_id = ? AND rating >= ? LIMIT ?")
+	if want := "SELECT * FROM driver_ratings WHERE driver_id = $1 AND rating >= $2 LIMIT $3"; got != want {
+		t.Fatalf("rebind gave %q, want %q", got, want)
+	}
+}
+
+func TestCoreRouter(t *testing.T) {
+	openTestDB(t, nil)
+	h, err := newCoreRouter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rateTest(t, h, "1", "a", 3)
+	rateTest(t, h, "2", "a", 5)
+	rateTest(t, h, "2", "b", 4)
+
+	rec := serveTest(h, "GET", "/drivers?sort=rating_desc&limit=2&user_id=a", "")
+	expectStatus(t, rec, http.StatusOK)
+	var list []Driver
+	decodeBody(t, rec, &list)
+	if len(list) != 2 || list[0].ID != "2" || list[0].AverageRating != 4.5 || list[1].ID != "1" || *list[1].UserRating != 3 {
+		t.Fatalf("drivers are %+v, want 2 (4.5) then 1 rated 3 by user a", list)
+	}
+	rec = serveTest(h, "GET", "/drivers/2/ratings?min_rating=5", "")
+	expectStatus(t, rec, http.StatusOK)
+	var ratings []Rating
+	decodeBody(t, rec, &ratings)
+	if len(ratings) != 1 || ratings[0].UserID != "a" {
+		t.Fatalf("ratings of driver 2 are %+v, want the 5 of user a", ratings)
+	}
+	expectStatus(t, serveTest(h, "DELETE", "/drivers/2/ratings/a", ""), http.StatusNoContent)
+	rec = serveTest(h, "GET", "/drivers/2", "")
+	expectStatus(t, rec, http.StatusOK)
+	var driver Driver
+	decodeBody(t, rec, &driver)
+	if driver.AverageRating != 4 || driver.TrustScore != nil {
+		t.Fatalf("driver 2 is %+v, want an average of 4 and none of the SQLite extras", driver)
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers?sort=blended", ""), http.StatusBadRequest)
+	expectStatus(t, serveTest(h, "GET", "/drivers/404", ""), http.StatusNotFound)
+	expectStatus(t, serveTest(h, "GET", "/readyz", ""), http.StatusOK)
+	// The routes needing SQLite aren't served.
+	expectStatus(t, serveTest(h, "GET", "/stats/driver-buckets", ""), http.StatusNotFound)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// streamDrivers writes the drivers list as a JSON array one driver at a
+// time, flushing after each so the client can render rows as they arrive.
+// The status is sent before the first row, so an error halfway through
+// aborts the response instead of ending the array.
+func streamDrivers(w http.ResponseWriter, q driverQuery, params listParams) {
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "application/json")
+	sep := []byte("[")
+	empty := true
+	err := eachDriver(q, func(driver Driver) error {
+		driver.AverageRating = presentAverage(driver.AverageRating, params)
+		d, err := json.Marshal(driver)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(append(sep, d...)); err != nil {
+			return err
+		}
+		sep = []byte(",")
+		empty = false
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err == nil && empty {
+		_, err = w.Write(sep)
+	}
+	if err == nil {
+		_, err = w.Write([]byte("]"))
+	}
+	if err != nil {
+		log.Println("stream drivers:", err)
+		panic(http.ErrAbortHandler)
+	}
+}
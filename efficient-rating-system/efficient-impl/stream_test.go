@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStreamDrivers(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "2", "a", 4)
+	server := httptest.NewServer(h)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/drivers?stream=true&limit=100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if fmt.Sprint(resp.TransferEncoding) != "[chunked]" {
+		t.Fatalf("transfer encoding is %v, want chunked", resp.TransferEncoding)
+	}
+	var list []Driver
+	if err = json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != seedDriverCount {
+		t.Fatalf("streamed %d drivers, want %d", len(list), seedDriverCount)
+	}
+	if list[1].ID != "2" || list[1].AverageRating != 4 {
+		t.Fatalf("the second driver is %+v, want driver 2 at 4", list[1])
+	}
+}
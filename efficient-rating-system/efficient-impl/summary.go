@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+)
+
+// DriverSummary is a driver with the distribution of its ratings and the
+// newest ratings that have a comment. There are at most cfg.SummaryComments
+// of them, MoreComments links to the feed page with the following ones when
+// there are more.
+type DriverSummary struct {
+	Driver       Driver         `json:"driver"`
+	Distribution map[string]int `json:"distribution"`
+	Comments     []Rating       `json:"comments"`
+	MoreComments string         `json:"more_comments,omitempty"`
+}
+
+func getDriverSummary(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	driverId := params["driver_id"]
+	driver, err := getDriverByID(driverId, driverRead{})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if driver == nil {
+		writeError(w, http.StatusNotFound, "driver not found")
+		return
+	}
+	localizeAverages(w, r, driver)
+	summary := DriverSummary{Driver: *driver}
+	summary.Distribution, err = getDriverRatingHistogram(driverId)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	hasComment := true
+	page, err := getDriverRatingsPage(driverId, ratingFilter{HasComment: &hasComment}, nil, cfg.SummaryComments)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	showRatings(r, page.Ratings)
+	summary.Comments = page.Ratings
+	if page.Next != "" {
+		summary.MoreComments = "/drivers/" + url.PathEscape(driverId) + "/ratings?has_comment=true&before=" + page.Next
+	}
+	d, err := json.Marshal(summary)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDriverSummaryCapsComments(t *testing.T) {
+	h := openTestDB(t, map[string]string{"SUMMARY_MAX_COMMENTS": "2"})
+	for _, user := range []string{"a", "b", "c"} {
+		expectStatus(t, serveTest(h, "POST", "/drivers/1/ratings", `{"user_id": "`+user+`", "rating": 4, "comment": "fine"}`), http.StatusOK)
+	}
+	rateTest(t, h, "1", "d", 5)
+	rec := serveTest(h, "GET", "/drivers/1/summary", "")
+	expectStatus(t, rec, http.StatusOK)
+	var summary DriverSummary
+	decodeBody(t, rec, &summary)
+	if len(summary.Comments) != 2 || summary.MoreComments == "" {
+		t.Fatalf("%d comments, more at %q, want 2 and a link to the rest", len(summary.Comments), summary.MoreComments)
+	}
+	if summary.Distribution["4"] != 3 || summary.Distribution["5"] != 1 {
+		t.Fatalf("distribution is %v, want 3 fours and a five", summary.Distribution)
+	}
+	rec = serveTest(h, "GET", summary.MoreComments, "")
+	expectStatus(t, rec, http.StatusOK)
+	var rest RatingsPage
+	decodeBody(t, rec, &rest)
+	if len(rest.Ratings) != 1 || rest.Ratings[0].Comment == "" {
+		t.Fatalf("the more link has %+v, want the third comment", rest.Ratings)
+	}
+
+	rec = serveTest(h, "GET", "/drivers/2/summary", "")
+	expectStatus(t, rec, http.StatusOK)
+	summary = DriverSummary{}
+	decodeBody(t, rec, &summary)
+	if len(summary.Comments) != 0 || summary.MoreComments != "" {
+		t.Fatalf("driver 2 has %d comments, more at %q, want none", len(summary.Comments), summary.MoreComments)
+	}
+}
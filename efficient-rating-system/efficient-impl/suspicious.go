@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultSuspiciousMinCount  = 20
+	defaultSuspiciousMaxStddev = 0.1
+)
+
+// SuspiciousDriver is a driver with many ratings that are nearly all the
+// same, which may be bots inflating (or sinking) its average.
+type SuspiciousDriver struct {
+	ID            string  `json:"id"`
+	DriverInfo    string  `json:"driver_info"`
+	RatingCount   int     `json:"rating_count"`
+	AverageRating float64 `json:"avg_rating"`
+	Stddev        float64 `json:"stddev"`
+}
+
+func getSuspiciousDrivers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	minCount := defaultSuspiciousMinCount
+	if v := query.Get("min_count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 2 {
+			writeError(w, http.StatusBadRequest, (&paramError{"min_count", "must be a number of at least 2"}).Error())
+			return
+		}
+		minCount = n
+	}
+	maxStddev := defaultSuspiciousMaxStddev
+	if v := query.Get("max_stddev"); v != "" {
+		s, err := strconv.ParseFloat(v, 64)
+		if err != nil || s < 0 {
+			writeError(w, http.StatusBadRequest, (&paramError{"max_stddev", "must be a non-negative number"}).Error())
+			return
+		}
+		maxStddev = s
+	}
+	list, err := getSuspiciousDriversList(entityType(r), minCount, maxStddev)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(list)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getSuspiciousDriversList returns the entities of entityType with at least
+// minCount ratings whose (population) standard deviation is at most
+// maxStddev, most uniform first. It reads the ratings themselves, not the
+// aggregates.
+func getSuspiciousDriversList(entityType string, minCount int, maxStddev float64) ([]SuspiciousDriver, error) {
+	row, err := srv.DB().Query(`SELECT d.id, d.driver_info, COUNT(*), AVG(r.rating),
+      MAX(AVG(r.rating * r.rating) - AVG(r.rating) * AVG(r.rating), 0) AS variance
+    FROM drivers d
+    JOIN driver_ratings r ON r.driver_id = d.id
+    WHERE d.deleted_at IS NULL AND d.entity_type = ?
+    GROUP BY d.id
+    HAVING COUNT(*) >= ? AND variance <= ?
+    ORDER BY variance, COUNT(*) DESC, d.id`, entityType, minCount, maxStddev*maxStddev)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []SuspiciousDriver{}
+	for row.Next() {
+		var driver SuspiciousDriver
+		var variance float64
+		err = row.Scan(&driver.ID, &driver.DriverInfo, &driver.RatingCount, &driver.AverageRating, &variance)
+		if err != nil {
+			return nil, err
+		}
+		driver.Stddev = math.Sqrt(variance)
+		list = append(list, driver)
+	}
+	return list, row.Err()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestSuspiciousDrivers(t *testing.T) {
+	h := openTestDB(t, adminEnv)
+	// Driver 1 only gets fives, driver 2 is rated all over and driver 3 has
+	// too few ratings to tell.
+	for u := 0; u < 5; u++ {
+		user := "u" + strconv.Itoa(u)
+		rateTest(t, h, "1", user, 5)
+		rateTest(t, h, "2", user, u+1)
+		if u < 2 {
+			rateTest(t, h, "3", user, 5)
+		}
+	}
+	rec := serveTest(h, "GET", "/admin/drivers/suspicious?min_count=5&max_stddev=0.1", "", adminAuth...)
+	expectStatus(t, rec, http.StatusOK)
+	var list []SuspiciousDriver
+	decodeBody(t, rec, &list)
+	if len(list) != 1 || list[0].ID != "1" || list[0].RatingCount != 5 || list[0].Stddev != 0 {
+		t.Fatalf("suspicious drivers are %+v, want driver 1 with 5 equal ratings", list)
+	}
+	expectStatus(t, serveTest(h, "GET", "/admin/drivers/suspicious?min_count=1", "", adminAuth...), http.StatusBadRequest)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+func getDriverTiers(w http.ResponseWriter, r *http.Request) {
+	tiers, err := getDriverTiersList(entityType(r))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(tiers)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getDriverTiersList groups the rated entities of entityType by their average
+// rounded to the nearest star, every tier from 1 to 5 is present even when
+// empty. Entities without ratings don't belong to any tier.
+func getDriverTiersList(entityType string) (map[string][]Driver, error) {
+	tiers := map[string][]Driver{}
+	for star := 1; star <= 5; star++ {
+		tiers[strconv.Itoa(star)] = []Driver{}
+	}
+	avg, args := averageExpr("d", cfg.AggFunction)
+	row, err := srv.DB().Query(`SELECT id, driver_info, `+avg+` AS avg_rating
+    FROM drivers d
+    WHERE rating_count > 0 AND deleted_at IS NULL AND d.entity_type = ?
+    ORDER BY avg_rating DESC, `+tieBreak("d"), append(args, entityType)...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	for row.Next() {
+		var driver Driver
+		err = row.Scan(&driver.ID, &driver.DriverInfo, &driver.AverageRating)
+		if err != nil {
+			return nil, err
+		}
+		key := strconv.Itoa(int(math.Round(driver.AverageRating)))
+		tiers[key] = append(tiers[key], driver)
+	}
+	return tiers, row.Err()
+}
+
+// unratedBucket holds the drivers without ratings when cfg.BucketUnrated is
+// set.
+const unratedBucket = "unrated"
+
+func getDriverBuckets(w http.ResponseWriter, r *http.Request) {
+	buckets, err := getDriverBucketCounts(entityType(r))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(buckets)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getDriverBucketCounts counts the entities of entityType of each tier, see
+// getDriverTiersList.
+func getDriverBucketCounts(entityType string) (map[string]int, error) {
+	buckets := map[string]int{}
+	for star := 1; star <= 5; star++ {
+		buckets[strconv.Itoa(star)] = 0
+	}
+	avg, args := averageExpr("d", cfg.AggFunction)
+	row, err := srv.DB().Query(`SELECT rating_count > 0, CASE WHEN rating_count > 0 THEN `+avg+` ELSE 0 END
+    FROM drivers d
+    WHERE deleted_at IS NULL AND d.entity_type = ?`, append(args, entityType)...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	if cfg.BucketUnrated {
+		buckets[unratedBucket] = 0
+	}
+	for row.Next() {
+		var rated bool
+		var average float64
+		err = row.Scan(&rated, &average)
+		if err != nil {
+			return nil, err
+		}
+		if rated {
+			buckets[strconv.Itoa(int(math.Round(average)))]++
+		} else if cfg.BucketUnrated {
+			buckets[unratedBucket]++
+		}
+	}
+	return buckets, row.Err()
+}
+
+// parseTierPage reads the tier and cursor parameters of GET /drivers. A tier
+// lists the drivers of one tier by id, the cursor is the id the previous
+// page ended at.
+func parseTierPage(query url.Values, params listParams) (tier int, after string, err error) {
+	if v := query.Get("tier"); v != "" {
+		tier, err = strconv.Atoi(v)
+		if err != nil || tier < minRating || tier > maxRating {
+			return 0, "", &paramError{"tier", "must be a number between 1 and 5"}
+		}
+	}
+	if v := query.Get("cursor"); v != "" {
+		if tier == 0 {
+			return 0, "", &paramError{"cursor", "needs a tier"}
+		}
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil || len(b) == 0 {
+			return 0, "", &paramError{"cursor", "is not a valid cursor"}
+		}
+		after = string(b)
+	}
+	if tier != 0 && (params.Sort != sortID || params.Offset != 0) {
+		return 0, "", &paramError{"tier", "is paginated with cursor, it can't be combined with sort or offset"}
+	}
+	return tier, after, nil
+}
+
+// tierCondition restricts avg_rating to the rated drivers of the tier, the
+// average rounds half away from zero like in getDriverTiersList.
+func tierCondition(tier int) (string, []interface{}) {
+	return " AND r.rating_count > 0 AND avg_rating >= ? AND avg_rating < ?", []interface{}{float64(tier) - 0.5, float64(tier) + 0.5}
+}
+
+// nextTierPage returns the link to the page after list when there is one.
+// The list holds one driver more than the page when the tier goes on, it is
+// dropped.
+func nextTierPage(r *http.Request, list []Driver, limit int) ([]Driver, string) {
+	if len(list) <= limit {
+		return list, ""
+	}
+	list = list[:limit]
+	query := r.URL.Query()
+	query.Set("cursor", base64.RawURLEncoding.EncodeToString([]byte(list[limit-1].ID)))
+	return list, r.URL.Path + "?" + query.Encode()
+}
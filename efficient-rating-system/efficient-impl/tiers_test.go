@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestDriverTiers(t *testing.T) {
+	h := openTestDB(t, nil)
+	// 4.5 rounds up to the 5 star tier, 1.5 to the 2 star one.
+	rateTest(t, h, "1", "a", 5)
+	rateTest(t, h, "1", "b", 4)
+	rateTest(t, h, "2", "a", 2)
+	rateTest(t, h, "3", "a", 1)
+	rateTest(t, h, "3", "b", 2)
+	rec := serveTest(h, "GET", "/drivers/tiers", "")
+	expectStatus(t, rec, http.StatusOK)
+	var tiers map[string][]Driver
+	decodeBody(t, rec, &tiers)
+	ids := map[string][]string{}
+	for tier, list := range tiers {
+		ids[tier] = []string{}
+		for _, driver := range list {
+			ids[tier] = append(ids[tier], driver.ID)
+		}
+	}
+	want := "map[1:[] 2:[2 3] 3:[] 4:[] 5:[1]]"
+	if got := fmt.Sprint(ids); got != want {
+		t.Fatalf("tiers are %s, want %s", got, want)
+	}
+}
+
+// TestTiersOfEntityType checks that the tiers and buckets of the drivers
+// leave the other entities out.
+func TestTiersOfEntityType(t *testing.T) {
+	h := openTestDB(t, nil)
+	rec := serveTest(h, "POST", "/entities/restaurant", `{"driver_info": {"name": "Bistro"}}`)
+	expectStatus(t, rec, http.StatusCreated)
+	var restaurant Driver
+	decodeBody(t, rec, &restaurant)
+	body := `{"user_id": "a", "rating": 5}`
+	expectStatus(t, serveTest(h, "POST", "/entities/restaurant/"+restaurant.ID+"/ratings", body), http.StatusOK)
+	rateTest(t, h, "1", "a", 4)
+	tierIDs := func(path string) string {
+		t.Helper()
+		rec := serveTest(h, "GET", path, "")
+		expectStatus(t, rec, http.StatusOK)
+		var tiers map[string][]Driver
+		decodeBody(t, rec, &tiers)
+		var ids []string
+		for star := 1; star <= 5; star++ {
+			for _, driver := range tiers[strconv.Itoa(star)] {
+				ids = append(ids, driver.ID)
+			}
+		}
+		return fmt.Sprint(ids)
+	}
+	if got := tierIDs("/drivers/tiers"); got != "[1]" {
+		t.Fatalf("drivers in tiers are %s, want only driver 1", got)
+	}
+	rec = serveTest(h, "GET", "/stats/driver-buckets", "")
+	var buckets map[string]int
+	decodeBody(t, rec, &buckets)
+	if buckets["4"] != 1 || buckets["5"] != 0 {
+		t.Fatalf("buckets are %v, want driver 1 alone", buckets)
+	}
+}
+
+func TestDriverBuckets(t *testing.T) {
+	for _, unrated := range []bool{false, true} {
+		t.Run(fmt.Sprint("unrated=", unrated), func(t *testing.T) {
+			h := openTestDB(t, map[string]string{"BUCKET_UNRATED": fmt.Sprint(unrated)})
+			rateTest(t, h, "1", "a", 5)
+			rateTest(t, h, "1", "b", 4)
+			rateTest(t, h, "2", "a", 2)
+			rateTest(t, h, "3", "a", 2)
+			rec := serveTest(h, "GET", "/stats/driver-buckets", "")
+			expectStatus(t, rec, http.StatusOK)
+			var buckets map[string]int
+			decodeBody(t, rec, &buckets)
+			want := "map[1:0 2:2 3:0 4:0 5:1]"
+			if unrated {
+				want = fmt.Sprintf("map[1:0 2:2 3:0 4:0 5:1 unrated:%d]", seedDriverCount-3)
+			}
+			if got := fmt.Sprint(buckets); got != want {
+				t.Fatalf("buckets are %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestDriversOfTierByCursor(t *testing.T) {
+	h := openTestDB(t, nil)
+	want := []string{}
+	for id := 1; id <= 16; id++ {
+		stars := 2
+		if id%2 == 0 {
+			stars = 4
+			want = append(want, strconv.Itoa(id))
+		}
+		rateTest(t, h, strconv.Itoa(id), "a", stars)
+	}
+	got := []string{}
+	next := regexp.MustCompile(`^<([^>]+)>; rel="next"$`)
+	for target := "/drivers?tier=4&limit=3"; target != ""; {
+		rec := serveTest(h, "GET", target, "")
+		expectStatus(t, rec, http.StatusOK)
+		var page []Driver
+		decodeBody(t, rec, &page)
+		for _, driver := range page {
+			got = append(got, driver.ID)
+		}
+		target = ""
+		if m := next.FindStringSubmatch(rec.Header().Get("Link")); m != nil {
+			target = m[1]
+		}
+		if len(got) > len(want) {
+			break
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("the pages of tier 4 hold %v, want %v", got, want)
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers?tier=4&offset=3", ""), http.StatusBadRequest)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// requestTimeouts puts the deadline of the matched route on the context of
+// the request, see routeTimeout.
+func requestTimeouts(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d := routeTimeout(r); d > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routeTimeout looks the matched route up in cfg.RouteTimeouts, first with
+// the method then without it, and falls back to cfg.RequestTimeout.
+func routeTimeout(r *http.Request) time.Duration {
+	if route := mux.CurrentRoute(r); route != nil {
+		template, err := route.GetPathTemplate()
+		if err == nil {
+			if d, ok := cfg.RouteTimeouts[r.Method+" "+template]; ok {
+				return d
+			}
+			if d, ok := cfg.RouteTimeouts[template]; ok {
+				return d
+			}
+		}
+	}
+	return cfg.RequestTimeout
+}
+
+// checkRouteTimeouts makes sure every key of cfg.RouteTimeouts names a route
+// of the router, so that a typo does not go unnoticed.
+func checkRouteTimeouts(router *mux.Router) error {
+	known := map[string]bool{}
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		known[template] = true
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			known[method+" "+template] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for key := range cfg.RouteTimeouts {
+		if !known[key] {
+			return fmt.Errorf("ROUTE_TIMEOUTS_MS: unknown route %q, routes look like \"GET /drivers/{driver_id}\"", key)
+		}
+	}
+	return nil
+}
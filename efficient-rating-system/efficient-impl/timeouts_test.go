@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRouteTimeouts(t *testing.T) {
+	cfg = testConfig(t, map[string]string{
+		"REQUEST_TIMEOUT_MS": "1000",
+		"ROUTE_TIMEOUTS_MS":  "GET /export=60000",
+	})
+	var left time.Duration
+	deadline := func(w http.ResponseWriter, r *http.Request) {
+		if d, ok := r.Context().Deadline(); ok {
+			left = time.Until(d)
+		}
+	}
+	r := mux.NewRouter()
+	r.Use(requestTimeouts)
+	r.HandleFunc("/export", deadline).Methods("GET")
+	r.HandleFunc("/rate", deadline).Methods("POST")
+	if err := checkRouteTimeouts(r); err != nil {
+		t.Fatal(err)
+	}
+	for target, want := range map[string]time.Duration{"GET /export": time.Minute, "POST /rate": time.Second} {
+		left = 0
+		method, path, _ := strings.Cut(target, " ")
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, nil))
+		if left <= want-time.Second/2 || left > want {
+			t.Fatalf("%s has %v left, want about %v", target, left, want)
+		}
+	}
+	cfg.RouteTimeouts["GET /exports"] = time.Minute
+	if checkRouteTimeouts(r) == nil {
+		t.Fatal("an unknown route is accepted")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultTopDrivers = 10
+	maxTopDrivers     = 100
+)
+
+// topDriversRefreshed is when top_drivers was last rebuilt, in Unix
+// nanoseconds, 0 before the first time.
+var topDriversRefreshed atomic.Int64
+
+// TopDriver is a driver of GET /drivers/top as of the last refresh of the
+// ranking. Name is the name field of driver_info like in LeaderboardEntry.
+type TopDriver struct {
+	Position      int     `json:"position"`
+	ID            string  `json:"id"`
+	Name          *string `json:"name"`
+	AverageRating float64 `json:"avg_rating"`
+	RatingCount   int64   `json:"rating_count"`
+}
+
+// topDriversLoop rebuilds the ranking right away and then every interval.
+func topDriversLoop(interval time.Duration) {
+	for {
+		if err := refreshTopDrivers(); err != nil {
+			log.Println("top drivers:", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// refreshTopDrivers ranks the rated drivers, not the other entities, by their
+// average with cfg.AggFunction, and replaces top_drivers with the ranking in
+// one transaction so that readers never see it half built.
+func refreshTopDrivers() error {
+	tx, err := srv.DB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec("DELETE FROM top_drivers"); err != nil {
+		return err
+	}
+	avg, args := averageExpr("d", cfg.AggFunction)
+	_, err = tx.Exec(`INSERT INTO top_drivers (position, driver_id, avg_rating, rating_count)
+    SELECT ROW_NUMBER() OVER (ORDER BY d.avg_rating DESC, `+tieBreak("d")+`), d.id, d.avg_rating, d.rating_count
+    FROM (
+      SELECT d.id, d.rating_count, `+avg+` AS avg_rating
+      FROM drivers d
+      WHERE d.rating_count > 0 AND d.deleted_at IS NULL AND d.entity_type = ?
+    ) d`, append(args, entityDriver)...)
+	if err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	topDriversRefreshed.Store(time.Now().UnixNano())
+	return nil
+}
+
+// getTopDrivers returns the best drivers with at least min_ratings ratings
+// from the materialized ranking, so that no request sorts all the drivers.
+// Last-Modified tells how old the ranking is.
+func getTopDrivers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultTopDrivers
+	if v := query.Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxTopDrivers {
+			writeError(w, http.StatusBadRequest, (&paramError{"limit", "must be a number between 1 and " + strconv.Itoa(maxTopDrivers)}).Error())
+			return
+		}
+	}
+	minRatings := 1
+	if v := query.Get("min_ratings"); v != "" {
+		var err error
+		minRatings, err = strconv.Atoi(v)
+		if err != nil || minRatings < 1 {
+			writeError(w, http.StatusBadRequest, (&paramError{"min_ratings", "must be a positive number"}).Error())
+			return
+		}
+	}
+	list, err := getTopDriversList(limit, minRatings)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(list)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if refreshed := topDriversRefreshed.Load(); refreshed != 0 {
+		w.Header().Set("Last-Modified", time.Unix(0, refreshed).UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getTopDriversList walks top_drivers in order until it has limit drivers
+// with enough ratings. Drivers deleted since the refresh are skipped, their
+// names are the current ones.
+func getTopDriversList(limit, minRatings int) ([]TopDriver, error) {
+	row, err := srv.DB().Query(`SELECT t.position, t.driver_id, `+infoFieldExpr("d")+`, t.avg_rating, t.rating_count
+    FROM top_drivers t
+    JOIN drivers d ON d.id = t.driver_id
+    WHERE t.rating_count >= ? AND d.deleted_at IS NULL
+    ORDER BY t.position
+    LIMIT ?`, infoFieldPath("name"), minRatings, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []TopDriver{}
+	for row.Next() {
+		var driver TopDriver
+		var name sql.NullString
+		err = row.Scan(&driver.Position, &driver.ID, &name, &driver.AverageRating, &driver.RatingCount)
+		if err != nil {
+			return nil, err
+		}
+		if name.Valid {
+			driver.Name = &name.String
+		}
+		list = append(list, driver)
+	}
+	return list, row.Err()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// TopRater is a user with the number of ratings they submitted for a
+// driver, counting every update of their rating.
+type TopRater struct {
+	UserID      string `json:"user_id"`
+	Submissions int    `json:"submissions"`
+}
+
+func getDriverTopRaters(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListParams(r, listOptions{DefaultLimit: 10, MaxLimit: 100})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	driverId := mux.Vars(r)["driver_id"]
+	list, err := getDriverTopRatersList(driverId, params.Limit)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	for i := range list {
+		list[i].UserID = shownUserID(r, list[i].UserID)
+	}
+	d, err := json.Marshal(list)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getDriverTopRatersList ranks the users by how many times they rated the
+// driver according to rating_events, driver_ratings only keeps the last one.
+func getDriverTopRatersList(driverId string, limit int) ([]TopRater, error) {
+	row, err := srv.DB().Query(`SELECT user_id, COUNT(*) AS submissions FROM rating_events
+    WHERE driver_id = ? AND rating IS NOT NULL GROUP BY user_id ORDER BY submissions DESC, user_id LIMIT ?`, driverId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []TopRater{}
+	for row.Next() {
+		var rater TopRater
+		err = row.Scan(&rater.UserID, &rater.Submissions)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rater)
+	}
+	return list, row.Err()
+}
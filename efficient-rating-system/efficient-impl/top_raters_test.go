@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestDriverTopRaters(t *testing.T) {
+	h := openTestDB(t, nil)
+	for _, stars := range []int{1, 2, 3} {
+		rateTest(t, h, "1", "b", stars)
+	}
+	rateTest(t, h, "1", "a", 4)
+	rateTest(t, h, "1", "a", 5)
+	rateTest(t, h, "1", "c", 5)
+	rateTest(t, h, "2", "c", 5)
+	rec := serveTest(h, "GET", "/drivers/1/top-raters?limit=2", "")
+	expectStatus(t, rec, http.StatusOK)
+	var list []TopRater
+	decodeBody(t, rec, &list)
+	if got := fmt.Sprint(list); got != "[{b 3} {a 2}]" {
+		t.Fatalf("top raters are %s, want [{b 3} {a 2}]", got)
+	}
+}
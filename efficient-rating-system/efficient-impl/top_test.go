@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// topTest returns the position:id pairs of GET /drivers/top?query.
+func topTest(tb testing.TB, h http.Handler, query string) string {
+	tb.Helper()
+	rec := serveTest(h, "GET", "/drivers/top?"+query, "")
+	expectStatus(tb, rec, http.StatusOK)
+	var list []TopDriver
+	decodeBody(tb, rec, &list)
+	var pairs []string
+	for _, d := range list {
+		pairs = append(pairs, fmt.Sprintf("%d:%s", d.Position, d.ID))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// rateTopTest gives each driver the ratings listed for it.
+func rateTopTest(tb testing.TB, h http.Handler, ratings map[string][]int) {
+	tb.Helper()
+	for driver, stars := range ratings {
+		for i, s := range stars {
+			rateTest(tb, h, driver, fmt.Sprintf("u%d", i), s)
+		}
+	}
+}
+
+func TestTopDrivers(t *testing.T) {
+	h := openTestDB(t, nil)
+	// Ties on the average go to the driver with more ratings, then to the
+	// lower id.
+	rateTopTest(t, h, map[string][]int{"1": {5}, "2": {5, 5}, "3": {4, 4, 4}, "4": {4}, "5": {4}, "6": {1}})
+	rec := serveTest(h, "POST", "/entities/restaurant", `{"driver_info": {}}`)
+	expectStatus(t, rec, http.StatusCreated)
+	var restaurant Driver
+	decodeBody(t, rec, &restaurant)
+	expectStatus(t, serveTest(h, "POST", "/entities/restaurant/"+restaurant.ID+"/ratings", `{"user_id": "a", "rating": 5}`), http.StatusOK)
+	if err := refreshTopDrivers(); err != nil {
+		t.Fatal(err)
+	}
+	rec = serveTest(h, "GET", "/drivers/top", "")
+	expectStatus(t, rec, http.StatusOK)
+	if rec.Header().Get("Last-Modified") == "" {
+		t.Fatal("no Last-Modified on the ranking")
+	}
+	for _, test := range []struct {
+		query string
+		want  string
+	}{
+		{"", "1:2 2:1 3:3 4:4 5:5 6:6"},
+		{"limit=2", "1:2 2:1"},
+		{"limit=1", "1:2"},
+		{"limit=100", "1:2 2:1 3:3 4:4 5:5 6:6"},
+		// Positions stay the ones among all the rated drivers.
+		{"min_ratings=2", "1:2 3:3"},
+		{"min_ratings=2&limit=1", "1:2"},
+		{"min_ratings=4", ""},
+	} {
+		if got := topTest(t, h, test.query); got != test.want {
+			t.Errorf("%q: top drivers are %q, want %q", test.query, got, test.want)
+		}
+	}
+	for _, query := range []string{"limit=0", "limit=101", "limit=x", "min_ratings=0", "min_ratings=x"} {
+		expectStatus(t, serveTest(h, "GET", "/drivers/top?"+query, ""), http.StatusBadRequest)
+	}
+
+	// A driver deleted since the refresh is skipped, and left out of the
+	// next ranking.
+	expectStatus(t, serveTest(h, "DELETE", "/drivers/2", ""), http.StatusNoContent)
+	if got := topTest(t, h, "limit=2"); got != "2:1 3:3" {
+		t.Fatalf("top drivers after the deletion are %q, want 2:1 3:3", got)
+	}
+	if err := refreshTopDrivers(); err != nil {
+		t.Fatal(err)
+	}
+	if got := topTest(t, h, "limit=2"); got != "1:1 2:3" {
+		t.Fatalf("top drivers after the refresh are %q, want 1:1 2:3", got)
+	}
+}
+
+func TestTopDriversTieBreakByID(t *testing.T) {
+	h := openTestDB(t, map[string]string{"RANKING_TIE_BREAK": "id"})
+	rateTopTest(t, h, map[string][]int{"1": {5}, "2": {5, 5}, "3": {3}})
+	if err := refreshTopDrivers(); err != nil {
+		t.Fatal(err)
+	}
+	if got := topTest(t, h, ""); got != "1:1 2:2 3:3" {
+		t.Fatalf("top drivers are %q, want 1:1 2:2 3:3", got)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// trendBuckets are the accepted bucket values of GET
+// /drivers/{driver_id}/trend, with the SQL expression of the first day of the
+// bucket of a rating. Weeks start on Monday.
+var trendBuckets = map[string]string{
+	"day":   "date(created_at)",
+	"week":  "date(created_at, '-6 days', 'weekday 1')",
+	"month": "date(created_at, 'start of month')",
+}
+
+type TrendBucket struct {
+	Start         string  `json:"start"`
+	AverageRating float64 `json:"avg_rating"`
+	Count         int     `json:"count"`
+}
+
+// Trend is the average of a driver per bucket, oldest first, with the slope
+// of the line fitted through them in stars per bucket. The slope is positive
+// for an improving driver and null with fewer than two buckets.
+type Trend struct {
+	DriverID string        `json:"driver_id"`
+	Bucket   string        `json:"bucket"`
+	Slope    *float64      `json:"slope"`
+	Buckets  []TrendBucket `json:"buckets"`
+}
+
+func getDriverTrend(w http.ResponseWriter, r *http.Request) {
+	driverId := mux.Vars(r)["driver_id"]
+	bucket := r.URL.Query().Get("bucket")
+	if bucket == "" {
+		bucket = "week"
+	}
+	start, ok := trendBuckets[bucket]
+	if !ok {
+		writeError(w, http.StatusBadRequest, (&paramError{"bucket", "must be day, week or month"}).Error())
+		return
+	}
+	found, _, err := getDriverState(driverId)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "driver not found")
+		return
+	}
+	list, err := getDriverTrendBuckets(driverId, start)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	slope, err := trendSlope(list, bucket)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(Trend{DriverID: driverId, Bucket: bucket, Slope: slope, Buckets: list})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getDriverTrendBuckets averages the ratings of the driver by the bucket they
+// were created in. Buckets without ratings are left out.
+func getDriverTrendBuckets(driverId, start string) ([]TrendBucket, error) {
+	row, err := srv.DB().Query(`SELECT `+start+` AS start, AVG(rating), COUNT(*)
+    FROM driver_ratings
+    WHERE driver_id = ?
+    GROUP BY start
+    ORDER BY start`, driverId)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []TrendBucket{}
+	for row.Next() {
+		var b TrendBucket
+		if err = row.Scan(&b.Start, &b.AverageRating, &b.Count); err != nil {
+			return nil, err
+		}
+		list = append(list, b)
+	}
+	return list, row.Err()
+}
+
+// trendSlope fits a least squares line through the averages of the buckets.
+// x is the position of a bucket counted in buckets, so that a bucket without
+// ratings in between still counts as time passing.
+func trendSlope(list []TrendBucket, bucket string) (*float64, error) {
+	if len(list) < 2 {
+		return nil, nil
+	}
+	var x0, sumX, sumY, sumXY, sumXX float64
+	for i, b := range list {
+		t, err := time.Parse("2006-01-02", b.Start)
+		if err != nil {
+			return nil, err
+		}
+		var x float64
+		switch bucket {
+		case "month":
+			x = float64(t.Year()*12 + int(t.Month()))
+		case "week":
+			x = float64(t.Unix()) / (7 * 24 * 3600)
+		default:
+			x = float64(t.Unix()) / (24 * 3600)
+		}
+		// Counting from the first bucket keeps the sums small.
+		if i == 0 {
+			x0 = x
+		}
+		x -= x0
+		sumX += x
+		sumY += b.AverageRating
+		sumXY += x * b.AverageRating
+		sumXX += x * x
+	}
+	n := float64(len(list))
+	slope := (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+	return &slope, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDriverTrendSlope(t *testing.T) {
+	h := openTestDB(t, nil)
+	// Driver 1 averages 2, then 3, then 4 over the last three days.
+	for _, r := range []struct {
+		user           string
+		stars, daysAgo int
+	}{{"a", 1, 2}, {"b", 3, 2}, {"c", 3, 1}, {"d", 4, 0}} {
+		rateTest(t, h, "1", r.user, r.stars)
+		created := time.Now().UTC().Add(-time.Duration(r.daysAgo) * 24 * time.Hour).Format(timeFormat)
+		execTest(t, "UPDATE driver_ratings SET created_at = ? WHERE user_id = ?", created, r.user)
+	}
+	rec := serveTest(h, "GET", "/drivers/1/trend?bucket=day", "")
+	expectStatus(t, rec, http.StatusOK)
+	var trend Trend
+	decodeBody(t, rec, &trend)
+	if len(trend.Buckets) != 3 || trend.Slope == nil || *trend.Slope != 1 {
+		t.Fatalf("trend is %+v with slope %v, want 3 buckets rising by 1 a day", trend.Buckets, trend.Slope)
+	}
+
+	// A single bucket has no slope, unless the month started within the days
+	// rated.
+	rec = serveTest(h, "GET", "/drivers/1/trend?bucket=month", "")
+	expectStatus(t, rec, http.StatusOK)
+	trend = Trend{}
+	decodeBody(t, rec, &trend)
+	if time.Now().UTC().Day() > 2 && (len(trend.Buckets) != 1 || trend.Slope != nil) {
+		t.Fatalf("monthly trend is %+v with slope %v, want a single bucket without a slope", trend.Buckets, trend.Slope)
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers/1/trend?bucket=year", ""), http.StatusBadRequest)
+	expectStatus(t, serveTest(h, "GET", "/drivers/404/trend", ""), http.StatusNotFound)
+}
@@ -0,0 +1,35 @@
+package main
+
+import "strings"
+
+const (
+	// avgMean is the plain average of all ratings, from the stored aggregates.
+	avgMean = "mean"
+	// avgTrusted averages only the ratings of trusted raters.
+	avgTrusted = "trusted"
+)
+
+func trustConfigured() bool {
+	return len(cfg.TrustedUsers) > 0 || cfg.TrustedMinRatings > 0
+}
+
+// trustedRaterCondition returns an SQL condition, with its arguments, that
+// holds for the ratings in alias given by trusted raters.
+func trustedRaterCondition(alias string) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if len(cfg.TrustedUsers) > 0 {
+		conds = append(conds, alias+".user_id IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(cfg.TrustedUsers)), ", ")+")")
+		for _, u := range cfg.TrustedUsers {
+			args = append(args, storedUserID(u))
+		}
+	}
+	if cfg.TrustedMinRatings > 0 {
+		conds = append(conds, alias+".user_id IN (SELECT user_id FROM driver_ratings GROUP BY user_id HAVING COUNT(*) >= ?)")
+		args = append(args, cfg.TrustedMinRatings)
+	}
+	if len(conds) == 0 {
+		return "0", nil
+	}
+	return "(" + strings.Join(conds, " OR ") + ")", args
+}
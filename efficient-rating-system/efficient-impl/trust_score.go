@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"strings"
+)
+
+const (
+	// trustCountScale is the number of ratings at which the count component
+	// of the trust score is 1/2.
+	trustCountScale = 10
+	// trustHalfLifeDays is the age of the latest rating at which the recency
+	// component of the trust score is 1/2.
+	trustHalfLifeDays = 30
+	// maxRatingStddev is the largest standard deviation ratings from
+	// minRating to maxRating can have, half of them at each end.
+	maxRatingStddev = float64(maxRating-minRating) / 2
+)
+
+// trustInputs are what the trust score of a driver is computed from.
+// Submissions counts every rating the raters submitted, updates included,
+// from rating_events.
+type trustInputs struct {
+	Count       int
+	AgeDays     float64
+	Stddev      float64
+	Raters      int
+	Submissions int
+}
+
+// trustScore rates from 0 to 100 how much an average can be relied on. It is
+// the mean of four components between 0 and 1, weighed with
+// cfg.TrustScoreWeights in this order:
+//
+//   - count: count / (count + trustCountScale)
+//   - recency: H / (H + days since the latest rating), H trustHalfLifeDays
+//   - consistency: 1 - stddev / maxRatingStddev
+//   - diversity: distinct raters / submissions, low when a few raters keep
+//     resubmitting
+//
+// Unrated drivers score 0.
+func trustScore(in trustInputs) float64 {
+	if in.Count == 0 || in.Submissions == 0 {
+		return 0
+	}
+	components := []float64{
+		float64(in.Count) / float64(in.Count+trustCountScale),
+		trustHalfLifeDays / (trustHalfLifeDays + math.Max(in.AgeDays, 0)),
+		1 - math.Min(in.Stddev/maxRatingStddev, 1),
+		float64(in.Raters) / float64(in.Submissions),
+	}
+	var score, total float64
+	for i, weight := range cfg.TrustScoreWeights {
+		score += weight * components[i]
+		total += weight
+	}
+	return math.Round(1000*score/total) / 10
+}
+
+// addTrustScores sets the trust score of the drivers, see trustScore. Only
+// the ones of entityType get one.
+func addTrustScores(entityType string, drivers ...*Driver) error {
+	if len(drivers) == 0 {
+		return nil
+	}
+	byID := make(map[string]*Driver, len(drivers))
+	args := make([]interface{}, len(drivers), len(drivers)+1)
+	for i, driver := range drivers {
+		byID[driver.ID] = driver
+		args[i] = driver.ID
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(drivers)), ", ")
+	row, err := srv.DB().Query(`SELECT d.id, COUNT(r.rating), julianday('now') - julianday(MAX(r.updated_at)),
+      AVG(r.rating * r.rating) - AVG(r.rating) * AVG(r.rating),
+      (SELECT COUNT(DISTINCT user_id) FROM rating_events WHERE driver_id = d.id AND rating IS NOT NULL),
+      (SELECT COUNT(*) FROM rating_events WHERE driver_id = d.id AND rating IS NOT NULL)
+    FROM drivers d
+    LEFT JOIN driver_ratings r ON r.driver_id = d.id
+    WHERE d.id IN (`+placeholders+`) AND d.entity_type = ?
+    GROUP BY d.id`, append(args, entityType)...)
+	if err != nil {
+		return err
+	}
+	defer row.Close()
+	for row.Next() {
+		var driverId string
+		var in trustInputs
+		var age, variance sql.NullFloat64
+		err = row.Scan(&driverId, &in.Count, &age, &variance, &in.Raters, &in.Submissions)
+		if err != nil {
+			return err
+		}
+		in.AgeDays = age.Float64
+		in.Stddev = math.Sqrt(math.Max(variance.Float64, 0))
+		if driver := byID[driverId]; driver != nil {
+			score := trustScore(in)
+			driver.TrustScore = &score
+		}
+	}
+	return row.Err()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTrustScore(t *testing.T) {
+	h := openTestDB(t, nil)
+	// Driver 1 has few, split ratings from two months ago, one of its two
+	// raters kept resubmitting. Driver 2 has more raters agreeing recently.
+	for _, stars := range []int{1, 5, 1, 5} {
+		rateTest(t, h, "1", "a", stars)
+	}
+	rateTest(t, h, "1", "b", 1)
+	old := time.Now().UTC().Add(-60 * 24 * time.Hour).Format(timeFormat)
+	execTest(t, "UPDATE driver_ratings SET updated_at = ? WHERE driver_id = 1", old)
+	for _, user := range []string{"a", "b", "c", "d", "e", "f"} {
+		rateTest(t, h, "2", user, 4)
+	}
+	score := func(driverId string) float64 {
+		t.Helper()
+		rec := serveTest(h, "GET", "/drivers/"+driverId, "")
+		expectStatus(t, rec, http.StatusOK)
+		var driver Driver
+		decodeBody(t, rec, &driver)
+		if driver.TrustScore == nil {
+			t.Fatalf("driver %s has no trust score", driverId)
+		}
+		return *driver.TrustScore
+	}
+	low, high, unrated := score("1"), score("2"), score("3")
+	if !(unrated == 0 && 0 < low && low < high && high <= 100) {
+		t.Fatalf("trust scores are %v, %v and %v unrated, want 0 < %v < %v <= 100 and 0", low, high, unrated, low, high)
+	}
+
+	// Every component raises the score on its own.
+	base := trustInputs{Count: 2, AgeDays: 30, Stddev: 1, Raters: 1, Submissions: 2}
+	for name, better := range map[string]trustInputs{
+		"count":       {Count: 20, AgeDays: 30, Stddev: 1, Raters: 1, Submissions: 2},
+		"recency":     {Count: 2, AgeDays: 0, Stddev: 1, Raters: 1, Submissions: 2},
+		"consistency": {Count: 2, AgeDays: 30, Stddev: 0, Raters: 1, Submissions: 2},
+		"diversity":   {Count: 2, AgeDays: 30, Stddev: 1, Raters: 2, Submissions: 2},
+	} {
+		if trustScore(better) <= trustScore(base) {
+			t.Errorf("better %s scores %v, no more than %v", name, trustScore(better), trustScore(base))
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTrustedAverage(t *testing.T) {
+	h := openTestDB(t, map[string]string{"TRUSTED_USERS": "a,b"})
+	rateTest(t, h, "1", "a", 5)
+	rateTest(t, h, "1", "b", 4)
+	rateTest(t, h, "1", "c", 1)
+	for target, want := range map[string]float64{
+		"/drivers/1?avg=trusted":       4.5,
+		"/drivers?limit=1&avg=trusted": 4.5,
+		"/drivers/1":                   10.0 / 3,
+	} {
+		rec := serveTest(h, "GET", target, "")
+		expectStatus(t, rec, http.StatusOK)
+		var driver Driver
+		if target == "/drivers?limit=1&avg=trusted" {
+			var list []Driver
+			decodeBody(t, rec, &list)
+			driver = list[0]
+		} else {
+			decodeBody(t, rec, &driver)
+		}
+		if driver.AverageRating != want {
+			t.Fatalf("%s: average is %v, want %v", target, driver.AverageRating, want)
+		}
+	}
+}
+
+func TestTrustedAverageNotConfigured(t *testing.T) {
+	h := openTestDB(t, nil)
+	for _, target := range []string{"/drivers/1?avg=trusted", "/drivers?avg=trusted"} {
+		expectStatus(t, serveTest(h, "GET", target, ""), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxCohortSize keeps the IN list well below SQLite's limit on the number
+// of query parameters.
+const maxCohortSize = 1000
+
+type Cohort struct {
+	UserIDs []string `json:"user_ids"`
+}
+
+func getDriversUnratedBy(w http.ResponseWriter, r *http.Request) {
+	dec := json.NewDecoder(r.Body)
+	var cohort Cohort
+	err := dec.Decode(&cohort)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if len(cohort.UserIDs) == 0 || len(cohort.UserIDs) > maxCohortSize {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("user_ids must have between 1 and %d items", maxCohortSize))
+		return
+	}
+	list, err := getDriversUnratedByList(entityType(r), storedUserIDs(cohort.UserIDs))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(list)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getDriversUnratedByList returns the entities of entityType none of the
+// given users rated.
+func getDriversUnratedByList(entityType string, userIds []string) ([]Driver, error) {
+	args := make([]interface{}, len(userIds))
+	for i, id := range userIds {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(userIds)), ", ")
+	avg, avgArgs := averageExpr("d", cfg.AggFunction)
+	args = append(append(avgArgs, entityType), args...)
+	row, err := srv.DB().Query(`SELECT d.id, d.driver_info, COALESCE(`+avg+`, 0) AS avg_rating
+    FROM drivers d
+    WHERE d.deleted_at IS NULL AND d.entity_type = ? AND NOT EXISTS (
+      SELECT 1 FROM driver_ratings r WHERE r.driver_id = d.id AND r.user_id IN (`+placeholders+`)
+    )
+    ORDER BY d.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []Driver{}
+	for row.Next() {
+		var driver Driver
+		err = row.Scan(&driver.ID, &driver.DriverInfo, &driver.AverageRating)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, driver)
+	}
+	return list, row.Err()
+}
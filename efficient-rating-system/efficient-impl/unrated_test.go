@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestDriversUnratedByCohort(t *testing.T) {
+	h := openTestDB(t, nil)
+	for id := 1; id <= 28; id++ {
+		user := "a"
+		if id > 15 {
+			user = "b"
+		}
+		rateTest(t, h, strconv.Itoa(id), user, 4)
+	}
+	// c is not in the cohort.
+	rateTest(t, h, "29", "c", 4)
+	rec := serveTest(h, "POST", "/drivers/unrated-by", `{"user_ids": ["a", "b"]}`)
+	expectStatus(t, rec, http.StatusOK)
+	var list []Driver
+	decodeBody(t, rec, &list)
+	ids := []string{}
+	for _, driver := range list {
+		ids = append(ids, driver.ID)
+	}
+	if got := fmt.Sprint(ids); got != "[29 30]" {
+		t.Fatalf("drivers unrated by the cohort are %s, want [29 30]", got)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// RatingPosition is the rating of a user along with where it falls among the
+// ratings the other users gave the driver. HigherThan and LowerThan are the
+// percentages of the other raters who gave a lower and a higher rating, they
+// are null when nobody else rated the driver.
+type RatingPosition struct {
+	Rating
+	OtherRaters int      `json:"other_raters"`
+	HigherThan  *float64 `json:"higher_than_percent"`
+	LowerThan   *float64 `json:"lower_than_percent"`
+}
+
+func getUserRating(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	rating, err := getRating(srv.DB(), params["driver_id"], storedUserID(params["user_id"]))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if rating == nil {
+		writeError(w, http.StatusNotFound, "rating not found")
+		return
+	}
+	position, err := getRatingPosition(*rating)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	position.Rating.UserID = shownUserID(r, position.Rating.UserID)
+	hideModerated(r, &position.Rating)
+	d, err := json.Marshal(position)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func getRatingPosition(rating Rating) (*RatingPosition, error) {
+	position := &RatingPosition{Rating: rating}
+	var lower, higher int
+	err := srv.DB().QueryRow(`SELECT COUNT(*), COALESCE(SUM(rating < ?), 0), COALESCE(SUM(rating > ?), 0)
+    FROM driver_ratings WHERE driver_id = ? AND user_id != ?`,
+		rating.Rating, rating.Rating, rating.DriverID, rating.UserID).Scan(&position.OtherRaters, &lower, &higher)
+	if err != nil {
+		return nil, err
+	}
+	if position.OtherRaters > 0 {
+		higherThan := float64(lower) * 100 / float64(position.OtherRaters)
+		lowerThan := float64(higher) * 100 / float64(position.OtherRaters)
+		position.HigherThan, position.LowerThan = &higherThan, &lowerThan
+	}
+	return position, nil
+}
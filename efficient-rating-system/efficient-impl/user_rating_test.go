@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestUserRatingPosition(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "me", 4)
+	for i, stars := range []int{2, 3, 4, 5, 5} {
+		rateTest(t, h, "1", "u"+strconv.Itoa(i), stars)
+	}
+	rateTest(t, h, "2", "me", 3)
+	rec := serveTest(h, "GET", "/drivers/1/ratings/me", "")
+	expectStatus(t, rec, http.StatusOK)
+	var position RatingPosition
+	decodeBody(t, rec, &position)
+	// Of the 5 others, 2 gave less than 4 and 2 more.
+	if position.Rating.Rating != 4 || position.OtherRaters != 5 || position.HigherThan == nil || *position.HigherThan != 40 ||
+		position.LowerThan == nil || *position.LowerThan != 40 {
+		t.Fatalf("position is %+v, want higher than 40%% and lower than 40%% of 5 raters", position)
+	}
+	rec = serveTest(h, "GET", "/drivers/2/ratings/me", "")
+	expectStatus(t, rec, http.StatusOK)
+	position = RatingPosition{}
+	decodeBody(t, rec, &position)
+	if position.OtherRaters != 0 || position.HigherThan != nil || position.LowerThan != nil {
+		t.Fatalf("position of the only rater is %+v, want no percentages", position)
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers/2/ratings/nobody", ""), http.StatusNotFound)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// userIDs is set when user ids are encrypted at rest, see Config.UserIDKey.
+var userIDs *userIDCipher
+
+// sealedPrefix starts every encrypted user id, ids without it were stored
+// before encryption was turned on and are shown as they are.
+const sealedPrefix = "enc:"
+
+// userIDCipher encrypts user ids with AES-GCM. The nonce is a MAC of the id,
+// so an id always encrypts to the same value and the stored ids can still be
+// compared, grouped and looked up in SQL. Equal ids can be told apart from
+// different ones, nothing more is learned without the key.
+type userIDCipher struct {
+	aead     cipher.AEAD
+	nonceKey []byte
+}
+
+func newUserIDCipher(key string) (*userIDCipher, error) {
+	block, err := aes.NewCipher(deriveKey(key, "user id encryption"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &userIDCipher{aead: aead, nonceKey: deriveKey(key, "user id nonce")}, nil
+}
+
+// deriveKey gives every use of the configured key its own 256 bit key.
+func deriveKey(key, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func (c *userIDCipher) seal(id string) string {
+	mac := hmac.New(sha256.New, c.nonceKey)
+	mac.Write([]byte(id))
+	nonce := mac.Sum(nil)[:c.aead.NonceSize()]
+	return sealedPrefix + base64.RawURLEncoding.EncodeToString(c.aead.Seal(nonce, nonce, []byte(id), nil))
+}
+
+func (c *userIDCipher) open(s string) (string, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, sealedPrefix))
+	if !strings.HasPrefix(s, sealedPrefix) || err != nil || len(b) < c.aead.NonceSize() {
+		return "", false
+	}
+	id, err := c.aead.Open(nil, b[:c.aead.NonceSize()], b[c.aead.NonceSize():], nil)
+	if err != nil {
+		return "", false
+	}
+	return string(id), true
+}
+
+// storedUserID returns the form the user id is stored and queried in.
+func storedUserID(id string) string {
+	if userIDs == nil || id == "" {
+		return id
+	}
+	return userIDs.seal(id)
+}
+
+// storedUserIDs is storedUserID of every id.
+func storedUserIDs(ids []string) []string {
+	stored := make([]string, len(ids))
+	for i, id := range ids {
+		stored[i] = storedUserID(id)
+	}
+	return stored
+}
+
+// shownUserID returns how a stored user id is shown in the response to r:
+// decrypted for an admin, as the opaque encrypted value to everyone else.
+func shownUserID(r *http.Request, id string) string {
+	if userIDs == nil || !adminAuthorized(r) {
+		return id
+	}
+	if plain, ok := userIDs.open(id); ok {
+		return plain
+	}
+	return id
+}
+
+// showRatings replaces the stored user ids of the ratings by shownUserID,
+// and blanks the hidden comments, see hideModerated.
+func showRatings(r *http.Request, list []Rating) {
+	for i := range list {
+		list[i].UserID = shownUserID(r, list[i].UserID)
+		hideModerated(r, &list[i])
+	}
+}
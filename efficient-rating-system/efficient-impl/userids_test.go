@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedUserIDs(t *testing.T) {
+	h := openTestDB(t, map[string]string{"ADMIN_TOKEN": "secret-token", "USER_ID_KEY": "user-key"})
+	rateTest(t, h, "1", "alice", 4)
+	var stored string
+	if err := srv.DB().QueryRow("SELECT user_id FROM driver_ratings WHERE driver_id = '1'").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stored, sealedPrefix) || strings.Contains(stored, "alice") {
+		t.Fatalf("user id stored as %q, want it encrypted", stored)
+	}
+	for _, test := range []struct {
+		header []string
+		want   string
+	}{{nil, stored}, {adminAuth, "alice"}} {
+		rec := serveTest(h, "GET", "/drivers/1/ratings", "", test.header...)
+		expectStatus(t, rec, http.StatusOK)
+		var list []Rating
+		decodeBody(t, rec, &list)
+		if len(list) != 1 || list[0].UserID != test.want {
+			t.Fatalf("ratings with header %v are %+v, want the user shown as %q", test.header, list, test.want)
+		}
+	}
+	// Lookups take the plain id.
+	rec := serveTest(h, "GET", "/users/alice/ratings", "")
+	expectStatus(t, rec, http.StatusOK)
+	var list []Rating
+	decodeBody(t, rec, &list)
+	if len(list) != 1 || list[0].DriverID != "1" {
+		t.Fatalf("ratings of alice are %+v, want the one of driver 1", list)
+	}
+}
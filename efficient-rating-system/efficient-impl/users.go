@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Similarity tells how alike two users rate the drivers both of them rated.
+// Score is the Pearson correlation of their ratings, from -1 to 1, it is null
+// when there are fewer than two shared drivers or one of the users gave the
+// same rating to all of them.
+type Similarity struct {
+	UserA         string   `json:"user_a"`
+	UserB         string   `json:"user_b"`
+	SharedDrivers int      `json:"shared_drivers"`
+	Score         *float64 `json:"score"`
+}
+
+func getUserSimilarity(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	similarity, err := getUsersSimilarity(storedUserID(params["a"]), storedUserID(params["b"]))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	similarity.UserA, similarity.UserB = params["a"], params["b"]
+	d, err := json.Marshal(similarity)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func getUsersSimilarity(userA, userB string) (*Similarity, error) {
+	xs, ys, err := queryRatingPairs(`SELECT a.rating, b.rating FROM driver_ratings a
+    JOIN driver_ratings b ON b.driver_id = a.driver_id AND b.user_id = ?
+    WHERE a.user_id = ?`, userB, userA)
+	if err != nil {
+		return nil, err
+	}
+	return &Similarity{
+		UserA:         userA,
+		UserB:         userB,
+		SharedDrivers: len(xs),
+		Score:         pearson(xs, ys),
+	}, nil
+}
+
+// queryRatingPairs reads the two rating columns of the query into paired
+// samples for pearson.
+func queryRatingPairs(query string, args ...interface{}) (xs, ys []float64, err error) {
+	row, err := srv.DB().Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer row.Close()
+	for row.Next() {
+		var x, y float64
+		err = row.Scan(&x, &y)
+		if err != nil {
+			return nil, nil, err
+		}
+		xs = append(xs, x)
+		ys = append(ys, y)
+	}
+	return xs, ys, row.Err()
+}
+
+// pearson returns the correlation coefficient of the paired samples, or nil
+// when it is undefined.
+func pearson(xs, ys []float64) *float64 {
+	n := float64(len(xs))
+	if n < 2 {
+		return nil
+	}
+	var sumX, sumY float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+	var cov, varX, varY float64
+	for i := range xs {
+		dx, dy := xs[i]-meanX, ys[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return nil
+	}
+	r := cov / math.Sqrt(varX*varY)
+	return &r
+}
+
+// getUserRatings lists the ratings a user gave across drivers, newest first.
+// With rating only the ones of that value are listed, e.g. every driver the
+// user gave one star.
+func getUserRatings(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	var value *int
+	if v := r.URL.Query().Get("rating"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < minRating || n > maxRating {
+			writeError(w, http.StatusBadRequest, (&paramError{"rating", "must be a number between 1 and 5"}).Error())
+			return
+		}
+		value = &n
+	}
+	list, err := getUserRatingsList(storedUserID(params["user_id"]), value)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	showRatings(r, list)
+	d, err := json.Marshal(list)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func getUserRatingsList(userId string, value *int) ([]Rating, error) {
+	query := `SELECT r.driver_id, r.user_id, r.rating, COALESCE(r.source, ''), COALESCE(r.comment, ''), r.tags, COALESCE(r.region, ''), r.created_at, r.updated_at, r.moderation
+    FROM driver_ratings r
+    JOIN drivers d ON d.id = r.driver_id AND d.deleted_at IS NULL
+    WHERE r.user_id = ?`
+	args := []interface{}{userId}
+	if value != nil {
+		query += " AND r.rating = ?"
+		args = append(args, *value)
+	}
+	row, err := srv.DB().Query(query+" ORDER BY r.updated_at DESC, r.driver_id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []Rating{}
+	for row.Next() {
+		var rating Rating
+		err = row.Scan(&rating.DriverID, &rating.UserID, &rating.Rating, &rating.Source, &rating.Comment, &rating.Tags, &rating.Region, &rating.CreatedAt, &rating.UpdatedAt, &rating.Moderation)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rating)
+	}
+	return list, row.Err()
+}
+
+type ErasureResult struct {
+	UserID          string `json:"user_id"`
+	DeletedRatings  int64  `json:"deleted_ratings"`
+	AffectedDrivers int64  `json:"affected_drivers"`
+}
+
+// deleteUserRatings erases every rating of the user, e.g. for a GDPR
+// request, and takes them out of the aggregates of the rated drivers.
+func deleteUserRatings(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	result, err := eraseUserRatings(storedUserID(params["user_id"]), requestActor(r))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	result.UserID = params["user_id"]
+	d, err := json.Marshal(result)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func eraseUserRatings(userId, actor string) (*ErasureResult, error) {
+	tx, err := srv.DB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	result := &ErasureResult{UserID: userId}
+	counted := countedRatings()
+	if lazy != nil {
+		result.AffectedDrivers, err = markStaleDrivers(tx, "SELECT DISTINCT driver_id FROM "+counted+" WHERE user_id = ?", userId)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		res, err := tx.Exec(`UPDATE drivers
+      SET rating_sum = rating_sum - (SELECT SUM(rating) FROM `+counted+` WHERE driver_id = drivers.id AND user_id = ?),
+        rating_count = rating_count - (SELECT COUNT(*) FROM `+counted+` WHERE driver_id = drivers.id AND user_id = ?)
+      WHERE id IN (SELECT driver_id FROM `+counted+` WHERE user_id = ?)`, userId, userId, userId)
+		if err != nil {
+			return nil, err
+		}
+		if result.AffectedDrivers, err = res.RowsAffected(); err != nil {
+			return nil, err
+		}
+	}
+	res, err := tx.Exec("DELETE FROM driver_ratings WHERE user_id = ?", userId)
+	if err != nil {
+		return nil, err
+	}
+	if result.DeletedRatings, err = res.RowsAffected(); err != nil {
+		return nil, err
+	}
+	res, err = tx.Exec("DELETE FROM archived_ratings WHERE user_id = ?", userId)
+	if err != nil {
+		return nil, err
+	}
+	archived, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	result.DeletedRatings += archived
+	if cfg.RatingEventLog {
+		// The ratings would come back from the log otherwise.
+		if _, err = tx.Exec("DELETE FROM rating_events WHERE user_id = ?", userId); err != nil {
+			return nil, err
+		}
+	}
+	err = recordAudit(tx, actor, "delete", "user_ratings", userId, map[string]interface{}{
+		"deleted_ratings":  result.DeletedRatings,
+		"affected_drivers": result.AffectedDrivers,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	averageCache.purge()
+	return result, nil
+}
+
+// UserAverage is the mean of the ratings a user gave, null when they rated
+// nothing.
+type UserAverage struct {
+	UserID  string   `json:"user_id"`
+	Average *float64 `json:"avg_rating"`
+	Count   int      `json:"count"`
+}
+
+func getUserAverage(w http.ResponseWriter, r *http.Request) {
+	userId := mux.Vars(r)["user_id"]
+	avg := UserAverage{UserID: userId}
+	err := srv.DB().QueryRow(`SELECT AVG(r.rating), COUNT(*)
+    FROM driver_ratings r
+    JOIN drivers d ON d.id = r.driver_id AND d.deleted_at IS NULL
+    WHERE r.user_id = ?`, storedUserID(userId)).Scan(&avg.Average, &avg.Count)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(avg)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestUserSimilarity(t *testing.T) {
+	h := openTestDB(t, nil)
+	for driver, stars := range map[string]int{"1": 1, "2": 3, "3": 5, "4": 2} {
+		rateTest(t, h, driver, "a", stars)
+	}
+	for driver, stars := range map[string]int{"1": 2, "2": 4, "3": 5, "5": 1} {
+		rateTest(t, h, driver, "b", stars)
+	}
+	rec := serveTest(h, "GET", "/users/a/similarity/b", "")
+	expectStatus(t, rec, http.StatusOK)
+	var similarity Similarity
+	decodeBody(t, rec, &similarity)
+	// The correlation of 1, 3, 5 and 2, 4, 5 over the shared drivers.
+	want := 18 / math.Sqrt(336)
+	if similarity.SharedDrivers != 3 || similarity.Score == nil || math.Abs(*similarity.Score-want) > 1e-9 {
+		t.Fatalf("similarity is %d shared drivers, score %v, want 3 and %v", similarity.SharedDrivers, similarity.Score, want)
+	}
+}
+
+func TestEraseUserRatings(t *testing.T) {
+	h := openTestDB(t, adminEnv)
+	rateTest(t, h, "1", "a", 1)
+	rateTest(t, h, "1", "b", 5)
+	rateTest(t, h, "2", "a", 2)
+	rateTest(t, h, "3", "b", 4)
+	rec := serveTest(h, "DELETE", "/users/a/ratings", "", adminAuth...)
+	expectStatus(t, rec, http.StatusOK)
+	var result ErasureResult
+	decodeBody(t, rec, &result)
+	if result.DeletedRatings != 2 || result.AffectedDrivers != 2 {
+		t.Fatalf("erasure is %+v, want 2 ratings of 2 drivers", result)
+	}
+	var left int
+	if err := srv.DB().QueryRow("SELECT COUNT(*) FROM driver_ratings WHERE user_id = 'a'").Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Fatalf("%d ratings of the user left", left)
+	}
+	for driver, want := range map[string][2]int64{"1": {5, 1}, "2": {0, 0}, "3": {4, 1}} {
+		if sum, count := driverAggregates(t, driver); sum != want[0] || count != want[1] {
+			t.Fatalf("aggregates of driver %s are sum %d count %d, want %v", driver, sum, count, want)
+		}
+	}
+}
+
+func TestUserRatingsOfValue(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 1)
+	rateTest(t, h, "2", "a", 4)
+	rateTest(t, h, "3", "a", 1)
+	rateTest(t, h, "4", "b", 1)
+	for target, want := range map[string]string{"/users/a/ratings?rating=1": "1,3", "/users/a/ratings": "1,2,3"} {
+		rec := serveTest(h, "GET", target, "")
+		expectStatus(t, rec, http.StatusOK)
+		var list []Rating
+		decodeBody(t, rec, &list)
+		ids := []string{}
+		for _, rating := range list {
+			if rating.UserID != "a" {
+				t.Fatalf("%s lists a rating of %q", target, rating.UserID)
+			}
+			ids = append(ids, rating.DriverID)
+		}
+		sort.Strings(ids)
+		if got := strings.Join(ids, ","); got != want {
+			t.Fatalf("%s lists drivers %s, want %s", target, got, want)
+		}
+	}
+	expectStatus(t, serveTest(h, "GET", "/users/a/ratings?rating=6", ""), http.StatusBadRequest)
+}
+
+func TestUserAverage(t *testing.T) {
+	h := openTestDB(t, nil)
+	for driver, stars := range map[string]int{"1": 1, "2": 5, "3": 4, "4": 4} {
+		rateTest(t, h, driver, "a", stars)
+	}
+	rateTest(t, h, "1", "b", 2)
+	rec := serveTest(h, "GET", "/users/a/average", "")
+	expectStatus(t, rec, http.StatusOK)
+	var avg UserAverage
+	decodeBody(t, rec, &avg)
+	if avg.UserID != "a" || avg.Count != 4 || avg.Average == nil || *avg.Average != 3.5 {
+		t.Fatalf("average of user a is %+v, want 3.5 over 4 ratings", avg)
+	}
+
+	rec = serveTest(h, "GET", "/users/nobody/average", "")
+	expectStatus(t, rec, http.StatusOK)
+	avg = UserAverage{}
+	decodeBody(t, rec, &avg)
+	if avg.Count != 0 || avg.Average != nil {
+		t.Fatalf("average of a user without ratings is %+v, want null over 0 ratings", avg)
+	}
+}
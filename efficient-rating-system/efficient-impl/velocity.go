@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const defaultVelocityWindow = "7d"
+
+type Velocity struct {
+	DriverID string  `json:"driver_id"`
+	Window   string  `json:"window"`
+	Count    int     `json:"count"`
+	PerDay   float64 `json:"per_day"`
+}
+
+func getDriverVelocity(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	driverId := params["driver_id"]
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		window = defaultVelocityWindow
+	}
+	d, err := parseWindow("window", window)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	count, err := countRatingsSince(driverId, time.Now().Add(-d))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	res, err := json.Marshal(Velocity{
+		DriverID: driverId,
+		Window:   window,
+		Count:    count,
+		PerDay:   float64(count) / d.Hours() * 24,
+	})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(res)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// countRatingsSince counts the ratings the driver received since the given
+// time. Updating a rating doesn't count as a new one.
+func countRatingsSince(driverId string, since time.Time) (int, error) {
+	var count int
+	err := srv.DB().QueryRow("SELECT COUNT(*) FROM driver_ratings WHERE driver_id = ? AND created_at >= ?",
+		driverId, since.UTC().Format(timeFormat)).Scan(&count)
+	return count, err
+}
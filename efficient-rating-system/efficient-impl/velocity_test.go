@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDriverVelocity(t *testing.T) {
+	h := openTestDB(t, nil)
+	for _, user := range []string{"a", "b", "c", "d"} {
+		rateTest(t, h, "1", user, 4)
+	}
+	old := time.Now().Add(-10 * 24 * time.Hour).UTC().Format(timeFormat)
+	execTest(t, "UPDATE driver_ratings SET created_at = ? WHERE driver_id = '1' AND user_id IN ('c', 'd')", old)
+	rec := serveTest(h, "GET", "/drivers/1/velocity?window=7d", "")
+	expectStatus(t, rec, http.StatusOK)
+	var v Velocity
+	decodeBody(t, rec, &v)
+	if v.Count != 2 || v.PerDay != 2.0/7 {
+		t.Fatalf("velocity is %d ratings, %v a day, want 2 and %v", v.Count, v.PerDay, 2.0/7)
+	}
+}
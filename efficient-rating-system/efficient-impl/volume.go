@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// volumeBuckets are the accepted bucket values of GET /admin/rate-volume,
+// with the strftime format of the start of a bucket, its length and how far
+// back the volume goes by default.
+var volumeBuckets = map[string]struct {
+	format string
+	length time.Duration
+	since  time.Duration
+}{
+	"hour": {"%Y-%m-%dT%H:00:00Z", time.Hour, 24 * time.Hour},
+	"day":  {"%Y-%m-%dT00:00:00Z", 24 * time.Hour, 30 * 24 * time.Hour},
+}
+
+type VolumeBucket struct {
+	Start string `json:"start"`
+	Count int    `json:"count"`
+}
+
+type RateVolume struct {
+	Bucket string         `json:"bucket"`
+	Since  time.Time      `json:"since"`
+	Volume []VolumeBucket `json:"volume"`
+}
+
+// getRateVolume counts the rating submissions per hour or day, from
+// rating_events so that updates count as well as new ratings.
+func getRateVolume(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListParams(r, listOptions{Since: true})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	bucket := r.URL.Query().Get("bucket")
+	if bucket == "" {
+		bucket = "hour"
+	}
+	b, ok := volumeBuckets[bucket]
+	if !ok {
+		writeError(w, http.StatusBadRequest, (&paramError{"bucket", "must be hour or day"}).Error())
+		return
+	}
+	since := time.Now().Add(-b.since).UTC().Truncate(b.length)
+	if params.Since != nil {
+		since = params.Since.UTC()
+	}
+	list, err := getRateVolumeList(b.format, since)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	d, err := json.Marshal(RateVolume{Bucket: bucket, Since: since, Volume: list})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getRateVolumeList groups the submissions since the given time by the start
+// of their bucket, oldest first. Buckets without submissions are left out.
+func getRateVolumeList(format string, since time.Time) ([]VolumeBucket, error) {
+	row, err := srv.DB().Query(`SELECT strftime(?, created_at) AS start, COUNT(*)
+    FROM rating_events
+    WHERE created_at >= ? AND rating IS NOT NULL
+    GROUP BY start
+    ORDER BY start`, format, since.Format(timeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	list := []VolumeBucket{}
+	for row.Next() {
+		var bucket VolumeBucket
+		if err = row.Scan(&bucket.Start, &bucket.Count); err != nil {
+			return nil, err
+		}
+		list = append(list, bucket)
+	}
+	return list, row.Err()
+}
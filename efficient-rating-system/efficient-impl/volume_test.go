@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateVolumeCountsSubmissions(t *testing.T) {
+	h := openTestDB(t, adminEnv)
+	volume := func(bucket string) int {
+		t.Helper()
+		rec := serveTest(h, "GET", "/admin/rate-volume?bucket="+bucket, "", adminAuth...)
+		expectStatus(t, rec, http.StatusOK)
+		var body RateVolume
+		decodeBody(t, rec, &body)
+		var count int
+		for _, b := range body.Volume {
+			count += b.Count
+		}
+		return count
+	}
+	rateTest(t, h, "1", "a", 4)
+	rateTest(t, h, "2", "a", 3)
+	// An update is a submission too.
+	rateTest(t, h, "1", "a", 5)
+	if n := volume("hour"); n != 3 {
+		t.Fatalf("hourly volume is %d, want 3", n)
+	}
+	rateTest(t, h, "3", "b", 2)
+	if n := volume("hour"); n != 4 {
+		t.Fatalf("hourly volume after another rating is %d, want 4", n)
+	}
+	if n := volume("day"); n != 4 {
+		t.Fatalf("daily volume is %d, want 4", n)
+	}
+
+	rec := serveTest(h, "GET", "/admin/rate-volume?bucket=hour", "", adminAuth...)
+	var body RateVolume
+	decodeBody(t, rec, &body)
+	if len(body.Volume) == 0 || body.Volume[len(body.Volume)-1].Start != time.Now().UTC().Truncate(time.Hour).Format("2006-01-02T15:00:00Z") {
+		t.Fatalf("hourly volume is %+v, want the current hour last", body.Volume)
+	}
+	expectStatus(t, serveTest(h, "GET", "/admin/rate-volume?bucket=week", "", adminAuth...), http.StatusBadRequest)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// WhatIf projects the average of a driver after a rating, without storing
+// it. When the user asked about has rated the driver already the rating
+// replaces theirs, PreviousRating is set and the count doesn't change.
+type WhatIf struct {
+	DriverID         string  `json:"driver_id"`
+	Rating           int     `json:"rating"`
+	PreviousRating   *int    `json:"previous_rating,omitempty"`
+	AverageRating    float64 `json:"avg_rating"`
+	RatingCount      int64   `json:"rating_count"`
+	ProjectedAverage float64 `json:"projected_avg_rating"`
+	ProjectedCount   int64   `json:"projected_rating_count"`
+}
+
+func getDriverWhatIf(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	driverId := params["driver_id"]
+	query := r.URL.Query()
+	rating, err := strconv.Atoi(query.Get("rating"))
+	if err != nil || rating < minRating || rating > maxRating {
+		writeError(w, http.StatusBadRequest, (&paramError{"rating", "must be a number between 1 and 5"}).Error())
+		return
+	}
+	var sum, count int64
+	err = srv.DB().QueryRow("SELECT rating_sum, rating_count FROM drivers WHERE id = ? AND deleted_at IS NULL", driverId).Scan(&sum, &count)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "driver not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	var previous *Rating
+	if userId := query.Get("user_id"); userId != "" {
+		previous, err = getRating(srv.DB(), driverId, storedUserID(userId))
+		if err != nil {
+			writeInternalError(w, err)
+			return
+		}
+	}
+	d, err := json.Marshal(projectRating(driverId, sum, count, rating, previous))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	_, err = w.Write(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// projectRating applies rating to the aggregates the way writeRating would,
+// as a new rating or in place of previous.
+func projectRating(driverId string, sum, count int64, rating int, previous *Rating) WhatIf {
+	what := WhatIf{DriverID: driverId, Rating: rating, RatingCount: count}
+	if count > 0 {
+		what.AverageRating = float64(sum) / float64(count)
+	}
+	sum += int64(rating)
+	if previous != nil {
+		what.PreviousRating = &previous.Rating
+		sum -= int64(previous.Rating)
+	} else {
+		count++
+	}
+	what.ProjectedCount = count
+	what.ProjectedAverage = float64(sum) / float64(count)
+	return what
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDriverWhatIf(t *testing.T) {
+	h := openTestDB(t, nil)
+	rateTest(t, h, "1", "a", 5)
+	rateTest(t, h, "1", "b", 4)
+	tests := []struct {
+		query    string
+		previous *int
+		average  float64
+		count    int64
+	}{
+		// A new rating adds to the count, the rating of a replaces 5.
+		{"rating=3", nil, 4, 3},
+		{"rating=2&user_id=a", intPtr(5), 3, 2},
+		{"rating=2&user_id=z", nil, 11.0 / 3, 3},
+	}
+	for _, test := range tests {
+		rec := serveTest(h, "GET", "/drivers/1/what-if?"+test.query, "")
+		expectStatus(t, rec, http.StatusOK)
+		var what WhatIf
+		decodeBody(t, rec, &what)
+		if what.AverageRating != 4.5 || what.RatingCount != 2 || what.ProjectedAverage != test.average ||
+			what.ProjectedCount != test.count || (what.PreviousRating == nil) != (test.previous == nil) ||
+			test.previous != nil && *what.PreviousRating != *test.previous {
+			t.Errorf("%s: got %+v, want %v over %d ratings", test.query, what, test.average, test.count)
+		}
+	}
+	if sum, count := driverAggregates(t, "1"); sum != 9 || count != 2 {
+		t.Fatalf("aggregates are sum %d count %d after the projections, want them untouched", sum, count)
+	}
+	expectStatus(t, serveTest(h, "GET", "/drivers/1/what-if?rating=6", ""), http.StatusBadRequest)
+	expectStatus(t, serveTest(h, "GET", "/drivers/404/what-if?rating=3", ""), http.StatusNotFound)
+}
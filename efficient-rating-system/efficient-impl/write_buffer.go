@@ -0,0 +1,156 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// ratingBuffer is set when batched writes are enabled, see Config.BatchInterval.
+var ratingBuffer *writeBuffer
+
+// writeBuffer queues incoming ratings and writes them in batches, one
+// transaction per batch instead of one per request.
+//
+// Writes are eventually consistent: a rating is acknowledged as soon as it is
+// queued, and shows up in the aggregates and listings once its batch is
+// flushed, at most one interval later. Ratings still queued when the process
+// dies are lost, on a graceful shutdown stop writes them.
+type writeBuffer struct {
+	queue    chan queuedRating
+	interval time.Duration
+	size     int
+	closing  chan struct{}
+	stopped  chan struct{}
+}
+
+func newWriteBuffer(interval time.Duration, size int) *writeBuffer {
+	b := &writeBuffer{
+		queue:    make(chan queuedRating, size),
+		interval: interval,
+		size:     size,
+		closing:  make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// queuedRating is a rating waiting for its batch, Status is the id its
+// outcome is reported to in ratingStatuses, if any.
+type queuedRating struct {
+	Rating
+	Status string
+}
+
+// add queues the rating, blocking while the queue is full. The outcome is
+// reported to the status id unless it is empty.
+func (b *writeBuffer) add(rating Rating, status string) {
+	b.queue <- queuedRating{rating, status}
+}
+
+// stop flushes the queued ratings and stops the buffer. No rating may be
+// added once it is called.
+func (b *writeBuffer) stop() {
+	close(b.closing)
+	<-b.stopped
+}
+
+func (b *writeBuffer) run() {
+	defer close(b.stopped)
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	batch := make([]queuedRating, 0, b.size)
+	for {
+		select {
+		case p := <-b.queue:
+			batch = append(batch, p)
+			if len(batch) < b.size {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-b.closing:
+			for len(b.queue) > 0 {
+				batch = append(batch, <-b.queue)
+			}
+			if len(batch) > 0 {
+				if err := flushRatings(batch); err != nil {
+					log.Println("flush ratings:", err)
+				}
+			}
+			return
+		}
+		if err := flushRatings(batch); err != nil {
+			log.Println("flush ratings:", err)
+		}
+		batch = batch[:0]
+	}
+}
+
+// flushRatings writes the batch in a single transaction. Every rating is
+// written under a savepoint: one that fails is rolled back to it, logged and
+// skipped, without aborting the rest of the batch or leaving part of its
+// writes behind. The outcome of every rating is reported to ratingStatuses.
+func flushRatings(batch []queuedRating) error {
+	tx, err := srv.DB().Begin()
+	if err != nil {
+		finishRatings(batch, err)
+		return err
+	}
+	defer tx.Rollback()
+	done := make([]queuedRating, 0, len(batch))
+	stored := make([]storedRating, 0, len(batch))
+	written := 0
+	// left are the ratings without an outcome when the batch is abandoned.
+	var left []queuedRating
+	for i, p := range batch {
+		left = batch[i:]
+		if _, err = tx.Exec("SAVEPOINT rating"); err != nil {
+			break
+		}
+		s, failed := storeRating(tx, p.Rating)
+		if failed != nil {
+			log.Printf("flush rating of driver %s by %s: %v", p.DriverID, p.UserID, failed)
+			ratingStatuses.finish(p.Status, failed)
+			left = batch[i+1:]
+			_, err = tx.Exec("ROLLBACK TO rating")
+		}
+		if err == nil {
+			_, err = tx.Exec("RELEASE rating")
+		}
+		if err != nil {
+			break
+		}
+		if failed == nil {
+			done = append(done, p)
+			stored = append(stored, s)
+			if s.ok {
+				written++
+			}
+		}
+	}
+	if err != nil {
+		finishRatings(append(done, left...), err)
+		return err
+	}
+	err = tx.Commit()
+	finishRatings(done, err)
+	if err != nil {
+		return err
+	}
+	for i, p := range done {
+		stored[i].apply(p.DriverID)
+		averageCache.forget(p.DriverID)
+	}
+	events.notify()
+	metrics.ratingsStored(written)
+	return nil
+}
+
+func finishRatings(batch []queuedRating, err error) {
+	for _, p := range batch {
+		ratingStatuses.finish(p.Status, err)
+	}
+}
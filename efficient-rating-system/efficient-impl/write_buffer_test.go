@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// eventually fails the test unless done turns true within a few seconds.
+func eventually(tb testing.TB, done func() bool) {
+	tb.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !done(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			tb.Fatal("timed out")
+		}
+	}
+}
+
+func TestBufferedRatingsLand(t *testing.T) {
+	h := openTestDB(t, map[string]string{"RATING_BATCH_INTERVAL_MS": "10", "RATING_BATCH_SIZE": "2"})
+	rateTest(t, h, "1", "a", 5)
+	rateTest(t, h, "1", "b", 2)
+	rateTest(t, h, "1", "c", 4)
+	rateTest(t, h, "1", "a", 3)
+	eventually(t, func() bool {
+		sum, count := driverAggregates(t, "1")
+		return sum == 9 && count == 3
+	})
+}
+
+func TestFlushRollsBackFailedRating(t *testing.T) {
+	openTestDB(t, nil)
+	execTest(t, `CREATE TRIGGER fail_driver_5 BEFORE UPDATE OF rating_sum ON drivers WHEN NEW.id = 5
+		BEGIN SELECT RAISE(ABORT, 'injected'); END`)
+	err := flushRatings([]queuedRating{
+		{Rating: Rating{DriverID: "5", UserID: "a", Rating: 1}},
+		{Rating: Rating{DriverID: "1", UserID: "a", Rating: 4}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The rating of driver 5 fails after its row, event and audit entry are
+	// written, none of them may be committed.
+	var rows, events int
+	err = srv.DB().QueryRow(`SELECT (SELECT COUNT(*) FROM driver_ratings WHERE driver_id = '5'),
+		(SELECT COUNT(*) FROM rating_events WHERE driver_id = '5')`).Scan(&rows, &events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 0 || events != 0 {
+		t.Fatalf("%d ratings and %d events left by the failed rating, want none", rows, events)
+	}
+	if sum, count := driverAggregates(t, "1"); sum != 4 || count != 1 {
+		t.Fatalf("aggregates of driver 1 are sum %d count %d, want 4 and 1", sum, count)
+	}
+}
+
+func TestFlushDropsResubmissions(t *testing.T) {
+	openTestDB(t, map[string]string{"RATING_DEDUP_WINDOW_MS": "60000"})
+	rating := queuedRating{Rating: Rating{DriverID: "1", UserID: "a", Rating: 4}}
+	for i := 0; i < 2; i++ {
+		if err := flushRatings([]queuedRating{rating}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var events int
+	if err := srv.DB().QueryRow("SELECT COUNT(*) FROM rating_events WHERE driver_id = '1'").Scan(&events); err != nil {
+		t.Fatal(err)
+	}
+	if events != 1 {
+		t.Fatalf("%d submissions logged, want the resubmission dropped", events)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestConcurrentRatingsOfOneUser submits ratings of the same user for the
+// same driver at once, they must end up as one rating counted once. Run it
+// with go test -race.
+func TestConcurrentRatingsOfOneUser(t *testing.T) {
+	openTestDB(t, nil)
+	const writers = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(stars int) {
+			defer wg.Done()
+			errs <- createOrUpdateRating(Rating{DriverID: "1", UserID: "u1", Rating: stars})
+		}(i%5 + 1)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var rows int
+	var stars int64
+	err := srv.DB().QueryRow("SELECT COUNT(*), MAX(rating) FROM driver_ratings WHERE driver_id = '1' AND user_id = 'u1'").Scan(&rows, &stars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Fatalf("%d ratings stored for one user, want 1", rows)
+	}
+	sum, count := driverAggregates(t, "1")
+	if count != 1 || sum != stars {
+		t.Fatalf("aggregates are sum %d count %d, want sum %d count 1", sum, count, stars)
+	}
+}
+
+// TestConcurrentRatingsOfManyUsers has users rate the same driver at once,
+// each of them repeatedly, and checks the aggregates against the ratings.
+func TestConcurrentRatingsOfManyUsers(t *testing.T) {
+	openTestDB(t, nil)
+	const users, rounds = 8, 5
+	var wg sync.WaitGroup
+	var failed atomic.Value
+	for u := 0; u < users; u++ {
+		for i := 0; i < rounds; i++ {
+			wg.Add(1)
+			go func(user string, stars int) {
+				defer wg.Done()
+				if err := createOrUpdateRating(Rating{DriverID: "2", UserID: user, Rating: stars}); err != nil {
+					failed.Store(err)
+				}
+			}("u"+strconv.Itoa(u), (u+i)%5+1)
+		}
+	}
+	wg.Wait()
+	if err, _ := failed.Load().(error); err != nil {
+		t.Fatal(err)
+	}
+	var rows, stars int64
+	err := srv.DB().QueryRow("SELECT COUNT(*), SUM(rating) FROM driver_ratings WHERE driver_id = '2'").Scan(&rows, &stars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, count := driverAggregates(t, "2")
+	if rows != users || count != rows || sum != stars {
+		t.Fatalf("%d ratings summing to %d, aggregates are sum %d count %d", rows, stars, sum, count)
+	}
+}
+
+// TestConcurrentRatingsThroughBuffers rates at once with batched writes and
+// coalesced aggregates, so that the background goroutines of both buffers run
+// along with the handlers under go test -race. Once the buffers are stopped
+// the aggregates must match the ratings.
+func TestConcurrentRatingsThroughBuffers(t *testing.T) {
+	h := openTestDB(t, map[string]string{"RATING_BATCH_INTERVAL_MS": "5", "AGGREGATE_FLUSH_INTERVAL_MS": "5"})
+	const users, rounds = 8, 5
+	var wg sync.WaitGroup
+	for u := 0; u < users; u++ {
+		for i := 0; i < rounds; i++ {
+			wg.Add(1)
+			go func(user string, stars int) {
+				defer wg.Done()
+				body := fmt.Sprintf(`{"user_id": %q, "rating": %d}`, user, stars)
+				if rec := serveTest(h, "POST", "/drivers/4/ratings", body); rec.Code != http.StatusOK {
+					t.Errorf("status %d: %s", rec.Code, rec.Body.String())
+				}
+			}("u"+strconv.Itoa(u), (u+i)%5+1)
+		}
+	}
+	wg.Wait()
+	ratingBuffer.stop()
+	ratingBuffer = nil
+	aggregates.stop()
+	var rows, stars int64
+	err := srv.DB().QueryRow("SELECT COUNT(*), SUM(rating) FROM driver_ratings WHERE driver_id = '4'").Scan(&rows, &stars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, count := driverAggregates(t, "4")
+	if rows != users || count != rows || sum != stars {
+		t.Fatalf("%d ratings summing to %d, aggregates are sum %d count %d", rows, stars, sum, count)
+	}
+}
+
+// TestConcurrentRatingUpdates changes existing ratings at once through the
+// API, each update must move the aggregates by the delta RETURNING gives.
+func TestConcurrentRatingUpdates(t *testing.T) {
+	h := openTestDB(t, nil)
+	const users = 10
+	for u := 0; u < users; u++ {
+		rateTest(t, h, "3", "u"+strconv.Itoa(u), 1)
+	}
+	var wg sync.WaitGroup
+	for u := 0; u < users; u++ {
+		for stars := 2; stars <= 5; stars++ {
+			wg.Add(1)
+			go func(user string, stars int) {
+				defer wg.Done()
+				body := fmt.Sprintf(`{"user_id": %q, "rating": %d}`, user, stars)
+				if rec := serveTest(h, "POST", "/drivers/3/ratings", body); rec.Code != http.StatusOK {
+					t.Errorf("status %d: %s", rec.Code, rec.Body.String())
+				}
+			}("u"+strconv.Itoa(u), stars)
+		}
+	}
+	wg.Wait()
+	var stars int64
+	if err := srv.DB().QueryRow("SELECT SUM(rating) FROM driver_ratings WHERE driver_id = '3'").Scan(&stars); err != nil {
+		t.Fatal(err)
+	}
+	if sum, count := driverAggregates(t, "3"); count != users || sum != stars {
+		t.Fatalf("ratings sum to %d, aggregates are sum %d count %d, want count %d", stars, sum, count, users)
+	}
+}
+
+// naiveCreateOrUpdateRating is the write path the service had before the
+// upsert, as in naive-impl: look the rating up and then insert or update it.
+// Two concurrent submissions can both miss the rating, it is only kept for
+// the benchmarks to compare against.
+func naiveCreateOrUpdateRating(r Rating) error {
+	tx, err := srv.DB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	prev, err := getRating(tx, r.DriverID, r.UserID)
+	if err != nil {
+		return err
+	}
+	delta, added := int64(r.Rating), int64(1)
+	if prev == nil {
+		_, err = tx.Exec("INSERT INTO driver_ratings (driver_id, user_id, rating) VALUES (?, ?, ?)", r.DriverID, r.UserID, r.Rating)
+	} else {
+		delta, added = int64(r.Rating-prev.Rating), 0
+		_, err = tx.Exec("UPDATE driver_ratings SET rating = ?, updated_at = CURRENT_TIMESTAMP WHERE driver_id = ? AND user_id = ?", r.Rating, r.DriverID, r.UserID)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE drivers SET rating_sum = rating_sum + ?, rating_count = rating_count + ? WHERE id = ?", delta, added, r.DriverID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func benchmarkWritePath(b *testing.B, write func(Rating) error) {
+	for _, users := range []int{1, 1000} {
+		b.Run(fmt.Sprintf("users=%d", users), func(b *testing.B) {
+			openTestDB(b, nil)
+			var n atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := n.Add(1)
+					r := Rating{
+						DriverID: strconv.FormatInt(i%seedDriverCount+1, 10),
+						UserID:   "u" + strconv.FormatInt(i%int64(users), 10),
+						Rating:   int(i%5) + 1,
+					}
+					if err := write(r); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkUpsert measures the write path of the service.
+func BenchmarkUpsert(b *testing.B) {
+	benchmarkWritePath(b, createOrUpdateRating)
+}
+
+// BenchmarkSelectThenWrite measures the lookup-then-write path it replaced.
+func BenchmarkSelectThenWrite(b *testing.B) {
+	benchmarkWritePath(b, naiveCreateOrUpdateRating)
+}